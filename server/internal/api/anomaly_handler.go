@@ -0,0 +1,80 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type AnomalyHandler struct {
+	logger       *log.Logger
+	anomalyStore store.AnomalyStore
+}
+
+func NewAnomalyHandler(logger *log.Logger, anomalyStore store.AnomalyStore) *AnomalyHandler {
+	return &AnomalyHandler{
+		logger,
+		anomalyStore,
+	}
+}
+
+func (ah *AnomalyHandler) HandleGetAnomalies(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.AnomalyQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		ah.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if queryParams.Sensitivity == "" {
+		queryParams.Sensitivity = "medium"
+	}
+
+	if !store.IsValidAnomalySensitivity(queryParams.Sensitivity) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "sensitivity must be one of low, medium, high"})
+		return
+	}
+
+	for _, date := range []*string{queryParams.StartDate, queryParams.EndDate} {
+		if date == nil {
+			continue
+		}
+
+		_, err = time.Parse("2006-01-02", *date)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "dates must be formatted as YYYY-MM-DD"})
+			return
+		}
+	}
+
+	queryParams.Timezone = user.Timezone
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		ah.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	anomalies, err := ah.anomalyStore.ListAnomalies(ledger.ID, queryParams)
+	if errors.Is(err, store.ErrInvalidAnomalyRange) || errors.Is(err, store.ErrAnomalyRangeTooLong) || errors.Is(err, store.ErrAnomalyLookbackRange) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ah.logger.Printf("ERROR: ListAnomalies: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": anomalies,
+	})
+}
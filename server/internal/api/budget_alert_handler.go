@@ -0,0 +1,103 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+)
+
+type BudgetAlertHandler struct {
+	logger           *log.Logger
+	budgetAlertStore store.BudgetAlertStore
+}
+
+func NewBudgetAlertHandler(logger *log.Logger, budgetAlertStore store.BudgetAlertStore) *BudgetAlertHandler {
+	return &BudgetAlertHandler{
+		logger,
+		budgetAlertStore,
+	}
+}
+
+func (bh *BudgetAlertHandler) HandleGetAlertThresholds(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	thresholds, err := bh.budgetAlertStore.ListAlertThresholds(ledger.ID)
+	if err != nil {
+		bh.logger.Printf("ERROR: ListAlertThresholds: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data":     thresholds,
+		"defaults": store.DefaultAlertThresholds,
+	})
+}
+
+func (bh *BudgetAlertHandler) HandleSetAlertThresholds(w http.ResponseWriter, r *http.Request) {
+	var thresholds store.AlertThresholds
+
+	err := utils.ReadRequestBody(r, &thresholds)
+	if err != nil {
+		bh.logger.Printf("ERROR: decoding set alert thresholds request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if thresholds.Thresholds == nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "thresholds is required, use an empty list to turn alerts off"})
+		return
+	}
+
+	for _, threshold := range thresholds.Thresholds {
+		if threshold < 1 || threshold > 1000 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "thresholds must be percentages between 1 and 1000"})
+			return
+		}
+	}
+
+	ledger := middleware.GetLedger(r)
+	thresholds.LedgerID = ledger.ID
+
+	updatedThresholds, err := bh.budgetAlertStore.SetAlertThresholds(&thresholds)
+	if errors.Is(err, store.ErrCategoryNotFound) {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	if err != nil {
+		bh.logger.Printf("ERROR: SetAlertThresholds: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": updatedThresholds,
+	})
+}
+
+func (bh *BudgetAlertHandler) HandleGetBudgetAlerts(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.BudgetAlertQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		bh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	alerts, err := bh.budgetAlertStore.ListBudgetAlerts(ledger.ID, queryParams)
+	if err != nil {
+		bh.logger.Printf("ERROR: ListBudgetAlerts: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": alerts,
+	})
+}
@@ -0,0 +1,247 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type BudgetGroupHandler struct {
+	logger           *log.Logger
+	budgetGroupStore store.BudgetGroupStore
+}
+
+func NewBudgetGroupHandler(logger *log.Logger, budgetGroupStore store.BudgetGroupStore) *BudgetGroupHandler {
+	return &BudgetGroupHandler{
+		logger,
+		budgetGroupStore,
+	}
+}
+
+type setMonthlyLimitRequest struct {
+	Amount *float64 `json:"amount"`
+}
+
+func (bh *BudgetGroupHandler) HandleGetMonthlyLimit(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	amount, err := bh.budgetGroupStore.GetMonthlyLimit(ledger.ID)
+	if err != nil {
+		bh.logger.Printf("ERROR: GetMonthlyLimit: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": setMonthlyLimitRequest{Amount: amount},
+	})
+}
+
+func (bh *BudgetGroupHandler) HandleSetMonthlyLimit(w http.ResponseWriter, r *http.Request) {
+	var req setMonthlyLimitRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		bh.logger.Printf("ERROR: decoding set monthly limit request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.Amount != nil && *req.Amount < 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "amount must not be negative"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	err = bh.budgetGroupStore.SetMonthlyLimit(ledger.ID, req.Amount)
+	if err != nil {
+		bh.logger.Printf("ERROR: SetMonthlyLimit: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": req,
+	})
+}
+
+func (bh *BudgetGroupHandler) HandleCreateBudgetGroup(w http.ResponseWriter, r *http.Request) {
+	var group store.BudgetGroup
+
+	err := utils.ReadRequestBody(r, &group)
+	if err != nil {
+		bh.logger.Printf("ERROR: decoding create budget group request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if group.Name == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "name is required"})
+		return
+	}
+
+	if group.Amount < 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "amount must not be negative"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+	group.LedgerID = ledger.ID
+
+	createdGroup, err := bh.budgetGroupStore.CreateBudgetGroup(&group)
+	if errors.Is(err, store.ErrCategoryNotFound) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	if err != nil {
+		bh.logger.Printf("ERROR: CreateBudgetGroup: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": createdGroup,
+	})
+}
+
+func (bh *BudgetGroupHandler) HandleUpdateBudgetGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		bh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var group store.BudgetGroup
+
+	err = utils.ReadRequestBody(r, &group)
+	if err != nil {
+		bh.logger.Printf("ERROR: decoding update budget group request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if group.Name == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "name is required"})
+		return
+	}
+
+	if group.Amount < 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "amount must not be negative"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+	group.ID = int(id)
+	group.LedgerID = ledger.ID
+
+	updatedGroup, err := bh.budgetGroupStore.UpdateBudgetGroup(&group)
+	if errors.Is(err, store.ErrCategoryNotFound) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	if err != nil {
+		bh.logger.Printf("ERROR: UpdateBudgetGroup: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if updatedGroup == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "budget group not found"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": updatedGroup,
+	})
+}
+
+func (bh *BudgetGroupHandler) HandleGetAllBudgetGroups(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	groups, err := bh.budgetGroupStore.ListBudgetGroups(ledger.ID)
+	if err != nil {
+		bh.logger.Printf("ERROR: ListBudgetGroups: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": groups,
+	})
+}
+
+func (bh *BudgetGroupHandler) HandleDeleteBudgetGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		bh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	deleted, err := bh.budgetGroupStore.DeleteBudgetGroup(ledger.ID, id)
+	if err != nil {
+		bh.logger.Printf("ERROR: DeleteBudgetGroup: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "budget group not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (bh *BudgetGroupHandler) HandleGetBudgetStatus(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.BudgetStatusQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		bh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	for _, date := range []*string{queryParams.StartDate, queryParams.EndDate} {
+		if date == nil {
+			continue
+		}
+
+		_, err = time.Parse("2006-01-02", *date)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "dates must be formatted as YYYY-MM-DD"})
+			return
+		}
+	}
+
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		bh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	status, err := bh.budgetGroupStore.BudgetStatus(ledger.ID, queryParams)
+	if err != nil {
+		bh.logger.Printf("ERROR: BudgetStatus: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": status,
+	})
+}
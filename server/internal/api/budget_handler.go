@@ -0,0 +1,152 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type BudgetHandler struct {
+	logger      *log.Logger
+	budgetStore store.BudgetStore
+}
+
+func NewBudgetHandler(logger *log.Logger, budgetStore store.BudgetStore) *BudgetHandler {
+	return &BudgetHandler{
+		logger,
+		budgetStore,
+	}
+}
+
+type setBudgetRequest struct {
+	CategoryID   int     `json:"category_id"`
+	Cadence      string  `json:"cadence"`
+	Period       string  `json:"period"`
+	Amount       float64 `json:"amount"`
+	CarryForward *bool   `json:"carry_forward"`
+}
+
+// parseBudgetPeriod accepts either a month (YYYY-MM) or any date inside the
+// period (YYYY-MM-DD).
+func parseBudgetPeriod(period string) (time.Time, error) {
+	date, err := time.Parse("2006-01", period)
+	if err == nil {
+		return date, nil
+	}
+
+	date, err = time.Parse("2006-01-02", period)
+	if err != nil {
+		return time.Time{}, errors.New("period must be formatted as YYYY-MM or YYYY-MM-DD")
+	}
+
+	return date, nil
+}
+
+func (bh *BudgetHandler) HandleSetBudget(w http.ResponseWriter, r *http.Request) {
+	var req setBudgetRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		bh.logger.Printf("ERROR: decoding set budget request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.Cadence != "" && !store.IsValidBudgetCadence(req.Cadence) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "cadence must be one of weekly, monthly, yearly"})
+		return
+	}
+
+	if req.Amount < 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "amount must not be negative"})
+		return
+	}
+
+	period, err := parseBudgetPeriod(req.Period)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	carryForward := true
+	if req.CarryForward != nil {
+		carryForward = *req.CarryForward
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	budget, err := bh.budgetStore.SetBudget(&store.Budget{
+		CategoryID:   req.CategoryID,
+		Cadence:      req.Cadence,
+		PeriodStart:  period.Format("2006-01-02"),
+		Amount:       req.Amount,
+		CarryForward: carryForward,
+		LedgerID:     ledger.ID,
+	})
+	if errors.Is(err, store.ErrCategoryNotFound) {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	if err != nil {
+		bh.logger.Printf("ERROR: SetBudget: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": budget,
+	})
+}
+
+func (bh *BudgetHandler) HandleGetAllBudgets(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.BudgetQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		bh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	budgets, err := bh.budgetStore.ListBudgets(ledger.ID, queryParams)
+	if err != nil {
+		bh.logger.Printf("ERROR: ListBudgets: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": budgets,
+	})
+}
+
+func (bh *BudgetHandler) HandleDeleteBudget(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		bh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	deleted, err := bh.budgetStore.DeleteBudget(ledger.ID, id)
+	if err != nil {
+		bh.logger.Printf("ERROR: DeleteBudget: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "budget not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
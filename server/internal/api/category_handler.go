@@ -1,9 +1,11 @@
 package api
 
 import (
+	"cha-ching-server/internal/events"
 	"cha-ching-server/internal/middleware"
 	"cha-ching-server/internal/store"
 	"cha-ching-server/internal/utils"
+	"errors"
 	"log"
 	"net/http"
 )
@@ -11,12 +13,14 @@ import (
 type CategoryHandler struct {
 	logger        *log.Logger
 	categoryStore store.CategoryStore
+	publisher     events.Publisher
 }
 
-func NewCategoryHandler(logger *log.Logger, categoryStore store.CategoryStore) *CategoryHandler {
+func NewCategoryHandler(logger *log.Logger, categoryStore store.CategoryStore, publisher events.Publisher) *CategoryHandler {
 	return &CategoryHandler{
 		logger,
 		categoryStore,
+		publisher,
 	}
 }
 
@@ -30,35 +34,86 @@ func (ch *CategoryHandler) HandleCreateCategory(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	if category.BudgetCadence != "" && !store.IsValidBudgetCadence(category.BudgetCadence) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "budget_cadence must be one of weekly, monthly, yearly"})
+		return
+	}
+
+	if category.TaxSection != nil && !store.IsValidTaxSection(*category.TaxSection) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "tax_section must be one of 80C, 80D, 80E, 80G, business"})
+		return
+	}
+
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 	category.UserID = user.ID
+	category.LedgerID = ledger.ID
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		ch.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	createdCategory, err := ch.categoryStore.CreateCategory(&category, today)
+	if errors.Is(err, store.ErrInvalidParentCategory) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
 
-	createdCategory, err := ch.categoryStore.CreateCategory(&category)
 	if err != nil {
 		ch.logger.Printf("ERROR: CreateCategory: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
 
+	ch.publisher.Publish(ledger.ID, store.WebhookEventCategoryCreated, createdCategory)
+
 	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
 		"data": createdCategory,
 	})
 }
 
+// HandleUpdateCategory changes only the fields present in the body. Sending
+// null for parent_id or tax_section clears it.
 func (ch *CategoryHandler) HandleUpdateCategory(w http.ResponseWriter, r *http.Request) {
-	var category store.Category
+	var update store.CategoryUpdate
 
-	err := utils.ReadRequestBody(r, &category)
+	err := utils.ReadRequestBody(r, &update)
 	if err != nil {
 		ch.logger.Printf("ERROR: decoding update category request body: %v", err)
 		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
 		return
 	}
 
+	if update.BudgetCadence != nil && !store.IsValidBudgetCadence(*update.BudgetCadence) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "budget_cadence must be one of weekly, monthly, yearly"})
+		return
+	}
+
+	if update.TaxSection.Value != nil && !store.IsValidTaxSection(*update.TaxSection.Value) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "tax_section must be one of 80C, 80D, 80E, 80G, business"})
+		return
+	}
+
 	user := middleware.GetUser(r)
-	category.UserID = user.ID
+	ledger := middleware.GetLedger(r)
+	update.LedgerID = ledger.ID
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		ch.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	updatedCategory, err := ch.categoryStore.UpdateCategory(&update, today)
+	if errors.Is(err, store.ErrInvalidParentCategory) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
 
-	updatedCategory, err := ch.categoryStore.UpdateCategory(&category)
 	if err != nil {
 		ch.logger.Printf("ERROR: UpdateCategory: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
@@ -70,6 +125,8 @@ func (ch *CategoryHandler) HandleUpdateCategory(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	ch.publisher.Publish(ledger.ID, store.WebhookEventCategoryUpdated, updatedCategory)
+
 	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
 		"data": updatedCategory,
 	})
@@ -77,8 +134,24 @@ func (ch *CategoryHandler) HandleUpdateCategory(w http.ResponseWriter, r *http.R
 
 func (ch *CategoryHandler) HandleGetAllCategories(w http.ResponseWriter, r *http.Request) {
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 
-	categories, err := ch.categoryStore.ListCategories(user.ID)
+	var queryParams store.CategoryQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		ch.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		ch.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	categories, err := ch.categoryStore.ListCategories(ledger.ID, queryParams)
 
 	if err != nil {
 		ch.logger.Printf("ERROR: ListCategories: %v", err)
@@ -91,8 +164,40 @@ func (ch *CategoryHandler) HandleGetAllCategories(w http.ResponseWriter, r *http
 	})
 }
 
+func (ch *CategoryHandler) HandleGetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.CategoryQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		ch.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		ch.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	tree, err := ch.categoryStore.ListCategoryTree(ledger.ID, queryParams)
+	if err != nil {
+		ch.logger.Printf("ERROR: ListCategoryTree: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": tree,
+	})
+}
+
 func (ch *CategoryHandler) HandleGetCategoryStats(w http.ResponseWriter, r *http.Request) {
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 
 	var queryParams store.CategoryStatQueryParams
 	err := utils.QueryParamsDecoder(r, &queryParams)
@@ -102,7 +207,14 @@ func (ch *CategoryHandler) HandleGetCategoryStats(w http.ResponseWriter, r *http
 		return
 	}
 
-	stats, err := ch.categoryStore.CategoryStats(user.ID, queryParams)
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		ch.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	stats, err := ch.categoryStore.CategoryStats(ledger.ID, queryParams)
 	if err != nil {
 		ch.logger.Printf("ERROR: CategoryStats: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
@@ -113,3 +225,131 @@ func (ch *CategoryHandler) HandleGetCategoryStats(w http.ResponseWriter, r *http
 		"data": stats,
 	})
 }
+
+type archiveCategoryRequest struct {
+	Archived bool `json:"archived"`
+}
+
+type reassignCategoryRequest struct {
+	TargetCategoryID int `json:"target_category_id"`
+}
+
+func (ch *CategoryHandler) HandleArchiveCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ch.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req archiveCategoryRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		ch.logger.Printf("ERROR: decoding archive category request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	updated, err := ch.categoryStore.SetCategoryArchived(ledger.ID, id, req.Archived)
+	if err != nil {
+		ch.logger.Printf("ERROR: SetCategoryArchived: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !updated {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	ch.publisher.Publish(ledger.ID, store.WebhookEventCategoryArchived, map[string]any{"id": id, "archived": req.Archived})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeleteCategory requires a target category for the expenses of the
+// deleted one, so no expense is ever left without a category.
+func (ch *CategoryHandler) HandleDeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ch.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req reassignCategoryRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		ch.logger.Printf("ERROR: decoding delete category request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "target_category_id is required"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	deleted, err := ch.categoryStore.DeleteCategory(ledger.ID, id, req.TargetCategoryID)
+	if errors.Is(err, store.ErrInvalidTargetCategory) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		ch.logger.Printf("ERROR: DeleteCategory: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	ch.publisher.Publish(ledger.ID, store.WebhookEventCategoryDeleted, map[string]any{"id": id, "target_category_id": req.TargetCategoryID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (ch *CategoryHandler) HandleMergeCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ch.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req reassignCategoryRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		ch.logger.Printf("ERROR: decoding merge category request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	merged, err := ch.categoryStore.MergeCategories(ledger.ID, id, req.TargetCategoryID)
+	if errors.Is(err, store.ErrInvalidTargetCategory) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "target must be another category in the same ledger that is not one of its descendants"})
+		return
+	}
+
+	if err != nil {
+		ch.logger.Printf("ERROR: MergeCategories: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !merged {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	ch.publisher.Publish(ledger.ID, store.WebhookEventCategoryMerged, map[string]any{"id": id, "target_category_id": req.TargetCategoryID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type discardPublisher struct{}
+
+func (discardPublisher) Publish(ledgerID int, eventType string, data any) {}
+
+type recordingCategoryStore struct {
+	store.CategoryStore
+	update *store.CategoryUpdate
+}
+
+func (s *recordingCategoryStore) UpdateCategory(update *store.CategoryUpdate, today time.Time) (*store.Category, error) {
+	s.update = update
+	return &store.Category{ID: update.ID}, nil
+}
+
+func updateCategory(t *testing.T, body string) (*store.CategoryUpdate, int) {
+	t.Helper()
+
+	categoryStore := &recordingCategoryStore{}
+	handler := NewCategoryHandler(log.New(io.Discard, "", 0), categoryStore, discardPublisher{})
+
+	r := httptest.NewRequest(http.MethodPut, "/categories", strings.NewReader(body))
+	r = middleware.SetUser(r, &store.User{ID: 1, Timezone: "Asia/Kolkata"})
+	r = middleware.SetLedger(r, &store.Ledger{ID: 2})
+
+	w := httptest.NewRecorder()
+	handler.HandleUpdateCategory(w, r)
+
+	return categoryStore.update, w.Code
+}
+
+func TestUpdateCategoryOnlyChangesFieldsSent(t *testing.T) {
+	// What the client sends when a category is renamed
+	update, status := updateCategory(t, `{"id": 5, "name": "Groceries", "budget": 4000}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+
+	if update.ID != 5 || update.LedgerID != 2 || *update.Name != "Groceries" || *update.Budget != 4000 {
+		t.Errorf("update = %+v", update)
+	}
+	if update.ParentID.Set || update.TaxSection.Set || update.BudgetCadence != nil {
+		t.Errorf("rename changes parent %v, tax section %v or cadence %v", update.ParentID.Set, update.TaxSection.Set, update.BudgetCadence)
+	}
+}
+
+func TestUpdateCategoryClearsNullFields(t *testing.T) {
+	update, status := updateCategory(t, `{"id": 5, "parent_id": null, "tax_section": null}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+
+	if update.Name != nil || update.Budget != nil {
+		t.Errorf("name %v and budget %v were not sent", update.Name, update.Budget)
+	}
+	if !update.ParentID.Set || update.ParentID.Value != nil || !update.TaxSection.Set || update.TaxSection.Value != nil {
+		t.Errorf("parent %+v and tax section %+v should be cleared", update.ParentID, update.TaxSection)
+	}
+}
+
+func TestUpdateCategoryValidates(t *testing.T) {
+	for _, body := range []string{
+		`{"id": 5, "budget_cadence": "daily"}`,
+		`{"id": 5, "budget_cadence": ""}`,
+		`{"id": 5, "tax_section": "80Z"}`,
+	} {
+		if update, status := updateCategory(t, body); status != http.StatusBadRequest || update != nil {
+			t.Errorf("%s: status = %d, want 400 without an update", body, status)
+		}
+	}
+}
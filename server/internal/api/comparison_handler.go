@@ -0,0 +1,88 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"log"
+	"net/http"
+	"time"
+)
+
+type ComparisonHandler struct {
+	logger          *log.Logger
+	comparisonStore store.ComparisonStore
+}
+
+func NewComparisonHandler(logger *log.Logger, comparisonStore store.ComparisonStore) *ComparisonHandler {
+	return &ComparisonHandler{
+		logger,
+		comparisonStore,
+	}
+}
+
+func (ch *ComparisonHandler) HandleGetComparison(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.ComparisonQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		ch.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	var current, previous store.DateRange
+
+	if queryParams.Preset != nil {
+		anchor, err := utils.TodayIn(user.Timezone)
+		if err != nil {
+			ch.logger.Printf("ERROR: TodayIn: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+
+		if queryParams.Date != nil {
+			anchor, err = time.Parse("2006-01-02", *queryParams.Date)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "date must be formatted as YYYY-MM-DD"})
+				return
+			}
+		}
+
+		current, previous, err = store.ResolveComparisonPreset(*queryParams.Preset, anchor)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
+	} else {
+		dates := []*string{queryParams.StartDate, queryParams.EndDate, queryParams.PreviousStartDate, queryParams.PreviousEndDate}
+		for _, date := range dates {
+			if date == nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "either preset or start_date, end_date, previous_start_date and previous_end_date are required"})
+				return
+			}
+
+			_, err = time.Parse("2006-01-02", *date)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "dates must be formatted as YYYY-MM-DD"})
+				return
+			}
+		}
+
+		current = store.DateRange{StartDate: *queryParams.StartDate, EndDate: *queryParams.EndDate}
+		previous = store.DateRange{StartDate: *queryParams.PreviousStartDate, EndDate: *queryParams.PreviousEndDate}
+	}
+
+	comparison, err := ch.comparisonStore.ComparePeriods(ledger.ID, current, previous)
+	if err != nil {
+		ch.logger.Printf("ERROR: ComparePeriods: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": comparison,
+	})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+)
+
+type DigestHandler struct {
+	logger      *log.Logger
+	digestStore store.DigestStore
+}
+
+func NewDigestHandler(logger *log.Logger, digestStore store.DigestStore) *DigestHandler {
+	return &DigestHandler{
+		logger,
+		digestStore,
+	}
+}
+
+type setDigestPreferenceRequest struct {
+	LedgerID  int    `json:"ledger_id"`
+	Frequency string `json:"frequency"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+func (req *setDigestPreferenceRequest) validate() string {
+	if req.LedgerID <= 0 {
+		return "ledger_id is required"
+	}
+
+	if !store.IsValidDigestFrequency(req.Frequency) {
+		return "frequency must be one of weekly, monthly"
+	}
+
+	return ""
+}
+
+// HandleGetDigestPreference returns a disabled preference for users that
+// never opted in.
+func (dh *DigestHandler) HandleGetDigestPreference(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	preference, err := dh.digestStore.GetDigestPreference(user.ID)
+	if err != nil {
+		dh.logger.Printf("ERROR: GetDigestPreference: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if preference == nil {
+		preference = &store.DigestPreference{Frequency: store.DigestFrequencyWeekly}
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": preference,
+	})
+}
+
+func (dh *DigestHandler) HandleSetDigestPreference(w http.ResponseWriter, r *http.Request) {
+	var req setDigestPreferenceRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		dh.logger.Printf("ERROR: decoding set digest preference request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if message := req.validate(); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	preference := &store.DigestPreference{
+		UserID:    user.ID,
+		LedgerID:  req.LedgerID,
+		Frequency: req.Frequency,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+	}
+
+	saved, err := dh.digestStore.SetDigestPreference(preference)
+	if errors.Is(err, store.ErrInvalidDigestLedger) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		dh.logger.Printf("ERROR: SetDigestPreference: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": saved,
+	})
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type EnvelopeHandler struct {
+	logger        *log.Logger
+	envelopeStore store.EnvelopeStore
+}
+
+func NewEnvelopeHandler(logger *log.Logger, envelopeStore store.EnvelopeStore) *EnvelopeHandler {
+	return &EnvelopeHandler{
+		logger,
+		envelopeStore,
+	}
+}
+
+type updateEnvelopeSettingsRequest struct {
+	Enabled  bool   `json:"enabled"`
+	Rollover string `json:"rollover"`
+}
+
+type moveMoneyRequest struct {
+	FromCategoryID int     `json:"from_category_id"`
+	ToCategoryID   int     `json:"to_category_id"`
+	Month          string  `json:"month"`
+	Amount         float64 `json:"amount"`
+	Note           string  `json:"note"`
+}
+
+func (eh *EnvelopeHandler) HandleUpdateEnvelopeSettings(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		eh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req updateEnvelopeSettingsRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		eh.logger.Printf("ERROR: decoding envelope settings request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.Rollover == "" {
+		req.Rollover = store.RolloverNone
+	}
+
+	if !store.IsValidRollover(req.Rollover) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "rollover must be one of none, positive, both"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	settings, err := eh.envelopeStore.UpdateEnvelopeSettings(&store.EnvelopeSettings{
+		CategoryID: int(id),
+		Enabled:    req.Enabled,
+		Rollover:   req.Rollover,
+		LedgerID:   ledger.ID,
+	})
+	if err != nil {
+		eh.logger.Printf("ERROR: UpdateEnvelopeSettings: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if settings == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "category not found"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": settings,
+	})
+}
+
+func (eh *EnvelopeHandler) HandleGetEnvelopes(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.EnvelopeQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		eh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if queryParams.Month != nil {
+		_, err = time.Parse("2006-01", *queryParams.Month)
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "month must be formatted as YYYY-MM"})
+			return
+		}
+	}
+
+	if queryParams.Months != nil && (*queryParams.Months < 1 || *queryParams.Months > 24) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "months must be between 1 and 24"})
+		return
+	}
+
+	queryParams.Timezone = user.Timezone
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		eh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	envelopes, err := eh.envelopeStore.ListEnvelopes(ledger.ID, queryParams)
+	if err != nil {
+		eh.logger.Printf("ERROR: ListEnvelopes: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": envelopes,
+	})
+}
+
+func (eh *EnvelopeHandler) HandleMoveMoney(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var req moveMoneyRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		eh.logger.Printf("ERROR: decoding move money request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.FromCategoryID == req.ToCategoryID {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "from_category_id and to_category_id must differ"})
+		return
+	}
+
+	if req.Amount <= 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "amount must be greater than zero"})
+		return
+	}
+
+	month, err := time.Parse("2006-01", req.Month)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "month must be formatted as YYYY-MM"})
+		return
+	}
+
+	allocations, err := eh.envelopeStore.MoveMoney(&store.EnvelopeTransfer{
+		FromCategoryID: req.FromCategoryID,
+		ToCategoryID:   req.ToCategoryID,
+		Month:          month.Format("2006-01-02"),
+		Amount:         req.Amount,
+		Note:           req.Note,
+		LedgerID:       ledger.ID,
+		UserID:         user.ID,
+	})
+	if errors.Is(err, store.ErrEnvelopeNotEnabled) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		eh.logger.Printf("ERROR: MoveMoney: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": allocations,
+	})
+}
+
+func (eh *EnvelopeHandler) HandleGetAllocations(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	month, err := time.Parse("2006-01", r.URL.Query().Get("month"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "month must be formatted as YYYY-MM"})
+		return
+	}
+
+	allocations, err := eh.envelopeStore.ListAllocations(ledger.ID, month.Format("2006-01-02"))
+	if err != nil {
+		eh.logger.Printf("ERROR: ListAllocations: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": allocations,
+	})
+}
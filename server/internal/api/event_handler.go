@@ -0,0 +1,90 @@
+package api
+
+import (
+	"cha-ching-server/internal/events"
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// heartbeatInterval keeps idle streams from being closed by proxies.
+const heartbeatInterval = 15 * time.Second
+
+type EventHandler struct {
+	logger *log.Logger
+	broker *events.Broker
+}
+
+func NewEventHandler(logger *log.Logger, broker *events.Broker) *EventHandler {
+	return &EventHandler{
+		logger,
+		broker,
+	}
+}
+
+// HandleGetEvents streams the changes to every ledger of the user as
+// Server-Sent Events. Each event is named after its type and carries the
+// event JSON, including the changed record when it fits.
+func (eh *EventHandler) HandleGetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "streaming unsupported"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	subscription, err := eh.broker.Subscribe(user.ID)
+	if err != nil {
+		eh.logger.Printf("ERROR: Subscribe: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	defer eh.broker.Unsubscribe(subscription)
+
+	// The stream outlives the server write timeout
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		eh.logger.Printf("ERROR: SetWriteDeadline: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				eh.logger.Printf("ERROR: encoding event: %v", err)
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+		}
+
+		flusher.Flush()
+	}
+}
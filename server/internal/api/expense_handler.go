@@ -1,25 +1,101 @@
 package api
 
 import (
+	"cha-ching-server/internal/events"
 	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/notifier"
 	"cha-ching-server/internal/store"
 	"cha-ching-server/internal/utils"
+	"errors"
 	"log"
 	"net/http"
 )
 
 type ExpenseHandler struct {
-	logger       *log.Logger
-	expenseStore store.ExpenseStore
+	logger           *log.Logger
+	expenseStore     store.ExpenseStore
+	anomalyStore     store.AnomalyStore
+	budgetAlertStore store.BudgetAlertStore
+	statementStore   store.StatementStore
+	dispatcher       *notifier.Dispatcher
+	publisher        events.Publisher
 }
 
-func NewExpenseHandler(logger *log.Logger, expenseStore store.ExpenseStore) *ExpenseHandler {
+func NewExpenseHandler(logger *log.Logger, expenseStore store.ExpenseStore, anomalyStore store.AnomalyStore, budgetAlertStore store.BudgetAlertStore, statementStore store.StatementStore, dispatcher *notifier.Dispatcher, publisher events.Publisher) *ExpenseHandler {
 	return &ExpenseHandler{
 		logger,
 		expenseStore,
+		anomalyStore,
+		budgetAlertStore,
+		statementStore,
+		dispatcher,
+		publisher,
 	}
 }
 
+// notifyBudgetAlerts checks the budgets affected by a saved expense and sends
+// any newly crossed thresholds. The expense is already saved, so failures are
+// only logged.
+func notifyBudgetAlerts(logger *log.Logger, budgetAlertStore store.BudgetAlertStore, dispatcher *notifier.Dispatcher, expense *store.Expense, timezone string) {
+	alerts, err := budgetAlertStore.EvaluateBudgetAlerts(expense, timezone)
+	if err != nil {
+		logger.Printf("ERROR: EvaluateBudgetAlerts: %v", err)
+		return
+	}
+
+	notifications := make([]*notifier.Notification, 0, len(alerts))
+	for _, alert := range alerts {
+		notifications = append(notifications, notifier.NewBudgetAlertNotification(alert))
+	}
+
+	dispatcher.Dispatch(expense.LedgerID, notifications)
+}
+
+// applyStatementCycle replaces the date range with the window of a credit
+// card statement when a cycle is asked for. It writes the error response and
+// returns false when the cycle cannot be resolved.
+func (eh *ExpenseHandler) applyStatementCycle(w http.ResponseWriter, r *http.Request, cycle *string, paymentMethodID *int, startDate **string, endDate **string) bool {
+	if cycle == nil {
+		return true
+	}
+
+	if !store.IsValidStatementCycle(*cycle) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "cycle must be one of current, previous"})
+		return false
+	}
+
+	if paymentMethodID == nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "payment_method_id is required with cycle"})
+		return false
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		eh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return false
+	}
+
+	statement, err := eh.statementStore.GetStatement(ledger.ID, *paymentMethodID, *cycle, today, user.Timezone)
+	if errors.Is(err, store.ErrPaymentMethodNotFound) || errors.Is(err, store.ErrNotStatementCard) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return false
+	}
+
+	if err != nil {
+		eh.logger.Printf("ERROR: GetStatement: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return false
+	}
+
+	*startDate = &statement.StartDate
+	*endDate = &statement.EndDate
+	return true
+}
+
 func (eh *ExpenseHandler) HandleCreateExpense(w http.ResponseWriter, r *http.Request) {
 	var expense store.Expense
 
@@ -30,8 +106,15 @@ func (eh *ExpenseHandler) HandleCreateExpense(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if expense.TaxSection != nil && !store.IsValidTaxSection(*expense.TaxSection) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "tax_section must be one of 80C, 80D, 80E, 80G, business"})
+		return
+	}
+
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 	expense.UserID = user.ID
+	expense.LedgerID = ledger.ID
 
 	createdExpense, err := eh.expenseStore.CreateExpense(&expense)
 	if err != nil {
@@ -40,8 +123,19 @@ func (eh *ExpenseHandler) HandleCreateExpense(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	// The expense is already saved, so a failed check only drops the warnings
+	warnings, err := eh.anomalyStore.CheckExpense(createdExpense, user.Timezone, "medium")
+	if err != nil {
+		eh.logger.Printf("ERROR: CheckExpense: %v", err)
+		warnings = []*store.Anomaly{}
+	}
+
+	notifyBudgetAlerts(eh.logger, eh.budgetAlertStore, eh.dispatcher, createdExpense, user.Timezone)
+	eh.publisher.Publish(ledger.ID, store.WebhookEventExpenseCreated, createdExpense)
+
 	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
-		"data": createdExpense,
+		"data":     createdExpense,
+		"warnings": warnings,
 	})
 }
 
@@ -63,11 +157,22 @@ func (eh *ExpenseHandler) HandleUpdateExpense(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if expense.TaxSection != nil && !store.IsValidTaxSection(*expense.TaxSection) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "tax_section must be one of 80C, 80D, 80E, 80G, business"})
+		return
+	}
+
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 	expense.UserID = user.ID
+	expense.LedgerID = ledger.ID
 
 	updatedExpense, err := eh.expenseStore.UpdateExpense(id, &expense)
-	if updatedExpense == nil {
+	if errors.Is(err, store.ErrCategoryNotFound) || errors.Is(err, store.ErrPaymentMethodNotFound) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if updatedExpense == nil && err == nil {
 		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "expense not found"})
 		return
 	}
@@ -77,13 +182,16 @@ func (eh *ExpenseHandler) HandleUpdateExpense(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	notifyBudgetAlerts(eh.logger, eh.budgetAlertStore, eh.dispatcher, updatedExpense, user.Timezone)
+	eh.publisher.Publish(ledger.ID, store.WebhookEventExpenseUpdated, updatedExpense)
+
 	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
 		"data": updatedExpense,
 	})
 }
 
 func (eh *ExpenseHandler) HandleGetAllExpenses(w http.ResponseWriter, r *http.Request) {
-	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 
 	queryParams := store.ExpenseQueryParams{}
 	err := utils.QueryParamsDecoder(r, &queryParams)
@@ -93,9 +201,13 @@ func (eh *ExpenseHandler) HandleGetAllExpenses(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	expenses, paginationData, relatedItems, metaItems, err := eh.expenseStore.ListExpensesByUserID(user.ID, queryParams)
+	if !eh.applyStatementCycle(w, r, queryParams.Cycle, queryParams.PaymentMethodID, &queryParams.StartDate, &queryParams.EndDate) {
+		return
+	}
+
+	expenses, paginationData, relatedItems, metaItems, err := eh.expenseStore.ListExpensesByLedgerID(ledger.ID, queryParams)
 	if err != nil {
-		eh.logger.Printf("ERROR: ListExpensesByUserID: %v", err)
+		eh.logger.Printf("ERROR: ListExpensesByLedgerID: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
@@ -109,7 +221,7 @@ func (eh *ExpenseHandler) HandleGetAllExpenses(w http.ResponseWriter, r *http.Re
 }
 
 func (eh *ExpenseHandler) HandleGetExpensesTotalPerDay(w http.ResponseWriter, r *http.Request) {
-	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 
 	queryParams := store.ExpenseTotalPerDayQueryParams{}
 	err := utils.QueryParamsDecoder(r, &queryParams)
@@ -119,7 +231,11 @@ func (eh *ExpenseHandler) HandleGetExpensesTotalPerDay(w http.ResponseWriter, r
 		return
 	}
 
-	expenses, metaItems, err := eh.expenseStore.ListExpensesTotalPerDay(user.ID, queryParams)
+	if !eh.applyStatementCycle(w, r, queryParams.Cycle, queryParams.PaymentMethodID, &queryParams.StartDate, &queryParams.EndDate) {
+		return
+	}
+
+	expenses, metaItems, err := eh.expenseStore.ListExpensesTotalPerDay(ledger.ID, queryParams)
 	if err != nil {
 		eh.logger.Printf("ERROR: ListExpensesTotalPerDay: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
@@ -132,8 +248,100 @@ func (eh *ExpenseHandler) HandleGetExpensesTotalPerDay(w http.ResponseWriter, r
 	})
 }
 
-func (eh *ExpenseHandler) HandleSearchExpensesByTitle(w http.ResponseWriter, r *http.Request) {
+func (eh *ExpenseHandler) HandleGetExpensesTimeSeries(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	queryParams := store.ExpenseTimeSeriesQueryParams{}
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		eh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if !eh.applyStatementCycle(w, r, queryParams.Cycle, queryParams.PaymentMethodID, &queryParams.StartDate, &queryParams.EndDate) {
+		return
+	}
+
+	if queryParams.Granularity == "" {
+		queryParams.Granularity = "day"
+	}
+
+	if !store.IsValidTimeSeriesGranularity(queryParams.Granularity) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "granularity must be one of day, week, month, quarter, year"})
+		return
+	}
+
+	if queryParams.GroupBy != nil && !store.IsValidTimeSeriesGroupBy(*queryParams.GroupBy) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "group_by must be one of category, payment_method"})
+		return
+	}
+
+	queryParams.Timezone = user.Timezone
+
+	timeSeries, metaItems, err := eh.expenseStore.ExpenseTimeSeries(ledger.ID, queryParams)
+	if errors.Is(err, store.ErrTooManyTimeSeriesBuckets) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		eh.logger.Printf("ERROR: ExpenseTimeSeries: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": timeSeries,
+		"meta": metaItems,
+	})
+}
+
+func (eh *ExpenseHandler) HandleGetExpensesHeatmap(w http.ResponseWriter, r *http.Request) {
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	queryParams := store.ExpenseHeatmapQueryParams{}
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		eh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if queryParams.Year == nil {
+		today, err := utils.TodayIn(user.Timezone)
+		if err != nil {
+			eh.logger.Printf("ERROR: TodayIn: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+
+		year := today.Year()
+		queryParams.Year = &year
+	}
+
+	if *queryParams.Year < 1900 || *queryParams.Year > 9999 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid year"})
+		return
+	}
+
+	queryParams.Timezone = user.Timezone
+
+	heatmap, err := eh.expenseStore.ExpenseHeatmap(ledger.ID, queryParams)
+	if err != nil {
+		eh.logger.Printf("ERROR: ExpenseHeatmap: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": heatmap,
+	})
+}
+
+func (eh *ExpenseHandler) HandleSearchExpensesByTitle(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
 
 	query := r.URL.Query().Get("query")
 	if query == "" {
@@ -141,7 +349,7 @@ func (eh *ExpenseHandler) HandleSearchExpensesByTitle(w http.ResponseWriter, r *
 		return
 	}
 
-	expenses, relatedItems, err := eh.expenseStore.SearchExpensesByTitle(user.ID, query)
+	expenses, relatedItems, err := eh.expenseStore.SearchExpensesByTitle(ledger.ID, query)
 	if err != nil {
 		eh.logger.Printf("ERROR: SearchExpensesByTitle: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
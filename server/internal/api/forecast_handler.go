@@ -0,0 +1,58 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+)
+
+type ForecastHandler struct {
+	logger        *log.Logger
+	forecastStore store.ForecastStore
+}
+
+func NewForecastHandler(logger *log.Logger, forecastStore store.ForecastStore) *ForecastHandler {
+	return &ForecastHandler{
+		logger,
+		forecastStore,
+	}
+}
+
+func (fh *ForecastHandler) HandleGetForecast(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.ForecastQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		fh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	queryParams.Timezone = user.Timezone
+	queryParams.Today, err = utils.TodayIn(user.Timezone)
+	if err != nil {
+		fh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	forecast, err := fh.forecastStore.MonthForecast(ledger.ID, queryParams)
+	if errors.Is(err, store.ErrForecastLookbackTooShort) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		fh.logger.Printf("ERROR: MonthForecast: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": forecast,
+	})
+}
@@ -0,0 +1,368 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type GoalHandler struct {
+	logger    *log.Logger
+	goalStore store.GoalStore
+}
+
+func NewGoalHandler(logger *log.Logger, goalStore store.GoalStore) *GoalHandler {
+	return &GoalHandler{
+		logger,
+		goalStore,
+	}
+}
+
+type goalRequest struct {
+	Name            string  `json:"name"`
+	TargetAmount    float64 `json:"target_amount"`
+	StartDate       string  `json:"start_date"`
+	TargetDate      string  `json:"target_date"`
+	PaymentMethodID *int    `json:"payment_method_id"`
+}
+
+type contributionRequest struct {
+	Amount           float64 `json:"amount"`
+	ContributionDate string  `json:"contribution_date"`
+	Note             string  `json:"note"`
+}
+
+// validate checks a goal request and returns a message for the client when it
+// is invalid.
+func (req *goalRequest) validate() string {
+	if req.Name == "" {
+		return "name is required"
+	}
+
+	if req.TargetAmount <= 0 {
+		return "target_amount must be greater than zero"
+	}
+
+	targetDate, err := time.Parse("2006-01-02", req.TargetDate)
+	if err != nil {
+		return "target_date must be formatted as YYYY-MM-DD"
+	}
+
+	if req.StartDate != "" {
+		startDate, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			return "start_date must be formatted as YYYY-MM-DD"
+		}
+
+		if targetDate.Before(startDate) {
+			return "target_date must not be before start_date"
+		}
+	}
+
+	return ""
+}
+
+func (gh *GoalHandler) writeGoalStoreError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, store.ErrPaymentMethodNotFound) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "payment method not found"})
+		return
+	}
+
+	gh.logger.Printf("ERROR: %s: %v", action, err)
+	utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+}
+
+func (gh *GoalHandler) HandleCreateGoal(w http.ResponseWriter, r *http.Request) {
+	var req goalRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		gh.logger.Printf("ERROR: decoding create goal request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if message := req.validate(); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		gh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	goal, err := gh.goalStore.CreateGoal(&store.Goal{
+		Name:            req.Name,
+		TargetAmount:    req.TargetAmount,
+		StartDate:       req.StartDate,
+		TargetDate:      req.TargetDate,
+		PaymentMethodID: req.PaymentMethodID,
+		UserID:          user.ID,
+		LedgerID:        ledger.ID,
+	}, today)
+	if err != nil {
+		gh.writeGoalStoreError(w, "CreateGoal", err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": goal,
+	})
+}
+
+func (gh *GoalHandler) HandleUpdateGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		gh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req goalRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		gh.logger.Printf("ERROR: decoding update goal request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if message := req.validate(); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		gh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	goal, err := gh.goalStore.UpdateGoal(&store.Goal{
+		ID:              int(id),
+		Name:            req.Name,
+		TargetAmount:    req.TargetAmount,
+		StartDate:       req.StartDate,
+		TargetDate:      req.TargetDate,
+		PaymentMethodID: req.PaymentMethodID,
+		UserID:          user.ID,
+		LedgerID:        ledger.ID,
+	}, today)
+	if err != nil {
+		gh.writeGoalStoreError(w, "UpdateGoal", err)
+		return
+	}
+
+	if goal == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": goal,
+	})
+}
+
+func (gh *GoalHandler) HandleGetAllGoals(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		gh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	goals, err := gh.goalStore.ListGoals(ledger.ID, today)
+	if err != nil {
+		gh.logger.Printf("ERROR: ListGoals: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": goals,
+	})
+}
+
+func (gh *GoalHandler) HandleDeleteGoal(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		gh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	deleted, err := gh.goalStore.DeleteGoal(ledger.ID, id)
+	if err != nil {
+		gh.logger.Printf("ERROR: DeleteGoal: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (gh *GoalHandler) HandleAddContribution(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		gh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req contributionRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		gh.logger.Printf("ERROR: decoding add contribution request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.Amount == 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "amount must not be zero, use a negative amount for a withdrawal"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	if req.ContributionDate == "" {
+		today, err := utils.TodayIn(user.Timezone)
+		if err != nil {
+			gh.logger.Printf("ERROR: TodayIn: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		req.ContributionDate = today.Format("2006-01-02")
+	}
+
+	_, err = time.Parse("2006-01-02", req.ContributionDate)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "contribution_date must be formatted as YYYY-MM-DD"})
+		return
+	}
+
+	contribution, err := gh.goalStore.AddContribution(&store.GoalContribution{
+		GoalID:           int(id),
+		Amount:           req.Amount,
+		ContributionDate: req.ContributionDate,
+		Note:             req.Note,
+		UserID:           user.ID,
+		LedgerID:         ledger.ID,
+	})
+	if errors.Is(err, store.ErrGoalNotFound) {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "goal not found"})
+		return
+	}
+
+	if err != nil {
+		gh.logger.Printf("ERROR: AddContribution: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": contribution,
+	})
+}
+
+func (gh *GoalHandler) HandleGetContributions(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		gh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	contributions, err := gh.goalStore.ListContributions(ledger.ID, id)
+	if err != nil {
+		gh.logger.Printf("ERROR: ListContributions: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": contributions,
+	})
+}
+
+func (gh *GoalHandler) HandleDeleteContribution(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		gh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	contributionID, err := utils.ReadInt64URLParam(r, "contributionID")
+	if err != nil {
+		gh.logger.Printf("ERROR: ReadInt64URLParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	deleted, err := gh.goalStore.DeleteContribution(ledger.ID, id, contributionID)
+	if err != nil {
+		gh.logger.Printf("ERROR: DeleteContribution: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "contribution not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (gh *GoalHandler) HandleGetGoalsSummary(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		gh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	summary, err := gh.goalStore.GoalsSummary(ledger.ID, today)
+	if err != nil {
+		gh.logger.Printf("ERROR: GoalsSummary: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": summary,
+	})
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"cha-ching-server/internal/events"
+	"cha-ching-server/internal/graphql"
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/notifier"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// maxGraphQLRequestSize bounds a POSTed body, or the query string of a GET.
+const maxGraphQLRequestSize = 64 << 10
+
+type GraphQLHandler struct {
+	logger             *log.Logger
+	expenseStore       store.ExpenseStore
+	categoryStore      store.CategoryStore
+	paymentMethodStore store.PaymentMethodStore
+	budgetAlertStore   store.BudgetAlertStore
+	dispatcher         *notifier.Dispatcher
+	publisher          events.Publisher
+	schema             *graphql.Schema
+}
+
+func NewGraphQLHandler(logger *log.Logger, expenseStore store.ExpenseStore, categoryStore store.CategoryStore, paymentMethodStore store.PaymentMethodStore, budgetAlertStore store.BudgetAlertStore, dispatcher *notifier.Dispatcher, publisher events.Publisher) *GraphQLHandler {
+	gh := &GraphQLHandler{
+		logger:             logger,
+		expenseStore:       expenseStore,
+		categoryStore:      categoryStore,
+		paymentMethodStore: paymentMethodStore,
+		budgetAlertStore:   budgetAlertStore,
+		dispatcher:         dispatcher,
+		publisher:          publisher,
+	}
+
+	gh.schema = gh.newSchema()
+
+	return gh
+}
+
+type graphQLRequestBody struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+	Extensions    map[string]any `json:"extensions"`
+}
+
+// HandleGraphQL runs a query or mutation against the active ledger. Queries
+// may be sent as GET with the query string parameters query, operationName
+// and variables. Mutations must be POSTed and need editor access.
+func (gh *GraphQLHandler) HandleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var body graphQLRequestBody
+
+	if r.Method == http.MethodGet {
+		if len(r.URL.RawQuery) > maxGraphQLRequestSize {
+			utils.WriteJSONResponse(w, http.StatusRequestEntityTooLarge, utils.Envelope{"error": "request is too large"})
+			return
+		}
+
+		body.Query = r.URL.Query().Get("query")
+		body.OperationName = r.URL.Query().Get("operationName")
+
+		if variables := r.URL.Query().Get("variables"); variables != "" {
+			err := json.Unmarshal([]byte(variables), &body.Variables)
+			if err != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "variables must be a JSON object"})
+				return
+			}
+		}
+	} else {
+		r.Body = http.MaxBytesReader(w, r.Body, maxGraphQLRequestSize)
+
+		err := utils.ReadRequestBody(r, &body)
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.WriteJSONResponse(w, http.StatusRequestEntityTooLarge, utils.Envelope{"error": "request is too large"})
+			return
+		}
+
+		if err != nil {
+			gh.logger.Printf("ERROR: decoding graphql request body: %v", err)
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+			return
+		}
+	}
+
+	if body.Query == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "query is required"})
+		return
+	}
+
+	doc, err := graphql.Parse(body.Query)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"errors": []error{err}})
+		return
+	}
+
+	operation, err := doc.Operation(body.OperationName)
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"errors": []error{err}})
+		return
+	}
+
+	if errs := gh.schema.Validate(doc, operation); len(errs) > 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"errors": errs})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	if operation.Type == "mutation" {
+		if r.Method == http.MethodGet {
+			w.Header().Set("Allow", http.MethodPost)
+			utils.WriteJSONResponse(w, http.StatusMethodNotAllowed, utils.Envelope{"error": "mutations must be sent with POST"})
+			return
+		}
+
+		if !store.LedgerRoleAllows(ledger.Role, store.LedgerRoleEditor) {
+			utils.WriteJSONResponse(w, http.StatusForbidden, utils.Envelope{"error": "you do not have permission to perform this action"})
+			return
+		}
+	}
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		gh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	ctx := gh.newGraphQLRequest(r.Context(), user, ledger, today)
+	result := gh.schema.Execute(ctx, doc, operation, body.Variables)
+
+	response := utils.Envelope{"data": result.Data}
+	if len(result.Errors) > 0 {
+		response["errors"] = result.Errors
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, response)
+}
@@ -0,0 +1,991 @@
+package api
+
+import (
+	"cha-ching-server/internal/graphql"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultGraphQLExpenseLimit = 10
+	maxGraphQLExpenseLimit     = 100
+)
+
+// Without limits a query could follow category parents as deep as it likes,
+// and repeat fields under aliases and fragments as often as it likes.
+const (
+	maxGraphQLDepth  = 10
+	maxGraphQLFields = 500
+)
+
+var errGraphQLInternal = errors.New("internal server error")
+
+type graphQLContextKey struct{}
+
+// graphQLRequest is the state of one GraphQL request. The loaders cache
+// every record they fetch until the request ends.
+type graphQLRequest struct {
+	user               *store.User
+	ledger             *store.Ledger
+	today              time.Time
+	categories         *graphql.Loader[int, *store.Category]
+	paymentMethods     *graphql.Loader[int, *store.PaymentMethod]
+	categoryStats      *graphql.Loader[statsKey, *store.CategoryStat]
+	paymentMethodStats *graphql.Loader[statsKey, *store.PaymentMethodStats]
+}
+
+// statsKey is a category or payment method and the date range its totals
+// are for. Unset dates are empty.
+type statsKey struct {
+	id        int
+	startDate string
+	endDate   string
+}
+
+func (k statsKey) dates() (*string, *string) {
+	var startDate, endDate *string
+	if k.startDate != "" {
+		startDate = &k.startDate
+	}
+	if k.endDate != "" {
+		endDate = &k.endDate
+	}
+	return startDate, endDate
+}
+
+func graphQLRequestFrom(ctx context.Context) *graphQLRequest {
+	return ctx.Value(graphQLContextKey{}).(*graphQLRequest)
+}
+
+// newGraphQLRequest sets up the loaders of a request. The stores list all
+// categories or payment methods of a ledger in one query, so each batch is
+// a single list call however many keys it holds.
+func (gh *GraphQLHandler) newGraphQLRequest(ctx context.Context, user *store.User, ledger *store.Ledger, today time.Time) context.Context {
+	includeArchived := true
+
+	req := &graphQLRequest{
+		user:   user,
+		ledger: ledger,
+		today:  today,
+		categories: graphql.NewLoader(func(keys []int) (map[int]*store.Category, error) {
+			categories, err := gh.categoryStore.ListCategories(ledger.ID, store.CategoryQueryParams{IncludeArchived: &includeArchived, Today: today})
+			if err != nil {
+				gh.logger.Printf("ERROR: ListCategories: %v", err)
+				return nil, errGraphQLInternal
+			}
+
+			byID := make(map[int]*store.Category, len(categories))
+			for _, category := range categories {
+				byID[category.ID] = category
+			}
+			return byID, nil
+		}),
+		paymentMethods: graphql.NewLoader(func(keys []int) (map[int]*store.PaymentMethod, error) {
+			paymentMethods, err := gh.paymentMethodStore.ListPaymentMethods(ledger.ID, store.PaymentMethodQueryParams{IncludeArchived: &includeArchived})
+			if err != nil {
+				gh.logger.Printf("ERROR: ListPaymentMethods: %v", err)
+				return nil, errGraphQLInternal
+			}
+
+			byID := make(map[int]*store.PaymentMethod, len(paymentMethods))
+			for _, paymentMethod := range paymentMethods {
+				byID[paymentMethod.ID] = paymentMethod
+			}
+			return byID, nil
+		}),
+		categoryStats: graphql.NewLoader(func(keys []statsKey) (map[statsKey]*store.CategoryStat, error) {
+			byKey := map[statsKey]*store.CategoryStat{}
+
+			for _, rangeKey := range dateRanges(keys) {
+				startDate, endDate := rangeKey.dates()
+
+				stats, err := gh.categoryStore.CategoryStats(ledger.ID, store.CategoryStatQueryParams{StartDate: startDate, EndDate: endDate, Today: today})
+				if err != nil {
+					gh.logger.Printf("ERROR: CategoryStats: %v", err)
+					return nil, errGraphQLInternal
+				}
+
+				for _, stat := range stats {
+					byKey[statsKey{stat.ID, rangeKey.startDate, rangeKey.endDate}] = stat
+				}
+			}
+			return byKey, nil
+		}),
+		paymentMethodStats: graphql.NewLoader(func(keys []statsKey) (map[statsKey]*store.PaymentMethodStats, error) {
+			byKey := map[statsKey]*store.PaymentMethodStats{}
+
+			for _, rangeKey := range dateRanges(keys) {
+				startDate, endDate := rangeKey.dates()
+
+				stats, err := gh.paymentMethodStore.PaymentMethodStats(ledger.ID, store.PaymentMethodStatsQueryParams{StartDate: startDate, EndDate: endDate})
+				if err != nil {
+					gh.logger.Printf("ERROR: PaymentMethodStats: %v", err)
+					return nil, errGraphQLInternal
+				}
+
+				for _, stat := range stats {
+					byKey[statsKey{stat.ID, rangeKey.startDate, rangeKey.endDate}] = stat
+				}
+			}
+			return byKey, nil
+		}),
+	}
+
+	return context.WithValue(ctx, graphQLContextKey{}, req)
+}
+
+// dateRanges returns each distinct date range among keys once, with a zero
+// id.
+func dateRanges(keys []statsKey) []statsKey {
+	seen := map[statsKey]bool{}
+	ranges := []statsKey{}
+
+	for _, key := range keys {
+		rangeKey := statsKey{startDate: key.startDate, endDate: key.endDate}
+		if !seen[rangeKey] {
+			seen[rangeKey] = true
+			ranges = append(ranges, rangeKey)
+		}
+	}
+
+	return ranges
+}
+
+func idArg(args map[string]any, name string) (int, error) {
+	raw, ok := args[name].(string)
+	if !ok {
+		return 0, nil
+	}
+
+	id, err := strconv.Atoi(raw)
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer id", name)
+	}
+
+	return id, nil
+}
+
+func optionalIDArg(args map[string]any, name string) (*int, error) {
+	if args[name] == nil {
+		return nil, nil
+	}
+
+	id, err := idArg(args, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &id, nil
+}
+
+func stringArg(args map[string]any, name string) *string {
+	value, ok := args[name].(string)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func intArg(args map[string]any, name string) *int {
+	value, ok := args[name].(int)
+	if !ok {
+		return nil
+	}
+	return &value
+}
+
+func dateArg(args map[string]any, name string) (*string, error) {
+	value := stringArg(args, name)
+	if value == nil {
+		return nil, nil
+	}
+
+	_, err := time.Parse("2006-01-02", *value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a date in YYYY-MM-DD format", name)
+	}
+
+	return value, nil
+}
+
+func statsKeyArgs(args map[string]any, id int) (statsKey, error) {
+	key := statsKey{id: id}
+
+	startDate, err := dateArg(args, "startDate")
+	if err != nil {
+		return key, err
+	}
+
+	endDate, err := dateArg(args, "endDate")
+	if err != nil {
+		return key, err
+	}
+
+	if startDate != nil {
+		key.startDate = *startDate
+	}
+	if endDate != nil {
+		key.endDate = *endDate
+	}
+
+	return key, nil
+}
+
+type graphQLExpensePage struct {
+	Items       []*store.Expense
+	Pagination  *store.ExpensePaginationData
+	TotalAmount float64
+	TotalCount  int
+}
+
+var dateRangeArgs = []*graphql.ArgDef{
+	{Name: "startDate", Type: graphql.String},
+	{Name: "endDate", Type: graphql.String},
+}
+
+// newSchema builds the schema. Field names are camelCase and ids are
+// strings, as GraphQL clients expect, rather than the snake_case and
+// numbers of the REST API.
+func (gh *GraphQLHandler) newSchema() *graphql.Schema {
+	nonNull := graphql.NewNonNull
+	list := graphql.NewList
+
+	categoryStatType := &graphql.Object{
+		Name: "CategoryStat",
+		Fields: graphql.Fields{
+			"id":                  {Type: nonNull(graphql.ID)},
+			"name":                {Type: nonNull(graphql.String)},
+			"parentId":            {Type: graphql.ID},
+			"count":               {Type: nonNull(graphql.Int)},
+			"budget":              {Type: nonNull(graphql.Float)},
+			"budgetCadence":       {Type: nonNull(graphql.String)},
+			"totalAmount":         {Type: nonNull(graphql.Float)},
+			"rolledUpCount":       {Type: nonNull(graphql.Int)},
+			"rolledUpBudget":      {Type: nonNull(graphql.Float)},
+			"rolledUpTotalAmount": {Type: nonNull(graphql.Float)},
+		},
+	}
+
+	categoryType := &graphql.Object{
+		Name: "Category",
+		Fields: graphql.Fields{
+			"id":            {Type: nonNull(graphql.ID)},
+			"name":          {Type: nonNull(graphql.String)},
+			"parentId":      {Type: graphql.ID},
+			"budget":        {Type: nonNull(graphql.Float)},
+			"budgetCadence": {Type: nonNull(graphql.String)},
+			"taxSection":    {Type: graphql.String},
+			"archived":      {Type: nonNull(graphql.Boolean)},
+			"stats": {
+				Type: categoryStatType,
+				Args: dateRangeArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key, err := statsKeyArgs(p.Args, p.Source.(*store.Category).ID)
+					if err != nil {
+						return nil, err
+					}
+					return graphQLRequestFrom(p.Context).categoryStats.Load(key), nil
+				},
+			},
+		},
+	}
+
+	categoryType.Fields["parent"] = &graphql.FieldDef{
+		Type: categoryType,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			category := p.Source.(*store.Category)
+			if category.ParentID == nil {
+				return nil, nil
+			}
+			return graphQLRequestFrom(p.Context).categories.Load(*category.ParentID), nil
+		},
+	}
+
+	categoryStatType.Fields["category"] = &graphql.FieldDef{
+		Type: categoryType,
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			return graphQLRequestFrom(p.Context).categories.Load(p.Source.(*store.CategoryStat).ID), nil
+		},
+	}
+
+	paymentMethodStatType := &graphql.Object{
+		Name: "PaymentMethodStat",
+		Fields: graphql.Fields{
+			"id": {
+				Type: graphql.ID,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					// Kinds have no id when grouped by kind
+					if id := p.Source.(*store.PaymentMethodStats).ID; id != 0 {
+						return id, nil
+					}
+					return nil, nil
+				},
+			},
+			"name":        {Type: nonNull(graphql.String)},
+			"kind":        {Type: graphql.String},
+			"count":       {Type: nonNull(graphql.Int)},
+			"totalAmount": {Type: nonNull(graphql.Float)},
+		},
+	}
+
+	paymentMethodType := &graphql.Object{
+		Name: "PaymentMethod",
+		Fields: graphql.Fields{
+			"id":              {Type: nonNull(graphql.ID)},
+			"name":            {Type: nonNull(graphql.String)},
+			"kind":            {Type: graphql.String},
+			"lastFour":        {Type: graphql.String},
+			"issuer":          {Type: graphql.String},
+			"billingCycleDay": {Type: graphql.Int},
+			"paymentDueDay":   {Type: graphql.Int},
+			"archived":        {Type: nonNull(graphql.Boolean)},
+			"stats": {
+				Type: paymentMethodStatType,
+				Args: dateRangeArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key, err := statsKeyArgs(p.Args, p.Source.(*store.PaymentMethod).ID)
+					if err != nil {
+						return nil, err
+					}
+					return graphQLRequestFrom(p.Context).paymentMethodStats.Load(key), nil
+				},
+			},
+		},
+	}
+
+	expenseType := &graphql.Object{
+		Name: "Expense",
+		Fields: graphql.Fields{
+			"id":              {Type: nonNull(graphql.ID)},
+			"title":           {Type: nonNull(graphql.String)},
+			"amount":          {Type: nonNull(graphql.Float)},
+			"expenseDate":     {Type: nonNull(graphql.String)},
+			"taxSection":      {Type: graphql.String},
+			"categoryId":      {Type: nonNull(graphql.ID)},
+			"paymentMethodId": {Type: nonNull(graphql.ID)},
+			"category": {
+				Type: categoryType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return graphQLRequestFrom(p.Context).categories.Load(p.Source.(*store.Expense).CategoryID), nil
+				},
+			},
+			"paymentMethod": {
+				Type: paymentMethodType,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return graphQLRequestFrom(p.Context).paymentMethods.Load(p.Source.(*store.Expense).PaymentMethodID), nil
+				},
+			},
+		},
+	}
+
+	paginationType := &graphql.Object{
+		Name: "Pagination",
+		Fields: graphql.Fields{
+			"totalPages":   {Type: nonNull(graphql.Int)},
+			"currentPage":  {Type: nonNull(graphql.Int)},
+			"itemsPerPage": {Type: nonNull(graphql.Int)},
+			"nextPage":     {Type: graphql.Int},
+			"prevPage":     {Type: graphql.Int},
+		},
+	}
+
+	expensePageType := &graphql.Object{
+		Name: "ExpensePage",
+		Fields: graphql.Fields{
+			"items":       {Type: nonNull(list(nonNull(expenseType)))},
+			"pagination":  {Type: nonNull(paginationType)},
+			"totalAmount": {Type: nonNull(graphql.Float)},
+			"totalCount":  {Type: nonNull(graphql.Int)},
+		},
+	}
+
+	ledgerType := &graphql.Object{
+		Name: "Ledger",
+		Fields: graphql.Fields{
+			"id":   {Type: nonNull(graphql.ID)},
+			"name": {Type: nonNull(graphql.String)},
+			"role": {Type: nonNull(graphql.String)},
+		},
+	}
+
+	userType := &graphql.Object{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id":       {Type: nonNull(graphql.ID)},
+			"name":     {Type: nonNull(graphql.String)},
+			"email":    {Type: nonNull(graphql.String)},
+			"timezone": {Type: nonNull(graphql.String)},
+		},
+	}
+
+	query := &graphql.Object{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"me": {
+				Type: nonNull(userType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return graphQLRequestFrom(p.Context).user, nil
+				},
+			},
+			"ledger": {
+				Type: nonNull(ledgerType),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					return graphQLRequestFrom(p.Context).ledger, nil
+				},
+			},
+			"expenses": {
+				Type: nonNull(expensePageType),
+				Args: []*graphql.ArgDef{
+					{Name: "page", Type: nonNull(graphql.Int), Default: 1},
+					{Name: "limit", Type: nonNull(graphql.Int), Default: defaultGraphQLExpenseLimit},
+					{Name: "startDate", Type: graphql.String},
+					{Name: "endDate", Type: graphql.String},
+					{Name: "categoryId", Type: graphql.ID},
+					{Name: "paymentMethodId", Type: graphql.ID},
+				},
+				Resolve: gh.resolveExpenses,
+			},
+			"searchExpenses": {
+				Type: nonNull(list(nonNull(expenseType))),
+				Args: []*graphql.ArgDef{{Name: "title", Type: nonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					expenses, _, err := gh.expenseStore.SearchExpensesByTitle(graphQLRequestFrom(p.Context).ledger.ID, p.Args["title"].(string))
+					if err != nil {
+						gh.logger.Printf("ERROR: SearchExpensesByTitle: %v", err)
+						return nil, errGraphQLInternal
+					}
+					return expenses, nil
+				},
+			},
+			"categories": {
+				Type: nonNull(list(nonNull(categoryType))),
+				Args: []*graphql.ArgDef{{Name: "includeArchived", Type: nonNull(graphql.Boolean), Default: false}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					req := graphQLRequestFrom(p.Context)
+					includeArchived := p.Args["includeArchived"].(bool)
+
+					categories, err := gh.categoryStore.ListCategories(req.ledger.ID, store.CategoryQueryParams{IncludeArchived: &includeArchived, Today: req.today})
+					if err != nil {
+						gh.logger.Printf("ERROR: ListCategories: %v", err)
+						return nil, errGraphQLInternal
+					}
+
+					for _, category := range categories {
+						req.categories.Prime(category.ID, category)
+					}
+					return categories, nil
+				},
+			},
+			"category": {
+				Type: categoryType,
+				Args: []*graphql.ArgDef{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := idArg(p.Args, "id")
+					if err != nil {
+						return nil, err
+					}
+					return graphQLRequestFrom(p.Context).categories.Load(id), nil
+				},
+			},
+			"categoryStats": {
+				Type: nonNull(list(nonNull(categoryStatType))),
+				Args: dateRangeArgs,
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key, err := statsKeyArgs(p.Args, 0)
+					if err != nil {
+						return nil, err
+					}
+
+					req := graphQLRequestFrom(p.Context)
+					startDate, endDate := key.dates()
+
+					stats, err := gh.categoryStore.CategoryStats(req.ledger.ID, store.CategoryStatQueryParams{StartDate: startDate, EndDate: endDate, Today: req.today})
+					if err != nil {
+						gh.logger.Printf("ERROR: CategoryStats: %v", err)
+						return nil, errGraphQLInternal
+					}
+					return stats, nil
+				},
+			},
+			"paymentMethods": {
+				Type: nonNull(list(nonNull(paymentMethodType))),
+				Args: []*graphql.ArgDef{{Name: "includeArchived", Type: nonNull(graphql.Boolean), Default: false}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					req := graphQLRequestFrom(p.Context)
+					includeArchived := p.Args["includeArchived"].(bool)
+
+					paymentMethods, err := gh.paymentMethodStore.ListPaymentMethods(req.ledger.ID, store.PaymentMethodQueryParams{IncludeArchived: &includeArchived})
+					if err != nil {
+						gh.logger.Printf("ERROR: ListPaymentMethods: %v", err)
+						return nil, errGraphQLInternal
+					}
+
+					for _, paymentMethod := range paymentMethods {
+						req.paymentMethods.Prime(paymentMethod.ID, paymentMethod)
+					}
+					return paymentMethods, nil
+				},
+			},
+			"paymentMethod": {
+				Type: paymentMethodType,
+				Args: []*graphql.ArgDef{{Name: "id", Type: nonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := idArg(p.Args, "id")
+					if err != nil {
+						return nil, err
+					}
+					return graphQLRequestFrom(p.Context).paymentMethods.Load(id), nil
+				},
+			},
+			"paymentMethodStats": {
+				Type: nonNull(list(nonNull(paymentMethodStatType))),
+				Args: append([]*graphql.ArgDef{{Name: "groupByKind", Type: graphql.NewNonNull(graphql.Boolean), Default: false}}, dateRangeArgs...),
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					key, err := statsKeyArgs(p.Args, 0)
+					if err != nil {
+						return nil, err
+					}
+
+					queryParams := store.PaymentMethodStatsQueryParams{}
+					queryParams.StartDate, queryParams.EndDate = key.dates()
+					if p.Args["groupByKind"].(bool) {
+						groupBy := "kind"
+						queryParams.GroupBy = &groupBy
+					}
+
+					stats, err := gh.paymentMethodStore.PaymentMethodStats(graphQLRequestFrom(p.Context).ledger.ID, queryParams)
+					if err != nil {
+						gh.logger.Printf("ERROR: PaymentMethodStats: %v", err)
+						return nil, errGraphQLInternal
+					}
+					return stats, nil
+				},
+			},
+		},
+	}
+
+	expenseInputType := &graphql.InputObject{
+		Name: "ExpenseInput",
+		Fields: []*graphql.ArgDef{
+			{Name: "title", Type: nonNull(graphql.String)},
+			{Name: "amount", Type: nonNull(graphql.Float)},
+			{Name: "expenseDate", Type: nonNull(graphql.String)},
+			{Name: "categoryId", Type: nonNull(graphql.ID)},
+			{Name: "paymentMethodId", Type: nonNull(graphql.ID)},
+			{Name: "taxSection", Type: graphql.String},
+		},
+	}
+
+	categoryInputType := &graphql.InputObject{
+		Name: "CategoryInput",
+		Fields: []*graphql.ArgDef{
+			{Name: "name", Type: nonNull(graphql.String)},
+			{Name: "parentId", Type: graphql.ID},
+			{Name: "budgetCadence", Type: graphql.String},
+			{Name: "taxSection", Type: graphql.String},
+		},
+	}
+
+	paymentMethodInputType := &graphql.InputObject{
+		Name: "PaymentMethodInput",
+		Fields: []*graphql.ArgDef{
+			{Name: "name", Type: nonNull(graphql.String)},
+			{Name: "kind", Type: graphql.String},
+			{Name: "lastFour", Type: graphql.String},
+			{Name: "issuer", Type: graphql.String},
+			{Name: "billingCycleDay", Type: graphql.Int},
+			{Name: "paymentDueDay", Type: graphql.Int},
+		},
+	}
+
+	idArgs := func(extra ...*graphql.ArgDef) []*graphql.ArgDef {
+		return append([]*graphql.ArgDef{{Name: "id", Type: nonNull(graphql.ID)}}, extra...)
+	}
+
+	mutation := &graphql.Object{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createExpense": {
+				Type:    nonNull(expenseType),
+				Args:    []*graphql.ArgDef{{Name: "input", Type: nonNull(expenseInputType)}},
+				Resolve: gh.resolveSaveExpense,
+			},
+			"updateExpense": {
+				Type:    expenseType,
+				Args:    idArgs(&graphql.ArgDef{Name: "input", Type: nonNull(expenseInputType)}),
+				Resolve: gh.resolveSaveExpense,
+			},
+			"createCategory": {
+				Type:    nonNull(categoryType),
+				Args:    []*graphql.ArgDef{{Name: "input", Type: nonNull(categoryInputType)}},
+				Resolve: gh.resolveSaveCategory,
+			},
+			"updateCategory": {
+				Type:    categoryType,
+				Args:    idArgs(&graphql.ArgDef{Name: "input", Type: nonNull(categoryInputType)}),
+				Resolve: gh.resolveSaveCategory,
+			},
+			"archiveCategory": {
+				Type:    nonNull(graphql.Boolean),
+				Args:    idArgs(&graphql.ArgDef{Name: "archived", Type: nonNull(graphql.Boolean), Default: true}),
+				Resolve: gh.resolveArchiveCategory,
+			},
+			"createPaymentMethod": {
+				Type:    nonNull(paymentMethodType),
+				Args:    []*graphql.ArgDef{{Name: "input", Type: nonNull(paymentMethodInputType)}},
+				Resolve: gh.resolveSavePaymentMethod,
+			},
+			"updatePaymentMethod": {
+				Type:    paymentMethodType,
+				Args:    idArgs(&graphql.ArgDef{Name: "input", Type: nonNull(paymentMethodInputType)}),
+				Resolve: gh.resolveSavePaymentMethod,
+			},
+			"archivePaymentMethod": {
+				Type:    nonNull(graphql.Boolean),
+				Args:    idArgs(&graphql.ArgDef{Name: "archived", Type: nonNull(graphql.Boolean), Default: true}),
+				Resolve: gh.resolveArchivePaymentMethod,
+			},
+		},
+	}
+
+	schema, err := graphql.NewSchema(query, mutation)
+	if err != nil {
+		panic(err)
+	}
+
+	schema.MaxDepth = maxGraphQLDepth
+	schema.MaxFields = maxGraphQLFields
+
+	return schema
+}
+
+func (gh *GraphQLHandler) resolveExpenses(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	queryParams := store.ExpenseQueryParams{
+		Page:  intArg(p.Args, "page"),
+		Limit: intArg(p.Args, "limit"),
+	}
+
+	if queryParams.Page == nil || *queryParams.Page < 1 {
+		return nil, errors.New("page must be at least 1")
+	}
+
+	if queryParams.Limit == nil || *queryParams.Limit < 1 || *queryParams.Limit > maxGraphQLExpenseLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d", maxGraphQLExpenseLimit)
+	}
+
+	var err error
+
+	queryParams.StartDate, err = dateArg(p.Args, "startDate")
+	if err != nil {
+		return nil, err
+	}
+
+	queryParams.EndDate, err = dateArg(p.Args, "endDate")
+	if err != nil {
+		return nil, err
+	}
+
+	queryParams.CategoryID, err = optionalIDArg(p.Args, "categoryId")
+	if err != nil {
+		return nil, err
+	}
+
+	queryParams.PaymentMethodID, err = optionalIDArg(p.Args, "paymentMethodId")
+	if err != nil {
+		return nil, err
+	}
+
+	expenses, paginationData, _, metaItems, err := gh.expenseStore.ListExpensesByLedgerID(req.ledger.ID, queryParams)
+	if err != nil {
+		gh.logger.Printf("ERROR: ListExpensesByLedgerID: %v", err)
+		return nil, errGraphQLInternal
+	}
+
+	return &graphQLExpensePage{
+		Items:       expenses,
+		Pagination:  paginationData,
+		TotalAmount: metaItems.TotalAmount,
+		TotalCount:  metaItems.TotalCount,
+	}, nil
+}
+
+// resolveSaveExpense serves createExpense and updateExpense, which differ
+// only in the id argument.
+func (gh *GraphQLHandler) resolveSaveExpense(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+	input := p.Args["input"].(map[string]any)
+
+	id, err := idArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	expense := store.Expense{
+		Title:       input["title"].(string),
+		Amount:      input["amount"].(float64),
+		ExpenseDate: input["expenseDate"].(string),
+		TaxSection:  stringArg(input, "taxSection"),
+		UserID:      req.user.ID,
+		LedgerID:    req.ledger.ID,
+	}
+
+	if expense.TaxSection != nil && !store.IsValidTaxSection(*expense.TaxSection) {
+		return nil, errors.New("tax_section must be one of 80C, 80D, 80E, 80G, business")
+	}
+
+	expense.CategoryID, err = idArg(input, "categoryId")
+	if err != nil {
+		return nil, err
+	}
+
+	expense.PaymentMethodID, err = idArg(input, "paymentMethodId")
+	if err != nil {
+		return nil, err
+	}
+
+	// The store reports a category or payment method from another ledger as
+	// an internal error, so they are checked here first
+	category, err := req.categories.Load(expense.CategoryID)()
+	if err != nil {
+		return nil, err
+	}
+	if category.(*store.Category) == nil {
+		return nil, errors.New("category does not exist in the ledger")
+	}
+
+	paymentMethod, err := req.paymentMethods.Load(expense.PaymentMethodID)()
+	if err != nil {
+		return nil, err
+	}
+	if paymentMethod.(*store.PaymentMethod) == nil {
+		return nil, errors.New("payment method does not exist in the ledger")
+	}
+
+	if id == 0 {
+		createdExpense, err := gh.expenseStore.CreateExpense(&expense)
+		if err != nil {
+			gh.logger.Printf("ERROR: CreateExpense: %v", err)
+			return nil, errGraphQLInternal
+		}
+
+		notifyBudgetAlerts(gh.logger, gh.budgetAlertStore, gh.dispatcher, createdExpense, req.user.Timezone)
+		gh.publisher.Publish(req.ledger.ID, store.WebhookEventExpenseCreated, createdExpense)
+
+		return createdExpense, nil
+	}
+
+	updatedExpense, err := gh.expenseStore.UpdateExpense(int64(id), &expense)
+	if err != nil {
+		gh.logger.Printf("ERROR: UpdateExpense: %v", err)
+		return nil, errGraphQLInternal
+	}
+
+	if updatedExpense == nil {
+		return nil, errors.New("expense not found")
+	}
+
+	notifyBudgetAlerts(gh.logger, gh.budgetAlertStore, gh.dispatcher, updatedExpense, req.user.Timezone)
+	gh.publisher.Publish(req.ledger.ID, store.WebhookEventExpenseUpdated, updatedExpense)
+
+	return updatedExpense, nil
+}
+
+func (gh *GraphQLHandler) resolveSaveCategory(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+	input := p.Args["input"].(map[string]any)
+
+	id, err := idArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	category := store.Category{
+		ID:         id,
+		Name:       input["name"].(string),
+		TaxSection: stringArg(input, "taxSection"),
+		UserID:     req.user.ID,
+		LedgerID:   req.ledger.ID,
+	}
+
+	if budgetCadence := stringArg(input, "budgetCadence"); budgetCadence != nil {
+		category.BudgetCadence = *budgetCadence
+	}
+
+	if category.BudgetCadence != "" && !store.IsValidBudgetCadence(category.BudgetCadence) {
+		return nil, errors.New("budget_cadence must be one of weekly, monthly, yearly")
+	}
+
+	if category.TaxSection != nil && !store.IsValidTaxSection(*category.TaxSection) {
+		return nil, errors.New("tax_section must be one of 80C, 80D, 80E, 80G, business")
+	}
+
+	category.ParentID, err = optionalIDArg(input, "parentId")
+	if err != nil {
+		return nil, err
+	}
+
+	if id == 0 {
+		createdCategory, err := gh.categoryStore.CreateCategory(&category, req.today)
+		if errors.Is(err, store.ErrInvalidParentCategory) {
+			return nil, err
+		}
+
+		if err != nil {
+			gh.logger.Printf("ERROR: CreateCategory: %v", err)
+			return nil, errGraphQLInternal
+		}
+
+		req.categories.Prime(createdCategory.ID, createdCategory)
+		gh.publisher.Publish(req.ledger.ID, store.WebhookEventCategoryCreated, createdCategory)
+
+		return createdCategory, nil
+	}
+
+	// Only the fields given change. The input has no budget, so the one in
+	// effect is kept
+	update := store.CategoryUpdate{
+		ID:       id,
+		Name:     &category.Name,
+		LedgerID: req.ledger.ID,
+	}
+
+	if _, ok := input["parentId"]; ok {
+		update.ParentID = utils.Optional[*int]{Value: category.ParentID, Set: true}
+	}
+	if category.BudgetCadence != "" {
+		update.BudgetCadence = &category.BudgetCadence
+	}
+	if _, ok := input["taxSection"]; ok {
+		update.TaxSection = utils.Optional[*string]{Value: category.TaxSection, Set: true}
+	}
+
+	updatedCategory, err := gh.categoryStore.UpdateCategory(&update, req.today)
+	if errors.Is(err, store.ErrInvalidParentCategory) {
+		return nil, err
+	}
+
+	if err != nil {
+		gh.logger.Printf("ERROR: UpdateCategory: %v", err)
+		return nil, errGraphQLInternal
+	}
+
+	if updatedCategory == nil {
+		return nil, errors.New("category not found")
+	}
+
+	req.categories.Prime(updatedCategory.ID, updatedCategory)
+	gh.publisher.Publish(req.ledger.ID, store.WebhookEventCategoryUpdated, updatedCategory)
+
+	return updatedCategory, nil
+}
+
+func (gh *GraphQLHandler) resolveArchiveCategory(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	id, err := idArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	archived := p.Args["archived"].(bool)
+
+	updated, err := gh.categoryStore.SetCategoryArchived(req.ledger.ID, int64(id), archived)
+	if err != nil {
+		gh.logger.Printf("ERROR: SetCategoryArchived: %v", err)
+		return nil, errGraphQLInternal
+	}
+
+	if !updated {
+		return nil, errors.New("category not found")
+	}
+
+	gh.publisher.Publish(req.ledger.ID, store.WebhookEventCategoryArchived, map[string]any{"id": int64(id), "archived": archived})
+
+	return archived, nil
+}
+
+func (gh *GraphQLHandler) resolveSavePaymentMethod(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+	input := p.Args["input"].(map[string]any)
+
+	id, err := idArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	paymentMethod := store.PaymentMethod{
+		ID:              id,
+		Name:            input["name"].(string),
+		Kind:            stringArg(input, "kind"),
+		LastFour:        stringArg(input, "lastFour"),
+		Issuer:          stringArg(input, "issuer"),
+		BillingCycleDay: intArg(input, "billingCycleDay"),
+		PaymentDueDay:   intArg(input, "paymentDueDay"),
+		UserID:          req.user.ID,
+		LedgerID:        req.ledger.ID,
+	}
+
+	if message := validatePaymentMethod(&paymentMethod); message != "" {
+		return nil, errors.New(message)
+	}
+
+	if id == 0 {
+		createdPaymentMethod, err := gh.paymentMethodStore.CreatePaymentMethod(&paymentMethod)
+		if err != nil {
+			gh.logger.Printf("ERROR: CreatePaymentMethod: %v", err)
+			return nil, errGraphQLInternal
+		}
+
+		req.paymentMethods.Prime(createdPaymentMethod.ID, createdPaymentMethod)
+		gh.publisher.Publish(req.ledger.ID, store.WebhookEventPaymentMethodCreated, createdPaymentMethod)
+
+		return createdPaymentMethod, nil
+	}
+
+	updatedPaymentMethod, err := gh.paymentMethodStore.UpdatePaymentMethod(&paymentMethod)
+	if err != nil {
+		gh.logger.Printf("ERROR: UpdatePaymentMethod: %v", err)
+		return nil, errGraphQLInternal
+	}
+
+	if updatedPaymentMethod == nil {
+		return nil, errors.New("payment method not found")
+	}
+
+	req.paymentMethods.Prime(updatedPaymentMethod.ID, updatedPaymentMethod)
+	gh.publisher.Publish(req.ledger.ID, store.WebhookEventPaymentMethodUpdated, updatedPaymentMethod)
+
+	return updatedPaymentMethod, nil
+}
+
+func (gh *GraphQLHandler) resolveArchivePaymentMethod(p graphql.ResolveParams) (any, error) {
+	req := graphQLRequestFrom(p.Context)
+
+	id, err := idArg(p.Args, "id")
+	if err != nil {
+		return nil, err
+	}
+
+	archived := p.Args["archived"].(bool)
+
+	updated, err := gh.paymentMethodStore.SetPaymentMethodArchived(req.ledger.ID, int64(id), archived)
+	if err != nil {
+		gh.logger.Printf("ERROR: SetPaymentMethodArchived: %v", err)
+		return nil, errGraphQLInternal
+	}
+
+	if !updated {
+		return nil, errors.New("payment method not found")
+	}
+
+	gh.publisher.Publish(req.ledger.ID, store.WebhookEventPaymentMethodArchived, map[string]any{"id": int64(id), "archived": archived})
+
+	return archived, nil
+}
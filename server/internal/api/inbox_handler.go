@@ -0,0 +1,69 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"log"
+	"net/http"
+)
+
+type InboxHandler struct {
+	logger     *log.Logger
+	inboxStore store.InboxStore
+}
+
+func NewInboxHandler(logger *log.Logger, inboxStore store.InboxStore) *InboxHandler {
+	return &InboxHandler{
+		logger,
+		inboxStore,
+	}
+}
+
+func (ih *InboxHandler) HandleGetInboxItems(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	var queryParams store.InboxQueryParams
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		ih.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	items, err := ih.inboxStore.ListInboxItems(user.ID, queryParams)
+	if err != nil {
+		ih.logger.Printf("ERROR: ListInboxItems: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": items,
+	})
+}
+
+func (ih *InboxHandler) HandleMarkInboxItemRead(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ih.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	updated, err := ih.inboxStore.MarkInboxItemRead(user.ID, id)
+	if err != nil {
+		ih.logger.Printf("ERROR: MarkInboxItemRead: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !updated {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "inbox item not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
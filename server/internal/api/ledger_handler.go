@@ -0,0 +1,240 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type LedgerHandler struct {
+	logger      *log.Logger
+	ledgerStore store.LedgerStore
+}
+
+func NewLedgerHandler(logger *log.Logger, ledgerStore store.LedgerStore) *LedgerHandler {
+	return &LedgerHandler{
+		logger,
+		ledgerStore,
+	}
+}
+
+type createLedgerRequest struct {
+	Name string `json:"name"`
+}
+
+type updateLedgerMemberRequest struct {
+	Role string `json:"role"`
+}
+
+type createLedgerInviteRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+type acceptLedgerInviteRequest struct {
+	Token string `json:"token"`
+}
+
+func (lh *LedgerHandler) HandleCreateLedger(w http.ResponseWriter, r *http.Request) {
+	var req createLedgerRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		lh.logger.Printf("ERROR: decoding create ledger request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.Name == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "name is required"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	createdLedger, err := lh.ledgerStore.CreateLedger(&store.Ledger{
+		Name:      req.Name,
+		CreatedBy: user.ID,
+	})
+	if err != nil {
+		lh.logger.Printf("ERROR: CreateLedger: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": createdLedger,
+	})
+}
+
+func (lh *LedgerHandler) HandleGetAllLedgers(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	ledgers, err := lh.ledgerStore.ListLedgersForUser(user.ID)
+	if err != nil {
+		lh.logger.Printf("ERROR: ListLedgersForUser: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": ledgers,
+	})
+}
+
+func (lh *LedgerHandler) HandleGetLedgerMembers(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	members, err := lh.ledgerStore.ListLedgerMembers(ledger.ID)
+	if err != nil {
+		lh.logger.Printf("ERROR: ListLedgerMembers: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": members,
+	})
+}
+
+func (lh *LedgerHandler) HandleUpdateLedgerMember(w http.ResponseWriter, r *http.Request) {
+	var req updateLedgerMemberRequest
+
+	memberID, err := utils.ReadInt64URLParam(r, "userID")
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id parameter"})
+		return
+	}
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		lh.logger.Printf("ERROR: decoding update ledger member request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if !store.IsValidLedgerRole(req.Role) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid role"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	member, err := lh.ledgerStore.UpdateLedgerMemberRole(ledger.ID, int(memberID), req.Role)
+	if errors.Is(err, store.ErrLastLedgerOwner) {
+		utils.WriteJSONResponse(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		lh.logger.Printf("ERROR: UpdateLedgerMemberRole: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if member == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "member not found"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": member,
+	})
+}
+
+func (lh *LedgerHandler) HandleDeleteLedgerMember(w http.ResponseWriter, r *http.Request) {
+	memberID, err := utils.ReadInt64URLParam(r, "userID")
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id parameter"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	removed, err := lh.ledgerStore.RemoveLedgerMember(ledger.ID, int(memberID))
+	if errors.Is(err, store.ErrLastLedgerOwner) {
+		utils.WriteJSONResponse(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		lh.logger.Printf("ERROR: RemoveLedgerMember: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !removed {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "member not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (lh *LedgerHandler) HandleCreateLedgerInvite(w http.ResponseWriter, r *http.Request) {
+	var req createLedgerInviteRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		lh.logger.Printf("ERROR: decoding create ledger invite request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.Email == "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "email is required"})
+		return
+	}
+
+	if !store.IsValidLedgerRole(req.Role) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid role"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	invite, err := lh.ledgerStore.CreateLedgerInvite(ledger.ID, req.Email, req.Role, user.ID, 7*24*time.Hour)
+	if err != nil {
+		lh.logger.Printf("ERROR: CreateLedgerInvite: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": invite,
+	})
+}
+
+func (lh *LedgerHandler) HandleAcceptLedgerInvite(w http.ResponseWriter, r *http.Request) {
+	var req acceptLedgerInviteRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		lh.logger.Printf("ERROR: decoding accept ledger invite request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	ledger, err := lh.ledgerStore.AcceptLedgerInvite(req.Token, user)
+	if errors.Is(err, store.ErrInvalidInvite) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		lh.logger.Printf("ERROR: AcceptLedgerInvite: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": ledger,
+	})
+}
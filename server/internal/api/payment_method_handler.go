@@ -1,9 +1,11 @@
 package api
 
 import (
+	"cha-ching-server/internal/events"
 	"cha-ching-server/internal/middleware"
 	"cha-ching-server/internal/store"
 	"cha-ching-server/internal/utils"
+	"errors"
 	"log"
 	"net/http"
 )
@@ -11,12 +13,14 @@ import (
 type PaymentMethodHandler struct {
 	logger             *log.Logger
 	paymentMethodStore store.PaymentMethodStore
+	publisher          events.Publisher
 }
 
-func NewPaymentMethodHandler(logger *log.Logger, paymentMethodStore store.PaymentMethodStore) *PaymentMethodHandler {
+func NewPaymentMethodHandler(logger *log.Logger, paymentMethodStore store.PaymentMethodStore, publisher events.Publisher) *PaymentMethodHandler {
 	return &PaymentMethodHandler{
 		logger,
 		paymentMethodStore,
+		publisher,
 	}
 }
 
@@ -30,8 +34,15 @@ func (ph *PaymentMethodHandler) HandleCreatePaymentMethod(w http.ResponseWriter,
 		return
 	}
 
+	if message := validatePaymentMethod(&paymentMethod); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
 	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 	paymentMethod.UserID = user.ID
+	paymentMethod.LedgerID = ledger.ID
 
 	createdPaymentMethod, err := ph.paymentMethodStore.CreatePaymentMethod(&paymentMethod)
 	if err != nil {
@@ -40,15 +51,71 @@ func (ph *PaymentMethodHandler) HandleCreatePaymentMethod(w http.ResponseWriter,
 		return
 	}
 
+	ph.publisher.Publish(ledger.ID, store.WebhookEventPaymentMethodCreated, createdPaymentMethod)
+
 	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
 		"data": createdPaymentMethod,
 	})
 }
 
+func (ph *PaymentMethodHandler) HandleUpdatePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ph.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var paymentMethod store.PaymentMethod
+
+	err = utils.ReadRequestBody(r, &paymentMethod)
+	if err != nil {
+		ph.logger.Printf("ERROR: decoding update payment method request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if message := validatePaymentMethod(&paymentMethod); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+	paymentMethod.ID = int(id)
+	paymentMethod.LedgerID = ledger.ID
+
+	updatedPaymentMethod, err := ph.paymentMethodStore.UpdatePaymentMethod(&paymentMethod)
+	if err != nil {
+		ph.logger.Printf("ERROR: UpdatePaymentMethod: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if updatedPaymentMethod == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "payment method not found"})
+		return
+	}
+
+	ph.publisher.Publish(ledger.ID, store.WebhookEventPaymentMethodUpdated, updatedPaymentMethod)
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": updatedPaymentMethod,
+	})
+}
+
 func (ph *PaymentMethodHandler) HandleGetAllPaymentMethods(w http.ResponseWriter, r *http.Request) {
-	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.PaymentMethodQueryParams
+
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		ph.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query params"})
+		return
+	}
 
-	paymentMethods, err := ph.paymentMethodStore.ListPaymentMethods(user.ID)
+	paymentMethods, err := ph.paymentMethodStore.ListPaymentMethods(ledger.ID, queryParams)
 	if err != nil {
 		ph.logger.Printf("ERROR: ListPaymentMethods: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
@@ -61,7 +128,7 @@ func (ph *PaymentMethodHandler) HandleGetAllPaymentMethods(w http.ResponseWriter
 }
 
 func (ph *PaymentMethodHandler) HandleGetPaymentMethodStats(w http.ResponseWriter, r *http.Request) {
-	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
 
 	var queryParams store.PaymentMethodStatsQueryParams
 
@@ -72,7 +139,12 @@ func (ph *PaymentMethodHandler) HandleGetPaymentMethodStats(w http.ResponseWrite
 		return
 	}
 
-	stats, err := ph.paymentMethodStore.PaymentMethodStats(user.ID, queryParams)
+	if queryParams.GroupBy != nil && *queryParams.GroupBy != "kind" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "group_by must be kind"})
+		return
+	}
+
+	stats, err := ph.paymentMethodStore.PaymentMethodStats(ledger.ID, queryParams)
 	if err != nil {
 		ph.logger.Printf("ERROR: PaymentMethodStats: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
@@ -83,3 +155,121 @@ func (ph *PaymentMethodHandler) HandleGetPaymentMethodStats(w http.ResponseWrite
 		"data": stats,
 	})
 }
+
+type archivePaymentMethodRequest struct {
+	Archived bool `json:"archived"`
+}
+
+type reassignPaymentMethodRequest struct {
+	TargetPaymentMethodID int `json:"target_payment_method_id"`
+}
+
+func (ph *PaymentMethodHandler) HandleArchivePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ph.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req archivePaymentMethodRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		ph.logger.Printf("ERROR: decoding archive payment method request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	updated, err := ph.paymentMethodStore.SetPaymentMethodArchived(ledger.ID, id, req.Archived)
+	if err != nil {
+		ph.logger.Printf("ERROR: SetPaymentMethodArchived: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !updated {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "payment method not found"})
+		return
+	}
+
+	ph.publisher.Publish(ledger.ID, store.WebhookEventPaymentMethodArchived, map[string]any{"id": id, "archived": req.Archived})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeletePaymentMethod requires a target payment method for the
+// expenses of the deleted one.
+func (ph *PaymentMethodHandler) HandleDeletePaymentMethod(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		ph.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req reassignPaymentMethodRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		ph.logger.Printf("ERROR: decoding delete payment method request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "target_payment_method_id is required"})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	deleted, err := ph.paymentMethodStore.DeletePaymentMethod(ledger.ID, id, req.TargetPaymentMethodID)
+	if errors.Is(err, store.ErrInvalidTargetPaymentMethod) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		ph.logger.Printf("ERROR: DeletePaymentMethod: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "payment method not found"})
+		return
+	}
+
+	ph.publisher.Publish(ledger.ID, store.WebhookEventPaymentMethodDeleted, map[string]any{"id": id, "target_payment_method_id": req.TargetPaymentMethodID})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func validatePaymentMethod(paymentMethod *store.PaymentMethod) string {
+	if paymentMethod.Name == "" {
+		return "name is required"
+	}
+
+	if paymentMethod.Kind != nil && !store.IsValidPaymentMethodKind(*paymentMethod.Kind) {
+		return "kind must be one of upi, credit_card, debit_card, cash, net_banking, wallet"
+	}
+
+	if paymentMethod.LastFour != nil {
+		if len(*paymentMethod.LastFour) != 4 {
+			return "last_four must be 4 digits"
+		}
+		for _, c := range *paymentMethod.LastFour {
+			if c < '0' || c > '9' {
+				return "last_four must be 4 digits"
+			}
+		}
+	}
+
+	if paymentMethod.BillingCycleDay != nil && (*paymentMethod.BillingCycleDay < 1 || *paymentMethod.BillingCycleDay > 31) {
+		return "billing_cycle_day must be between 1 and 31"
+	}
+
+	if paymentMethod.PaymentDueDay != nil && (*paymentMethod.PaymentDueDay < 1 || *paymentMethod.PaymentDueDay > 31) {
+		return "payment_due_day must be between 1 and 31"
+	}
+
+	return ""
+}
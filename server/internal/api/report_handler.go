@@ -0,0 +1,258 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/pdf"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type ReportHandler struct {
+	logger             *log.Logger
+	reportStore        store.ReportStore
+	expenseStore       store.ExpenseStore
+	categoryStore      store.CategoryStore
+	paymentMethodStore store.PaymentMethodStore
+}
+
+func NewReportHandler(logger *log.Logger, reportStore store.ReportStore, expenseStore store.ExpenseStore, categoryStore store.CategoryStore, paymentMethodStore store.PaymentMethodStore) *ReportHandler {
+	return &ReportHandler{
+		logger,
+		reportStore,
+		expenseStore,
+		categoryStore,
+		paymentMethodStore,
+	}
+}
+
+// HandleGetCategoryPaymentMethodPivot returns spend by category and payment
+// method as JSON, or as a CSV table with format=csv.
+func (rh *ReportHandler) HandleGetCategoryPaymentMethodPivot(w http.ResponseWriter, r *http.Request) {
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.PivotQueryParams
+
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		rh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if queryParams.Format == "" {
+		queryParams.Format = store.ReportFormatJSON
+	}
+
+	if !store.IsValidReportFormat(queryParams.Format) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "format must be one of json, csv"})
+		return
+	}
+
+	pivot, err := rh.reportStore.CategoryPaymentMethodPivot(ledger.ID, queryParams)
+	if err != nil {
+		rh.logger.Printf("ERROR: CategoryPaymentMethodPivot: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if queryParams.Format == store.ReportFormatCSV {
+		err = utils.WriteCSVResponse(w, "category-payment-method.csv", pivot.Records())
+		if err != nil {
+			rh.logger.Printf("ERROR: WriteCSVResponse: %v", err)
+		}
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": pivot,
+	})
+}
+
+const (
+	defaultReportLimit = 1000
+	maxReportLimit     = 10000
+)
+
+func validateReportQueryParams(queryParams *store.ReportQueryParams) string {
+	if len(queryParams.Dimensions) > store.MaxReportDimensions {
+		return fmt.Sprintf("at most %d dimensions are allowed", store.MaxReportDimensions)
+	}
+
+	seen := make(map[string]bool)
+	for _, dimension := range queryParams.Dimensions {
+		if dimension == "tag" {
+			return store.ErrTagDimension.Error()
+		}
+		if !store.IsValidReportDimension(dimension) {
+			return "dimension must be one of category, payment_method, day, week, month, weekday, title"
+		}
+		if seen[dimension] {
+			return "dimensions must not repeat"
+		}
+		seen[dimension] = true
+	}
+
+	if len(queryParams.Measures) == 0 {
+		queryParams.Measures = []string{"sum", "count"}
+	}
+
+	seen = make(map[string]bool)
+	for _, measure := range queryParams.Measures {
+		if !store.IsValidReportMeasure(measure) {
+			return "measure must be one of sum, count, avg, min, max"
+		}
+		if seen[measure] {
+			return "measures must not repeat"
+		}
+		seen[measure] = true
+	}
+
+	if queryParams.Sort != nil && !seen[*queryParams.Sort] {
+		return "sort must be one of the requested measures"
+	}
+
+	if queryParams.Limit == nil {
+		limit := defaultReportLimit
+		queryParams.Limit = &limit
+	}
+
+	if *queryParams.Limit < 1 || *queryParams.Limit > maxReportLimit {
+		return fmt.Sprintf("limit must be between 1 and %d", maxReportLimit)
+	}
+
+	if queryParams.Format == "" {
+		queryParams.Format = store.ReportFormatJSON
+	}
+
+	if !store.IsValidReportFormat(queryParams.Format) {
+		return "format must be one of json, csv"
+	}
+
+	return ""
+}
+
+// HandleBuildReport groups expenses by the requested dimensions and returns
+// the requested measures as a flat table.
+func (rh *ReportHandler) HandleBuildReport(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.ReportQueryParams
+
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		rh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if message := validateReportQueryParams(&queryParams); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	queryParams.Timezone = user.Timezone
+
+	table, err := rh.reportStore.BuildReport(ledger.ID, queryParams)
+	if err != nil {
+		rh.logger.Printf("ERROR: BuildReport: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if queryParams.Format == store.ReportFormatCSV {
+		err = utils.WriteCSVResponse(w, "report.csv", table.Records())
+		if err != nil {
+			rh.logger.Printf("ERROR: WriteCSVResponse: %v", err)
+		}
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": table,
+	})
+}
+
+// HandleGetMonthlyPDF renders the printable statement of one month.
+func (rh *ReportHandler) HandleGetMonthlyPDF(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	month, err := time.Parse("2006-01", r.URL.Query().Get("month"))
+	if err != nil {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "month must be in YYYY-MM format"})
+		return
+	}
+
+	startDate := month.Format("2006-01-02")
+	endDate := month.AddDate(0, 1, -1).Format("2006-01-02")
+
+	expenses, _, relatedItems, metaItems, err := rh.expenseStore.ListExpensesByLedgerID(ledger.ID, store.ExpenseQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	if err != nil {
+		rh.logger.Printf("ERROR: ListExpensesByLedgerID: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	categories, err := rh.categoryStore.CategoryStats(ledger.ID, store.CategoryStatQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	if err != nil {
+		rh.logger.Printf("ERROR: CategoryStats: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	paymentMethods, err := rh.paymentMethodStore.PaymentMethodStats(ledger.ID, store.PaymentMethodStatsQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	if err != nil {
+		rh.logger.Printf("ERROR: PaymentMethodStats: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	days, _, err := rh.expenseStore.ListExpensesTotalPerDay(ledger.ID, store.ExpenseTotalPerDayQueryParams{
+		StartDate: &startDate,
+		EndDate:   &endDate,
+	})
+	if err != nil {
+		rh.logger.Printf("ERROR: ListExpensesTotalPerDay: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		today = time.Now()
+	}
+
+	// Expense dates and the per day totals are grouped in IST like the rest
+	// of the stats endpoints
+	document := pdf.RenderMonthlyStatement(&pdf.MonthlyStatement{
+		LedgerName:     ledger.Name,
+		Month:          month,
+		GeneratedOn:    today,
+		Timezone:       store.DefaultTimezone,
+		Meta:           metaItems,
+		Categories:     categories,
+		PaymentMethods: paymentMethods,
+		Days:           days,
+		Expenses:       expenses,
+		Related:        relatedItems,
+	})
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `inline; filename="statement-`+month.Format("2006-01")+`.pdf"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(document)
+}
@@ -0,0 +1,27 @@
+package api
+
+import (
+	"cha-ching-server/internal/store"
+	"testing"
+)
+
+func TestValidateReportQueryParams(t *testing.T) {
+	tests := []struct {
+		dimensions []string
+		message    string
+	}{
+		{[]string{"category"}, ""},
+		{[]string{"tag"}, store.ErrTagDimension.Error()},
+		{[]string{"category", "tag"}, store.ErrTagDimension.Error()},
+		{[]string{"colour"}, "dimension must be one of category, payment_method, day, week, month, weekday, title"},
+		{[]string{"day", "day"}, "dimensions must not repeat"},
+	}
+
+	for _, test := range tests {
+		queryParams := store.ReportQueryParams{Dimensions: test.dimensions}
+
+		if message := validateReportQueryParams(&queryParams); message != test.message {
+			t.Errorf("dimensions %v: got %q, want %q", test.dimensions, message, test.message)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+type StatementHandler struct {
+	logger         *log.Logger
+	statementStore store.StatementStore
+}
+
+func NewStatementHandler(logger *log.Logger, statementStore store.StatementStore) *StatementHandler {
+	return &StatementHandler{
+		logger,
+		statementStore,
+	}
+}
+
+type cardPaymentRequest struct {
+	Cycle  string  `json:"cycle"`
+	Amount float64 `json:"amount"`
+	PaidOn string  `json:"paid_on"`
+}
+
+func (req *cardPaymentRequest) validate() string {
+	if req.Cycle == "" {
+		req.Cycle = store.StatementCyclePrevious
+	}
+
+	if !store.IsValidStatementCycle(req.Cycle) {
+		return "cycle must be one of current, previous"
+	}
+
+	if req.Amount < 0 {
+		return "amount must be positive"
+	}
+
+	if req.PaidOn != "" {
+		if _, err := time.Parse("2006-01-02", req.PaidOn); err != nil {
+			return "paid_on must be a date in YYYY-MM-DD format"
+		}
+	}
+
+	return ""
+}
+
+func (sh *StatementHandler) writeStatementStoreError(w http.ResponseWriter, action string, err error) {
+	if errors.Is(err, store.ErrPaymentMethodNotFound) {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "payment method not found"})
+		return
+	}
+
+	if errors.Is(err, store.ErrNotStatementCard) || errors.Is(err, store.ErrStatementAlreadyPaid) || errors.Is(err, store.ErrInvalidCardPayment) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	sh.logger.Printf("ERROR: %s: %v", action, err)
+	utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+}
+
+// HandleGetStatements returns the current and previous statement of a credit
+// card, with their due dates and unpaid amounts.
+func (sh *StatementHandler) HandleGetStatements(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		sh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		sh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	statements, err := sh.statementStore.GetStatements(ledger.ID, int(id), today, user.Timezone)
+	if err != nil {
+		sh.writeStatementStoreError(w, "GetStatements", err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": statements,
+	})
+}
+
+// HandleRecordCardPayment records a payment against the previous statement
+// unless another cycle is given.
+func (sh *StatementHandler) HandleRecordCardPayment(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		sh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req cardPaymentRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		sh.logger.Printf("ERROR: decoding card payment request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if message := req.validate(); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	today, err := utils.TodayIn(user.Timezone)
+	if err != nil {
+		sh.logger.Printf("ERROR: TodayIn: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	statement, err := sh.statementStore.RecordCardPayment(&store.CardPayment{
+		PaymentMethodID: int(id),
+		Amount:          req.Amount,
+		PaidOn:          req.PaidOn,
+		UserID:          user.ID,
+		LedgerID:        ledger.ID,
+	}, req.Cycle, today, user.Timezone)
+	if err != nil {
+		sh.writeStatementStoreError(w, "RecordCardPayment", err)
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": statement,
+	})
+}
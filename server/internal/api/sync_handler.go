@@ -0,0 +1,155 @@
+package api
+
+import (
+	"cha-ching-server/internal/events"
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const maxSyncMutations = 500
+
+const (
+	defaultSyncPullLimit = 1000
+	maxSyncPullLimit     = 5000
+)
+
+type SyncHandler struct {
+	logger    *log.Logger
+	syncStore store.SyncStore
+	publisher events.Publisher
+}
+
+func NewSyncHandler(logger *log.Logger, syncStore store.SyncStore, publisher events.Publisher) *SyncHandler {
+	return &SyncHandler{
+		logger,
+		syncStore,
+		publisher,
+	}
+}
+
+type syncPushRequest struct {
+	Strategy  string                `json:"strategy"`
+	Mutations []*store.SyncMutation `json:"mutations"`
+}
+
+func (req *syncPushRequest) validate() string {
+	if req.Strategy == "" {
+		req.Strategy = store.SyncStrategyLastWriterWins
+	}
+
+	if !store.IsValidSyncStrategy(req.Strategy) {
+		return store.ErrInvalidSyncStrategy.Error()
+	}
+
+	if len(req.Mutations) == 0 {
+		return "mutations is required"
+	}
+
+	if len(req.Mutations) > maxSyncMutations {
+		return fmt.Sprintf("at most %d mutations can be pushed at once", maxSyncMutations)
+	}
+
+	for i, mutation := range req.Mutations {
+		if mutation == nil {
+			return fmt.Sprintf("mutations[%d] must be an object", i)
+		}
+	}
+
+	return ""
+}
+
+// syncEventTypes maps an applied mutation to the event published for it.
+var syncEventTypes = map[string][2]string{
+	store.SyncEntityExpense:       {store.WebhookEventExpenseCreated, store.WebhookEventExpenseUpdated},
+	store.SyncEntityCategory:      {store.WebhookEventCategoryCreated, store.WebhookEventCategoryUpdated},
+	store.SyncEntityPaymentMethod: {store.WebhookEventPaymentMethodCreated, store.WebhookEventPaymentMethodUpdated},
+}
+
+// HandlePullChanges returns up to limit changes to the ledger after the since
+// token of the previous pull. Without since the whole ledger is returned,
+// page by page while has_more is set.
+func (sh *SyncHandler) HandlePullChanges(w http.ResponseWriter, r *http.Request) {
+	sinceParam, _ := utils.ReadStringQueryParam(r, "since", "0")
+
+	since, err := strconv.ParseInt(sinceParam, 10, 64)
+	if err != nil || since < 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "since must be a token returned by a previous pull"})
+		return
+	}
+
+	limit, err := utils.ReadIntQueryParam(r, "limit", defaultSyncPullLimit)
+	if err != nil || limit < 1 || limit > maxSyncPullLimit {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": fmt.Sprintf("limit must be between 1 and %d", maxSyncPullLimit)})
+		return
+	}
+
+	ledger := middleware.GetLedger(r)
+
+	changes, err := sh.syncStore.PullChanges(ledger.ID, since, limit)
+	if err != nil {
+		sh.logger.Printf("ERROR: PullChanges: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": changes,
+	})
+}
+
+// HandlePushMutations applies a batch of offline mutations in order and
+// reports the outcome of each. Conflicts and invalid mutations do not fail
+// the batch, they are returned in the results for the client to resolve.
+func (sh *SyncHandler) HandlePushMutations(w http.ResponseWriter, r *http.Request) {
+	var req syncPushRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		sh.logger.Printf("ERROR: decoding push sync request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if message := req.validate(); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	results, err := sh.syncStore.PushMutations(ledger.ID, user.ID, req.Strategy, req.Mutations)
+	if err != nil {
+		sh.logger.Printf("ERROR: PushMutations: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	for _, result := range results {
+		if result.Status != store.SyncStatusApplied && result.Status != store.SyncStatusMerged {
+			continue
+		}
+
+		data := map[string]any{"id": result.ID, "client_id": result.ClientID, "version": result.Version}
+
+		switch {
+		case result.Deleted:
+			sh.publisher.Publish(ledger.ID, store.WebhookEventExpenseDeleted, data)
+		case result.Op == store.SyncOpDelete:
+			// Already deleted before, nothing changed
+		case result.Created:
+			sh.publisher.Publish(ledger.ID, syncEventTypes[result.Entity][0], data)
+		default:
+			sh.publisher.Publish(ledger.ID, syncEventTypes[result.Entity][1], data)
+		}
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": results,
+	})
+}
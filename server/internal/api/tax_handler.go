@@ -0,0 +1,95 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type TaxHandler struct {
+	logger   *log.Logger
+	taxStore store.TaxStore
+}
+
+func NewTaxHandler(logger *log.Logger, taxStore store.TaxStore) *TaxHandler {
+	return &TaxHandler{
+		logger,
+		taxStore,
+	}
+}
+
+// HandleGetTaxReport returns deductible spending by section for a financial
+// year, the current one unless a year is given. Financial years start in
+// April unless start_month says otherwise.
+func (th *TaxHandler) HandleGetTaxReport(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	var queryParams store.TaxReportQueryParams
+
+	err := utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		th.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	startMonth := store.DefaultFinancialYearStartMonth
+	if queryParams.StartMonth != nil {
+		if *queryParams.StartMonth < 1 || *queryParams.StartMonth > 12 {
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "start_month must be between 1 and 12"})
+			return
+		}
+		startMonth = time.Month(*queryParams.StartMonth)
+	}
+
+	if queryParams.Format == "" {
+		queryParams.Format = store.ReportFormatJSON
+	}
+
+	if !store.IsValidReportFormat(queryParams.Format) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "format must be one of json, csv"})
+		return
+	}
+
+	var year int
+	if queryParams.Year != nil {
+		year = *queryParams.Year
+	} else {
+		today, err := utils.TodayIn(user.Timezone)
+		if err != nil {
+			th.logger.Printf("ERROR: TodayIn: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		year = store.FinancialYear(today, startMonth)
+	}
+
+	if year < 1900 || year > 9999 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid year"})
+		return
+	}
+
+	report, err := th.taxStore.TaxReport(ledger.ID, year, startMonth, user.Timezone)
+	if err != nil {
+		th.logger.Printf("ERROR: TaxReport: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if queryParams.Format == store.ReportFormatCSV {
+		err = utils.WriteCSVResponse(w, "tax-report-"+strconv.Itoa(year)+".csv", report.Records())
+		if err != nil {
+			th.logger.Printf("ERROR: WriteCSVResponse: %v", err)
+		}
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": report,
+	})
+}
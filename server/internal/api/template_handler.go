@@ -0,0 +1,54 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/templates"
+	"cha-ching-server/internal/utils"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type TemplateHandler struct {
+	logger        *log.Logger
+	templateStore store.TemplateStore
+}
+
+func NewTemplateHandler(logger *log.Logger, templateStore store.TemplateStore) *TemplateHandler {
+	return &TemplateHandler{
+		logger,
+		templateStore,
+	}
+}
+
+func (th *TemplateHandler) HandleGetTemplates(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": templates.All(),
+	})
+}
+
+// HandleApplyTemplate adds the categories and payment methods of a template
+// that the active ledger does not have yet.
+func (th *TemplateHandler) HandleApplyTemplate(w http.ResponseWriter, r *http.Request) {
+	template := templates.Get(chi.URLParam(r, "key"))
+	if template == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "template not found"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	ledger := middleware.GetLedger(r)
+
+	result, err := th.templateStore.ApplyTemplate(ledger.ID, user.ID, template)
+	if err != nil {
+		th.logger.Printf("ERROR: ApplyTemplate: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": result,
+	})
+}
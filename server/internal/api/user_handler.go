@@ -2,11 +2,13 @@ package api
 
 import (
 	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/templates"
 	"cha-ching-server/internal/utils"
 	"errors"
 	"log"
 	"net/http"
 	"regexp"
+	"time"
 )
 
 type UserHandler struct {
@@ -25,6 +27,8 @@ type registerUserRequest struct {
 	Name     string `json:"name"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Timezone string `json:"timezone"`
+	Template string `json:"template"`
 }
 
 func (uh *UserHandler) validateUserRegisterRequest(request *registerUserRequest) error {
@@ -46,6 +50,17 @@ func (uh *UserHandler) validateUserRegisterRequest(request *registerUserRequest)
 		return errors.New("invalid email")
 	}
 
+	if request.Timezone != "" {
+		_, err := time.LoadLocation(request.Timezone)
+		if err != nil {
+			return errors.New("invalid timezone")
+		}
+	}
+
+	if request.Template != "" && request.Template != templates.NoneKey && templates.Get(request.Template) == nil {
+		return errors.New("invalid template")
+	}
+
 	return nil
 }
 
@@ -78,8 +93,9 @@ func (uh *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request)
 	}
 
 	user := &store.User{
-		Name:  userReq.Name,
-		Email: userReq.Email,
+		Name:     userReq.Name,
+		Email:    userReq.Email,
+		Timezone: userReq.Timezone,
 	}
 
 	err = user.PasswordHash.Set(userReq.Password)
@@ -89,7 +105,11 @@ func (uh *UserHandler) HandleCreateUser(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	createdUser, err := uh.userStore.CreateUser(user)
+	if userReq.Template == "" {
+		userReq.Template = templates.DefaultKey
+	}
+
+	createdUser, err := uh.userStore.CreateUser(user, templates.Get(userReq.Template))
 	if err != nil {
 		uh.logger.Printf("ERROR: CreateUser: %v", err)
 		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
@@ -0,0 +1,309 @@
+package api
+
+import (
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"cha-ching-server/internal/webhook"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	defaultWebhookDeliveryLimit = 50
+	maxWebhookDeliveryLimit     = 500
+)
+
+type WebhookHandler struct {
+	logger       *log.Logger
+	webhookStore store.WebhookStore
+}
+
+func NewWebhookHandler(logger *log.Logger, webhookStore store.WebhookStore) *WebhookHandler {
+	return &WebhookHandler{
+		logger,
+		webhookStore,
+	}
+}
+
+type webhookSubscriptionRequest struct {
+	LedgerID   int      `json:"ledger_id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret"`
+	EventTypes []string `json:"event_types"`
+	Active     *bool    `json:"active"`
+}
+
+// validate resolves the URL, so the server is never pointed at its own
+// network.
+func (req *webhookSubscriptionRequest) validate(ctx context.Context) string {
+	err := webhook.ValidateURL(ctx, req.URL)
+	if err != nil {
+		return err.Error()
+	}
+
+	if len(req.EventTypes) == 0 {
+		return "event_types is required"
+	}
+
+	for _, eventType := range req.EventTypes {
+		if !store.IsValidWebhookEventType(eventType) {
+			return "event_types must be among " + strings.Join(store.WebhookEventTypes, ", ")
+		}
+	}
+
+	if req.Secret != "" && len(req.Secret) < 16 {
+		return "secret must be at least 16 characters"
+	}
+
+	return ""
+}
+
+func (wh *WebhookHandler) HandleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+
+	subscriptions, err := wh.webhookStore.ListWebhookSubscriptions(user.ID)
+	if err != nil {
+		wh.logger.Printf("ERROR: ListWebhookSubscriptions: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": subscriptions,
+	})
+}
+
+// HandleCreateWebhook returns the signing secret, generating one when none is
+// given. It is not shown again afterwards.
+func (wh *WebhookHandler) HandleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookSubscriptionRequest
+
+	err := utils.ReadRequestBody(r, &req)
+	if err != nil {
+		wh.logger.Printf("ERROR: decoding create webhook request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.LedgerID <= 0 {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "ledger_id is required"})
+		return
+	}
+
+	if message := req.validate(r.Context()); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	if req.Secret == "" {
+		req.Secret, err = webhook.NewSecret()
+		if err != nil {
+			wh.logger.Printf("ERROR: NewSecret: %v", err)
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	user := middleware.GetUser(r)
+
+	subscription := &store.WebhookSubscription{
+		LedgerID:   req.LedgerID,
+		URL:        req.URL,
+		Secret:     req.Secret,
+		EventTypes: req.EventTypes,
+		Active:     req.Active == nil || *req.Active,
+		UserID:     user.ID,
+	}
+
+	createdSubscription, err := wh.webhookStore.CreateWebhookSubscription(subscription)
+	if errors.Is(err, store.ErrInvalidWebhookLedger) {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("ERROR: CreateWebhookSubscription: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusCreated, utils.Envelope{
+		"data": createdSubscription,
+	})
+}
+
+// HandleUpdateWebhook replaces the URL, event types and active flag. The
+// ledger and secret of a subscription cannot be changed.
+func (wh *WebhookHandler) HandleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		wh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var req webhookSubscriptionRequest
+
+	err = utils.ReadRequestBody(r, &req)
+	if err != nil {
+		wh.logger.Printf("ERROR: decoding update webhook request body: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request body"})
+		return
+	}
+
+	if req.LedgerID != 0 || req.Secret != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "ledger_id and secret cannot be changed"})
+		return
+	}
+
+	if message := req.validate(r.Context()); message != "" {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": message})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	subscription := &store.WebhookSubscription{
+		ID:         int(id),
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+		Active:     req.Active == nil || *req.Active,
+		UserID:     user.ID,
+	}
+
+	updatedSubscription, err := wh.webhookStore.UpdateWebhookSubscription(subscription)
+	if err != nil {
+		wh.logger.Printf("ERROR: UpdateWebhookSubscription: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if updatedSubscription == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "webhook subscription not found"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": updatedSubscription,
+	})
+}
+
+func (wh *WebhookHandler) HandleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		wh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	deleted, err := wh.webhookStore.DeleteWebhookSubscription(user.ID, id)
+	if err != nil {
+		wh.logger.Printf("ERROR: DeleteWebhookSubscription: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if !deleted {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "webhook subscription not found"})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (wh *WebhookHandler) HandleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		wh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	var queryParams store.WebhookDeliveryQueryParams
+	err = utils.QueryParamsDecoder(r, &queryParams)
+	if err != nil {
+		wh.logger.Printf("ERROR: QueryParamsDecoder: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid query parameters"})
+		return
+	}
+
+	if queryParams.Status != nil {
+		switch *queryParams.Status {
+		case store.WebhookDeliveryPending, store.WebhookDeliverySucceeded, store.WebhookDeliveryFailed:
+		default:
+			utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "status must be one of pending, succeeded, failed"})
+			return
+		}
+	}
+
+	if queryParams.Limit == nil {
+		limit := defaultWebhookDeliveryLimit
+		queryParams.Limit = &limit
+	}
+
+	if *queryParams.Limit < 1 || *queryParams.Limit > maxWebhookDeliveryLimit {
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": fmt.Sprintf("limit must be between 1 and %d", maxWebhookDeliveryLimit)})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	deliveries, err := wh.webhookStore.ListWebhookDeliveries(user.ID, id, queryParams)
+	if errors.Is(err, store.ErrWebhookSubscriptionNotFound) {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	if err != nil {
+		wh.logger.Printf("ERROR: ListWebhookDeliveries: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusOK, utils.Envelope{
+		"data": deliveries,
+	})
+}
+
+// HandleRedeliverWebhook queues the event of a past delivery again. The new
+// delivery is sent by the retry worker within a few seconds.
+func (wh *WebhookHandler) HandleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := utils.ReadIDParam(r)
+	if err != nil {
+		wh.logger.Printf("ERROR: ReadIDParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid id parameter"})
+		return
+	}
+
+	deliveryID, err := utils.ReadInt64URLParam(r, "deliveryID")
+	if err != nil {
+		wh.logger.Printf("ERROR: ReadInt64URLParam: %v", err)
+		utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	user := middleware.GetUser(r)
+
+	delivery, err := wh.webhookStore.RedeliverWebhook(user.ID, id, deliveryID)
+	if err != nil {
+		wh.logger.Printf("ERROR: RedeliverWebhook: %v", err)
+		utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	if delivery == nil {
+		utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "webhook delivery not found"})
+		return
+	}
+
+	utils.WriteJSONResponse(w, http.StatusAccepted, utils.Envelope{
+		"data": delivery,
+	})
+}
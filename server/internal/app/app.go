@@ -3,9 +3,13 @@ package app
 import (
 	"cha-ching-server/internal/api"
 	"cha-ching-server/internal/config"
+	"cha-ching-server/internal/digest"
+	"cha-ching-server/internal/events"
 	"cha-ching-server/internal/middleware"
 	"cha-ching-server/internal/migrations"
+	"cha-ching-server/internal/notifier"
 	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/webhook"
 	"database/sql"
 	"fmt"
 	"log"
@@ -20,7 +24,30 @@ type Application struct {
 	PaymentMethodHandler *api.PaymentMethodHandler
 	UserHandler          *api.UserHandler
 	TokenHandler         *api.TokenHandler
+	LedgerHandler        *api.LedgerHandler
+	ComparisonHandler    *api.ComparisonHandler
+	ForecastHandler      *api.ForecastHandler
+	AnomalyHandler       *api.AnomalyHandler
+	BudgetHandler        *api.BudgetHandler
+	EnvelopeHandler      *api.EnvelopeHandler
+	BudgetGroupHandler   *api.BudgetGroupHandler
+	BudgetAlertHandler   *api.BudgetAlertHandler
+	InboxHandler         *api.InboxHandler
+	GoalHandler          *api.GoalHandler
+	StatementHandler     *api.StatementHandler
+	TemplateHandler      *api.TemplateHandler
+	ReportHandler        *api.ReportHandler
+	TaxHandler           *api.TaxHandler
+	DigestHandler        *api.DigestHandler
+	DigestScheduler      *digest.Scheduler
+	WebhookHandler       *api.WebhookHandler
+	WebhookPublisher     *webhook.Publisher
+	EventHandler         *api.EventHandler
+	EventBroker          *events.Broker
+	SyncHandler          *api.SyncHandler
+	GraphQLHandler       *api.GraphQLHandler
 	UserMiddleware       *middleware.UserMiddleware
+	LedgerMiddleware     *middleware.LedgerMiddleware
 	Database             *sql.DB
 }
 
@@ -42,14 +69,67 @@ func NewApplication(cfg *config.Config) (*Application, error) {
 	categoryStore := store.NewPostgresCategoryStore(db)
 	paymentMethodStore := store.NewPostgresPaymentMethodStore(db)
 	tokenStore := store.NewPostgresTokenStore(db)
+	ledgerStore := store.NewPostgresLedgerStore(db)
+	comparisonStore := store.NewPostgresComparisonStore(db)
+	forecastStore := store.NewPostgresForecastStore(db)
+	anomalyStore := store.NewPostgresAnomalyStore(db)
+	budgetStore := store.NewPostgresBudgetStore(db)
+	envelopeStore := store.NewPostgresEnvelopeStore(db)
+	budgetGroupStore := store.NewPostgresBudgetGroupStore(db)
+	budgetAlertStore := store.NewPostgresBudgetAlertStore(db)
+	inboxStore := store.NewPostgresInboxStore(db)
+	goalStore := store.NewPostgresGoalStore(db)
+	statementStore := store.NewPostgresStatementStore(db)
+	templateStore := store.NewPostgresTemplateStore(db)
+	reportStore := store.NewPostgresReportStore(db)
+	taxStore := store.NewPostgresTaxStore(db)
+	digestStore := store.NewPostgresDigestStore(db)
+	webhookStore := store.NewPostgresWebhookStore(db)
+	syncStore := store.NewPostgresSyncStore(db)
+
+	notifiers := notifier.Multi{notifier.NewInboxNotifier(inboxStore)}
+	if cfg.Notifier.WebhookURL != "" {
+		notifiers = append(notifiers, notifier.NewWebhookNotifier(cfg.Notifier.WebhookURL))
+	}
+	// Digests are only scheduled when there is a mail server to send them
+	var digestScheduler *digest.Scheduler
+	if cfg.SMTP.Host != "" {
+		mailer := notifier.NewMailer(cfg.SMTP)
+		notifiers = append(notifiers, notifier.NewSMTPNotifier(mailer))
+		digestScheduler = digest.NewScheduler(logger, digestStore, mailer, cfg.Digest.Interval)
+	}
+	dispatcher := notifier.NewDispatcher(logger, ledgerStore, notifiers)
+	webhookPublisher := webhook.NewPublisher(logger, webhookStore)
+	eventBroker := events.NewBroker(logger, db, ledgerStore)
+	publisher := events.Multi{webhookPublisher, eventBroker}
 
 	userHandler := api.NewUserHandler(logger, userStore)
-	expenseHandler := api.NewExpenseHandler(logger, expenseStore)
-	categoryHandler := api.NewCategoryHandler(logger, categoryStore)
-	paymentMethodHandler := api.NewPaymentMethodHandler(logger, paymentMethodStore)
+	expenseHandler := api.NewExpenseHandler(logger, expenseStore, anomalyStore, budgetAlertStore, statementStore, dispatcher, publisher)
+	categoryHandler := api.NewCategoryHandler(logger, categoryStore, publisher)
+	paymentMethodHandler := api.NewPaymentMethodHandler(logger, paymentMethodStore, publisher)
 	tokenHandler := api.NewTokenHandler(logger, tokenStore, userStore)
+	ledgerHandler := api.NewLedgerHandler(logger, ledgerStore)
+	comparisonHandler := api.NewComparisonHandler(logger, comparisonStore)
+	forecastHandler := api.NewForecastHandler(logger, forecastStore)
+	anomalyHandler := api.NewAnomalyHandler(logger, anomalyStore)
+	budgetHandler := api.NewBudgetHandler(logger, budgetStore)
+	envelopeHandler := api.NewEnvelopeHandler(logger, envelopeStore)
+	budgetGroupHandler := api.NewBudgetGroupHandler(logger, budgetGroupStore)
+	budgetAlertHandler := api.NewBudgetAlertHandler(logger, budgetAlertStore)
+	inboxHandler := api.NewInboxHandler(logger, inboxStore)
+	goalHandler := api.NewGoalHandler(logger, goalStore)
+	statementHandler := api.NewStatementHandler(logger, statementStore)
+	templateHandler := api.NewTemplateHandler(logger, templateStore)
+	reportHandler := api.NewReportHandler(logger, reportStore, expenseStore, categoryStore, paymentMethodStore)
+	taxHandler := api.NewTaxHandler(logger, taxStore)
+	digestHandler := api.NewDigestHandler(logger, digestStore)
+	webhookHandler := api.NewWebhookHandler(logger, webhookStore)
+	eventHandler := api.NewEventHandler(logger, eventBroker)
+	syncHandler := api.NewSyncHandler(logger, syncStore, publisher)
+	graphQLHandler := api.NewGraphQLHandler(logger, expenseStore, categoryStore, paymentMethodStore, budgetAlertStore, dispatcher, publisher)
 
 	userMiddleware := middleware.NewUserMiddleware(userStore)
+	ledgerMiddleware := middleware.NewLedgerMiddleware(ledgerStore)
 
 	app := &Application{
 		Logger:               logger,
@@ -58,7 +138,30 @@ func NewApplication(cfg *config.Config) (*Application, error) {
 		PaymentMethodHandler: paymentMethodHandler,
 		UserHandler:          userHandler,
 		TokenHandler:         tokenHandler,
+		LedgerHandler:        ledgerHandler,
+		ComparisonHandler:    comparisonHandler,
+		ForecastHandler:      forecastHandler,
+		AnomalyHandler:       anomalyHandler,
+		BudgetHandler:        budgetHandler,
+		EnvelopeHandler:      envelopeHandler,
+		BudgetGroupHandler:   budgetGroupHandler,
+		BudgetAlertHandler:   budgetAlertHandler,
+		InboxHandler:         inboxHandler,
+		GoalHandler:          goalHandler,
+		StatementHandler:     statementHandler,
+		TemplateHandler:      templateHandler,
+		ReportHandler:        reportHandler,
+		TaxHandler:           taxHandler,
+		DigestHandler:        digestHandler,
+		DigestScheduler:      digestScheduler,
+		WebhookHandler:       webhookHandler,
+		WebhookPublisher:     webhookPublisher,
+		EventHandler:         eventHandler,
+		EventBroker:          eventBroker,
+		SyncHandler:          syncHandler,
+		GraphQLHandler:       graphQLHandler,
 		UserMiddleware:       userMiddleware,
+		LedgerMiddleware:     ledgerMiddleware,
 		Database:             db,
 	}
 
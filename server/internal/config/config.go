@@ -2,12 +2,16 @@ package config
 
 import (
 	"os"
+	"time"
 )
 
 type Config struct {
 	Database DatabaseConfig
 	Server   ServerConfig
 	Client   ClientConfig
+	SMTP     SMTPConfig
+	Notifier NotifierConfig
+	Digest   DigestConfig
 }
 
 type DatabaseConfig struct {
@@ -27,7 +31,33 @@ type ClientConfig struct {
 	AllowedOrigins []string
 }
 
+// SMTPConfig is the mail server used for email notifications. Email is
+// disabled when Host is empty. Username may be empty for servers without
+// authentication, such as a local development SMTP sink.
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type NotifierConfig struct {
+	WebhookURL string
+}
+
+// DigestConfig controls how often the digest scheduler checks for users whose
+// weekly or monthly digest is due. Digests are only sent when SMTP is set up.
+type DigestConfig struct {
+	Interval time.Duration
+}
+
 func Load() (*Config, error) {
+	digestInterval, err := time.ParseDuration(getEnv("DIGEST_INTERVAL", "1h"))
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
 		Database: DatabaseConfig{
 			Host:     getEnv("POSTGRES_HOST", "localhost"),
@@ -43,6 +73,19 @@ func Load() (*Config, error) {
 		Client: ClientConfig{
 			AllowedOrigins: []string{getEnv("CLIENT_ALLOWED_ORIGIN", "http://localhost:8081")},
 		},
+		SMTP: SMTPConfig{
+			Host:     getEnv("SMTP_HOST", ""),
+			Port:     getEnv("SMTP_PORT", "25"),
+			Username: getEnv("SMTP_USERNAME", ""),
+			Password: getEnv("SMTP_PASSWORD", ""),
+			From:     getEnv("SMTP_FROM", "cha-ching@localhost"),
+		},
+		Notifier: NotifierConfig{
+			WebhookURL: getEnv("NOTIFIER_WEBHOOK_URL", ""),
+		},
+		Digest: DigestConfig{
+			Interval: digestInterval,
+		},
 	}, nil
 }
 
@@ -0,0 +1,116 @@
+// Package digest renders and sends the opt-in weekly and monthly summary
+// emails.
+package digest
+
+import (
+	"bytes"
+	"cha-ching-server/internal/store"
+	_ "embed"
+	"fmt"
+	htmltemplate "html/template"
+	"math"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed digest.txt.tmpl
+var textSource string
+
+//go:embed digest.html.tmpl
+var htmlSource string
+
+var funcs = map[string]any{
+	"money":   formatMoney,
+	"percent": formatPercent,
+	"change":  formatChange,
+	"date":    formatDate,
+}
+
+var (
+	textTemplate = texttemplate.Must(texttemplate.New("digest.txt").Funcs(funcs).Parse(textSource))
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(funcs).Parse(htmlSource))
+)
+
+type Email struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type emailData struct {
+	*store.Digest
+	Name       string
+	LedgerName string
+	Frequency  string
+	PeriodNoun string
+}
+
+// Render builds the email for one subscriber from their digest.
+func Render(subscriber *store.DigestSubscriber, digest *store.Digest) (*Email, error) {
+	data := emailData{
+		Digest:     digest,
+		Name:       subscriber.Name,
+		LedgerName: subscriber.LedgerName,
+		Frequency:  subscriber.Frequency,
+		PeriodNoun: "month",
+	}
+	if subscriber.Frequency == store.DigestFrequencyWeekly {
+		data.PeriodNoun = "week"
+	}
+
+	var text bytes.Buffer
+	err := textTemplate.Execute(&text, data)
+	if err != nil {
+		return nil, err
+	}
+
+	var html bytes.Buffer
+	err = htmlTemplate.Execute(&html, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Email{
+		Subject: fmt.Sprintf("Your %s digest for %s: %s to %s", subscriber.Frequency, subscriber.LedgerName, formatDate(digest.Current.StartDate), formatDate(digest.Current.EndDate)),
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+func formatMoney(amount float64) string {
+	return fmt.Sprintf("Rs. %.2f", amount)
+}
+
+func formatPercent(percent *float64) string {
+	if percent == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%.0f%%", *percent)
+}
+
+func formatChange(delta store.Delta) string {
+	if delta.Amount == 0 {
+		return "no change"
+	}
+
+	direction := "up"
+	if delta.Amount < 0 {
+		direction = "down"
+	}
+
+	if delta.Percent == nil {
+		return fmt.Sprintf("%s %s", direction, formatMoney(math.Abs(delta.Amount)))
+	}
+
+	return fmt.Sprintf("%s %.0f%%", direction, math.Abs(*delta.Percent))
+}
+
+func formatDate(date string) string {
+	parsed, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+
+	return parsed.Format("2 Jan 2006")
+}
@@ -0,0 +1,153 @@
+package digest
+
+import (
+	"cha-ching-server/internal/notifier"
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"context"
+	"log"
+	"time"
+)
+
+const (
+	// sendTimeout bounds building and mailing one digest.
+	sendTimeout = time.Minute
+
+	// claimLease outlasts sendTimeout, so a claim only runs out when the
+	// instance holding it stopped midway.
+	claimLease = 5 * time.Minute
+
+	// markAttempts and markRetryDelay keep retrying to mark a mailed digest
+	// well within its lease.
+	markAttempts   = 3
+	markRetryDelay = 10 * time.Second
+)
+
+// Scheduler periodically emails every subscriber whose last complete week
+// or month has not been sent yet. Progress is kept in the database, so a
+// restart neither skips nor repeats a digest, and each digest is claimed
+// before it is sent, so every server instance can run a scheduler.
+type Scheduler struct {
+	logger      *log.Logger
+	digestStore store.DigestStore
+	mailer      *notifier.Mailer
+	interval    time.Duration
+	retryDelay  time.Duration
+}
+
+func NewScheduler(logger *log.Logger, digestStore store.DigestStore, mailer *notifier.Mailer, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		logger,
+		digestStore,
+		mailer,
+		interval,
+		markRetryDelay,
+	}
+}
+
+// Start checks for due digests right away and then once every interval
+// until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.SendDue(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) SendDue(ctx context.Context) {
+	subscribers, err := s.digestStore.ListDigestSubscribers()
+	if err != nil {
+		s.logger.Printf("ERROR: ListDigestSubscribers: %v", err)
+		return
+	}
+
+	for _, subscriber := range subscribers {
+		today, err := utils.TodayIn(subscriber.Timezone)
+		if err != nil {
+			s.logger.Printf("ERROR: TodayIn %s: %v", subscriber.Timezone, err)
+			continue
+		}
+
+		current, previous := store.DigestPeriods(subscriber.Frequency, today)
+		if subscriber.LastPeriodStart != nil && *subscriber.LastPeriodStart >= current.StartDate {
+			continue
+		}
+
+		claimed, err := s.digestStore.ClaimDigest(subscriber.UserID, current.StartDate, claimLease)
+		if err != nil {
+			s.logger.Printf("ERROR: ClaimDigest: %v", err)
+			continue
+		}
+
+		// Another instance is sending it or just did
+		if !claimed {
+			continue
+		}
+
+		err = s.send(ctx, subscriber, current, previous)
+		if err != nil {
+			s.logger.Printf("ERROR: sending digest to user %d: %v", subscriber.UserID, err)
+
+			err = s.digestStore.ReleaseDigest(subscriber.UserID)
+			if err != nil {
+				s.logger.Printf("ERROR: ReleaseDigest: %v", err)
+			}
+		} else {
+			s.markSent(ctx, subscriber, current)
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (s *Scheduler) send(ctx context.Context, subscriber *store.DigestSubscriber, current store.DateRange, previous store.DateRange) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+
+	digest, err := s.digestStore.BuildDigest(subscriber.LedgerID, current, previous)
+	if err != nil {
+		return err
+	}
+
+	email, err := Render(subscriber, digest)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.SendContext(ctx, []string{subscriber.Email}, email.Subject, email.Text, email.HTML)
+}
+
+// markSent records a mailed digest as sent. The claim is never released
+// here, as that would mail the digest again on the next check. If every
+// attempt fails, the claim runs out with its lease.
+func (s *Scheduler) markSent(ctx context.Context, subscriber *store.DigestSubscriber, current store.DateRange) {
+	for attempt := 1; ; attempt++ {
+		err := s.digestStore.MarkDigestSent(subscriber.UserID, current.StartDate)
+		if err == nil {
+			return
+		}
+
+		s.logger.Printf("ERROR: MarkDigestSent for user %d, attempt %d: %v", subscriber.UserID, attempt, err)
+		if attempt == markAttempts {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.retryDelay):
+		}
+	}
+}
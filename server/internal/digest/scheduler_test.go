@@ -0,0 +1,351 @@
+package digest
+
+import (
+	"bytes"
+	"cha-ching-server/internal/config"
+	"cha-ching-server/internal/notifier"
+	"cha-ching-server/internal/smtptest"
+	"cha-ching-server/internal/store"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDigestStore keeps preferences in memory with the same claim rules as
+// the Postgres store.
+type fakeDigestStore struct {
+	mu          sync.Mutex
+	subscribers []*store.DigestSubscriber
+	claims      map[int]time.Time
+	builds      int
+	markErrors  int
+	releases    int
+}
+
+func newFakeDigestStore(subscribers ...*store.DigestSubscriber) *fakeDigestStore {
+	return &fakeDigestStore{
+		subscribers: subscribers,
+		claims:      map[int]time.Time{},
+	}
+}
+
+func (f *fakeDigestStore) GetDigestPreference(userID int) (*store.DigestPreference, error) {
+	panic("not used")
+}
+
+func (f *fakeDigestStore) SetDigestPreference(preference *store.DigestPreference) (*store.DigestPreference, error) {
+	panic("not used")
+}
+
+func (f *fakeDigestStore) ListDigestSubscribers() ([]*store.DigestSubscriber, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscribers := make([]*store.DigestSubscriber, len(f.subscribers))
+	for i, subscriber := range f.subscribers {
+		copied := *subscriber
+		subscribers[i] = &copied
+	}
+	return subscribers, nil
+}
+
+func (f *fakeDigestStore) BuildDigest(ledgerID int, current store.DateRange, previous store.DateRange) (*store.Digest, error) {
+	f.mu.Lock()
+	f.builds++
+	f.mu.Unlock()
+
+	return &store.Digest{
+		Current:  store.PeriodTotals{DateRange: current, TotalAmount: 1200, TotalCount: 3},
+		Previous: store.PeriodTotals{DateRange: previous, TotalAmount: 1000, TotalCount: 2},
+		Delta:    store.Delta{Amount: 200, Count: 1},
+		TopCategories: []*store.DigestCategory{
+			{ID: 1, Name: "Food", TotalAmount: 1200},
+		},
+		BiggestExpenses: []*store.DigestExpense{
+			{ID: 7, Title: "Groceries", Amount: 800, ExpenseDate: current.StartDate, CategoryName: "Food"},
+		},
+	}, nil
+}
+
+func (f *fakeDigestStore) find(userID int) *store.DigestSubscriber {
+	for _, subscriber := range f.subscribers {
+		if subscriber.UserID == userID {
+			return subscriber
+		}
+	}
+	return nil
+}
+
+func (f *fakeDigestStore) ClaimDigest(userID int, periodStart string, lease time.Duration) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscriber := f.find(userID)
+	if subscriber == nil || !subscriber.Enabled {
+		return false, nil
+	}
+	if subscriber.LastPeriodStart != nil && *subscriber.LastPeriodStart >= periodStart {
+		return false, nil
+	}
+	if until, ok := f.claims[userID]; ok && time.Now().Before(until) {
+		return false, nil
+	}
+
+	f.claims[userID] = time.Now().Add(lease)
+	return true, nil
+}
+
+func (f *fakeDigestStore) ReleaseDigest(userID int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.releases++
+	delete(f.claims, userID)
+	return nil
+}
+
+// MarkDigestSent fails while markErrors is above zero.
+func (f *fakeDigestStore) MarkDigestSent(userID int, periodStart string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.markErrors > 0 {
+		f.markErrors--
+		return errors.New("connection reset")
+	}
+
+	f.find(userID).LastPeriodStart = &periodStart
+	delete(f.claims, userID)
+	return nil
+}
+
+func (f *fakeDigestStore) lastPeriodStart(userID int) *string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.find(userID).LastPeriodStart
+}
+
+func newSubscriber(userID int, email string, frequency string) *store.DigestSubscriber {
+	return &store.DigestSubscriber{
+		DigestPreference: store.DigestPreference{
+			UserID:    userID,
+			LedgerID:  1,
+			Frequency: frequency,
+			Enabled:   true,
+		},
+		Name:       "Asha",
+		Email:      email,
+		Timezone:   "Asia/Kolkata",
+		LedgerName: "Home",
+	}
+}
+
+func currentPeriod(t *testing.T, frequency string) store.DateRange {
+	t.Helper()
+
+	location, err := time.LoadLocation("Asia/Kolkata")
+	if err != nil {
+		t.Fatalf("loading timezone: %v", err)
+	}
+
+	now := time.Now().In(location)
+	current, _ := store.DigestPeriods(frequency, time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC))
+	return current
+}
+
+func newTestScheduler(digestStore store.DigestStore, cfg config.SMTPConfig) *Scheduler {
+	return NewScheduler(log.New(io.Discard, "", 0), digestStore, notifier.NewMailer(cfg), time.Hour)
+}
+
+func TestSchedulerSendDue(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	digestStore := newFakeDigestStore(newSubscriber(1, "asha@example.com", store.DigestFrequencyWeekly))
+	scheduler := newTestScheduler(digestStore, sink.Config("digest@cha-ching.test"))
+
+	scheduler.SendDue(context.Background())
+
+	messages := sink.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	message := messages[0]
+	if len(message.To) != 1 || message.To[0] != "asha@example.com" {
+		t.Errorf("envelope to = %q", message.To)
+	}
+	if message.From != "digest@cha-ching.test" {
+		t.Errorf("envelope from = %q", message.From)
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message.Data))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("decoding subject: %v", err)
+	}
+	if !strings.HasPrefix(subject, "Your weekly digest for Home: ") {
+		t.Errorf("Subject = %q", subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", parsed.Header.Get("Content-Type"), err)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, contentType := range []string{"text/plain", "text/html"} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("reading %s part: %v", contentType, err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), contentType) {
+			t.Errorf("part Content-Type = %q, want %s", part.Header.Get("Content-Type"), contentType)
+		}
+
+		body, _ := io.ReadAll(part)
+		if !strings.Contains(string(body), "Groceries") {
+			t.Errorf("%s part does not list the biggest expense", contentType)
+		}
+	}
+
+	current := currentPeriod(t, store.DigestFrequencyWeekly)
+	if last := digestStore.lastPeriodStart(1); last == nil || *last != current.StartDate {
+		t.Errorf("last period start = %v, want %s", last, current.StartDate)
+	}
+
+	// The period is sent, so the next check has nothing to do
+	scheduler.SendDue(context.Background())
+
+	if messages := sink.Messages(); len(messages) != 1 {
+		t.Errorf("got %d messages after a second check, want 1", len(messages))
+	}
+}
+
+func TestSchedulerSkipsSentPeriod(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	current := currentPeriod(t, store.DigestFrequencyMonthly)
+
+	subscriber := newSubscriber(1, "asha@example.com", store.DigestFrequencyMonthly)
+	subscriber.LastPeriodStart = &current.StartDate
+
+	digestStore := newFakeDigestStore(subscriber)
+	newTestScheduler(digestStore, sink.Config("digest@cha-ching.test")).SendDue(context.Background())
+
+	if messages := sink.Messages(); len(messages) != 0 {
+		t.Errorf("got %d messages, want none", len(messages))
+	}
+	if digestStore.builds != 0 {
+		t.Errorf("built %d digests, want none", digestStore.builds)
+	}
+}
+
+func TestSchedulerInstancesSendOnce(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	digestStore := newFakeDigestStore(
+		newSubscriber(1, "asha@example.com", store.DigestFrequencyWeekly),
+		newSubscriber(2, "ravi@example.com", store.DigestFrequencyMonthly),
+		newSubscriber(3, "meera@example.com", store.DigestFrequencyWeekly),
+	)
+
+	// Every instance sees the same due subscribers, but only one may send
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			newTestScheduler(digestStore, sink.Config("digest@cha-ching.test")).SendDue(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	sent := map[string]int{}
+	for _, message := range sink.Messages() {
+		for _, to := range message.To {
+			sent[to]++
+		}
+	}
+
+	for _, email := range []string{"asha@example.com", "ravi@example.com", "meera@example.com"} {
+		if sent[email] != 1 {
+			t.Errorf("%s got %d digests, want 1", email, sent[email])
+		}
+	}
+}
+
+func TestSchedulerRetriesFailedSend(t *testing.T) {
+	// Nothing listens on a closed listener's port, so the first send fails
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+
+	digestStore := newFakeDigestStore(newSubscriber(1, "asha@example.com", store.DigestFrequencyWeekly))
+
+	newTestScheduler(digestStore, config.SMTPConfig{Host: host, Port: port, From: "digest@cha-ching.test"}).SendDue(context.Background())
+
+	if last := digestStore.lastPeriodStart(1); last != nil {
+		t.Fatalf("failed send marked period %s as sent", *last)
+	}
+
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	newTestScheduler(digestStore, sink.Config("digest@cha-ching.test")).SendDue(context.Background())
+
+	if messages := sink.Messages(); len(messages) != 1 {
+		t.Errorf("got %d messages on retry, want 1", len(messages))
+	}
+}
+
+func TestSchedulerKeepsClaimWhenMarkFails(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	digestStore := newFakeDigestStore(newSubscriber(1, "asha@example.com", store.DigestFrequencyWeekly))
+	digestStore.markErrors = 1
+
+	scheduler := newTestScheduler(digestStore, sink.Config("digest@cha-ching.test"))
+	scheduler.retryDelay = time.Millisecond
+	scheduler.SendDue(context.Background())
+
+	current := currentPeriod(t, store.DigestFrequencyWeekly)
+	if last := digestStore.lastPeriodStart(1); last == nil || *last != current.StartDate {
+		t.Errorf("last period start = %v, want %s after a retried mark", last, current.StartDate)
+	}
+
+	// Every mark fails now, but the mail went out, so the claim stays
+	digestStore.find(1).LastPeriodStart = nil
+	digestStore.markErrors = markAttempts
+	scheduler.SendDue(context.Background())
+
+	if digestStore.releases != 0 {
+		t.Errorf("released the claim %d times after mailing, want never", digestStore.releases)
+	}
+
+	scheduler.SendDue(context.Background())
+
+	if messages := sink.Messages(); len(messages) != 2 {
+		t.Errorf("got %d messages, want 2", len(messages))
+	}
+}
@@ -0,0 +1,309 @@
+package events
+
+import (
+	"cha-ching-server/internal/store"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+// channel is the Postgres NOTIFY channel shared by every server instance.
+const channel = "ledger_events"
+
+// membersChannel carries the ledger_members changes announced by the
+// ledger_members_notify trigger.
+const membersChannel = "ledger_members"
+
+// maxPayload keeps notifications under the 8000 byte limit of NOTIFY. Larger
+// events are sent without their data, and clients fetch the record instead.
+const maxPayload = 7900
+
+const (
+	subscriberBuffer = 32
+	reconnectDelay   = 5 * time.Second
+)
+
+type Event struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	LedgerID  int             `json:"ledger_id"`
+	CreatedAt string          `json:"created_at"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Subscription receives the events of the ledgers its user is a member of.
+// Ledgers are added and removed as the user joins and leaves them. Events is
+// closed when the subscriber falls too far behind or membership changes may
+// have been missed, and the client is expected to reconnect and refetch.
+type Subscription struct {
+	Events  <-chan *Event
+	events  chan *Event
+	userID  int
+	ledgers map[int]bool
+
+	// pending holds the membership changes that arrive while Subscribe is
+	// still loading the user's ledgers. They are applied over what it loads.
+	loaded  bool
+	pending []*memberChange
+}
+
+// memberChange is the payload of a membersChannel notification.
+type memberChange struct {
+	Op       string `json:"op"`
+	LedgerID int    `json:"ledger_id"`
+	UserID   int    `json:"user_id"`
+}
+
+// Broker publishes events with NOTIFY and delivers the notifications every
+// instance receives to the subscriptions held in this process.
+type Broker struct {
+	logger      *log.Logger
+	db          *sql.DB
+	ledgerStore store.LedgerStore
+
+	mu            sync.Mutex
+	subscriptions map[*Subscription]bool
+}
+
+func NewBroker(logger *log.Logger, db *sql.DB, ledgerStore store.LedgerStore) *Broker {
+	return &Broker{
+		logger:        logger,
+		db:            db,
+		ledgerStore:   ledgerStore,
+		subscriptions: make(map[*Subscription]bool),
+	}
+}
+
+func (b *Broker) Publish(ledgerID int, eventType string, data any) {
+	go func() {
+		id := make([]byte, 16)
+		_, err := rand.Read(id)
+		if err != nil {
+			b.logger.Printf("ERROR: generating event id: %v", err)
+			return
+		}
+
+		event := &Event{
+			ID:        hex.EncodeToString(id),
+			Type:      eventType,
+			LedgerID:  ledgerID,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		}
+
+		event.Data, err = json.Marshal(data)
+		if err != nil {
+			b.logger.Printf("ERROR: encoding event %s: %v", eventType, err)
+			return
+		}
+
+		payload, err := json.Marshal(event)
+		if err == nil && len(payload) > maxPayload {
+			event.Data = nil
+			payload, err = json.Marshal(event)
+		}
+		if err != nil {
+			b.logger.Printf("ERROR: encoding event %s: %v", eventType, err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_, err = b.db.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, string(payload))
+		if err != nil {
+			b.logger.Printf("ERROR: pg_notify %s: %v", eventType, err)
+		}
+	}()
+}
+
+// Subscribe starts a subscription to every ledger the user is a member of.
+// Callers must Unsubscribe when they are done.
+func (b *Broker) Subscribe(userID int) (*Subscription, error) {
+	events := make(chan *Event, subscriberBuffer)
+	subscription := &Subscription{
+		Events:  events,
+		events:  events,
+		userID:  userID,
+		ledgers: make(map[int]bool),
+	}
+
+	// Register before loading, so a change committed during the load is
+	// still seen
+	b.mu.Lock()
+	b.subscriptions[subscription] = true
+	b.mu.Unlock()
+
+	ledgers, err := b.ledgerStore.ListLedgersForUser(userID)
+	if err != nil {
+		b.Unsubscribe(subscription)
+		return nil, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, ledger := range ledgers {
+		subscription.ledgers[ledger.ID] = true
+	}
+	for _, change := range subscription.pending {
+		subscription.apply(change)
+	}
+	subscription.loaded = true
+	subscription.pending = nil
+
+	return subscription, nil
+}
+
+func (s *Subscription) apply(change *memberChange) {
+	if change.Op == "DELETE" {
+		delete(s.ledgers, change.LedgerID)
+	} else {
+		s.ledgers[change.LedgerID] = true
+	}
+}
+
+func (b *Broker) Unsubscribe(subscription *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscriptions[subscription] {
+		delete(b.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+func (b *Broker) broadcast(event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscriptions {
+		if !subscription.ledgers[event.LedgerID] {
+			continue
+		}
+
+		select {
+		case subscription.events <- event:
+		default:
+			// Drop a subscriber that is not keeping up rather than block
+			// every other one
+			delete(b.subscriptions, subscription)
+			close(subscription.events)
+		}
+	}
+}
+
+// updateMembership adds or removes the ledger on the subscriptions of the
+// user whose membership changed. Nothing is sent for a removed ledger after
+// the change arrives.
+func (b *Broker) updateMembership(change *memberChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscriptions {
+		if subscription.userID != change.UserID {
+			continue
+		}
+
+		if !subscription.loaded {
+			subscription.pending = append(subscription.pending, change)
+			continue
+		}
+
+		subscription.apply(change)
+	}
+}
+
+// closeAll ends every subscription. Membership changes sent while no
+// connection was listening are lost, so the ledgers of open subscriptions
+// can no longer be trusted.
+func (b *Broker) closeAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for subscription := range b.subscriptions {
+		delete(b.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
+
+// Start listens for notifications until ctx is done, reconnecting whenever
+// the listening connection is lost.
+func (b *Broker) Start(ctx context.Context) {
+	go func() {
+		for {
+			err := b.listen(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
+			b.logger.Printf("ERROR: listening for events: %v", err)
+			b.closeAll()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(reconnectDelay):
+			}
+		}
+	}()
+}
+
+// listen holds one connection out of the pool for LISTEN. The connection is
+// discarded afterwards rather than returned to the pool still listening.
+func (b *Broker) listen(ctx context.Context) error {
+	conn, err := b.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	var listenErr error
+	conn.Raw(func(driverConn any) error {
+		pgxConn := driverConn.(*stdlib.Conn).Conn()
+
+		_, listenErr = pgxConn.Exec(ctx, "LISTEN "+channel+"; LISTEN "+membersChannel)
+		if listenErr != nil {
+			return driver.ErrBadConn
+		}
+
+		for {
+			notification, err := pgxConn.WaitForNotification(ctx)
+			if err != nil {
+				listenErr = err
+				return driver.ErrBadConn
+			}
+
+			if notification.Channel == membersChannel {
+				var change memberChange
+				err = json.Unmarshal([]byte(notification.Payload), &change)
+				if err != nil {
+					b.logger.Printf("ERROR: decoding member notification: %v", err)
+					continue
+				}
+
+				b.updateMembership(&change)
+				continue
+			}
+
+			var event Event
+			err = json.Unmarshal([]byte(notification.Payload), &event)
+			if err != nil {
+				b.logger.Printf("ERROR: decoding event notification: %v", err)
+				continue
+			}
+
+			b.broadcast(&event)
+		}
+	})
+
+	return listenErr
+}
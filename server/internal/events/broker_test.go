@@ -0,0 +1,119 @@
+package events
+
+import (
+	"cha-ching-server/internal/store"
+	"io"
+	"log"
+	"testing"
+)
+
+// fakeLedgerStore lists fixed ledgers, and can run a hook while a
+// subscription is loading them.
+type fakeLedgerStore struct {
+	store.LedgerStore
+	ledgers  map[int][]int
+	onListed func()
+}
+
+func (f *fakeLedgerStore) ListLedgersForUser(userID int) ([]*store.Ledger, error) {
+	ledgers := []*store.Ledger{}
+	for _, id := range f.ledgers[userID] {
+		ledgers = append(ledgers, &store.Ledger{ID: id})
+	}
+
+	if f.onListed != nil {
+		f.onListed()
+	}
+	return ledgers, nil
+}
+
+func newTestBroker(ledgerStore store.LedgerStore) *Broker {
+	return NewBroker(log.New(io.Discard, "", 0), nil, ledgerStore)
+}
+
+// received drains the events already delivered to subscription.
+func received(subscription *Subscription) []int {
+	ledgers := []int{}
+	for {
+		select {
+		case event, ok := <-subscription.Events:
+			if !ok {
+				return ledgers
+			}
+			ledgers = append(ledgers, event.LedgerID)
+		default:
+			return ledgers
+		}
+	}
+}
+
+func TestBroadcastFollowsMembership(t *testing.T) {
+	broker := newTestBroker(&fakeLedgerStore{ledgers: map[int][]int{1: {10, 11}}})
+
+	subscription, err := broker.Subscribe(1)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer broker.Unsubscribe(subscription)
+
+	broker.broadcast(&Event{LedgerID: 10})
+	broker.broadcast(&Event{LedgerID: 12})
+
+	if got := received(subscription); len(got) != 1 || got[0] != 10 {
+		t.Fatalf("received events for ledgers %v, want [10]", got)
+	}
+
+	// Another user's changes leave the subscription alone
+	broker.updateMembership(&memberChange{Op: "DELETE", LedgerID: 10, UserID: 2})
+	broker.updateMembership(&memberChange{Op: "DELETE", LedgerID: 10, UserID: 1})
+	broker.updateMembership(&memberChange{Op: "INSERT", LedgerID: 12, UserID: 1})
+
+	broker.broadcast(&Event{LedgerID: 10})
+	broker.broadcast(&Event{LedgerID: 11})
+	broker.broadcast(&Event{LedgerID: 12})
+
+	if got := received(subscription); len(got) != 2 || got[0] != 11 || got[1] != 12 {
+		t.Errorf("received events for ledgers %v after leaving 10 and joining 12, want [11 12]", got)
+	}
+}
+
+func TestSubscribeAppliesChangesDuringLoad(t *testing.T) {
+	ledgerStore := &fakeLedgerStore{ledgers: map[int][]int{1: {10}}}
+	broker := newTestBroker(ledgerStore)
+
+	// The removal is announced after the ledgers were read but before the
+	// subscription has them
+	ledgerStore.onListed = func() {
+		broker.updateMembership(&memberChange{Op: "DELETE", LedgerID: 10, UserID: 1})
+	}
+
+	subscription, err := broker.Subscribe(1)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer broker.Unsubscribe(subscription)
+
+	broker.broadcast(&Event{LedgerID: 10})
+
+	if got := received(subscription); len(got) != 0 {
+		t.Errorf("received events for ledgers %v from a ledger the user left", got)
+	}
+}
+
+func TestCloseAllEndsSubscriptions(t *testing.T) {
+	broker := newTestBroker(&fakeLedgerStore{ledgers: map[int][]int{1: {10}}})
+
+	subscription, err := broker.Subscribe(1)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	broker.closeAll()
+
+	if _, ok := <-subscription.Events; ok {
+		t.Error("subscription is still open after closeAll")
+	}
+
+	// Unsubscribing afterwards, as the handler does, must not close twice
+	broker.Unsubscribe(subscription)
+}
@@ -0,0 +1,19 @@
+// Package events carries ledger changes to the clients watching them. Every
+// change is published once and fanned out to webhooks and to the live event
+// stream of each server instance.
+package events
+
+// Publisher announces a change to a ledger. Implementations deliver in the
+// background, so publishing never holds up a request.
+type Publisher interface {
+	Publish(ledgerID int, eventType string, data any)
+}
+
+// Multi publishes every event to each of its publishers.
+type Multi []Publisher
+
+func (m Multi) Publish(ledgerID int, eventType string, data any) {
+	for _, p := range m {
+		p.Publish(ledgerID, eventType, data)
+	}
+}
@@ -0,0 +1,455 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+type Result struct {
+	Data   any      `json:"data"`
+	Errors []*Error `json:"errors,omitempty"`
+}
+
+// orderedMap keeps the fields of a result in the order they were selected.
+type orderedMap struct {
+	keys   []string
+	values map[string]any
+}
+
+func newOrderedMap() *orderedMap {
+	return &orderedMap{values: map[string]any{}}
+}
+
+func (m *orderedMap) set(key string, value any) {
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+
+		v, err := json.Marshal(m.values[key])
+		if err != nil {
+			return nil, err
+		}
+
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+type executor struct {
+	doc       *Document
+	variables map[string]any
+	errors    []*Error
+}
+
+// collectedField is every selection of one response key in a selection set.
+type collectedField struct {
+	key    string
+	fields []*Field
+}
+
+// Execute runs an operation that passed Validate.
+//
+// Execution is breadth first: a field is resolved for every object in a
+// list before any of its subfields, and the thunks returned for the list
+// are only forced after all of them were resolved. A loader called from the
+// resolvers therefore sees every key of the list before it has to fetch.
+func (s *Schema) Execute(ctx context.Context, doc *Document, operation *Operation, variables map[string]any) *Result {
+	coerced, errs := s.coerceVariables(operation, variables)
+	if len(errs) > 0 {
+		return &Result{Errors: errs}
+	}
+
+	root := s.Query
+	if operation.Type == "mutation" {
+		root = s.Mutation
+	}
+
+	e := &executor{doc: doc, variables: coerced}
+
+	data := e.executeObjects(ctx, root, []any{nil}, operation.SelectionSet, [][]any{{}})
+
+	return &Result{Data: data[0], Errors: e.errors}
+}
+
+func (e *executor) addError(err error, field *Field, path []any) {
+	e.errors = append(e.errors, &Error{
+		Message:   err.Error(),
+		Locations: []Location{field.Location},
+		Path:      path,
+	})
+}
+
+func (e *executor) included(directives []*Directive) bool {
+	for _, directive := range directives {
+		args, err := coerceArguments(conditionArgs, directive.Arguments, e.variables)
+		if err != nil {
+			continue
+		}
+
+		condition := args["if"].(bool)
+		if (directive.Name == "skip" && condition) || (directive.Name == "include" && !condition) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (e *executor) collectFields(selections []Selection, collected []*collectedField, visited map[string]bool) []*collectedField {
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *Field:
+			if !e.included(selection.Directives) {
+				continue
+			}
+
+			key := selection.ResponseKey()
+			found := false
+			for _, c := range collected {
+				if c.key == key {
+					c.fields = append(c.fields, selection)
+					found = true
+				}
+			}
+			if !found {
+				collected = append(collected, &collectedField{key: key, fields: []*Field{selection}})
+			}
+		case *InlineFragment:
+			if e.included(selection.Directives) {
+				collected = e.collectFields(selection.SelectionSet, collected, visited)
+			}
+		case *FragmentSpread:
+			if visited[selection.Name] || !e.included(selection.Directives) {
+				continue
+			}
+			visited[selection.Name] = true
+			collected = e.collectFields(e.doc.Fragments[selection.Name].SelectionSet, collected, visited)
+		}
+	}
+
+	return collected
+}
+
+// executeObjects resolves a selection set on every source at once. An
+// object is returned as nil when a non-null field of it failed.
+func (e *executor) executeObjects(ctx context.Context, object *Object, sources []any, selections []Selection, paths [][]any) []any {
+	results := make([]*orderedMap, len(sources))
+	nulled := make([]bool, len(sources))
+	for i := range results {
+		results[i] = newOrderedMap()
+	}
+
+	for _, collected := range e.collectFields(selections, nil, map[string]bool{}) {
+		field := collected.fields[0]
+
+		fieldPaths := make([][]any, len(sources))
+		for i := range sources {
+			fieldPaths[i] = appendPath(paths[i], collected.key)
+		}
+
+		if field.Name == "__typename" {
+			for i := range results {
+				results[i].set(collected.key, object.Name)
+			}
+			continue
+		}
+
+		definition := object.Fields[field.Name]
+		values, failed := e.resolveField(ctx, definition, field, sources, fieldPaths)
+
+		var subselections []Selection
+		for _, f := range collected.fields {
+			subselections = append(subselections, f.SelectionSet...)
+		}
+
+		completed := e.completeValues(ctx, definition.Type, values, failed, field, subselections, fieldPaths)
+
+		_, nonNull := definition.Type.(*NonNull)
+		for i := range results {
+			results[i].set(collected.key, completed[i])
+			if nonNull && completed[i] == nil {
+				nulled[i] = true
+			}
+		}
+	}
+
+	out := make([]any, len(sources))
+	for i := range results {
+		if !nulled[i] {
+			out[i] = results[i]
+		}
+	}
+
+	return out
+}
+
+func (e *executor) resolveField(ctx context.Context, definition *FieldDef, field *Field, sources []any, paths [][]any) ([]any, []bool) {
+	values := make([]any, len(sources))
+	failed := make([]bool, len(sources))
+
+	fail := func(i int, err error) {
+		values[i] = nil
+		failed[i] = true
+		e.addError(err, field, paths[i])
+	}
+
+	args, err := coerceArguments(definition.Args, field.Arguments, e.variables)
+	if err != nil {
+		for i := range sources {
+			fail(i, err)
+		}
+		return values, failed
+	}
+
+	for i, source := range sources {
+		value, err := resolve(ctx, definition, field.Name, source, args)
+		if err != nil {
+			fail(i, err)
+			continue
+		}
+		values[i] = value
+	}
+
+	for i, value := range values {
+		thunk, ok := value.(Thunk)
+		if !ok {
+			continue
+		}
+
+		values[i], err = force(thunk)
+		if err != nil {
+			fail(i, err)
+		}
+	}
+
+	return values, failed
+}
+
+func resolve(ctx context.Context, definition *FieldDef, name string, source any, args map[string]any) (value any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("resolving %s: %v", name, r)
+		}
+	}()
+
+	if definition.Resolve != nil {
+		return definition.Resolve(ResolveParams{Context: ctx, Source: source, Args: args})
+	}
+
+	return defaultResolve(source, name), nil
+}
+
+func force(thunk Thunk) (value any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("loading: %v", r)
+		}
+	}()
+
+	return thunk()
+}
+
+// defaultResolve reads the struct field or map key named like the GraphQL
+// field, ignoring case, so expenseDate reads ExpenseDate.
+func defaultResolve(source any, name string) any {
+	v := reflect.ValueOf(source)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		value := v.MapIndex(reflect.ValueOf(name))
+		if value.IsValid() && value.CanInterface() {
+			return value.Interface()
+		}
+	case reflect.Struct:
+		value := v.FieldByNameFunc(func(field string) bool {
+			return strings.EqualFold(field, name)
+		})
+		if value.IsValid() && value.CanInterface() {
+			return value.Interface()
+		}
+	}
+
+	return nil
+}
+
+// completeValues converts resolved values of type t to their result form,
+// executing the subselections of objects a whole list at a time.
+func (e *executor) completeValues(ctx context.Context, t Type, values []any, failed []bool, field *Field, selections []Selection, paths [][]any) []any {
+	out := make([]any, len(values))
+
+	for i, value := range values {
+		values[i] = indirect(value)
+	}
+
+	switch t := t.(type) {
+	case *NonNull:
+		out = e.completeValues(ctx, t.OfType, values, failed, field, selections, paths)
+		for i := range out {
+			// Nulls from a failed resolver or subfield were reported already
+			if values[i] == nil && !failed[i] {
+				e.addError(fmt.Errorf("Cannot return null for non-nullable field %s.", field.Name), field, paths[i])
+			}
+		}
+	case *List:
+		var items []any
+		var itemPaths [][]any
+		var owners []int
+
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+
+			list := reflect.ValueOf(value)
+			if list.Kind() != reflect.Slice && list.Kind() != reflect.Array {
+				e.addError(fmt.Errorf("Expected a list for field %s.", field.Name), field, paths[i])
+				failed[i] = true
+				continue
+			}
+
+			out[i] = make([]any, list.Len())
+			for j := 0; j < list.Len(); j++ {
+				items = append(items, list.Index(j).Interface())
+				itemPaths = append(itemPaths, appendPath(paths[i], j))
+				owners = append(owners, i)
+			}
+		}
+
+		completed := e.completeValues(ctx, t.OfType, items, make([]bool, len(items)), field, selections, itemPaths)
+
+		_, itemsNonNull := t.OfType.(*NonNull)
+		offsets := make([]int, len(values))
+		for j, item := range completed {
+			owner := owners[j]
+			if out[owner] == nil {
+				continue
+			}
+
+			if itemsNonNull && item == nil {
+				out[owner] = nil
+				continue
+			}
+
+			out[owner].([]any)[offsets[owner]] = item
+			offsets[owner]++
+		}
+	case *Scalar:
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+
+			serialized, err := t.Serialize(value)
+			if err != nil {
+				e.addError(err, field, paths[i])
+				failed[i] = true
+				continue
+			}
+			out[i] = serialized
+		}
+	case *Enum:
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+
+			s, ok := value.(string)
+			if !ok || !t.has(s) {
+				e.addError(fmt.Errorf("Enum %s cannot represent %v", t.Name, value), field, paths[i])
+				failed[i] = true
+				continue
+			}
+			out[i] = s
+		}
+	case *Object:
+		var sources []any
+		var sourcePaths [][]any
+		var owners []int
+
+		for i, value := range values {
+			if value == nil {
+				continue
+			}
+			sources = append(sources, value)
+			sourcePaths = append(sourcePaths, paths[i])
+			owners = append(owners, i)
+		}
+
+		if len(sources) > 0 {
+			executed := e.executeObjects(ctx, t, sources, selections, sourcePaths)
+			for j, object := range executed {
+				out[owners[j]] = object
+			}
+		}
+	}
+
+	return out
+}
+
+// indirect dereferences pointers, turning nil pointers and other typed nils
+// into a plain nil.
+func indirect(value any) any {
+	v := reflect.ValueOf(value)
+	for v.IsValid() {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface:
+			if v.IsNil() {
+				return nil
+			}
+			// Objects are passed on as pointers to their resolvers
+			if v.Elem().Kind() == reflect.Struct {
+				return value
+			}
+			v = v.Elem()
+			value = v.Interface()
+		case reflect.Map, reflect.Slice, reflect.Func:
+			if v.IsNil() {
+				return nil
+			}
+			return value
+		default:
+			return value
+		}
+	}
+	return nil
+}
+
+func appendPath(path []any, key any) []any {
+	extended := make([]any, len(path)+1)
+	copy(extended, path)
+	extended[len(path)] = key
+	return extended
+}
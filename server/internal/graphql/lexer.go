@@ -0,0 +1,276 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	line   int
+	column int
+}
+
+// lexer splits a document into tokens, skipping whitespace, commas and
+// comments, which carry no meaning in GraphQL.
+type lexer struct {
+	source string
+	pos    int
+	line   int
+	column int
+}
+
+func newLexer(source string) *lexer {
+	return &lexer{source: strings.TrimPrefix(source, "\ufeff"), line: 1, column: 1}
+}
+
+func (l *lexer) errorf(format string, args ...any) error {
+	return &Error{
+		Message:   "Syntax Error: " + fmt.Sprintf(format, args...),
+		Locations: []Location{{Line: l.line, Column: l.column}},
+	}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n && l.pos < len(l.source); i++ {
+		if l.source[l.pos] == '\n' {
+			l.line++
+			l.column = 1
+		} else {
+			l.column++
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.source) {
+		switch c := l.source[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.advance(1)
+		case c == '#':
+			for l.pos < len(l.source) && l.source[l.pos] != '\n' {
+				l.advance(1)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+
+	tok := token{line: l.line, column: l.column}
+	if l.pos >= len(l.source) {
+		tok.kind = tokenEOF
+		return tok, nil
+	}
+
+	c := l.source[l.pos]
+	switch {
+	case strings.HasPrefix(l.source[l.pos:], "..."):
+		tok.kind = tokenPunctuator
+		tok.value = "..."
+		l.advance(3)
+	case strings.IndexByte("!$&():=@[]{|}", c) >= 0:
+		tok.kind = tokenPunctuator
+		tok.value = string(c)
+		l.advance(1)
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.source) && (l.source[l.pos] == '_' || isLetter(l.source[l.pos]) || isDigit(l.source[l.pos])) {
+			l.advance(1)
+		}
+		tok.kind = tokenName
+		tok.value = l.source[start:l.pos]
+	case c == '-' || isDigit(c):
+		return l.number(tok)
+	case c == '"':
+		return l.string(tok)
+	default:
+		r, _ := utf8.DecodeRuneInString(l.source[l.pos:])
+		return tok, l.errorf("unexpected character %q", r)
+	}
+
+	return tok, nil
+}
+
+func (l *lexer) number(tok token) (token, error) {
+	start := l.pos
+	tok.kind = tokenInt
+
+	if l.source[l.pos] == '-' {
+		l.advance(1)
+	}
+
+	digits := func() int {
+		n := 0
+		for l.pos < len(l.source) && isDigit(l.source[l.pos]) {
+			l.advance(1)
+			n++
+		}
+		return n
+	}
+
+	if digits() == 0 {
+		return tok, l.errorf("invalid number")
+	}
+
+	if l.pos < len(l.source) && l.source[l.pos] == '.' {
+		tok.kind = tokenFloat
+		l.advance(1)
+		if digits() == 0 {
+			return tok, l.errorf("invalid number")
+		}
+	}
+
+	if l.pos < len(l.source) && (l.source[l.pos] == 'e' || l.source[l.pos] == 'E') {
+		tok.kind = tokenFloat
+		l.advance(1)
+		if l.pos < len(l.source) && (l.source[l.pos] == '+' || l.source[l.pos] == '-') {
+			l.advance(1)
+		}
+		if digits() == 0 {
+			return tok, l.errorf("invalid number")
+		}
+	}
+
+	if l.pos < len(l.source) && (l.source[l.pos] == '_' || isLetter(l.source[l.pos]) || l.source[l.pos] == '.') {
+		return tok, l.errorf("invalid number")
+	}
+
+	tok.value = l.source[start:l.pos]
+	return tok, nil
+}
+
+func (l *lexer) string(tok token) (token, error) {
+	tok.kind = tokenString
+
+	if strings.HasPrefix(l.source[l.pos:], `"""`) {
+		l.advance(3)
+		end := strings.Index(l.source[l.pos:], `"""`)
+		if end < 0 {
+			return tok, l.errorf("unterminated string")
+		}
+		tok.value = blockStringValue(l.source[l.pos : l.pos+end])
+		l.advance(end + 3)
+		return tok, nil
+	}
+
+	l.advance(1)
+
+	var value strings.Builder
+	for {
+		if l.pos >= len(l.source) || l.source[l.pos] == '\n' {
+			return tok, l.errorf("unterminated string")
+		}
+
+		c := l.source[l.pos]
+		if c == '"' {
+			l.advance(1)
+			break
+		}
+
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(l.source[l.pos:])
+			value.WriteRune(r)
+			l.advance(size)
+			continue
+		}
+
+		if l.pos+1 >= len(l.source) {
+			return tok, l.errorf("unterminated string")
+		}
+
+		escape := l.source[l.pos+1]
+		switch escape {
+		case '"', '\\', '/':
+			value.WriteByte(escape)
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'u':
+			if l.pos+6 > len(l.source) {
+				return tok, l.errorf("invalid unicode escape")
+			}
+			var r rune
+			_, err := fmt.Sscanf(l.source[l.pos+2:l.pos+6], "%04x", &r)
+			if err != nil {
+				return tok, l.errorf("invalid unicode escape")
+			}
+			value.WriteRune(r)
+			l.advance(4)
+		default:
+			return tok, l.errorf("invalid escape \\%c", escape)
+		}
+		l.advance(2)
+	}
+
+	tok.value = value.String()
+	return tok, nil
+}
+
+// blockStringValue strips the common indentation and the blank first and
+// last lines of a """ string.
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.ReplaceAll(raw, "\r\n", "\n"), "\n")
+
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
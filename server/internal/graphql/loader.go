@@ -0,0 +1,70 @@
+package graphql
+
+// Loader batches and caches lookups by key for the length of one request.
+// Load only queues the key, and the first thunk forced fetches every key
+// queued until then in one call. Loaders are not safe for concurrent use,
+// which execution does not need.
+type Loader[K comparable, V any] struct {
+	fetch   func(keys []K) (map[K]V, error)
+	pending []K
+	results map[K]*loaded[V]
+}
+
+type loaded[V any] struct {
+	value V
+	err   error
+	done  bool
+}
+
+// NewLoader returns a loader that fetches with fetch. Keys missing from the
+// map fetch returns load as the zero value of V, and values for keys not
+// asked for are cached as well.
+func NewLoader[K comparable, V any](fetch func(keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:   fetch,
+		results: map[K]*loaded[V]{},
+	}
+}
+
+func (l *Loader[K, V]) Load(key K) Thunk {
+	if _, ok := l.results[key]; !ok {
+		l.results[key] = &loaded[V]{}
+		l.pending = append(l.pending, key)
+	}
+
+	return func() (any, error) {
+		result := l.results[key]
+		if !result.done {
+			l.dispatch()
+		}
+		return result.value, result.err
+	}
+}
+
+// Prime caches a value already at hand, such as a record just created.
+func (l *Loader[K, V]) Prime(key K, value V) {
+	l.results[key] = &loaded[V]{value: value, done: true}
+}
+
+func (l *Loader[K, V]) dispatch() {
+	keys := l.pending
+	l.pending = nil
+
+	values, err := l.fetch(keys)
+
+	for _, key := range keys {
+		result := l.results[key]
+		result.done = true
+		result.err = err
+		if err == nil {
+			result.value = values[key]
+		}
+	}
+
+	// Keep whatever else came back, often the rest of the ledger
+	for key, value := range values {
+		if _, ok := l.results[key]; !ok {
+			l.Prime(key, value)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package graphql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// countingFetch squares its keys, leaving out negative ones, and records
+// every batch it is called with.
+type countingFetch struct {
+	batches [][]int
+	extra   map[int]int
+	err     error
+}
+
+func (f *countingFetch) fetch(keys []int) (map[int]int, error) {
+	f.batches = append(f.batches, keys)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	values := map[int]int{}
+	for _, key := range keys {
+		if key >= 0 {
+			values[key] = key * key
+		}
+	}
+	for key, value := range f.extra {
+		values[key] = value
+	}
+	return values, nil
+}
+
+func forced(t *testing.T, thunk Thunk) any {
+	t.Helper()
+
+	value, err := thunk()
+	if err != nil {
+		t.Fatalf("thunk: %v", err)
+	}
+	return value
+}
+
+func TestLoaderBatchesAndCaches(t *testing.T) {
+	fetch := &countingFetch{}
+	loader := NewLoader(fetch.fetch)
+
+	thunks := []Thunk{loader.Load(2), loader.Load(3), loader.Load(2), loader.Load(-1)}
+
+	if len(fetch.batches) != 0 {
+		t.Fatalf("Load fetched before any thunk was forced")
+	}
+
+	for i, want := range []int{4, 9, 4, 0} {
+		if got := forced(t, thunks[i]); got != want {
+			t.Errorf("thunk %d = %v, want %d", i, got, want)
+		}
+	}
+
+	// A key is fetched once however often it is loaded
+	if got := forced(t, loader.Load(3)); got != 9 {
+		t.Errorf("cached Load(3) = %v, want 9", got)
+	}
+
+	if want := [][]int{{2, 3, -1}}; !reflect.DeepEqual(fetch.batches, want) {
+		t.Errorf("fetched %v, want %v", fetch.batches, want)
+	}
+}
+
+func TestLoaderKeepsExtraValues(t *testing.T) {
+	fetch := &countingFetch{extra: map[int]int{7: 49}}
+	loader := NewLoader(fetch.fetch)
+
+	forced(t, loader.Load(1))
+
+	if got := forced(t, loader.Load(7)); got != 49 {
+		t.Errorf("Load(7) = %v, want 49", got)
+	}
+	if len(fetch.batches) != 1 {
+		t.Errorf("fetched %d times, want once", len(fetch.batches))
+	}
+}
+
+func TestLoaderPrime(t *testing.T) {
+	fetch := &countingFetch{}
+	loader := NewLoader(fetch.fetch)
+
+	loader.Prime(5, 26)
+
+	if got := forced(t, loader.Load(5)); got != 26 {
+		t.Errorf("Load(5) = %v, want the primed 26", got)
+	}
+	if len(fetch.batches) != 0 {
+		t.Errorf("fetched %v for a primed key", fetch.batches)
+	}
+}
+
+func TestLoaderError(t *testing.T) {
+	fetch := &countingFetch{err: errors.New("connection reset")}
+	loader := NewLoader(fetch.fetch)
+
+	first, second := loader.Load(1), loader.Load(2)
+
+	for _, thunk := range []Thunk{first, second} {
+		if _, err := thunk(); !errors.Is(err, fetch.err) {
+			t.Errorf("thunk = %v, want the fetch error", err)
+		}
+	}
+
+	if len(fetch.batches) != 1 {
+		t.Errorf("fetched %d times, want once for the failed batch", len(fetch.batches))
+	}
+}
@@ -0,0 +1,741 @@
+package graphql
+
+import (
+	"fmt"
+)
+
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Document is a parsed request: its operations and the fragments they
+// spread.
+type Document struct {
+	Operations []*Operation
+	Fragments  map[string]*Fragment
+}
+
+type Operation struct {
+	Type         string
+	Name         string
+	Variables    []*VariableDefinition
+	Directives   []*Directive
+	SelectionSet []Selection
+	Location     Location
+}
+
+type VariableDefinition struct {
+	Name     string
+	Type     *TypeRef
+	Default  *Value
+	Location Location
+}
+
+// TypeRef is a type as written in a document, such as [ID!]!.
+type TypeRef struct {
+	Name    string
+	Elem    *TypeRef
+	NonNull bool
+}
+
+func (t *TypeRef) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Location      Location
+}
+
+// Selection is a *Field, *FragmentSpread or *InlineFragment.
+type Selection interface {
+	selection()
+}
+
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []*Argument
+	Directives   []*Directive
+	SelectionSet []Selection
+	Location     Location
+}
+
+// ResponseKey is the key of the field in the result, its alias if it has one.
+func (f *Field) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+	Location   Location
+}
+
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+	Location      Location
+}
+
+func (*Field) selection()          {}
+func (*FragmentSpread) selection() {}
+func (*InlineFragment) selection() {}
+
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+	Location  Location
+}
+
+type Argument struct {
+	Name     string
+	Value    *Value
+	Location Location
+}
+
+type ValueKind int
+
+const (
+	ValueVariable ValueKind = iota
+	ValueInt
+	ValueFloat
+	ValueString
+	ValueBoolean
+	ValueNull
+	ValueEnum
+	ValueList
+	ValueObject
+)
+
+// Value is a literal in a document. Raw holds the text of scalars and enums
+// and the name of variables.
+type Value struct {
+	Kind     ValueKind
+	Raw      string
+	List     []*Value
+	Fields   []*ObjectField
+	Location Location
+}
+
+type ObjectField struct {
+	Name  string
+	Value *Value
+}
+
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+// Parse parses a query document. Type system definitions are not accepted.
+func Parse(source string) (*Document, error) {
+	p := &parser{lexer: newLexer(source)}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := &Document{Fragments: map[string]*Fragment{}}
+
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunctuator, "{"):
+			operation := &Operation{Type: "query", Location: p.location()}
+
+			operation.SelectionSet, err = p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"), p.peek(tokenName, "subscription"):
+			operation, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, operation)
+		case p.peek(tokenName, "fragment"):
+			fragment, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.Fragments[fragment.Name]; ok {
+				return nil, newError(fmt.Sprintf("There can be only one fragment named %q.", fragment.Name), fragment.Location)
+			}
+			doc.Fragments[fragment.Name] = fragment
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(doc.Operations) == 0 {
+		return nil, &Error{Message: "Document contains no operations."}
+	}
+
+	return doc, nil
+}
+
+func (p *parser) location() Location {
+	return Location{Line: p.tok.line, Column: p.tok.column}
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return newError("Syntax Error: unexpected end of document", p.location())
+	}
+	return newError(fmt.Sprintf("Syntax Error: unexpected %q", p.tok.value), p.location())
+}
+
+func (p *parser) skip(value string) (bool, error) {
+	if p.peek(tokenPunctuator, value) {
+		return true, p.advance()
+	}
+	return false, nil
+}
+
+func (p *parser) expect(value string) error {
+	if !p.peek(tokenPunctuator, value) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	operation := &Operation{Type: p.tok.value, Location: p.location()}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		operation.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek(tokenPunctuator, "(") {
+		operation.Variables, err = p.variableDefinitions()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	operation.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	operation.SelectionSet, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return operation, nil
+}
+
+func (p *parser) variableDefinitions() ([]*VariableDefinition, error) {
+	err := p.expect("(")
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := []*VariableDefinition{}
+	for {
+		done, err := p.skip(")")
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return definitions, nil
+		}
+
+		definition := &VariableDefinition{Location: p.location()}
+
+		err = p.expect("$")
+		if err != nil {
+			return nil, err
+		}
+
+		definition.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		definition.Type, err = p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+
+		hasDefault, err := p.skip("=")
+		if err != nil {
+			return nil, err
+		}
+		if hasDefault {
+			definition.Default, err = p.value(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		_, err = p.directives()
+		if err != nil {
+			return nil, err
+		}
+
+		definitions = append(definitions, definition)
+	}
+}
+
+func (p *parser) typeRef() (*TypeRef, error) {
+	var ref *TypeRef
+
+	isList, err := p.skip("[")
+	if err != nil {
+		return nil, err
+	}
+
+	if isList {
+		elem, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect("]")
+		if err != nil {
+			return nil, err
+		}
+
+		ref = &TypeRef{Elem: elem}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		ref = &TypeRef{Name: name}
+	}
+
+	ref.NonNull, err = p.skip("!")
+	if err != nil {
+		return nil, err
+	}
+
+	return ref, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	fragment := &Fragment{Location: p.location()}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.Name, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if fragment.Name == "on" {
+		return nil, newError("Syntax Error: a fragment cannot be named \"on\"", fragment.Location)
+	}
+
+	if !p.peek(tokenName, "on") {
+		return nil, p.unexpected()
+	}
+
+	err = p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.TypeCondition, err = p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.SelectionSet, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return fragment, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	err := p.expect("{")
+	if err != nil {
+		return nil, err
+	}
+
+	selections := []Selection{}
+	for {
+		if len(selections) == 0 && p.peek(tokenPunctuator, "}") {
+			return nil, p.unexpected()
+		}
+
+		done, err := p.skip("}")
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return selections, nil
+		}
+
+		selection, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+
+		selections = append(selections, selection)
+	}
+}
+
+func (p *parser) selection() (Selection, error) {
+	location := p.location()
+
+	isFragment, err := p.skip("...")
+	if err != nil {
+		return nil, err
+	}
+
+	if !isFragment {
+		return p.field()
+	}
+
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		spread := &FragmentSpread{Location: location}
+
+		spread.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		spread.Directives, err = p.directives()
+		if err != nil {
+			return nil, err
+		}
+
+		return spread, nil
+	}
+
+	fragment := &InlineFragment{Location: location}
+
+	if p.peek(tokenName, "on") {
+		err = p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		fragment.TypeCondition, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fragment.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	fragment.SelectionSet, err = p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+
+	return fragment, nil
+}
+
+func (p *parser) field() (*Field, error) {
+	field := &Field{Location: p.location()}
+
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	hasAlias, err := p.skip(":")
+	if err != nil {
+		return nil, err
+	}
+
+	if hasAlias {
+		field.Alias = name
+		name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+	}
+	field.Name = name
+
+	field.Arguments, err = p.arguments()
+	if err != nil {
+		return nil, err
+	}
+
+	field.Directives, err = p.directives()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek(tokenPunctuator, "{") {
+		field.SelectionSet, err = p.selectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return field, nil
+}
+
+func (p *parser) arguments() ([]*Argument, error) {
+	hasArguments, err := p.skip("(")
+	if err != nil || !hasArguments {
+		return nil, err
+	}
+
+	arguments := []*Argument{}
+	for {
+		if len(arguments) == 0 && p.peek(tokenPunctuator, ")") {
+			return nil, p.unexpected()
+		}
+
+		done, err := p.skip(")")
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return arguments, nil
+		}
+
+		argument := &Argument{Location: p.location()}
+
+		argument.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		argument.Value, err = p.value(false)
+		if err != nil {
+			return nil, err
+		}
+
+		arguments = append(arguments, argument)
+	}
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	directives := []*Directive{}
+
+	for p.peek(tokenPunctuator, "@") {
+		directive := &Directive{Location: p.location()}
+
+		err := p.advance()
+		if err != nil {
+			return nil, err
+		}
+
+		directive.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		directive.Arguments, err = p.arguments()
+		if err != nil {
+			return nil, err
+		}
+
+		directives = append(directives, directive)
+	}
+
+	return directives, nil
+}
+
+// value parses a literal. Variables are not allowed in constant positions
+// such as variable defaults.
+func (p *parser) value(constant bool) (*Value, error) {
+	value := &Value{Raw: p.tok.value, Location: p.location()}
+
+	switch p.tok.kind {
+	case tokenInt:
+		value.Kind = ValueInt
+	case tokenFloat:
+		value.Kind = ValueFloat
+	case tokenString:
+		value.Kind = ValueString
+	case tokenName:
+		switch p.tok.value {
+		case "true", "false":
+			value.Kind = ValueBoolean
+		case "null":
+			value.Kind = ValueNull
+		default:
+			value.Kind = ValueEnum
+		}
+	case tokenPunctuator:
+		switch p.tok.value {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+
+			err := p.advance()
+			if err != nil {
+				return nil, err
+			}
+
+			value.Kind = ValueVariable
+			value.Raw, err = p.name()
+			return value, err
+		case "[":
+			return p.listValue(value, constant)
+		case "{":
+			return p.objectValue(value, constant)
+		default:
+			return nil, p.unexpected()
+		}
+	default:
+		return nil, p.unexpected()
+	}
+
+	return value, p.advance()
+}
+
+func (p *parser) listValue(value *Value, constant bool) (*Value, error) {
+	value.Kind = ValueList
+	value.List = []*Value{}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		done, err := p.skip("]")
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return value, nil
+		}
+
+		item, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+
+		value.List = append(value.List, item)
+	}
+}
+
+func (p *parser) objectValue(value *Value, constant bool) (*Value, error) {
+	value.Kind = ValueObject
+	value.Fields = []*ObjectField{}
+
+	err := p.advance()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		done, err := p.skip("}")
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return value, nil
+		}
+
+		field := &ObjectField{}
+
+		field.Name, err = p.name()
+		if err != nil {
+			return nil, err
+		}
+
+		err = p.expect(":")
+		if err != nil {
+			return nil, err
+		}
+
+		field.Value, err = p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+
+		value.Fields = append(value.Fields, field)
+	}
+}
+
+// Operation picks the operation to run: the one named, or the only one when
+// no name is given.
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) > 1 {
+			return nil, &Error{Message: "Must provide operation name if query contains multiple operations."}
+		}
+		return d.Operations[0], nil
+	}
+
+	for _, operation := range d.Operations {
+		if operation.Name == name {
+			return operation, nil
+		}
+	}
+
+	return nil, &Error{Message: fmt.Sprintf("Unknown operation named %q.", name)}
+}
@@ -0,0 +1,157 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	doc, err := Parse(`
+		# Expenses of a category
+		query Expenses($id: ID!, $limit: Int = 10) {
+			first: category(id: $id) @include(if: true) {
+				name
+				...Details
+				... on Category { id }
+			}
+			search(title: "café \"au lait\"", amounts: [1, -2.5e3], filter: {kind: FOOD, archived: null})
+		}
+
+		fragment Details on Category {
+			parent { name }
+		}
+	`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if len(doc.Operations) != 1 || len(doc.Fragments) != 1 {
+		t.Fatalf("got %d operations and %d fragments, want 1 and 1", len(doc.Operations), len(doc.Fragments))
+	}
+
+	operation := doc.Operations[0]
+	if operation.Type != "query" || operation.Name != "Expenses" {
+		t.Errorf("operation = %s %s", operation.Type, operation.Name)
+	}
+
+	if len(operation.Variables) != 2 {
+		t.Fatalf("got %d variables, want 2", len(operation.Variables))
+	}
+	if variable := operation.Variables[0]; variable.Name != "id" || variable.Type.String() != "ID!" {
+		t.Errorf("first variable = $%s: %s", variable.Name, variable.Type)
+	}
+	if variable := operation.Variables[1]; variable.Default == nil || variable.Default.Kind != ValueInt || variable.Default.Raw != "10" {
+		t.Errorf("second variable default = %+v", variable.Default)
+	}
+
+	first := operation.SelectionSet[0].(*Field)
+	if first.Alias != "first" || first.Name != "category" || first.ResponseKey() != "first" {
+		t.Errorf("field = %s: %s", first.Alias, first.Name)
+	}
+	if len(first.Directives) != 1 || first.Directives[0].Name != "include" {
+		t.Errorf("directives = %+v", first.Directives)
+	}
+	if argument := first.Arguments[0]; argument.Value.Kind != ValueVariable || argument.Value.Raw != "id" {
+		t.Errorf("argument = %+v", argument.Value)
+	}
+
+	if spread, ok := first.SelectionSet[1].(*FragmentSpread); !ok || spread.Name != "Details" {
+		t.Errorf("second selection = %#v, want a spread of Details", first.SelectionSet[1])
+	}
+	if inline, ok := first.SelectionSet[2].(*InlineFragment); !ok || inline.TypeCondition != "Category" {
+		t.Errorf("third selection = %#v, want an inline fragment on Category", first.SelectionSet[2])
+	}
+
+	search := operation.SelectionSet[1].(*Field)
+	if got := search.Arguments[0].Value.Raw; got != `café "au lait"` {
+		t.Errorf("string argument = %q", got)
+	}
+
+	amounts := search.Arguments[1].Value
+	if amounts.Kind != ValueList || len(amounts.List) != 2 || amounts.List[0].Kind != ValueInt || amounts.List[1].Kind != ValueFloat {
+		t.Errorf("list argument = %+v", amounts)
+	}
+
+	filter := search.Arguments[2].Value
+	if filter.Kind != ValueObject || filter.Fields[0].Value.Kind != ValueEnum || filter.Fields[1].Value.Kind != ValueNull {
+		t.Errorf("object argument = %+v", filter)
+	}
+
+	fragment := doc.Fragments["Details"]
+	if fragment.TypeCondition != "Category" || len(fragment.SelectionSet) != 1 {
+		t.Errorf("fragment = %+v", fragment)
+	}
+}
+
+func TestParseBlockString(t *testing.T) {
+	doc, err := Parse("{ search(title: \"\"\"\n    first\n      second\n\n  \"\"\") }")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	value := doc.Operations[0].SelectionSet[0].(*Field).Arguments[0].Value
+	if value.Raw != "first\n  second" {
+		t.Errorf("block string = %q", value.Raw)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		source   string
+		message  string
+		location Location
+	}{
+		{"", "Document contains no operations.", Location{}},
+		{"{ }", `Syntax Error: unexpected "}"`, Location{1, 3}},
+		{"{ name", "Syntax Error: unexpected end of document", Location{1, 7}},
+		{"{ a() }", `Syntax Error: unexpected ")"`, Location{1, 5}},
+		{"{ a(x: \"open) }", "Syntax Error: unterminated string", Location{1, 16}},
+		{"{ a(x: 1.) }", "Syntax Error: invalid number", Location{1, 10}},
+		{"{ a(x: 1) ? }", "Syntax Error: unexpected character '?'", Location{1, 11}},
+		{"query ($v: Int = $w) { a }", `Syntax Error: unexpected "$"`, Location{1, 18}},
+		{"fragment on on Category { a }", `Syntax Error: a fragment cannot be named "on"`, Location{1, 1}},
+		{"{ ...F }\nfragment F on A { a }\nfragment F on A { b }", `There can be only one fragment named "F".`, Location{3, 1}},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.source)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want %q", test.source, test.message)
+			continue
+		}
+
+		parseErr := err.(*Error)
+		if parseErr.Message != test.message {
+			t.Errorf("Parse(%q) = %q, want %q", test.source, parseErr.Message, test.message)
+		}
+
+		var locations []Location
+		if test.location != (Location{}) {
+			locations = []Location{test.location}
+		}
+		if !reflect.DeepEqual(parseErr.Locations, locations) {
+			t.Errorf("Parse(%q) located at %v, want %v", test.source, parseErr.Locations, locations)
+		}
+	}
+}
+
+func TestDocumentOperation(t *testing.T) {
+	doc, err := Parse("query A { a } mutation B { b }")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	operation, err := doc.Operation("B")
+	if err != nil || operation.Type != "mutation" {
+		t.Errorf("Operation(B) = %+v, %v", operation, err)
+	}
+
+	if _, err := doc.Operation(""); err == nil || !strings.Contains(err.Error(), "Must provide operation name") {
+		t.Errorf("Operation() = %v, want an error asking for a name", err)
+	}
+
+	if _, err := doc.Operation("C"); err == nil {
+		t.Error("Operation(C) found an operation that does not exist")
+	}
+}
@@ -0,0 +1,385 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Type is one of *Scalar, *Enum, *Object, *InputObject, *List or *NonNull.
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Serialize converts a resolved value for the
+// response, ParseValue a variable decoded from JSON and ParseLiteral a
+// literal written in the document.
+type Scalar struct {
+	Name         string
+	Serialize    func(value any) (any, error)
+	ParseValue   func(value any) (any, error)
+	ParseLiteral func(value *Value) (any, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+// Enum values are passed to and returned from resolvers as strings.
+type Enum struct {
+	Name   string
+	Values []string
+}
+
+func (e *Enum) String() string { return e.Name }
+
+func (e *Enum) has(value string) bool {
+	for _, v := range e.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type Object struct {
+	Name   string
+	Fields Fields
+}
+
+func (o *Object) String() string { return o.Name }
+
+type Fields map[string]*FieldDef
+
+// FieldDef is a field of an object type. Without Resolve the field is read
+// from the exported struct field or map key of the same name, ignoring case.
+type FieldDef struct {
+	Type    Type
+	Args    []*ArgDef
+	Resolve ResolveFunc
+}
+
+func (f *FieldDef) arg(name string) *ArgDef {
+	for _, arg := range f.Args {
+		if arg.Name == name {
+			return arg
+		}
+	}
+	return nil
+}
+
+// ArgDef is an argument of a field or a field of an input object. Default
+// is used when the argument is left out and has to be of the Go type the
+// argument coerces to.
+type ArgDef struct {
+	Name    string
+	Type    Type
+	Default any
+}
+
+// InputObject values are passed to resolvers as map[string]any holding only
+// the fields that were given or have a default.
+type InputObject struct {
+	Name   string
+	Fields []*ArgDef
+}
+
+func (i *InputObject) String() string { return i.Name }
+
+func (i *InputObject) field(name string) *ArgDef {
+	for _, field := range i.Fields {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+type List struct {
+	OfType Type
+}
+
+func NewList(ofType Type) *List {
+	return &List{OfType: ofType}
+}
+
+func (l *List) String() string { return "[" + l.OfType.String() + "]" }
+
+type NonNull struct {
+	OfType Type
+}
+
+func NewNonNull(ofType Type) *NonNull {
+	return &NonNull{OfType: ofType}
+}
+
+func (n *NonNull) String() string { return n.OfType.String() + "!" }
+
+type ResolveParams struct {
+	Context context.Context
+	Source  any
+	Args    map[string]any
+}
+
+// ResolveFunc returns the value of a field, or a Thunk to defer loading it
+// until every sibling field has been resolved.
+type ResolveFunc func(p ResolveParams) (any, error)
+
+// Thunk is a value that is not loaded yet. Resolvers return one to batch
+// their loads with the same field of every other object in the list.
+type Thunk func() (any, error)
+
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+
+	// MaxDepth and MaxFields bound the operations Validate accepts: how
+	// deeply fields nest, and how many fields are selected with fragments
+	// expanded. Zero leaves a limit off.
+	MaxDepth  int
+	MaxFields int
+
+	types map[string]Type
+}
+
+// NewSchema collects every type reachable from the query and mutation
+// types, so variables can name them.
+func NewSchema(query *Object, mutation *Object) (*Schema, error) {
+	schema := &Schema{Query: query, Mutation: mutation, types: map[string]Type{}}
+
+	for _, scalar := range []*Scalar{Int, Float, String, Boolean, ID} {
+		schema.types[scalar.Name] = scalar
+	}
+
+	for _, root := range []*Object{query, mutation} {
+		if root == nil {
+			continue
+		}
+
+		err := schema.addType(root)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return schema, nil
+}
+
+func (s *Schema) addType(t Type) error {
+	switch t := t.(type) {
+	case *List:
+		return s.addType(t.OfType)
+	case *NonNull:
+		return s.addType(t.OfType)
+	}
+
+	if existing, ok := s.types[t.String()]; ok {
+		if existing != t {
+			return fmt.Errorf("graphql: two types named %s", t.String())
+		}
+		return nil
+	}
+
+	s.types[t.String()] = t
+
+	switch t := t.(type) {
+	case *Object:
+		for _, field := range t.Fields {
+			err := s.addType(field.Type)
+			if err != nil {
+				return err
+			}
+
+			for _, arg := range field.Args {
+				err := s.addType(arg.Type)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case *InputObject:
+		for _, field := range t.Fields {
+			err := s.addType(field.Type)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// typeFromRef resolves a type written in a document. It returns nil for
+// unknown names.
+func (s *Schema) typeFromRef(ref *TypeRef) Type {
+	var t Type
+
+	if ref.Elem != nil {
+		elem := s.typeFromRef(ref.Elem)
+		if elem == nil {
+			return nil
+		}
+		t = NewList(elem)
+	} else {
+		t = s.types[ref.Name]
+		if t == nil {
+			return nil
+		}
+	}
+
+	if ref.NonNull {
+		t = NewNonNull(t)
+	}
+
+	return t
+}
+
+// Error is a GraphQL error as returned in the errors list of a response.
+type Error struct {
+	Message   string     `json:"message"`
+	Locations []Location `json:"locations,omitempty"`
+	Path      []any      `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func newError(message string, location Location) *Error {
+	return &Error{Message: message, Locations: []Location{location}}
+}
+
+func coerceInt(value any) (any, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= math.MaxInt32 {
+			return int(v), nil
+		}
+	}
+	return nil, fmt.Errorf("Int cannot represent %v", value)
+}
+
+func coerceFloat(value any) (any, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	}
+	return nil, fmt.Errorf("Float cannot represent %v", value)
+}
+
+func coerceString(value any) (any, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return nil, fmt.Errorf("String cannot represent %v", value)
+}
+
+func coerceBoolean(value any) (any, error) {
+	if b, ok := value.(bool); ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("Boolean cannot represent %v", value)
+}
+
+func literalError(name string, value *Value) error {
+	return fmt.Errorf("%s cannot represent %s", name, value.Raw)
+}
+
+var Int = &Scalar{
+	Name:       "Int",
+	Serialize:  coerceInt,
+	ParseValue: coerceInt,
+	ParseLiteral: func(value *Value) (any, error) {
+		if value.Kind == ValueInt {
+			n, err := strconv.ParseInt(value.Raw, 10, 32)
+			if err == nil {
+				return int(n), nil
+			}
+		}
+		return nil, literalError("Int", value)
+	},
+}
+
+var Float = &Scalar{
+	Name:       "Float",
+	Serialize:  coerceFloat,
+	ParseValue: coerceFloat,
+	ParseLiteral: func(value *Value) (any, error) {
+		if value.Kind == ValueInt || value.Kind == ValueFloat {
+			f, err := strconv.ParseFloat(value.Raw, 64)
+			if err == nil {
+				return f, nil
+			}
+		}
+		return nil, literalError("Float", value)
+	},
+}
+
+var String = &Scalar{
+	Name:       "String",
+	Serialize:  coerceString,
+	ParseValue: coerceString,
+	ParseLiteral: func(value *Value) (any, error) {
+		if value.Kind == ValueString {
+			return value.Raw, nil
+		}
+		return nil, literalError("String", value)
+	},
+}
+
+var Boolean = &Scalar{
+	Name:       "Boolean",
+	Serialize:  coerceBoolean,
+	ParseValue: coerceBoolean,
+	ParseLiteral: func(value *Value) (any, error) {
+		if value.Kind == ValueBoolean {
+			return value.Raw == "true", nil
+		}
+		return nil, literalError("Boolean", value)
+	},
+}
+
+// ID is serialized as a string and accepts strings and integers as input.
+// Resolvers receive it as a string.
+var ID = &Scalar{
+	Name: "ID",
+	Serialize: func(value any) (any, error) {
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case int:
+			return strconv.Itoa(v), nil
+		case int64:
+			return strconv.FormatInt(v, 10), nil
+		}
+		return nil, fmt.Errorf("ID cannot represent %v", value)
+	},
+	ParseValue: func(value any) (any, error) {
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			if v == math.Trunc(v) {
+				return strconv.FormatInt(int64(v), 10), nil
+			}
+		}
+		return nil, fmt.Errorf("ID cannot represent %v", value)
+	},
+	ParseLiteral: func(value *Value) (any, error) {
+		if value.Kind == ValueString || value.Kind == ValueInt {
+			return value.Raw, nil
+		}
+		return nil, literalError("ID", value)
+	},
+}
@@ -0,0 +1,227 @@
+package graphql
+
+import (
+	"fmt"
+)
+
+// maxCost caps field counts, which can double with every fragment that
+// spreads another one twice.
+const maxCost = 1 << 30
+
+type validator struct {
+	schema    *Schema
+	doc       *Document
+	variables map[string]bool
+	fragments map[string]*fragmentState
+	errors    []*Error
+}
+
+// selectionCost is the size of a selection set with its fragments expanded:
+// how deep its fields nest and how many it selects, each alias counted.
+type selectionCost struct {
+	depth  int
+	fields int
+}
+
+func (c *selectionCost) add(other selectionCost) {
+	c.depth = max(c.depth, other.depth)
+	c.fields = min(c.fields+other.fields, maxCost)
+}
+
+// fragmentState is a fragment the validator has walked. A fragment can only
+// be spread on its type condition, so it is walked once however often it is
+// spread, and done is unset while the walk is still inside it.
+type fragmentState struct {
+	cost selectionCost
+	done bool
+}
+
+// Validate checks an operation against the schema: every field exists with
+// known arguments and the required ones given, leaves have no selections
+// and objects do, every fragment and variable used is defined, and the
+// operation stays within MaxDepth and MaxFields. Execute expects an
+// operation that passed.
+func (s *Schema) Validate(doc *Document, operation *Operation) []*Error {
+	v := &validator{schema: s, doc: doc, variables: map[string]bool{}, fragments: map[string]*fragmentState{}}
+
+	var root *Object
+	switch operation.Type {
+	case "query":
+		root = s.Query
+	case "mutation":
+		root = s.Mutation
+	}
+
+	if root == nil {
+		return []*Error{newError(fmt.Sprintf("Schema is not configured for %s operations.", operation.Type), operation.Location)}
+	}
+
+	for _, definition := range operation.Variables {
+		if v.variables[definition.Name] {
+			v.addError(definition.Location, "There can be only one variable named \"$%s\".", definition.Name)
+		}
+		v.variables[definition.Name] = true
+
+		t := s.typeFromRef(definition.Type)
+		if t == nil || !isInputType(t) {
+			v.addError(definition.Location, "Variable \"$%s\" cannot be of type \"%s\".", definition.Name, definition.Type)
+		}
+	}
+
+	v.directives(operation.Directives)
+	cost := v.selectionSet(root, operation.SelectionSet)
+
+	if s.MaxDepth > 0 && cost.depth > s.MaxDepth {
+		v.addError(operation.Location, "Operation nests fields %d levels deep, more than the limit of %d.", cost.depth, s.MaxDepth)
+	}
+	if s.MaxFields > 0 && cost.fields > s.MaxFields {
+		v.addError(operation.Location, "Operation selects more than the limit of %d fields.", s.MaxFields)
+	}
+
+	return v.errors
+}
+
+func (v *validator) addError(location Location, format string, args ...any) {
+	v.errors = append(v.errors, newError(fmt.Sprintf(format, args...), location))
+}
+
+func (v *validator) selectionSet(parent *Object, selections []Selection) selectionCost {
+	var cost selectionCost
+
+	for _, selection := range selections {
+		switch selection := selection.(type) {
+		case *Field:
+			cost.add(v.field(parent, selection))
+		case *InlineFragment:
+			v.directives(selection.Directives)
+			if selection.TypeCondition != "" && selection.TypeCondition != parent.Name {
+				v.addError(selection.Location, "Fragment cannot be spread here as objects of type \"%s\" can never be of type \"%s\".", parent.Name, selection.TypeCondition)
+				continue
+			}
+			cost.add(v.selectionSet(parent, selection.SelectionSet))
+		case *FragmentSpread:
+			v.directives(selection.Directives)
+			fragment, ok := v.doc.Fragments[selection.Name]
+			if !ok {
+				v.addError(selection.Location, "Unknown fragment \"%s\".", selection.Name)
+				continue
+			}
+			if fragment.TypeCondition != parent.Name {
+				v.addError(selection.Location, "Fragment \"%s\" cannot be spread here as objects of type \"%s\" can never be of type \"%s\".", fragment.Name, parent.Name, fragment.TypeCondition)
+				continue
+			}
+
+			state, walked := v.fragments[fragment.Name]
+			if walked && !state.done {
+				v.addError(selection.Location, "Cannot spread fragment \"%s\" within itself.", fragment.Name)
+				continue
+			}
+			if !walked {
+				state = &fragmentState{}
+				v.fragments[fragment.Name] = state
+
+				v.directives(fragment.Directives)
+				state.cost = v.selectionSet(parent, fragment.SelectionSet)
+				state.done = true
+			}
+
+			cost.add(state.cost)
+		}
+	}
+
+	return cost
+}
+
+func (v *validator) field(parent *Object, field *Field) selectionCost {
+	cost := selectionCost{depth: 1, fields: 1}
+
+	v.directives(field.Directives)
+
+	if field.Name == "__typename" {
+		if len(field.Arguments) > 0 || field.SelectionSet != nil {
+			v.addError(field.Location, "Field \"__typename\" takes no arguments or selections.")
+		}
+		return cost
+	}
+
+	definition, ok := parent.Fields[field.Name]
+	if !ok {
+		v.addError(field.Location, "Cannot query field \"%s\" on type \"%s\".", field.Name, parent.Name)
+		return cost
+	}
+
+	v.arguments(definition.Args, field.Arguments, fmt.Sprintf("field \"%s.%s\"", parent.Name, field.Name), field.Location)
+
+	object, isObject := namedType(definition.Type).(*Object)
+	switch {
+	case isObject && field.SelectionSet == nil:
+		v.addError(field.Location, "Field \"%s\" of type \"%s\" must have a selection of subfields.", field.Name, definition.Type)
+	case !isObject && field.SelectionSet != nil:
+		v.addError(field.Location, "Field \"%s\" must not have a selection since type \"%s\" has no subfields.", field.Name, definition.Type)
+	case isObject:
+		subfields := v.selectionSet(object, field.SelectionSet)
+		cost.depth += subfields.depth
+		cost.fields = min(cost.fields+subfields.fields, maxCost)
+	}
+
+	return cost
+}
+
+func (v *validator) arguments(definitions []*ArgDef, arguments []*Argument, owner string, location Location) {
+	given := map[string]bool{}
+
+	for _, argument := range arguments {
+		if given[argument.Name] {
+			v.addError(argument.Location, "There can be only one argument named \"%s\".", argument.Name)
+		}
+		given[argument.Name] = true
+
+		known := false
+		for _, definition := range definitions {
+			known = known || definition.Name == argument.Name
+		}
+		if !known {
+			v.addError(argument.Location, "Unknown argument \"%s\" on %s.", argument.Name, owner)
+		}
+
+		v.value(argument.Value)
+	}
+
+	for _, definition := range definitions {
+		_, nonNull := definition.Type.(*NonNull)
+		if nonNull && definition.Default == nil && !given[definition.Name] {
+			v.addError(location, "Argument \"%s\" of type \"%s\" is required on %s but not provided.", definition.Name, definition.Type, owner)
+		}
+	}
+}
+
+// value checks that the variables used in a literal are defined.
+func (v *validator) value(value *Value) {
+	switch value.Kind {
+	case ValueVariable:
+		if !v.variables[value.Raw] {
+			v.addError(value.Location, "Variable \"$%s\" is not defined.", value.Raw)
+		}
+	case ValueList:
+		for _, item := range value.List {
+			v.value(item)
+		}
+	case ValueObject:
+		for _, field := range value.Fields {
+			v.value(field.Value)
+		}
+	}
+}
+
+var conditionArgs = []*ArgDef{{Name: "if", Type: NewNonNull(Boolean)}}
+
+func (v *validator) directives(directives []*Directive) {
+	for _, directive := range directives {
+		if directive.Name != "skip" && directive.Name != "include" {
+			v.addError(directive.Location, "Unknown directive \"@%s\".", directive.Name)
+			continue
+		}
+
+		v.arguments(conditionArgs, directive.Arguments, "directive \"@"+directive.Name+"\"", directive.Location)
+	}
+}
@@ -0,0 +1,187 @@
+package graphql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSchema(t *testing.T) *Schema {
+	t.Helper()
+
+	category := &Object{
+		Name: "Category",
+		Fields: Fields{
+			"id":   {Type: NewNonNull(ID)},
+			"name": {Type: NewNonNull(String)},
+		},
+	}
+	category.Fields["parent"] = &FieldDef{Type: category}
+
+	query := &Object{
+		Name: "Query",
+		Fields: Fields{
+			"category": {
+				Type: category,
+				Args: []*ArgDef{{Name: "id", Type: NewNonNull(ID)}},
+			},
+			"categories": {
+				Type: NewNonNull(NewList(NewNonNull(category))),
+				Args: []*ArgDef{{Name: "includeArchived", Type: NewNonNull(Boolean), Default: false}},
+			},
+		},
+	}
+
+	schema, err := NewSchema(query, nil)
+	if err != nil {
+		t.Fatalf("NewSchema: %v", err)
+	}
+	return schema
+}
+
+func validate(t *testing.T, schema *Schema, source string) []string {
+	t.Helper()
+
+	doc, err := Parse(source)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", source, err)
+	}
+
+	operation, err := doc.Operation("")
+	if err != nil {
+		t.Fatalf("Operation: %v", err)
+	}
+
+	messages := []string{}
+	for _, err := range schema.Validate(doc, operation) {
+		messages = append(messages, err.Message)
+	}
+	return messages
+}
+
+func TestValidate(t *testing.T) {
+	schema := newTestSchema(t)
+
+	messages := validate(t, schema, `
+		query ($id: ID!, $all: Boolean = true) {
+			category(id: $id) { ...Named parent { ...Named } }
+			categories(includeArchived: $all) { id ... on Category { __typename } }
+			archived: categories @skip(if: false) { id }
+		}
+
+		fragment Named on Category { id name }
+	`)
+	if len(messages) != 0 {
+		t.Errorf("valid query failed with %q", messages)
+	}
+}
+
+func TestValidateErrors(t *testing.T) {
+	schema := newTestSchema(t)
+
+	tests := []struct {
+		source  string
+		message string
+	}{
+		{`{ budget }`, `Cannot query field "budget" on type "Query".`},
+		{`{ category { id } }`, `Argument "id" of type "ID!" is required on field "Query.category" but not provided.`},
+		{`{ category(id: 1, kind: 2) { id } }`, `Unknown argument "kind" on field "Query.category".`},
+		{`{ category(id: 1) }`, `Field "category" of type "Category" must have a selection of subfields.`},
+		{`{ categories { name { id } } }`, `Field "name" must not have a selection since type "String!" has no subfields.`},
+		{`{ category(id: $id) { id } }`, `Variable "$id" is not defined.`},
+		{`query ($id: Category) { category(id: 1) { id } }`, `Variable "$id" cannot be of type "Category".`},
+		{`{ categories @cached { id } }`, `Unknown directive "@cached".`},
+		{`{ categories { ...Missing } }`, `Unknown fragment "Missing".`},
+		{`{ ...Named } fragment Named on Category { id }`, `Fragment "Named" cannot be spread here as objects of type "Query" can never be of type "Category".`},
+		{`{ categories { ...A } } fragment A on Category { ...B } fragment B on Category { ...A }`, `Cannot spread fragment "A" within itself.`},
+		{`mutation { categories { id } }`, `Schema is not configured for mutation operations.`},
+	}
+
+	for _, test := range tests {
+		messages := validate(t, schema, test.source)
+		if len(messages) != 1 || messages[0] != test.message {
+			t.Errorf("Validate(%s) = %q, want [%q]", test.source, messages, test.message)
+		}
+	}
+}
+
+func TestValidateReportsFragmentErrorsOnce(t *testing.T) {
+	messages := validate(t, newTestSchema(t), `
+		{
+			categories { ...Broken parent { ...Broken } }
+			category(id: 1) { ...Broken }
+		}
+
+		fragment Broken on Category { budget }
+	`)
+
+	if len(messages) != 1 || messages[0] != `Cannot query field "budget" on type "Category".` {
+		t.Errorf("Validate = %q, want the error in Broken once", messages)
+	}
+}
+
+// fragmentChain spreads every fragment of a chain twice in the next one, so
+// expanding the last one selects 2^n fields.
+func fragmentChain(n int) string {
+	var source strings.Builder
+
+	source.WriteString("{ categories { ...F0 } }\n")
+	for i := range n {
+		fmt.Fprintf(&source, "fragment F%d on Category { ...F%d ...F%d }\n", i, i+1, i+1)
+	}
+	fmt.Fprintf(&source, "fragment F%d on Category { id }\n", n)
+
+	return source.String()
+}
+
+func TestValidateFragmentChainIsLinear(t *testing.T) {
+	schema := newTestSchema(t)
+
+	started := time.Now()
+	messages := validate(t, schema, fragmentChain(64))
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("validating 64 fragments took %v", elapsed)
+	}
+	if len(messages) != 0 {
+		t.Errorf("Validate = %q, want no errors without limits", messages)
+	}
+
+	schema.MaxFields = 1000
+
+	messages = validate(t, schema, fragmentChain(64))
+	if len(messages) != 1 || messages[0] != "Operation selects more than the limit of 1000 fields." {
+		t.Errorf("Validate = %q, want the field limit error", messages)
+	}
+}
+
+func TestValidateLimits(t *testing.T) {
+	schema := newTestSchema(t)
+	schema.MaxDepth = 4
+	schema.MaxFields = 6
+
+	tests := []struct {
+		source  string
+		message string
+	}{
+		{`{ category(id: 1) { parent { parent { name } } } }`, ""},
+		{`{ category(id: 1) { parent { parent { parent { name } } } } }`, "Operation nests fields 5 levels deep, more than the limit of 4."},
+		{`{ a: categories { id } b: categories { id } c: categories { id } }`, ""},
+		{`{ a: categories { id } b: categories { id } c: categories { id name } }`, "Operation selects more than the limit of 6 fields."},
+		{`{ categories { ...Deep } } fragment Deep on Category { parent { parent { parent { id } } } }`, "Operation nests fields 5 levels deep, more than the limit of 4."},
+		{`{ a: categories { ...Pair } b: categories { ...Pair } } fragment Pair on Category { id name __typename }`, "Operation selects more than the limit of 6 fields."},
+	}
+
+	for _, test := range tests {
+		messages := validate(t, schema, test.source)
+
+		want := []string{}
+		if test.message != "" {
+			want = append(want, test.message)
+		}
+		if fmt.Sprint(messages) != fmt.Sprint(want) {
+			t.Errorf("Validate(%s) = %q, want %q", test.source, messages, want)
+		}
+	}
+}
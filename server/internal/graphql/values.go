@@ -0,0 +1,285 @@
+package graphql
+
+import (
+	"fmt"
+)
+
+// coerceVariables checks the variables sent with a request against the
+// definitions of the operation and converts them to the Go values passed
+// to resolvers.
+func (s *Schema) coerceVariables(operation *Operation, inputs map[string]any) (map[string]any, []*Error) {
+	variables := map[string]any{}
+	errs := []*Error{}
+
+	for _, definition := range operation.Variables {
+		t := s.typeFromRef(definition.Type)
+
+		raw, ok := inputs[definition.Name]
+		if !ok {
+			if definition.Default != nil {
+				value, _, err := coerceLiteral(t, definition.Default, nil)
+				if err != nil {
+					errs = append(errs, newError(fmt.Sprintf("Variable \"$%s\" has an invalid default value: %v", definition.Name, err), definition.Location))
+					continue
+				}
+				variables[definition.Name] = value
+			} else if _, nonNull := t.(*NonNull); nonNull {
+				errs = append(errs, newError(fmt.Sprintf("Variable \"$%s\" of required type \"%s\" was not provided.", definition.Name, t), definition.Location))
+			}
+			continue
+		}
+
+		value, err := coerceInput(t, raw)
+		if err != nil {
+			errs = append(errs, newError(fmt.Sprintf("Variable \"$%s\" got invalid value: %v", definition.Name, err), definition.Location))
+			continue
+		}
+
+		variables[definition.Name] = value
+	}
+
+	return variables, errs
+}
+
+// coerceInput converts a value decoded from JSON to the Go value of t.
+func coerceInput(t Type, value any) (any, error) {
+	if nonNull, ok := t.(*NonNull); ok {
+		if value == nil {
+			return nil, fmt.Errorf("expected non-nullable type %s not to be null", t)
+		}
+		return coerceInput(nonNull.OfType, value)
+	}
+
+	if value == nil {
+		return nil, nil
+	}
+
+	switch t := t.(type) {
+	case *Scalar:
+		return t.ParseValue(value)
+	case *Enum:
+		s, ok := value.(string)
+		if !ok || !t.has(s) {
+			return nil, fmt.Errorf("value %v does not exist in %s enum", value, t.Name)
+		}
+		return s, nil
+	case *List:
+		items, ok := value.([]any)
+		if !ok {
+			// A single value stands for a list of one
+			item, err := coerceInput(t.OfType, value)
+			if err != nil {
+				return nil, err
+			}
+			return []any{item}, nil
+		}
+
+		list := make([]any, len(items))
+		for i, item := range items {
+			coerced, err := coerceInput(t.OfType, item)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = coerced
+		}
+		return list, nil
+	case *InputObject:
+		fields, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected type %s to be an object", t.Name)
+		}
+
+		for name := range fields {
+			if t.field(name) == nil {
+				return nil, fmt.Errorf("field %q is not defined by type %s", name, t.Name)
+			}
+		}
+
+		object := map[string]any{}
+		for _, field := range t.Fields {
+			raw, ok := fields[field.Name]
+			if !ok {
+				err := setDefault(object, field)
+				if err != nil {
+					return nil, fmt.Errorf("%s.%s: %w", t.Name, field.Name, err)
+				}
+				continue
+			}
+
+			coerced, err := coerceInput(field.Type, raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", t.Name, field.Name, err)
+			}
+			object[field.Name] = coerced
+		}
+		return object, nil
+	}
+
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceLiteral converts a literal from the document to the Go value of t.
+// It reports false when the value is a variable that was not provided, in
+// which case the argument or field counts as left out.
+func coerceLiteral(t Type, value *Value, variables map[string]any) (any, bool, error) {
+	if value.Kind == ValueVariable {
+		v, ok := variables[value.Raw]
+		if !ok {
+			return nil, false, nil
+		}
+		if _, nonNull := t.(*NonNull); nonNull && v == nil {
+			return nil, true, fmt.Errorf("expected non-nullable type %s not to be null", t)
+		}
+		return v, true, nil
+	}
+
+	if nonNull, ok := t.(*NonNull); ok {
+		if value.Kind == ValueNull {
+			return nil, true, fmt.Errorf("expected non-nullable type %s not to be null", t)
+		}
+		return coerceLiteral(nonNull.OfType, value, variables)
+	}
+
+	if value.Kind == ValueNull {
+		return nil, true, nil
+	}
+
+	switch t := t.(type) {
+	case *Scalar:
+		v, err := t.ParseLiteral(value)
+		return v, true, err
+	case *Enum:
+		if value.Kind != ValueEnum || !t.has(value.Raw) {
+			return nil, true, fmt.Errorf("value %s does not exist in %s enum", value.Raw, t.Name)
+		}
+		return value.Raw, true, nil
+	case *List:
+		if value.Kind != ValueList {
+			item, _, err := coerceLiteral(t.OfType, value, variables)
+			if err != nil {
+				return nil, true, err
+			}
+			return []any{item}, true, nil
+		}
+
+		list := make([]any, len(value.List))
+		for i, item := range value.List {
+			coerced, _, err := coerceLiteral(t.OfType, item, variables)
+			if err != nil {
+				return nil, true, err
+			}
+			list[i] = coerced
+		}
+		return list, true, nil
+	case *InputObject:
+		if value.Kind != ValueObject {
+			return nil, true, fmt.Errorf("expected type %s to be an object", t.Name)
+		}
+
+		given := map[string]*Value{}
+		for _, field := range value.Fields {
+			if t.field(field.Name) == nil {
+				return nil, true, fmt.Errorf("field %q is not defined by type %s", field.Name, t.Name)
+			}
+			given[field.Name] = field.Value
+		}
+
+		object := map[string]any{}
+		for _, field := range t.Fields {
+			literal, ok := given[field.Name]
+
+			var coerced any
+			var err error
+			if ok {
+				coerced, ok, err = coerceLiteral(field.Type, literal, variables)
+				if err != nil {
+					return nil, true, fmt.Errorf("%s.%s: %w", t.Name, field.Name, err)
+				}
+			}
+
+			if !ok {
+				err := setDefault(object, field)
+				if err != nil {
+					return nil, true, fmt.Errorf("%s.%s: %w", t.Name, field.Name, err)
+				}
+				continue
+			}
+
+			object[field.Name] = coerced
+		}
+		return object, true, nil
+	}
+
+	return nil, true, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceArguments converts the arguments of a field, filling in defaults.
+func coerceArguments(definitions []*ArgDef, arguments []*Argument, variables map[string]any) (map[string]any, error) {
+	values := map[string]any{}
+
+	for _, definition := range definitions {
+		var argument *Argument
+		for _, a := range arguments {
+			if a.Name == definition.Name {
+				argument = a
+			}
+		}
+
+		if argument != nil {
+			value, ok, err := coerceLiteral(definition.Type, argument.Value, variables)
+			if err != nil {
+				return nil, fmt.Errorf("Argument %q has an invalid value: %v", definition.Name, err)
+			}
+			if ok {
+				values[definition.Name] = value
+				continue
+			}
+		}
+
+		err := setDefault(values, definition)
+		if err != nil {
+			return nil, fmt.Errorf("Argument %q of required type %q was not provided.", definition.Name, definition.Type)
+		}
+	}
+
+	return values, nil
+}
+
+func setDefault(values map[string]any, definition *ArgDef) error {
+	if definition.Default != nil {
+		values[definition.Name] = definition.Default
+		return nil
+	}
+
+	if _, nonNull := definition.Type.(*NonNull); nonNull {
+		return fmt.Errorf("required value was not provided")
+	}
+
+	return nil
+}
+
+func isInputType(t Type) bool {
+	switch t := t.(type) {
+	case *NonNull:
+		return isInputType(t.OfType)
+	case *List:
+		return isInputType(t.OfType)
+	case *Scalar, *Enum, *InputObject:
+		return true
+	}
+	return false
+}
+
+// namedType strips the list and non-null wrappers of t.
+func namedType(t Type) Type {
+	for {
+		switch wrapper := t.(type) {
+		case *NonNull:
+			t = wrapper.OfType
+		case *List:
+			t = wrapper.OfType
+		default:
+			return t
+		}
+	}
+}
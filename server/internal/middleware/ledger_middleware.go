@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"cha-ching-server/internal/store"
+	"cha-ching-server/internal/utils"
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type LedgerMiddleware struct {
+	LedgerStore store.LedgerStore
+}
+
+func NewLedgerMiddleware(ledgerStore store.LedgerStore) *LedgerMiddleware {
+	return &LedgerMiddleware{
+		LedgerStore: ledgerStore,
+	}
+}
+
+const LedgerContextKey = contextKey("ledger")
+
+// LedgerHeader selects the active ledger for routes that are not nested
+// under /ledgers/{ledgerID}.
+const LedgerHeader = "X-Ledger-ID"
+
+func SetLedger(r *http.Request, ledger *store.Ledger) *http.Request {
+	ctx := context.WithValue(r.Context(), LedgerContextKey, ledger)
+	r = r.WithContext(ctx)
+	return r
+}
+
+func GetLedger(r *http.Request) *store.Ledger {
+	ledger, ok := r.Context().Value(LedgerContextKey).(*store.Ledger)
+
+	if !ok {
+		panic("missing ledger in request")
+	}
+
+	return ledger
+}
+
+// ResolveLedger loads the active ledger from the {ledgerID} path parameter,
+// falling back to the X-Ledger-ID header and then to the user's default
+// ledger. The user must be a member of the ledger.
+func (lm *LedgerMiddleware) ResolveLedger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", LedgerHeader)
+		user := GetUser(r)
+
+		ledgerParam := chi.URLParam(r, "ledgerID")
+		if ledgerParam == "" {
+			ledgerParam = r.Header.Get(LedgerHeader)
+		}
+
+		var ledger *store.Ledger
+		var err error
+
+		if ledgerParam == "" {
+			ledger, err = lm.LedgerStore.GetDefaultLedgerForUser(user.ID)
+		} else {
+			ledgerID, convErr := strconv.Atoi(ledgerParam)
+			if convErr != nil {
+				utils.WriteJSONResponse(w, http.StatusBadRequest, utils.Envelope{"error": "invalid ledger id"})
+				return
+			}
+			ledger, err = lm.LedgerStore.GetLedgerForUser(ledgerID, user.ID)
+		}
+
+		if err != nil {
+			utils.WriteJSONResponse(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+
+		if ledger == nil {
+			utils.WriteJSONResponse(w, http.StatusNotFound, utils.Envelope{"error": "ledger not found"})
+			return
+		}
+
+		r = SetLedger(r, ledger)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireLedgerRole rejects requests whose membership role in the active
+// ledger ranks below role.
+func (lm *LedgerMiddleware) RequireLedgerRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ledger := GetLedger(r)
+			if !store.LedgerRoleAllows(ledger.Role, role) {
+				utils.WriteJSONResponse(w, http.StatusForbidden, utils.Envelope{"error": "you do not have permission to perform this action"})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS ledgers (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    created_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS ledger_members (
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (ledger_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS ledger_invites (
+    hash BYTEA PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(20) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+    invited_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    expiry TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE
+);
+
+ALTER TABLE
+    categories
+ADD
+    COLUMN ledger_id BIGINT REFERENCES ledgers (id) ON DELETE CASCADE;
+
+ALTER TABLE
+    payment_methods
+ADD
+    COLUMN ledger_id BIGINT REFERENCES ledgers (id) ON DELETE CASCADE;
+
+ALTER TABLE
+    expenses
+ADD
+    COLUMN ledger_id BIGINT REFERENCES ledgers (id) ON DELETE CASCADE;
+
+-- Every existing user gets a personal ledger that takes over their rows
+INSERT INTO
+    ledgers (name, created_by)
+SELECT
+    'Personal',
+    u.id
+FROM
+    users u;
+
+INSERT INTO
+    ledger_members (ledger_id, user_id, role)
+SELECT
+    l.id,
+    l.created_by,
+    'owner'
+FROM
+    ledgers l;
+
+UPDATE
+    categories c
+SET
+    ledger_id = l.id
+FROM
+    ledgers l
+WHERE
+    l.created_by = c.user_id;
+
+UPDATE
+    payment_methods pm
+SET
+    ledger_id = l.id
+FROM
+    ledgers l
+WHERE
+    l.created_by = pm.user_id;
+
+UPDATE
+    expenses e
+SET
+    ledger_id = l.id
+FROM
+    ledgers l
+WHERE
+    l.created_by = e.user_id;
+
+CREATE INDEX IF NOT EXISTS ledger_members_user_id_idx ON ledger_members (user_id);
+
+CREATE INDEX IF NOT EXISTS expenses_ledger_id_expense_date_idx ON expenses (ledger_id, expense_date);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE
+    expenses DROP COLUMN ledger_id;
+
+ALTER TABLE
+    payment_methods DROP COLUMN ledger_id;
+
+ALTER TABLE
+    categories DROP COLUMN ledger_id;
+
+DROP TABLE IF EXISTS ledger_invites;
+
+DROP TABLE IF EXISTS ledger_members;
+
+DROP TABLE IF EXISTS ledgers;
+
+-- +goose StatementEnd
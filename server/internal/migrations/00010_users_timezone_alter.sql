@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    users
+ADD
+    COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'Asia/Kolkata';
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE
+    users DROP COLUMN timezone;
+
+-- +goose StatementEnd
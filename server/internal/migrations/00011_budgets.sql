@@ -0,0 +1,73 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    categories
+ADD
+    COLUMN budget_cadence VARCHAR(10) NOT NULL DEFAULT 'monthly' CHECK (budget_cadence IN ('weekly', 'monthly', 'yearly'));
+
+CREATE TABLE IF NOT EXISTS budgets (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    category_id BIGINT NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    cadence VARCHAR(10) NOT NULL CHECK (cadence IN ('weekly', 'monthly', 'yearly')),
+    period_start DATE NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    carry_forward BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (category_id, cadence, period_start)
+);
+
+-- The single budget column becomes a monthly default from the month the
+-- category was created
+INSERT INTO
+    budgets (ledger_id, category_id, cadence, period_start, amount)
+SELECT
+    c.ledger_id,
+    c.id,
+    'monthly',
+    DATE_TRUNC('month', c.created_at AT TIME ZONE 'Asia/Kolkata')::date,
+    c.budget
+FROM
+    categories c
+WHERE
+    c.budget > 0
+    AND c.ledger_id IS NOT NULL;
+
+ALTER TABLE
+    categories DROP COLUMN budget;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE
+    categories
+ADD
+    COLUMN budget DECIMAL(10, 2) NOT NULL DEFAULT 0.00;
+
+UPDATE
+    categories c
+SET
+    budget = b.amount
+FROM
+    (
+        SELECT
+            DISTINCT ON (category_id) category_id,
+            amount
+        FROM
+            budgets
+        WHERE
+            carry_forward
+        ORDER BY
+            category_id,
+            period_start DESC
+    ) b
+WHERE
+    b.category_id = c.id;
+
+DROP TABLE IF EXISTS budgets;
+
+ALTER TABLE
+    categories DROP COLUMN budget_cadence;
+
+-- +goose StatementEnd
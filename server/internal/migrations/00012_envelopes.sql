@@ -0,0 +1,38 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    categories
+ADD
+    COLUMN envelope_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE
+    categories
+ADD
+    COLUMN rollover VARCHAR(10) NOT NULL DEFAULT 'none' CHECK (rollover IN ('none', 'positive', 'both'));
+
+CREATE TABLE IF NOT EXISTS envelope_allocations (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    category_id BIGINT NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    counterpart_category_id BIGINT REFERENCES categories (id) ON DELETE SET NULL,
+    month DATE NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    note VARCHAR(255) NOT NULL DEFAULT '',
+    user_id BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS envelope_allocations_ledger_id_month_idx ON envelope_allocations (ledger_id, month);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS envelope_allocations;
+
+ALTER TABLE
+    categories DROP COLUMN rollover;
+
+ALTER TABLE
+    categories DROP COLUMN envelope_enabled;
+
+-- +goose StatementEnd
@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    ledgers
+ADD
+    COLUMN monthly_limit DECIMAL(10, 2);
+
+CREATE TABLE IF NOT EXISTS budget_groups (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS budget_group_categories (
+    group_id BIGINT NOT NULL REFERENCES budget_groups (id) ON DELETE CASCADE,
+    category_id BIGINT NOT NULL REFERENCES categories (id) ON DELETE CASCADE,
+    PRIMARY KEY (group_id, category_id)
+);
+
+CREATE INDEX IF NOT EXISTS budget_groups_ledger_id_idx ON budget_groups (ledger_id);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS budget_group_categories;
+
+DROP TABLE IF EXISTS budget_groups;
+
+ALTER TABLE
+    ledgers DROP COLUMN monthly_limit;
+
+-- +goose StatementEnd
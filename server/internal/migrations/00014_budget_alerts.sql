@@ -0,0 +1,52 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A missing row means the default thresholds apply, an empty array turns
+-- alerts off. A NULL category_id is the overall monthly limit.
+CREATE TABLE IF NOT EXISTS budget_alert_settings (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    category_id BIGINT REFERENCES categories (id) ON DELETE CASCADE,
+    thresholds INTEGER [] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS budget_alert_settings_scope_idx ON budget_alert_settings (ledger_id, (COALESCE(category_id, 0)));
+
+-- One row per threshold crossed per budget period, so each fires only once
+CREATE TABLE IF NOT EXISTS budget_alerts (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    category_id BIGINT REFERENCES categories (id) ON DELETE CASCADE,
+    threshold INTEGER NOT NULL,
+    period_start DATE NOT NULL,
+    budget DECIMAL(10, 2) NOT NULL,
+    spent DECIMAL(10, 2) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS budget_alerts_period_idx ON budget_alerts (ledger_id, (COALESCE(category_id, 0)), threshold, period_start);
+
+CREATE TABLE IF NOT EXISTS inbox_items (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    ledger_id BIGINT REFERENCES ledgers (id) ON DELETE CASCADE,
+    kind VARCHAR(50) NOT NULL,
+    title VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL DEFAULT '',
+    read_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS inbox_items_user_id_created_at_idx ON inbox_items (user_id, created_at DESC);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS inbox_items;
+
+DROP TABLE IF EXISTS budget_alerts;
+
+DROP TABLE IF EXISTS budget_alert_settings;
+
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    categories
+ADD
+    COLUMN parent_id BIGINT REFERENCES categories (id);
+
+CREATE INDEX IF NOT EXISTS categories_parent_id_idx ON categories (parent_id);
+
+-- category_descendants returns the category itself and every category below
+-- it, so filters on a parent include its children
+CREATE OR REPLACE FUNCTION category_descendants(root_id BIGINT) RETURNS SETOF BIGINT AS $$
+    WITH RECURSIVE tree AS (
+        SELECT c.id FROM categories c WHERE c.id = root_id
+        UNION ALL
+        SELECT c.id FROM categories c INNER JOIN tree t ON c.parent_id = t.id
+    )
+    SELECT tree.id FROM tree
+$$ LANGUAGE SQL STABLE;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP FUNCTION IF EXISTS category_descendants(BIGINT);
+
+ALTER TABLE
+    categories DROP COLUMN parent_id;
+
+-- +goose StatementEnd
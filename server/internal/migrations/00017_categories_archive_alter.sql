@@ -0,0 +1,34 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    categories
+ADD
+    COLUMN archived_at TIMESTAMP WITH TIME ZONE;
+
+-- Expenses must be reassigned before their category is deleted, a NULL
+-- category_id breaks expense listing
+ALTER TABLE
+    expenses DROP CONSTRAINT IF EXISTS expenses_category_id_fkey;
+
+ALTER TABLE
+    expenses
+ADD
+    CONSTRAINT expenses_category_id_fkey FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE RESTRICT;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE
+    expenses DROP CONSTRAINT IF EXISTS expenses_category_id_fkey;
+
+ALTER TABLE
+    expenses
+ADD
+    CONSTRAINT expenses_category_id_fkey FOREIGN KEY (category_id) REFERENCES categories (id) ON DELETE
+SET
+    NULL;
+
+ALTER TABLE
+    categories DROP COLUMN archived_at;
+
+-- +goose StatementEnd
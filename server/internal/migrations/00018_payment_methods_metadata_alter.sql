@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE
+    payment_methods
+ADD
+    COLUMN kind VARCHAR(20) CHECK (
+        kind IN (
+            'upi',
+            'credit_card',
+            'debit_card',
+            'cash',
+            'net_banking',
+            'wallet'
+        )
+    ),
+ADD
+    COLUMN last_four CHAR(4) CHECK (last_four ~ '^[0-9]{4}$'),
+ADD
+    COLUMN issuer VARCHAR(100),
+ADD
+    COLUMN billing_cycle_day SMALLINT CHECK (
+        billing_cycle_day BETWEEN 1
+        AND 31
+    ),
+ADD
+    COLUMN archived_at TIMESTAMP WITH TIME ZONE;
+
+-- Expenses must be reassigned before their payment method is deleted
+ALTER TABLE
+    expenses DROP CONSTRAINT IF EXISTS expenses_payment_method_id_fkey;
+
+ALTER TABLE
+    expenses
+ADD
+    CONSTRAINT expenses_payment_method_id_fkey FOREIGN KEY (payment_method_id) REFERENCES payment_methods (id) ON DELETE RESTRICT;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE
+    expenses DROP CONSTRAINT IF EXISTS expenses_payment_method_id_fkey;
+
+ALTER TABLE
+    expenses
+ADD
+    CONSTRAINT expenses_payment_method_id_fkey FOREIGN KEY (payment_method_id) REFERENCES payment_methods (id) ON DELETE
+SET
+    NULL;
+
+ALTER TABLE
+    payment_methods DROP COLUMN archived_at,
+    DROP COLUMN billing_cycle_day,
+    DROP COLUMN issuer,
+    DROP COLUMN last_four,
+    DROP COLUMN kind;
+
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- billing_cycle_day is the statement closing day of a credit card
+ALTER TABLE
+    payment_methods
+ADD
+    COLUMN payment_due_day SMALLINT CHECK (
+        payment_due_day BETWEEN 1
+        AND 31
+    );
+
+CREATE TABLE IF NOT EXISTS card_payments (
+    id BIGSERIAL PRIMARY KEY,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    payment_method_id BIGINT NOT NULL REFERENCES payment_methods (id) ON DELETE CASCADE,
+    user_id BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    statement_end_date DATE NOT NULL,
+    amount DECIMAL(10, 2) NOT NULL CHECK (amount > 0),
+    paid_on DATE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS card_payments_statement_idx ON card_payments (payment_method_id, statement_end_date);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS card_payments;
+
+ALTER TABLE
+    payment_methods DROP COLUMN payment_due_day;
+
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+-- A tax section on an expense overrides the one on its category
+ALTER TABLE
+    categories
+ADD
+    COLUMN tax_section VARCHAR(20) CHECK (
+        tax_section IN ('80C', '80D', '80E', '80G', 'business')
+    );
+
+ALTER TABLE
+    expenses
+ADD
+    COLUMN tax_section VARCHAR(20) CHECK (
+        tax_section IN ('80C', '80D', '80E', '80G', 'business')
+    );
+
+CREATE INDEX IF NOT EXISTS expenses_tax_section_idx ON expenses (ledger_id, tax_section)
+WHERE
+    tax_section IS NOT NULL;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS expenses_tax_section_idx;
+
+ALTER TABLE
+    expenses DROP COLUMN tax_section;
+
+ALTER TABLE
+    categories DROP COLUMN tax_section;
+
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+-- last_period_start is the start of the most recent period a digest was
+-- sent for, so a restart never sends the same digest twice
+CREATE TABLE IF NOT EXISTS digest_preferences (
+    user_id BIGINT PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    ledger_id BIGINT NOT NULL REFERENCES ledgers (id) ON DELETE CASCADE,
+    frequency VARCHAR(10) NOT NULL CHECK (frequency IN ('weekly', 'monthly')),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_period_start DATE,
+    last_sent_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS digest_preferences;
+
+-- +goose StatementEnd
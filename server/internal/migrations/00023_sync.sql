@@ -0,0 +1,148 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every change to a synced row takes the next sync_version, and a pull
+-- returns the rows above the version the client last saw. Writes to a
+-- ledger are serialized by an advisory lock until they commit, so versions
+-- become visible in order and a pull never skips one.
+CREATE SEQUENCE IF NOT EXISTS sync_version_seq;
+
+ALTER TABLE
+    expenses
+ADD
+    COLUMN client_id UUID UNIQUE,
+ADD
+    COLUMN sync_version BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE
+    categories
+ADD
+    COLUMN client_id UUID UNIQUE,
+ADD
+    COLUMN sync_version BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE
+    payment_methods
+ADD
+    COLUMN client_id UUID UNIQUE,
+ADD
+    COLUMN sync_version BIGINT NOT NULL DEFAULT 0;
+
+UPDATE
+    categories
+SET
+    sync_version = nextval('sync_version_seq');
+
+UPDATE
+    payment_methods
+SET
+    sync_version = nextval('sync_version_seq');
+
+UPDATE
+    expenses
+SET
+    sync_version = nextval('sync_version_seq');
+
+CREATE INDEX IF NOT EXISTS expenses_sync_version_idx ON expenses (ledger_id, sync_version);
+
+CREATE INDEX IF NOT EXISTS categories_sync_version_idx ON categories (ledger_id, sync_version);
+
+CREATE INDEX IF NOT EXISTS payment_methods_sync_version_idx ON payment_methods (ledger_id, sync_version);
+
+-- ledger_id has no foreign key, so deleting a ledger can record the
+-- tombstones of its rows while it goes
+CREATE TABLE IF NOT EXISTS sync_tombstones (
+    entity VARCHAR(20) NOT NULL,
+    id BIGINT NOT NULL,
+    client_id UUID,
+    ledger_id BIGINT NOT NULL,
+    sync_version BIGINT NOT NULL,
+    deleted_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS sync_tombstones_ledger_id_idx ON sync_tombstones (ledger_id, sync_version);
+
+CREATE OR REPLACE FUNCTION sync_touch() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_advisory_xact_lock(hashtext('sync_version'), NEW.ledger_id::int);
+    NEW.sync_version := nextval('sync_version_seq');
+    IF TG_OP = 'UPDATE' THEN
+        NEW.updated_at := CURRENT_TIMESTAMP;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION sync_tombstone() RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.ledger_id IS NOT NULL THEN
+        PERFORM pg_advisory_xact_lock(hashtext('sync_version'), OLD.ledger_id::int);
+        INSERT INTO sync_tombstones (entity, id, client_id, ledger_id, sync_version)
+        VALUES (TG_ARGV[0], OLD.id, OLD.client_id, OLD.ledger_id, nextval('sync_version_seq'));
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER expenses_sync_insert BEFORE INSERT ON expenses FOR EACH ROW EXECUTE FUNCTION sync_touch();
+
+CREATE TRIGGER expenses_sync_update BEFORE UPDATE ON expenses FOR EACH ROW
+WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION sync_touch();
+
+CREATE TRIGGER expenses_sync_delete AFTER DELETE ON expenses FOR EACH ROW EXECUTE FUNCTION sync_tombstone('expense');
+
+CREATE TRIGGER categories_sync_insert BEFORE INSERT ON categories FOR EACH ROW EXECUTE FUNCTION sync_touch();
+
+CREATE TRIGGER categories_sync_update BEFORE UPDATE ON categories FOR EACH ROW
+WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION sync_touch();
+
+CREATE TRIGGER categories_sync_delete AFTER DELETE ON categories FOR EACH ROW EXECUTE FUNCTION sync_tombstone('category');
+
+CREATE TRIGGER payment_methods_sync_insert BEFORE INSERT ON payment_methods FOR EACH ROW EXECUTE FUNCTION sync_touch();
+
+CREATE TRIGGER payment_methods_sync_update BEFORE UPDATE ON payment_methods FOR EACH ROW
+WHEN (OLD.* IS DISTINCT FROM NEW.*) EXECUTE FUNCTION sync_touch();
+
+CREATE TRIGGER payment_methods_sync_delete AFTER DELETE ON payment_methods FOR EACH ROW EXECUTE FUNCTION sync_tombstone('payment_method');
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS expenses_sync_insert ON expenses;
+
+DROP TRIGGER IF EXISTS expenses_sync_update ON expenses;
+
+DROP TRIGGER IF EXISTS expenses_sync_delete ON expenses;
+
+DROP TRIGGER IF EXISTS categories_sync_insert ON categories;
+
+DROP TRIGGER IF EXISTS categories_sync_update ON categories;
+
+DROP TRIGGER IF EXISTS categories_sync_delete ON categories;
+
+DROP TRIGGER IF EXISTS payment_methods_sync_insert ON payment_methods;
+
+DROP TRIGGER IF EXISTS payment_methods_sync_update ON payment_methods;
+
+DROP TRIGGER IF EXISTS payment_methods_sync_delete ON payment_methods;
+
+DROP FUNCTION IF EXISTS sync_tombstone();
+
+DROP FUNCTION IF EXISTS sync_touch();
+
+DROP TABLE IF EXISTS sync_tombstones;
+
+ALTER TABLE
+    expenses DROP COLUMN client_id,
+    DROP COLUMN sync_version;
+
+ALTER TABLE
+    categories DROP COLUMN client_id,
+    DROP COLUMN sync_version;
+
+ALTER TABLE
+    payment_methods DROP COLUMN client_id,
+    DROP COLUMN sync_version;
+
+DROP SEQUENCE IF EXISTS sync_version_seq;
+
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- claimed_until is set by the instance sending a digest, so other instances
+-- running the scheduler skip the subscriber until it is sent or the claim
+-- runs out
+ALTER TABLE digest_preferences
+ADD COLUMN IF NOT EXISTS claimed_until TIMESTAMP WITH TIME ZONE;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE digest_preferences
+DROP COLUMN IF EXISTS claimed_until;
+
+-- +goose StatementEnd
@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Every membership change is announced on the ledger_members channel, so
+-- event streams open on any instance start or stop receiving a ledger's
+-- events without waiting for the client to reconnect. This includes the
+-- rows removed when a ledger is deleted.
+CREATE OR REPLACE FUNCTION ledger_members_notify() RETURNS TRIGGER AS $$
+DECLARE
+    member ledger_members%ROWTYPE;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        member := OLD;
+    ELSE
+        member := NEW;
+    END IF;
+
+    PERFORM pg_notify('ledger_members', json_build_object(
+        'op', TG_OP,
+        'ledger_id', member.ledger_id,
+        'user_id', member.user_id
+    )::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_members_notify AFTER INSERT OR DELETE ON ledger_members FOR EACH ROW EXECUTE FUNCTION ledger_members_notify();
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+DROP TRIGGER IF EXISTS ledger_members_notify ON ledger_members;
+
+DROP FUNCTION IF EXISTS ledger_members_notify();
+
+-- +goose StatementEnd
@@ -0,0 +1,27 @@
+-- +goose Up
+-- +goose StatementBegin
+-- UNION drops categories already visited, so the walk ends even if a cycle
+-- slipped into parent_id. Re-parenting is serialized per ledger to keep new
+-- cycles out, see lockCategoryTree.
+CREATE OR REPLACE FUNCTION category_descendants(root_id BIGINT) RETURNS SETOF BIGINT AS $$
+    WITH RECURSIVE tree AS (
+        SELECT c.id FROM categories c WHERE c.id = root_id
+        UNION
+        SELECT c.id FROM categories c INNER JOIN tree t ON c.parent_id = t.id
+    )
+    SELECT tree.id FROM tree
+$$ LANGUAGE SQL STABLE;
+
+-- +goose StatementEnd
+-- +goose Down
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION category_descendants(root_id BIGINT) RETURNS SETOF BIGINT AS $$
+    WITH RECURSIVE tree AS (
+        SELECT c.id FROM categories c WHERE c.id = root_id
+        UNION ALL
+        SELECT c.id FROM categories c INNER JOIN tree t ON c.parent_id = t.id
+    )
+    SELECT tree.id FROM tree
+$$ LANGUAGE SQL STABLE;
+
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"cha-ching-server/internal/store"
+	"context"
+)
+
+// InboxNotifier stores notifications in the in-app inbox of each recipient.
+type InboxNotifier struct {
+	inboxStore store.InboxStore
+}
+
+func NewInboxNotifier(inboxStore store.InboxStore) *InboxNotifier {
+	return &InboxNotifier{
+		inboxStore,
+	}
+}
+
+func (in *InboxNotifier) Notify(ctx context.Context, notification *Notification) error {
+	userIDs := make([]int, 0, len(notification.Recipients))
+	for _, recipient := range notification.Recipients {
+		userIDs = append(userIDs, recipient.UserID)
+	}
+
+	ledgerID := notification.LedgerID
+
+	return in.inboxStore.CreateInboxItems(&store.InboxItem{
+		LedgerID: &ledgerID,
+		Kind:     notification.Kind,
+		Title:    notification.Title,
+		Body:     notification.Body,
+	}, userIDs)
+}
@@ -0,0 +1,174 @@
+package notifier
+
+import (
+	"bytes"
+	"cha-ching-server/internal/config"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends email through the SMTP server in config.SMTPConfig. Without
+// credentials it sends unauthenticated, which is what local SMTP sinks
+// used in development and tests expect.
+type Mailer struct {
+	cfg config.SMTPConfig
+}
+
+func NewMailer(cfg config.SMTPConfig) *Mailer {
+	return &Mailer{
+		cfg: cfg,
+	}
+}
+
+// Send delivers a message to every address in to. When htmlBody is set the
+// message is multipart/alternative with textBody as the plain part.
+func (m *Mailer) Send(to []string, subject string, textBody string, htmlBody string) error {
+	return m.SendContext(context.Background(), to, subject, textBody, htmlBody)
+}
+
+// SendContext is Send with a context. When ctx is done the connection is
+// closed, abandoning whatever step of the SMTP exchange is under way.
+func (m *Mailer) SendContext(ctx context.Context, to []string, subject string, textBody string, htmlBody string) error {
+	if len(to) == 0 {
+		return nil
+	}
+
+	for _, address := range append([]string{m.cfg.From}, to...) {
+		if strings.ContainsAny(address, "\r\n") {
+			return errors.New("smtp: addresses must not contain CR or LF")
+		}
+	}
+
+	message, err := buildMessage(m.cfg.From, to, subject, textBody, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.cfg.Host, m.cfg.Port))
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	err = m.deliver(conn, to, message)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	return err
+}
+
+// deliver runs the same exchange as smtp.SendMail over conn.
+func (m *Mailer) deliver(conn net.Conn, to []string, message []byte) error {
+	client, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: m.cfg.Host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.cfg.Username != "" {
+		if ok, _ := client.Extension("AUTH"); !ok {
+			return errors.New("smtp: server doesn't support AUTH")
+		}
+
+		err = client.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	// The From header may carry a display name, the envelope only the address
+	from, err := mail.ParseAddress(m.cfg.From)
+	if err != nil {
+		return err
+	}
+
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range to {
+		err = client.Rcpt(address)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(message)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func buildMessage(from string, to []string, subject string, textBody string, htmlBody string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if htmlBody == "" {
+		buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		buf.WriteString(normalizeNewlines(textBody))
+		return buf.Bytes(), nil
+	}
+
+	boundaryBytes := make([]byte, 12)
+	_, err := rand.Read(boundaryBytes)
+	if err != nil {
+		return nil, err
+	}
+	boundary := hex.EncodeToString(boundaryBytes)
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", boundary, normalizeNewlines(textBody))
+	fmt.Fprintf(&buf, "--%s\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n%s\r\n", boundary, normalizeNewlines(htmlBody))
+	fmt.Fprintf(&buf, "--%s--\r\n", boundary)
+
+	return buf.Bytes(), nil
+}
+
+func normalizeNewlines(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	return strings.ReplaceAll(body, "\n", "\r\n")
+}
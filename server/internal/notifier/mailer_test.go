@@ -0,0 +1,282 @@
+package notifier
+
+import (
+	"bytes"
+	"cha-ching-server/internal/config"
+	"cha-ching-server/internal/smtptest"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readMessage(t *testing.T, message *smtptest.Message) *mail.Message {
+	t.Helper()
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(message.Data))
+	if err != nil {
+		t.Fatalf("parsing message: %v", err)
+	}
+	return parsed
+}
+
+func TestMailerSendMultipart(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	mailer := NewMailer(sink.Config("Cha-Ching <noreply@cha-ching.test>"))
+
+	err := mailer.Send(
+		[]string{"asha@example.com", "ravi@example.com"},
+		"Your weekly digest – ₹1,200 spent",
+		"Spent ₹1,200\nTop: Food",
+		"<p>Spent ₹1,200</p>\n<p>Top: Food</p>",
+	)
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	messages := sink.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	message := messages[0]
+	if message.From != "noreply@cha-ching.test" {
+		t.Errorf("envelope from = %q", message.From)
+	}
+	if want := []string{"asha@example.com", "ravi@example.com"}; !reflect.DeepEqual(message.To, want) {
+		t.Errorf("envelope to = %q, want %q", message.To, want)
+	}
+
+	parsed := readMessage(t, message)
+
+	if got := parsed.Header.Get("From"); got != "Cha-Ching <noreply@cha-ching.test>" {
+		t.Errorf("From = %q", got)
+	}
+	if got := parsed.Header.Get("To"); got != "asha@example.com, ravi@example.com" {
+		t.Errorf("To = %q", got)
+	}
+	if got := parsed.Header.Get("MIME-Version"); got != "1.0" {
+		t.Errorf("MIME-Version = %q", got)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("decoding subject: %v", err)
+	}
+	if subject != "Your weekly digest – ₹1,200 spent" {
+		t.Errorf("Subject = %q", subject)
+	}
+
+	if _, err := parsed.Header.Date(); err != nil {
+		t.Errorf("Date: %v", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parsing Content-Type: %v", err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, want multipart/alternative", mediaType)
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+
+	wantParts := []struct {
+		contentType string
+		body        string
+	}{
+		{"text/plain; charset=UTF-8", "Spent ₹1,200\nTop: Food"},
+		{"text/html; charset=UTF-8", "<p>Spent ₹1,200</p>\n<p>Top: Food</p>"},
+	}
+
+	for _, want := range wantParts {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatalf("reading %s part: %v", want.contentType, err)
+		}
+
+		if got := part.Header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, want.contentType)
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("reading part body: %v", err)
+		}
+		if got := strings.TrimRight(string(body), "\n"); got != want.body {
+			t.Errorf("part body = %q, want %q", got, want.body)
+		}
+	}
+
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly two parts, got %v", err)
+	}
+}
+
+func TestMailerSendPlain(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	mailer := NewMailer(sink.Config("noreply@cha-ching.test"))
+
+	err := mailer.Send([]string{"asha@example.com"}, "Hello", "line one\nline two", "")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	messages := sink.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	parsed := readMessage(t, messages[0])
+
+	if got := parsed.Header.Get("Content-Type"); got != "text/plain; charset=UTF-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+
+	body, err := io.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if got := strings.TrimRight(string(body), "\n"); got != "line one\nline two" {
+		t.Errorf("body = %q", got)
+	}
+}
+
+func TestMailerSendWithoutRecipients(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	err := NewMailer(sink.Config("noreply@cha-ching.test")).Send(nil, "Hello", "body", "")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if messages := sink.Messages(); len(messages) != 0 {
+		t.Errorf("got %d messages, want none", len(messages))
+	}
+}
+
+func TestMailerRejectsHeaderInjection(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	err := NewMailer(sink.Config("noreply@cha-ching.test")).Send([]string{"asha@example.com\r\nBcc: eve@example.com"}, "Hello", "body", "")
+	if err == nil {
+		t.Fatal("expected an error for an address with CRLF")
+	}
+
+	if messages := sink.Messages(); len(messages) != 0 {
+		t.Errorf("got %d messages, want none", len(messages))
+	}
+}
+
+func TestSMTPNotifierNotify(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	notifier := NewSMTPNotifier(NewMailer(sink.Config("noreply@cha-ching.test")))
+
+	err := notifier.Notify(context.Background(), &Notification{
+		Kind:  KindBudgetAlert,
+		Title: "Food budget exceeded",
+		Body:  "You have spent ₹5,200 of ₹5,000.",
+		Recipients: []Recipient{
+			{UserID: 1, Name: "Asha", Email: "asha@example.com"},
+			{UserID: 2, Name: "No email"},
+			{UserID: 3, Name: "Ravi", Email: "ravi@example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+
+	messages := sink.Messages()
+	if len(messages) != 1 {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+
+	if want := []string{"asha@example.com", "ravi@example.com"}; !reflect.DeepEqual(messages[0].To, want) {
+		t.Errorf("envelope to = %q, want %q", messages[0].To, want)
+	}
+
+	parsed := readMessage(t, messages[0])
+	if got := parsed.Header.Get("Subject"); got != "Food budget exceeded" {
+		t.Errorf("Subject = %q", got)
+	}
+	if got := parsed.Header.Get("Content-Type"); got != "text/plain; charset=UTF-8" {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestSMTPNotifierNotifyCanceled(t *testing.T) {
+	sink := smtptest.NewServer()
+	defer sink.Close()
+
+	notifier := NewSMTPNotifier(NewMailer(sink.Config("noreply@cha-ching.test")))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := notifier.Notify(ctx, &Notification{
+		Title:      "Food budget exceeded",
+		Recipients: []Recipient{{Email: "asha@example.com"}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Notify = %v, want context.Canceled", err)
+	}
+
+	if messages := sink.Messages(); len(messages) != 0 {
+		t.Errorf("got %d messages, want none", len(messages))
+	}
+}
+
+func TestSMTPNotifierNotifyDeadline(t *testing.T) {
+	// A server that accepts but never greets leaves the client waiting
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer listener.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		<-done
+		conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	notifier := NewSMTPNotifier(NewMailer(config.SMTPConfig{Host: host, Port: port, From: "noreply@cha-ching.test"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	err = notifier.Notify(ctx, &Notification{
+		Title:      "Food budget exceeded",
+		Recipients: []Recipient{{Email: "asha@example.com"}},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Notify = %v, want context.DeadlineExceeded", err)
+	}
+
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Notify returned after %v, long past the deadline", elapsed)
+	}
+}
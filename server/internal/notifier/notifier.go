@@ -0,0 +1,116 @@
+package notifier
+
+import (
+	"cha-ching-server/internal/store"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+const KindBudgetAlert = "budget_alert"
+
+type Recipient struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+type Notification struct {
+	Kind       string      `json:"kind"`
+	LedgerID   int         `json:"ledger_id"`
+	Title      string      `json:"title"`
+	Body       string      `json:"body"`
+	Data       any         `json:"data"`
+	Recipients []Recipient `json:"-"`
+}
+
+// Notifier delivers a notification over one channel.
+type Notifier interface {
+	Notify(ctx context.Context, notification *Notification) error
+}
+
+// Multi delivers every notification over each of its channels. A failing
+// channel does not stop the others.
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, notification *Notification) error {
+	var errs []error
+
+	for _, n := range m {
+		err := n.Notify(ctx, notification)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func NewBudgetAlertNotification(alert *store.BudgetAlert) *Notification {
+	title := fmt.Sprintf("%s budget %d%% used", alert.CategoryName, alert.Threshold)
+	if alert.Threshold >= 100 {
+		title = fmt.Sprintf("%s budget exceeded", alert.CategoryName)
+	}
+
+	return &Notification{
+		Kind:     KindBudgetAlert,
+		LedgerID: alert.LedgerID,
+		Title:    title,
+		Body: fmt.Sprintf(
+			"You have spent %.2f of the %.2f %s budget for the period starting %s.",
+			alert.Spent,
+			alert.Budget,
+			alert.CategoryName,
+			alert.PeriodStart,
+		),
+		Data: alert,
+	}
+}
+
+// Dispatcher sends notifications to every member of a ledger in the
+// background, so slow channels never hold up a request.
+type Dispatcher struct {
+	logger      *log.Logger
+	ledgerStore store.LedgerStore
+	notifier    Notifier
+}
+
+func NewDispatcher(logger *log.Logger, ledgerStore store.LedgerStore, notifier Notifier) *Dispatcher {
+	return &Dispatcher{
+		logger,
+		ledgerStore,
+		notifier,
+	}
+}
+
+func (d *Dispatcher) Dispatch(ledgerID int, notifications []*Notification) {
+	if len(notifications) == 0 {
+		return
+	}
+
+	go func() {
+		members, err := d.ledgerStore.ListLedgerMembers(ledgerID)
+		if err != nil {
+			d.logger.Printf("ERROR: ListLedgerMembers: %v", err)
+			return
+		}
+
+		recipients := make([]Recipient, 0, len(members))
+		for _, member := range members {
+			recipients = append(recipients, Recipient{UserID: member.UserID, Name: member.Name, Email: member.Email})
+		}
+
+		for _, notification := range notifications {
+			notification.Recipients = recipients
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := d.notifier.Notify(ctx, notification)
+			cancel()
+			if err != nil {
+				d.logger.Printf("ERROR: Notify %s: %v", notification.Kind, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	"context"
+)
+
+// SMTPNotifier emails each notification to its recipients.
+type SMTPNotifier struct {
+	mailer *Mailer
+}
+
+func NewSMTPNotifier(mailer *Mailer) *SMTPNotifier {
+	return &SMTPNotifier{
+		mailer,
+	}
+}
+
+// Notify gives up on the SMTP exchange when ctx is done.
+func (sn *SMTPNotifier) Notify(ctx context.Context, notification *Notification) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	to := make([]string, 0, len(notification.Recipients))
+	for _, recipient := range notification.Recipients {
+		if recipient.Email != "" {
+			to = append(to, recipient.Email)
+		}
+	}
+
+	return sn.mailer.SendContext(ctx, to, notification.Title, notification.Body, "")
+}
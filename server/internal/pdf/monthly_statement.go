@@ -0,0 +1,471 @@
+package pdf
+
+import (
+	"cha-ching-server/internal/store"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	margin       = 40.0
+	contentWidth = PageWidth - 2*margin
+	footerY      = PageHeight - 24
+	bottomLimit  = PageHeight - 50
+)
+
+var (
+	black     = Color{0, 0, 0}
+	gray      = Color{0.45, 0.45, 0.45}
+	lightGray = Color{0.9, 0.9, 0.9}
+	accent    = Color{0.16, 0.45, 0.71}
+	warning   = Color{0.85, 0.55, 0.1}
+	danger    = Color{0.8, 0.2, 0.2}
+)
+
+// MonthlyStatement is everything that goes into the printable statement of
+// one month of a ledger.
+type MonthlyStatement struct {
+	LedgerName     string
+	Month          time.Time
+	GeneratedOn    time.Time
+	Timezone       string
+	Meta           *store.ExpenseMetaItems
+	Categories     []*store.CategoryStat
+	PaymentMethods []*store.PaymentMethodStats
+	Days           []*store.ExpenseTotalPerDay
+	Expenses       []*store.Expense
+	Related        *store.ExpenseRelatedItems
+}
+
+// statementWriter keeps track of where the next line goes and starts a new
+// page when the current one is full.
+type statementWriter struct {
+	doc *Document
+	y   float64
+}
+
+func (w *statementWriter) ensureSpace(height float64) bool {
+	if w.y+height <= bottomLimit {
+		return false
+	}
+	w.doc.AddPage()
+	w.y = margin
+	return true
+}
+
+func (w *statementWriter) heading(title string) {
+	w.ensureSpace(60)
+	w.y += 14
+	w.doc.SetFillColor(black)
+	w.doc.SetFont(true, 13)
+	w.doc.Text(margin, w.y, title)
+	w.y += 6
+	w.doc.SetStrokeColor(lightGray)
+	w.doc.Line(margin, w.y, PageWidth-margin, w.y, 0.8)
+	w.y += 14
+}
+
+// RenderMonthlyStatement lays out the statement and returns the PDF file.
+func RenderMonthlyStatement(statement *MonthlyStatement) []byte {
+	doc := New()
+	doc.AddPage()
+	w := &statementWriter{doc: doc, y: margin}
+
+	renderHeader(w, statement)
+	renderSummary(w, statement)
+	renderCategories(w, statement)
+	renderPaymentMethods(w, statement)
+	renderDailyChart(w, statement)
+	renderExpenses(w, statement)
+
+	pages := doc.PageCount()
+	for page := 1; page <= pages; page++ {
+		doc.SetPage(page)
+		doc.SetFillColor(gray)
+		doc.SetFont(false, 8)
+		doc.Text(margin, footerY, statement.LedgerName+" - "+statement.Month.Format("January 2006"))
+		doc.TextRight(PageWidth-margin, footerY, fmt.Sprintf("Page %d of %d", page, pages))
+	}
+
+	return doc.Bytes()
+}
+
+func renderHeader(w *statementWriter, statement *MonthlyStatement) {
+	w.doc.SetFillColor(accent)
+	w.doc.SetFont(true, 20)
+	w.doc.Text(margin, w.y+16, "Monthly statement")
+
+	w.doc.SetFillColor(black)
+	w.doc.SetFont(false, 11)
+	w.doc.TextRight(PageWidth-margin, w.y+8, statement.LedgerName)
+	w.doc.SetFont(true, 11)
+	w.doc.TextRight(PageWidth-margin, w.y+22, statement.Month.Format("January 2006"))
+
+	w.y += 34
+	w.doc.SetFillColor(gray)
+	w.doc.SetFont(false, 8)
+	w.doc.Text(margin, w.y, "Generated on "+statement.GeneratedOn.Format("2 January 2006")+", amounts in Rs., dates in "+statement.Timezone)
+	w.y += 10
+}
+
+func renderSummary(w *statementWriter, statement *MonthlyStatement) {
+	w.heading("Summary")
+
+	budget := 0.0
+	for _, category := range statement.Categories {
+		if category.ParentID == nil {
+			budget += category.RolledUpBudget
+		}
+	}
+
+	daysInMonth := statement.Month.AddDate(0, 1, -1).Day()
+
+	items := [][2]string{
+		{"Total spent", formatMoney(statement.Meta.TotalAmount)},
+		{"Expenses", strconv.Itoa(statement.Meta.TotalCount)},
+		{"Average per day", formatMoney(statement.Meta.TotalAmount / float64(daysInMonth))},
+		{"Budget", formatMoney(budget)},
+	}
+
+	boxWidth := contentWidth / float64(len(items))
+	for i, item := range items {
+		x := margin + float64(i)*boxWidth
+		w.doc.SetFillColor(Color{0.96, 0.97, 0.99})
+		w.doc.Rect(x+2, w.y, boxWidth-4, 42, true)
+		w.doc.SetFillColor(gray)
+		w.doc.SetFont(false, 8)
+		w.doc.Text(x+10, w.y+14, item[0])
+		w.doc.SetFillColor(black)
+		w.doc.SetFont(true, 13)
+		w.doc.Text(x+10, w.y+32, item[1])
+	}
+
+	w.y += 54
+
+	if budget > 0 {
+		remaining := budget - statement.Meta.TotalAmount
+		w.doc.SetFont(false, 9)
+		if remaining >= 0 {
+			w.doc.SetFillColor(gray)
+			w.doc.Text(margin, w.y, formatMoney(remaining)+" of the budget left")
+		} else {
+			w.doc.SetFillColor(danger)
+			w.doc.Text(margin, w.y, formatMoney(-remaining)+" over budget")
+		}
+		w.y += 12
+	}
+}
+
+// renderCategories lists top level categories with the totals of their
+// subcategories rolled in. The bar shows spend against budget, or the share
+// of the month when there is no budget.
+func renderCategories(w *statementWriter, statement *MonthlyStatement) {
+	w.heading("Categories")
+
+	categories := []*store.CategoryStat{}
+	for _, category := range statement.Categories {
+		if category.ParentID == nil && (category.RolledUpCount > 0 || category.RolledUpBudget > 0) {
+			categories = append(categories, category)
+		}
+	}
+
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i].RolledUpTotalAmount > categories[j].RolledUpTotalAmount
+	})
+
+	if len(categories) == 0 {
+		emptyLine(w, "No spending this month")
+		return
+	}
+
+	nameX := margin
+	barX := margin + 150
+	barWidth := 200.0
+	spentX := PageWidth - margin - 80
+	budgetX := PageWidth - margin
+
+	tableHeader(w, []headerCell{{nameX, "Category", false}, {barX, "Budget used", false}, {spentX, "Spent", true}, {budgetX, "Budget", true}})
+
+	for _, category := range categories {
+		if w.ensureSpace(18) {
+			tableHeader(w, []headerCell{{nameX, "Category", false}, {barX, "Budget used", false}, {spentX, "Spent", true}, {budgetX, "Budget", true}})
+		}
+
+		w.doc.SetFillColor(black)
+		w.doc.SetFont(false, 9)
+		w.doc.TextFit(nameX, w.y, barX-nameX-10, category.Name)
+
+		var ratio float64
+		color := accent
+		budget := "-"
+		if category.RolledUpBudget > 0 {
+			ratio = category.RolledUpTotalAmount / category.RolledUpBudget
+			budget = formatMoney(category.RolledUpBudget)
+			switch {
+			case ratio > 1:
+				color = danger
+			case ratio >= 0.8:
+				color = warning
+			}
+		} else if statement.Meta.TotalAmount > 0 {
+			ratio = category.RolledUpTotalAmount / statement.Meta.TotalAmount
+			color = gray
+		}
+
+		bar(w, barX, barWidth, ratio, color)
+		if category.RolledUpBudget > 0 {
+			w.doc.SetFillColor(gray)
+			w.doc.SetFont(false, 7)
+			w.doc.Text(barX+barWidth+6, w.y, fmt.Sprintf("%.0f%%", ratio*100))
+		}
+
+		w.doc.SetFillColor(black)
+		w.doc.SetFont(false, 9)
+		w.doc.TextRight(spentX, w.y, formatMoney(category.RolledUpTotalAmount))
+		w.doc.TextRight(budgetX, w.y, budget)
+		w.y += 16
+	}
+}
+
+func renderPaymentMethods(w *statementWriter, statement *MonthlyStatement) {
+	w.heading("Payment methods")
+
+	paymentMethods := []*store.PaymentMethodStats{}
+	for _, paymentMethod := range statement.PaymentMethods {
+		if paymentMethod.Count > 0 {
+			paymentMethods = append(paymentMethods, paymentMethod)
+		}
+	}
+
+	if len(paymentMethods) == 0 {
+		emptyLine(w, "No spending this month")
+		return
+	}
+
+	nameX := margin
+	barX := margin + 150
+	countX := PageWidth - margin - 80
+	amountX := PageWidth - margin
+
+	tableHeader(w, []headerCell{{nameX, "Payment method", false}, {barX, "Share", false}, {countX, "Expenses", true}, {amountX, "Spent", true}})
+
+	for _, paymentMethod := range paymentMethods {
+		if w.ensureSpace(18) {
+			tableHeader(w, []headerCell{{nameX, "Payment method", false}, {barX, "Share", false}, {countX, "Expenses", true}, {amountX, "Spent", true}})
+		}
+
+		w.doc.SetFillColor(black)
+		w.doc.SetFont(false, 9)
+		w.doc.TextFit(nameX, w.y, barX-nameX-10, paymentMethod.Name)
+
+		var ratio float64
+		if statement.Meta.TotalAmount > 0 {
+			ratio = paymentMethod.TotalAmount / statement.Meta.TotalAmount
+		}
+		bar(w, barX, 200, ratio, accent)
+
+		w.doc.SetFillColor(black)
+		w.doc.SetFont(false, 9)
+		w.doc.TextRight(countX, w.y, strconv.Itoa(paymentMethod.Count))
+		w.doc.TextRight(amountX, w.y, formatMoney(paymentMethod.TotalAmount))
+		w.y += 16
+	}
+}
+
+// renderDailyChart draws one bar per day of the month, scaled to the
+// busiest day.
+func renderDailyChart(w *statementWriter, statement *MonthlyStatement) {
+	const chartHeight = 120.0
+
+	w.heading("Daily spending")
+	w.ensureSpace(chartHeight + 30)
+
+	totals := make(map[string]float64, len(statement.Days))
+	maxTotal := 0.0
+	for _, day := range statement.Days {
+		totals[day.ExpenseDate] = day.TotalAmount
+		maxTotal = max(maxTotal, day.TotalAmount)
+	}
+
+	daysInMonth := statement.Month.AddDate(0, 1, -1).Day()
+	axisX := margin + 50
+	slot := (PageWidth - margin - axisX) / float64(daysInMonth)
+	top := w.y
+	baseline := top + chartHeight
+
+	w.doc.SetStrokeColor(lightGray)
+	w.doc.Line(axisX, top, PageWidth-margin, top, 0.5)
+	w.doc.Line(axisX, top+chartHeight/2, PageWidth-margin, top+chartHeight/2, 0.5)
+	w.doc.SetStrokeColor(gray)
+	w.doc.Line(axisX, baseline, PageWidth-margin, baseline, 0.8)
+
+	w.doc.SetFillColor(gray)
+	w.doc.SetFont(false, 7)
+	w.doc.TextRight(axisX-4, top+3, formatMoney(maxTotal))
+	w.doc.TextRight(axisX-4, top+chartHeight/2+3, formatMoney(maxTotal/2))
+	w.doc.TextRight(axisX-4, baseline+3, "0")
+
+	for day := 1; day <= daysInMonth; day++ {
+		date := statement.Month.AddDate(0, 0, day-1).Format("2006-01-02")
+		x := axisX + float64(day-1)*slot
+
+		if total := totals[date]; total > 0 && maxTotal > 0 {
+			height := max(total/maxTotal*chartHeight, 1)
+			w.doc.SetFillColor(accent)
+			w.doc.Rect(x+slot*0.15, baseline-height, slot*0.7, height, true)
+		}
+
+		if day == 1 || day%5 == 0 {
+			w.doc.SetFillColor(gray)
+			w.doc.SetFont(false, 7)
+			label := strconv.Itoa(day)
+			w.doc.Text(x+slot/2-w.doc.TextWidth(label)/2, baseline+10, label)
+		}
+	}
+
+	w.y = baseline + 22
+}
+
+func renderExpenses(w *statementWriter, statement *MonthlyStatement) {
+	w.heading("Expenses")
+
+	if len(statement.Expenses) == 0 {
+		emptyLine(w, "No expenses this month")
+		return
+	}
+
+	location, err := time.LoadLocation(statement.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	dateX := margin
+	titleX := margin + 60
+	categoryX := margin + 250
+	paymentMethodX := margin + 360
+	amountX := PageWidth - margin
+
+	header := []headerCell{{dateX, "Date", false}, {titleX, "Title", false}, {categoryX, "Category", false}, {paymentMethodX, "Payment method", false}, {amountX, "Amount", true}}
+	tableHeader(w, header)
+
+	expenses := append([]*store.Expense{}, statement.Expenses...)
+	sort.SliceStable(expenses, func(i, j int) bool {
+		return expenses[i].ExpenseDate < expenses[j].ExpenseDate
+	})
+
+	for i, expense := range expenses {
+		if w.ensureSpace(14) {
+			tableHeader(w, header)
+		}
+
+		if i%2 == 1 {
+			w.doc.SetFillColor(Color{0.97, 0.97, 0.97})
+			w.doc.Rect(margin, w.y-9, contentWidth, 13, true)
+		}
+
+		categoryName := ""
+		if category, ok := statement.Related.Categories[expense.CategoryID]; ok {
+			categoryName = category.Name
+		}
+
+		paymentMethodName := ""
+		if paymentMethod, ok := statement.Related.PaymentMethods[expense.PaymentMethodID]; ok {
+			paymentMethodName = paymentMethod.Name
+		}
+
+		w.doc.SetFillColor(black)
+		w.doc.SetFont(false, 8)
+		w.doc.Text(dateX, w.y, formatExpenseDate(expense.ExpenseDate, location))
+		w.doc.TextFit(titleX, w.y, categoryX-titleX-8, expense.Title)
+		w.doc.TextFit(categoryX, w.y, paymentMethodX-categoryX-8, categoryName)
+		w.doc.TextFit(paymentMethodX, w.y, amountX-paymentMethodX-70, paymentMethodName)
+		w.doc.TextRight(amountX, w.y, formatMoney(expense.Amount))
+		w.y += 13
+	}
+
+	w.ensureSpace(20)
+	w.y += 4
+	w.doc.SetStrokeColor(gray)
+	w.doc.Line(margin, w.y-9, PageWidth-margin, w.y-9, 0.5)
+	w.doc.SetFillColor(black)
+	w.doc.SetFont(true, 9)
+	w.doc.Text(dateX, w.y+2, "Total")
+	w.doc.TextRight(amountX, w.y+2, formatMoney(statement.Meta.TotalAmount))
+	w.y += 16
+}
+
+type headerCell struct {
+	x          float64
+	label      string
+	alignRight bool
+}
+
+func tableHeader(w *statementWriter, cells []headerCell) {
+	w.doc.SetFillColor(gray)
+	w.doc.SetFont(true, 8)
+	for _, cell := range cells {
+		if cell.alignRight {
+			w.doc.TextRight(cell.x, w.y, cell.label)
+		} else {
+			w.doc.Text(cell.x, w.y, cell.label)
+		}
+	}
+	w.y += 14
+}
+
+func bar(w *statementWriter, x, width, ratio float64, color Color) {
+	w.doc.SetFillColor(lightGray)
+	w.doc.Rect(x, w.y-7, width, 7, true)
+	if ratio > 0 {
+		w.doc.SetFillColor(color)
+		w.doc.Rect(x, w.y-7, width*min(ratio, 1), 7, true)
+	}
+}
+
+func emptyLine(w *statementWriter, text string) {
+	w.doc.SetFillColor(gray)
+	w.doc.SetFont(false, 9)
+	w.doc.Text(margin, w.y, text)
+	w.y += 14
+}
+
+// formatExpenseDate shows the local calendar date of an expense. Dates that
+// cannot be parsed are shown as stored.
+func formatExpenseDate(value string, location *time.Location) string {
+	date, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		if len(value) >= 10 {
+			return value[:10]
+		}
+		return value
+	}
+	return date.In(location).Format("02 Jan")
+}
+
+// formatMoney formats an amount with Indian digit grouping, 12,34,567.89.
+func formatMoney(amount float64) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	text := strconv.FormatFloat(amount, 'f', 2, 64)
+	whole, fraction, _ := strings.Cut(text, ".")
+
+	if len(whole) > 3 {
+		head, tail := whole[:len(whole)-3], whole[len(whole)-3:]
+		groups := []string{}
+		for len(head) > 2 {
+			groups = append([]string{head[len(head)-2:]}, groups...)
+			head = head[:len(head)-2]
+		}
+		groups = append([]string{head}, groups...)
+		whole = strings.Join(groups, ",") + "," + tail
+	}
+
+	return sign + whole + "." + fraction
+}
@@ -3,6 +3,8 @@ package routes
 import (
 	"cha-ching-server/internal/app"
 	"cha-ching-server/internal/config"
+	"cha-ching-server/internal/middleware"
+	"cha-ching-server/internal/store"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
@@ -15,7 +17,7 @@ func RegisterRoutes(app *app.Application, cfg *config.Config) *chi.Mux {
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   cfg.Client.AllowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", middleware.LedgerHeader},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300,
@@ -27,6 +29,9 @@ func RegisterRoutes(app *app.Application, cfg *config.Config) *chi.Mux {
 	// User endpoints
 	r.Post("/users", app.UserHandler.HandleCreateUser)
 
+	// Starter template endpoints, listed before signup so one can be picked
+	r.Get("/templates", app.TemplateHandler.HandleGetTemplates)
+
 	// Token endpoints
 	r.Post("/tokens/authenticate", app.TokenHandler.HandleCreateToken)
 
@@ -37,25 +42,165 @@ func RegisterRoutes(app *app.Application, cfg *config.Config) *chi.Mux {
 		// Current user endpoint
 		r.Get("/users/current", app.UserHandler.HandleGetUser)
 
+		// Digest email preference endpoints
+		r.Get("/users/current/digest", app.DigestHandler.HandleGetDigestPreference)
+		r.Put("/users/current/digest", app.DigestHandler.HandleSetDigestPreference)
+
+		// Inbox endpoints
+		r.Get("/inbox", app.InboxHandler.HandleGetInboxItems)
+		r.Put("/inbox/{id}/read", app.InboxHandler.HandleMarkInboxItemRead)
+
+		// Live updates for every ledger of the user
+		r.Get("/events", app.EventHandler.HandleGetEvents)
+
+		// Webhook subscription endpoints
+		r.Get("/webhooks", app.WebhookHandler.HandleGetWebhooks)
+		r.Post("/webhooks", app.WebhookHandler.HandleCreateWebhook)
+		r.Put("/webhooks/{id}", app.WebhookHandler.HandleUpdateWebhook)
+		r.Delete("/webhooks/{id}", app.WebhookHandler.HandleDeleteWebhook)
+		r.Get("/webhooks/{id}/deliveries", app.WebhookHandler.HandleGetWebhookDeliveries)
+		r.Post("/webhooks/{id}/deliveries/{deliveryID}/redeliver", app.WebhookHandler.HandleRedeliverWebhook)
+
+		// Ledger endpoints
+		r.Post("/ledgers", app.LedgerHandler.HandleCreateLedger)
+		r.Get("/ledgers", app.LedgerHandler.HandleGetAllLedgers)
+		r.Post("/invites/accept", app.LedgerHandler.HandleAcceptLedgerInvite)
+
+		// Ledger scoped endpoints, active ledger chosen by the X-Ledger-ID header
+		r.Group(func(r chi.Router) {
+			r.Use(app.LedgerMiddleware.ResolveLedger)
+			registerLedgerRoutes(r, app)
+		})
+
+		// Ledger scoped endpoints, active ledger chosen by path
+		r.Route("/ledgers/{ledgerID}", func(r chi.Router) {
+			r.Use(app.LedgerMiddleware.ResolveLedger)
+
+			r.With(app.LedgerMiddleware.RequireLedgerRole(store.LedgerRoleViewer)).Get("/members", app.LedgerHandler.HandleGetLedgerMembers)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.LedgerMiddleware.RequireLedgerRole(store.LedgerRoleOwner))
+
+				r.Put("/members/{userID}", app.LedgerHandler.HandleUpdateLedgerMember)
+				r.Delete("/members/{userID}", app.LedgerHandler.HandleDeleteLedgerMember)
+				r.Post("/invites", app.LedgerHandler.HandleCreateLedgerInvite)
+			})
+
+			registerLedgerRoutes(r, app)
+		})
+	})
+
+	return r
+}
+
+// registerLedgerRoutes mounts the endpoints that operate within the active
+// ledger. Reads need at least viewer access, writes need editor access.
+func registerLedgerRoutes(r chi.Router, app *app.Application) {
+	r.Group(func(r chi.Router) {
+		r.Use(app.LedgerMiddleware.RequireLedgerRole(store.LedgerRoleViewer))
+
 		// Category endpoints
-		r.Post("/categories", app.CategoryHandler.HandleCreateCategory)
 		r.Get("/categories", app.CategoryHandler.HandleGetAllCategories)
+		r.Get("/categories/tree", app.CategoryHandler.HandleGetCategoryTree)
 		r.Get("/categories/stats", app.CategoryHandler.HandleGetCategoryStats)
-		r.Put("/categories", app.CategoryHandler.HandleUpdateCategory)
+
+		// Budget endpoints
+		r.Get("/budgets", app.BudgetHandler.HandleGetAllBudgets)
+		r.Get("/budgets/overall", app.BudgetGroupHandler.HandleGetMonthlyLimit)
+		r.Get("/budgets/status", app.BudgetGroupHandler.HandleGetBudgetStatus)
+		r.Get("/budgets/alerts", app.BudgetAlertHandler.HandleGetBudgetAlerts)
+		r.Get("/budgets/alerts/thresholds", app.BudgetAlertHandler.HandleGetAlertThresholds)
+
+		// Budget group endpoints
+		r.Get("/budget-groups", app.BudgetGroupHandler.HandleGetAllBudgetGroups)
+
+		// Envelope endpoints
+		r.Get("/envelopes", app.EnvelopeHandler.HandleGetEnvelopes)
+		r.Get("/envelopes/allocations", app.EnvelopeHandler.HandleGetAllocations)
 
 		// Payment method endpoints
-		r.Post("/payment-methods", app.PaymentMethodHandler.HandleCreatePaymentMethod)
 		r.Get("/payment-methods", app.PaymentMethodHandler.HandleGetAllPaymentMethods)
 		r.Get("/payment-methods/stats", app.PaymentMethodHandler.HandleGetPaymentMethodStats)
+		r.Get("/payment-methods/{id}/statements", app.StatementHandler.HandleGetStatements)
+
+		// Goal endpoints
+		r.Get("/goals", app.GoalHandler.HandleGetAllGoals)
+		r.Get("/goals/stats", app.GoalHandler.HandleGetGoalsSummary)
+		r.Get("/goals/{id}/contributions", app.GoalHandler.HandleGetContributions)
 
 		// Expense endpoints
-		r.Post("/expenses", app.ExpenseHandler.HandleCreateExpense)
-		r.Put("/expenses/{id}", app.ExpenseHandler.HandleUpdateExpense)
 		r.Get("/expenses", app.ExpenseHandler.HandleGetAllExpenses)
 		r.Get("/expenses/stats/total-per-day", app.ExpenseHandler.HandleGetExpensesTotalPerDay)
+		r.Get("/expenses/stats/time-series", app.ExpenseHandler.HandleGetExpensesTimeSeries)
+		r.Get("/expenses/stats/heatmap", app.ExpenseHandler.HandleGetExpensesHeatmap)
 		r.Get("/expenses/search", app.ExpenseHandler.HandleSearchExpensesByTitle)
 
+		// Stats endpoints
+		r.Get("/stats/compare", app.ComparisonHandler.HandleGetComparison)
+		r.Get("/stats/forecast", app.ForecastHandler.HandleGetForecast)
+		r.Get("/stats/anomalies", app.AnomalyHandler.HandleGetAnomalies)
+
+		// Report endpoints
+		r.Get("/reports/category-payment-method", app.ReportHandler.HandleGetCategoryPaymentMethodPivot)
+		r.Get("/reports/query", app.ReportHandler.HandleBuildReport)
+		r.Get("/reports/tax", app.TaxHandler.HandleGetTaxReport)
+		r.Get("/reports/monthly.pdf", app.ReportHandler.HandleGetMonthlyPDF)
+
+		// Offline sync endpoints
+		r.Get("/sync", app.SyncHandler.HandlePullChanges)
+
+		// GraphQL endpoint, mutations check for editor access themselves
+		r.Get("/graphql", app.GraphQLHandler.HandleGraphQL)
+		r.Post("/graphql", app.GraphQLHandler.HandleGraphQL)
 	})
 
-	return r
+	r.Group(func(r chi.Router) {
+		r.Use(app.LedgerMiddleware.RequireLedgerRole(store.LedgerRoleEditor))
+
+		// Category endpoints
+		r.Post("/categories", app.CategoryHandler.HandleCreateCategory)
+		r.Put("/categories", app.CategoryHandler.HandleUpdateCategory)
+		r.Put("/categories/{id}/archive", app.CategoryHandler.HandleArchiveCategory)
+		r.Delete("/categories/{id}", app.CategoryHandler.HandleDeleteCategory)
+		r.Post("/categories/{id}/merge", app.CategoryHandler.HandleMergeCategory)
+
+		// Budget endpoints
+		r.Put("/budgets", app.BudgetHandler.HandleSetBudget)
+		r.Delete("/budgets/{id}", app.BudgetHandler.HandleDeleteBudget)
+		r.Put("/budgets/overall", app.BudgetGroupHandler.HandleSetMonthlyLimit)
+		r.Put("/budgets/alerts/thresholds", app.BudgetAlertHandler.HandleSetAlertThresholds)
+
+		// Budget group endpoints
+		r.Post("/budget-groups", app.BudgetGroupHandler.HandleCreateBudgetGroup)
+		r.Put("/budget-groups/{id}", app.BudgetGroupHandler.HandleUpdateBudgetGroup)
+		r.Delete("/budget-groups/{id}", app.BudgetGroupHandler.HandleDeleteBudgetGroup)
+
+		// Envelope endpoints
+		r.Put("/categories/{id}/envelope", app.EnvelopeHandler.HandleUpdateEnvelopeSettings)
+		r.Post("/envelopes/transfers", app.EnvelopeHandler.HandleMoveMoney)
+
+		// Payment method endpoints
+		r.Post("/payment-methods", app.PaymentMethodHandler.HandleCreatePaymentMethod)
+		r.Put("/payment-methods/{id}", app.PaymentMethodHandler.HandleUpdatePaymentMethod)
+		r.Put("/payment-methods/{id}/archive", app.PaymentMethodHandler.HandleArchivePaymentMethod)
+		r.Delete("/payment-methods/{id}", app.PaymentMethodHandler.HandleDeletePaymentMethod)
+		r.Post("/payment-methods/{id}/payments", app.StatementHandler.HandleRecordCardPayment)
+
+		// Starter template endpoints
+		r.Post("/templates/{key}/apply", app.TemplateHandler.HandleApplyTemplate)
+
+		// Goal endpoints
+		r.Post("/goals", app.GoalHandler.HandleCreateGoal)
+		r.Put("/goals/{id}", app.GoalHandler.HandleUpdateGoal)
+		r.Delete("/goals/{id}", app.GoalHandler.HandleDeleteGoal)
+		r.Post("/goals/{id}/contributions", app.GoalHandler.HandleAddContribution)
+		r.Delete("/goals/{id}/contributions/{contributionID}", app.GoalHandler.HandleDeleteContribution)
+
+		// Expense endpoints
+		r.Post("/expenses", app.ExpenseHandler.HandleCreateExpense)
+		r.Put("/expenses/{id}", app.ExpenseHandler.HandleUpdateExpense)
+
+		// Offline sync endpoints
+		r.Post("/sync", app.SyncHandler.HandlePushMutations)
+	})
 }
@@ -0,0 +1,153 @@
+// Package smtptest runs a local SMTP sink for tests, in the spirit of
+// net/http/httptest. It accepts every message without authentication and
+// keeps it in memory.
+package smtptest
+
+import (
+	"cha-ching-server/internal/config"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Message is one delivered email: the envelope and the raw data as sent,
+// with line endings turned into "\n".
+type Message struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+type Server struct {
+	Host string
+	Port string
+
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// NewServer starts a sink on a random loopback port. It panics when it
+// cannot listen, as httptest.NewServer does.
+func NewServer() *Server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("smtptest: failed to listen: %v", err))
+	}
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	s := &Server{
+		Host:     host,
+		Port:     port,
+		listener: listener,
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// Config returns SMTP settings that send through the sink.
+func (s *Server) Config(from string) config.SMTPConfig {
+	return config.SMTPConfig{
+		Host: s.Host,
+		Port: s.Port,
+		From: from,
+	}
+}
+
+// Messages returns the messages delivered so far, oldest first.
+func (s *Server) Messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]*Message(nil), s.messages...)
+}
+
+// Close stops accepting connections and waits for open ones to finish.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) handle(conn *textproto.Conn) {
+	message := &Message{}
+
+	conn.PrintfLine("220 smtptest ready")
+
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			conn.PrintfLine("250-smtptest")
+			conn.PrintfLine("250 8BITMIME")
+		case "HELO", "NOOP":
+			conn.PrintfLine("250 ok")
+		case "RSET":
+			message = &Message{}
+			conn.PrintfLine("250 ok")
+		case "MAIL":
+			message.From = address(arg)
+			conn.PrintfLine("250 ok")
+		case "RCPT":
+			message.To = append(message.To, address(arg))
+			conn.PrintfLine("250 ok")
+		case "DATA":
+			conn.PrintfLine("354 end data with <CR><LF>.<CR><LF>")
+
+			message.Data, err = conn.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			s.mu.Lock()
+			s.messages = append(s.messages, message)
+			s.mu.Unlock()
+
+			message = &Message{}
+			conn.PrintfLine("250 queued")
+		case "QUIT":
+			conn.PrintfLine("221 bye")
+			return
+		default:
+			conn.PrintfLine("502 command not implemented")
+		}
+	}
+}
+
+// address pulls the mailbox out of "FROM:<a@b> BODY=8BITMIME".
+func address(arg string) string {
+	_, rest, _ := strings.Cut(arg, "<")
+	mailbox, _, _ := strings.Cut(rest, ">")
+	return mailbox
+}
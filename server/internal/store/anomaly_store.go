@@ -0,0 +1,499 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	AnomalyKindCategoryAmount = "category_amount"
+	AnomalyKindMerchantAmount = "merchant_amount"
+	AnomalyKindDayTotal       = "day_total"
+	AnomalyKindCategorySpike  = "category_spike"
+)
+
+// anomalyMinSamples is how many historical expenses a category or merchant
+// needs before its amounts are considered typical enough to compare against.
+const anomalyMinSamples = 5
+
+const (
+	defaultAnomalyRangeDays    = 30
+	defaultAnomalyLookbackDays = 180
+)
+
+// The day anomalies walk every day of the history and the range, so both
+// are capped at a little over a year.
+const (
+	maxAnomalyRangeDays    = 366
+	maxAnomalyLookbackDays = 366
+)
+
+var (
+	ErrInvalidAnomalyRange  = errors.New("start_date must not be after end_date")
+	ErrAnomalyRangeTooLong  = errors.New("the date range must be at most 366 days")
+	ErrAnomalyLookbackRange = errors.New("lookback_days must be at most 366")
+)
+
+// AnomalySensitivity holds the thresholds for one sensitivity level. Amount
+// and day outliers use a z-score; category spikes use a ratio against the
+// trailing average.
+type AnomalySensitivity struct {
+	ZScore     float64
+	SpikeRatio float64
+}
+
+var anomalySensitivities = map[string]AnomalySensitivity{
+	"low":    {ZScore: 3.0, SpikeRatio: 2.0},
+	"medium": {ZScore: 2.5, SpikeRatio: 1.5},
+	"high":   {ZScore: 2.0, SpikeRatio: 1.25},
+}
+
+func IsValidAnomalySensitivity(sensitivity string) bool {
+	_, ok := anomalySensitivities[sensitivity]
+	return ok
+}
+
+type AnomalyQueryParams struct {
+	StartDate    *string   `schema:"start_date"`
+	EndDate      *string   `schema:"end_date"`
+	Sensitivity  string    `schema:"sensitivity"`
+	LookbackDays *int      `schema:"lookback_days"`
+	Timezone     string    `schema:"-"`
+	Today        time.Time `schema:"-"`
+}
+
+type Anomaly struct {
+	Kind        string  `json:"kind"`
+	Date        string  `json:"date"`
+	ExpenseID   *int    `json:"expense_id,omitempty"`
+	CategoryID  *int    `json:"category_id,omitempty"`
+	Title       string  `json:"title,omitempty"`
+	Amount      float64 `json:"amount"`
+	Expected    float64 `json:"expected"`
+	Score       float64 `json:"score"`
+	Explanation string  `json:"explanation"`
+}
+
+type PostgresAnomalyStore struct {
+	db *sql.DB
+}
+
+func NewPostgresAnomalyStore(db *sql.DB) *PostgresAnomalyStore {
+	return &PostgresAnomalyStore{
+		db: db,
+	}
+}
+
+type AnomalyStore interface {
+	ListAnomalies(ledgerID int, queryParams AnomalyQueryParams) ([]*Anomaly, error)
+	CheckExpense(expense *Expense, timezone string, sensitivity string) ([]*Anomaly, error)
+}
+
+func (pg *PostgresAnomalyStore) ListAnomalies(ledgerID int, queryParams AnomalyQueryParams) ([]*Anomaly, error) {
+	anomalies := []*Anomaly{}
+
+	sensitivity, ok := anomalySensitivities[queryParams.Sensitivity]
+	if !ok {
+		sensitivity = anomalySensitivities["medium"]
+	}
+
+	timezone := queryParams.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	lookbackDays := defaultAnomalyLookbackDays
+	if queryParams.LookbackDays != nil && *queryParams.LookbackDays > 0 {
+		lookbackDays = *queryParams.LookbackDays
+	}
+
+	if lookbackDays > maxAnomalyLookbackDays {
+		return nil, ErrAnomalyLookbackRange
+	}
+
+	rangeEnd := queryParams.Today
+	if queryParams.EndDate != nil {
+		parsed, err := time.Parse(dateLayout, *queryParams.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		rangeEnd = parsed
+	}
+
+	rangeStart := rangeEnd.AddDate(0, 0, -(defaultAnomalyRangeDays - 1))
+	if queryParams.StartDate != nil {
+		parsed, err := time.Parse(dateLayout, *queryParams.StartDate)
+		if err != nil {
+			return nil, err
+		}
+		rangeStart = parsed
+	}
+
+	rangeDays := int(rangeEnd.Sub(rangeStart).Hours()/24) + 1
+	if rangeDays < 1 {
+		return nil, ErrInvalidAnomalyRange
+	}
+
+	if rangeDays > maxAnomalyRangeDays {
+		return nil, ErrAnomalyRangeTooLong
+	}
+
+	historyStart := rangeStart.AddDate(0, 0, -lookbackDays)
+
+	args := []any{
+		ledgerID,
+		timezone,
+		historyStart.Format(dateLayout),
+		rangeStart.Format(dateLayout),
+		rangeEnd.Format(dateLayout),
+	}
+
+	expenseAnomalies, err := pg.expenseAnomalies(args, sensitivity)
+	if err != nil {
+		return nil, err
+	}
+	anomalies = append(anomalies, expenseAnomalies...)
+
+	dayAnomalies, err := pg.dayAnomalies(args, sensitivity)
+	if err != nil {
+		return nil, err
+	}
+	anomalies = append(anomalies, dayAnomalies...)
+
+	spikeAnomalies, err := pg.categorySpikes(args, sensitivity, rangeEnd.Format(dateLayout), float64(rangeDays)/float64(lookbackDays))
+	if err != nil {
+		return nil, err
+	}
+	anomalies = append(anomalies, spikeAnomalies...)
+
+	return anomalies, nil
+}
+
+// expenseAnomalies flags expenses in the range whose amount sits far above
+// the history of their category or of the same title (merchant).
+func (pg *PostgresAnomalyStore) expenseAnomalies(args []any, sensitivity AnomalySensitivity) ([]*Anomaly, error) {
+	anomalies := []*Anomaly{}
+
+	query := `
+	WITH history AS (
+		SELECT e.category_id, LOWER(e.title) AS title_key, e.amount
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			e.expense_date >= ($3::date::timestamp AT TIME ZONE $2::text) AND
+			e.expense_date < ($4::date::timestamp AT TIME ZONE $2::text)
+	),
+	category_stats AS (
+		SELECT category_id, AVG(amount) AS mean, STDDEV_SAMP(amount) AS sd, COUNT(*) AS n
+		FROM history
+		GROUP BY category_id
+	),
+	merchant_stats AS (
+		SELECT title_key, AVG(amount) AS mean, STDDEV_SAMP(amount) AS sd, COUNT(*) AS n
+		FROM history
+		GROUP BY title_key
+	),
+	current_expenses AS (
+		SELECT
+			e.id,
+			e.title,
+			e.amount,
+			e.category_id,
+			TO_CHAR(e.expense_date AT TIME ZONE $2::text, 'YYYY-MM-DD') AS day
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			e.expense_date >= ($4::date::timestamp AT TIME ZONE $2::text) AND
+			e.expense_date < (($5::date + 1)::timestamp AT TIME ZONE $2::text)
+	)
+	SELECT $8::text AS kind, ce.id, ce.title, ce.amount, ce.day, ce.category_id, COALESCE(c.name, ''), cs.mean, cs.sd
+	FROM current_expenses ce
+	INNER JOIN category_stats cs ON cs.category_id = ce.category_id
+	LEFT JOIN categories c ON c.id = ce.category_id
+	WHERE cs.n >= $7 AND cs.sd > 0 AND (ce.amount - cs.mean) / cs.sd >= $6::float8
+	UNION ALL
+	SELECT $9::text AS kind, ce.id, ce.title, ce.amount, ce.day, ce.category_id, COALESCE(c.name, ''), ms.mean, ms.sd
+	FROM current_expenses ce
+	INNER JOIN merchant_stats ms ON ms.title_key = LOWER(ce.title)
+	LEFT JOIN categories c ON c.id = ce.category_id
+	WHERE ms.n >= $7 AND ms.sd > 0 AND (ce.amount - ms.mean) / ms.sd >= $6::float8
+	ORDER BY day DESC, id DESC
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queryArgs := append(append([]any{}, args...), sensitivity.ZScore, anomalyMinSamples, AnomalyKindCategoryAmount, AnomalyKindMerchantAmount)
+	rows, err := pg.db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		anomaly, err := scanExpenseAnomaly(rows)
+		if err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
+
+func scanExpenseAnomaly(rows *sql.Rows) (*Anomaly, error) {
+	var anomaly Anomaly
+	var expenseID int
+	var categoryID sql.NullInt64
+	var categoryName string
+	var mean, sd float64
+
+	err := rows.Scan(
+		&anomaly.Kind,
+		&expenseID,
+		&anomaly.Title,
+		&anomaly.Amount,
+		&anomaly.Date,
+		&categoryID,
+		&categoryName,
+		&mean,
+		&sd,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	anomaly.ExpenseID = &expenseID
+	if categoryID.Valid {
+		id := int(categoryID.Int64)
+		anomaly.CategoryID = &id
+	}
+
+	anomaly.Expected = roundAmount(mean)
+	anomaly.Score = roundAmount((anomaly.Amount - mean) / sd)
+
+	switch anomaly.Kind {
+	case AnomalyKindCategoryAmount:
+		anomaly.Explanation = fmt.Sprintf("%q for %.2f is %.1f standard deviations above the typical %s expense of %.2f", anomaly.Title, anomaly.Amount, anomaly.Score, categoryName, anomaly.Expected)
+	case AnomalyKindMerchantAmount:
+		anomaly.Explanation = fmt.Sprintf("%q for %.2f is %.1f standard deviations above its usual amount of %.2f", anomaly.Title, anomaly.Amount, anomaly.Score, anomaly.Expected)
+	}
+
+	return &anomaly, nil
+}
+
+// dayAnomalies flags days whose total is an outlier against the zero-filled
+// daily totals of the history window.
+func (pg *PostgresAnomalyStore) dayAnomalies(args []any, sensitivity AnomalySensitivity) ([]*Anomaly, error) {
+	anomalies := []*Anomaly{}
+
+	query := `
+	WITH days AS (
+		SELECT GENERATE_SERIES($3::date, $5::date, INTERVAL '1 day')::date AS day
+	),
+	daily AS (
+		SELECT d.day, COALESCE(SUM(e.amount), 0) AS total_amount
+		FROM days d
+		LEFT JOIN expenses e
+		ON e.ledger_id = $1
+			AND (e.expense_date AT TIME ZONE $2::text)::date = d.day
+		GROUP BY d.day
+	),
+	stats AS (
+		SELECT AVG(total_amount) AS mean, STDDEV_SAMP(total_amount) AS sd
+		FROM daily
+		WHERE day < $4::date
+	)
+	SELECT TO_CHAR(d.day, 'YYYY-MM-DD'), d.total_amount, s.mean, s.sd
+	FROM daily d
+	CROSS JOIN stats s
+	WHERE d.day >= $4::date AND s.sd > 0 AND (d.total_amount - s.mean) / s.sd >= $6::float8
+	ORDER BY d.day DESC
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queryArgs := append(append([]any{}, args...), sensitivity.ZScore)
+	rows, err := pg.db.QueryContext(ctx, query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		anomaly := Anomaly{Kind: AnomalyKindDayTotal}
+		var mean, sd float64
+
+		err := rows.Scan(&anomaly.Date, &anomaly.Amount, &mean, &sd)
+		if err != nil {
+			return nil, err
+		}
+
+		anomaly.Expected = roundAmount(mean)
+		anomaly.Score = roundAmount((anomaly.Amount - mean) / sd)
+		anomaly.Explanation = fmt.Sprintf("Spending of %.2f on %s is %.1f standard deviations above the daily average of %.2f", anomaly.Amount, anomaly.Date, anomaly.Score, anomaly.Expected)
+
+		anomalies = append(anomalies, &anomaly)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
+
+// categorySpikes flags categories whose spend in the range exceeds their
+// trailing average for a window of the same length by the spike ratio.
+func (pg *PostgresAnomalyStore) categorySpikes(args []any, sensitivity AnomalySensitivity, rangeEnd string, windowShare float64) ([]*Anomaly, error) {
+	anomalies := []*Anomaly{}
+
+	query := `
+	SELECT
+		c.id,
+		c.name,
+		COALESCE(SUM(e.amount) FILTER (WHERE e.expense_date >= ($4::date::timestamp AT TIME ZONE $2::text)), 0) AS current_amount,
+		COALESCE(SUM(e.amount) FILTER (WHERE e.expense_date < ($4::date::timestamp AT TIME ZONE $2::text)), 0) AS history_amount
+	FROM categories c
+	LEFT JOIN expenses e
+	ON c.id = e.category_id
+		AND e.ledger_id = $1
+		AND e.expense_date >= ($3::date::timestamp AT TIME ZONE $2::text)
+		AND e.expense_date < (($5::date + 1)::timestamp AT TIME ZONE $2::text)
+	WHERE
+		c.ledger_id = $1
+	GROUP BY c.id, c.name
+	ORDER BY c.id
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var categoryID int
+		var categoryName string
+		var currentAmount, historyAmount float64
+
+		err := rows.Scan(&categoryID, &categoryName, &currentAmount, &historyAmount)
+		if err != nil {
+			return nil, err
+		}
+
+		trailingAverage := historyAmount * windowShare
+		if trailingAverage <= 0 || currentAmount < trailingAverage*sensitivity.SpikeRatio {
+			continue
+		}
+
+		anomaly := &Anomaly{
+			Kind:       AnomalyKindCategorySpike,
+			Date:       rangeEnd,
+			CategoryID: &categoryID,
+			Amount:     roundAmount(currentAmount),
+			Expected:   roundAmount(trailingAverage),
+			Score:      roundAmount(currentAmount / trailingAverage),
+		}
+		anomaly.Explanation = fmt.Sprintf("%s spending of %.2f is %.1fx its trailing average of %.2f", categoryName, anomaly.Amount, anomaly.Score, anomaly.Expected)
+
+		anomalies = append(anomalies, anomaly)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
+
+// CheckExpense compares a single expense against the history of its category
+// and title before its date. It is used to warn when an expense is created.
+func (pg *PostgresAnomalyStore) CheckExpense(expense *Expense, timezone string, sensitivity string) ([]*Anomaly, error) {
+	anomalies := []*Anomaly{}
+
+	thresholds, ok := anomalySensitivities[sensitivity]
+	if !ok {
+		thresholds = anomalySensitivities["medium"]
+	}
+
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	query := `
+	WITH history AS (
+		SELECT e.category_id, LOWER(e.title) AS title_key, e.amount
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			e.id <> $2 AND
+			e.expense_date < $3::timestamptz AND
+			e.expense_date >= $3::timestamptz - ($4::int * INTERVAL '1 day')
+	)
+	SELECT $8::text AS kind, $2::bigint, $5::text, $6::float8, TO_CHAR($3::timestamptz AT TIME ZONE $11::text, 'YYYY-MM-DD'), c.id, COALESCE(c.name, ''), AVG(h.amount), STDDEV_SAMP(h.amount)
+	FROM history h
+	LEFT JOIN categories c ON c.id = h.category_id
+	WHERE h.category_id = $7
+	GROUP BY c.id, c.name
+	HAVING COUNT(*) >= $9 AND STDDEV_SAMP(h.amount) > 0 AND ($6::float8 - AVG(h.amount)) / STDDEV_SAMP(h.amount) >= $10::float8
+	UNION ALL
+	SELECT $12::text AS kind, $2::bigint, $5::text, $6::float8, TO_CHAR($3::timestamptz AT TIME ZONE $11::text, 'YYYY-MM-DD'), $7::bigint, '', AVG(h.amount), STDDEV_SAMP(h.amount)
+	FROM history h
+	WHERE h.title_key = LOWER($5::text)
+	HAVING COUNT(*) >= $9 AND STDDEV_SAMP(h.amount) > 0 AND ($6::float8 - AVG(h.amount)) / STDDEV_SAMP(h.amount) >= $10::float8
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(
+		ctx,
+		query,
+		expense.LedgerID,
+		expense.ID,
+		expense.ExpenseDate,
+		defaultAnomalyLookbackDays,
+		expense.Title,
+		expense.Amount,
+		expense.CategoryID,
+		AnomalyKindCategoryAmount,
+		anomalyMinSamples,
+		thresholds.ZScore,
+		timezone,
+		AnomalyKindMerchantAmount,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		anomaly, err := scanExpenseAnomaly(rows)
+		if err != nil {
+			return nil, err
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return anomalies, nil
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestListAnomaliesRejectsRanges(t *testing.T) {
+	date := func(value string) *string { return &value }
+	days := func(value int) *int { return &value }
+	today := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		queryParams AnomalyQueryParams
+		want        error
+	}{
+		{"start after end", AnomalyQueryParams{StartDate: date("2026-10-02"), EndDate: date("2026-10-01")}, ErrInvalidAnomalyRange},
+		{"start after today", AnomalyQueryParams{StartDate: date("2026-11-01")}, ErrInvalidAnomalyRange},
+		{"range too long", AnomalyQueryParams{StartDate: date("2000-01-01"), EndDate: date("2026-10-01")}, ErrAnomalyRangeTooLong},
+		{"lookback too long", AnomalyQueryParams{LookbackDays: days(100000)}, ErrAnomalyLookbackRange},
+	}
+
+	// The checks run before any query, so the store needs no database
+	pg := &PostgresAnomalyStore{}
+	for _, test := range tests {
+		test.queryParams.Today = today
+		_, err := pg.ListAnomalies(1, test.queryParams)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: ListAnomalies error = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
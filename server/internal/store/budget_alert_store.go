@@ -0,0 +1,428 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultAlertThresholds apply to any category, and to the overall monthly
+// limit, that has no thresholds configured.
+var DefaultAlertThresholds = []int{80, 100}
+
+type AlertThresholds struct {
+	CategoryID *int  `json:"category_id"`
+	Thresholds []int `json:"thresholds"`
+	LedgerID   int   `json:"-"`
+}
+
+type BudgetAlert struct {
+	ID           int     `json:"id"`
+	CategoryID   *int    `json:"category_id"`
+	CategoryName string  `json:"category_name"`
+	Threshold    int     `json:"threshold"`
+	PeriodStart  string  `json:"period_start"`
+	Budget       float64 `json:"budget"`
+	Spent        float64 `json:"spent"`
+	CreatedAt    string  `json:"created_at"`
+	LedgerID     int     `json:"-"`
+}
+
+type BudgetAlertQueryParams struct {
+	CategoryID *int `schema:"category_id"`
+	Limit      *int `schema:"limit"`
+}
+
+type PostgresBudgetAlertStore struct {
+	db *sql.DB
+}
+
+func NewPostgresBudgetAlertStore(db *sql.DB) *PostgresBudgetAlertStore {
+	return &PostgresBudgetAlertStore{
+		db: db,
+	}
+}
+
+type BudgetAlertStore interface {
+	ListAlertThresholds(ledgerID int) ([]*AlertThresholds, error)
+	SetAlertThresholds(thresholds *AlertThresholds) (*AlertThresholds, error)
+	ListBudgetAlerts(ledgerID int, queryParams BudgetAlertQueryParams) ([]*BudgetAlert, error)
+	EvaluateBudgetAlerts(expense *Expense, timezone string) ([]*BudgetAlert, error)
+}
+
+func parseThresholds(value string) ([]int, error) {
+	thresholds := []int{}
+	if value == "" {
+		return thresholds, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		threshold, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, threshold)
+	}
+
+	return thresholds, nil
+}
+
+func (pg *PostgresBudgetAlertStore) ListAlertThresholds(ledgerID int) ([]*AlertThresholds, error) {
+	settings := []*AlertThresholds{}
+
+	query := `
+		SELECT s.category_id, ARRAY_TO_STRING(s.thresholds, ',')
+		FROM budget_alert_settings s
+		WHERE s.ledger_id = $1
+		ORDER BY s.category_id NULLS FIRST`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		setting := &AlertThresholds{LedgerID: ledgerID}
+		var categoryID sql.NullInt64
+		var thresholds string
+		err := rows.Scan(&categoryID, &thresholds)
+		if err != nil {
+			return nil, err
+		}
+
+		if categoryID.Valid {
+			id := int(categoryID.Int64)
+			setting.CategoryID = &id
+		}
+
+		setting.Thresholds, err = parseThresholds(thresholds)
+		if err != nil {
+			return nil, err
+		}
+
+		settings = append(settings, setting)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// SetAlertThresholds replaces the thresholds of a category, or of the overall
+// monthly limit when no category is given.
+func (pg *PostgresBudgetAlertStore) SetAlertThresholds(thresholds *AlertThresholds) (*AlertThresholds, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if thresholds.CategoryID != nil {
+		var exists bool
+		err := pg.db.QueryRowContext(
+			ctx,
+			`SELECT EXISTS (SELECT 1 FROM categories c WHERE c.id = $1 AND c.ledger_id = $2)`,
+			*thresholds.CategoryID,
+			thresholds.LedgerID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, ErrCategoryNotFound
+		}
+	}
+
+	sort.Ints(thresholds.Thresholds)
+	values := make([]string, 0, len(thresholds.Thresholds))
+	for _, threshold := range thresholds.Thresholds {
+		values = append(values, strconv.Itoa(threshold))
+	}
+
+	query := `
+		INSERT INTO budget_alert_settings (ledger_id, category_id, thresholds)
+		VALUES ($1, $2, STRING_TO_ARRAY($3::text, ',')::int[])
+		ON CONFLICT (ledger_id, (COALESCE(category_id, 0)))
+		DO UPDATE SET thresholds = EXCLUDED.thresholds, updated_at = CURRENT_TIMESTAMP`
+
+	_, err := pg.db.ExecContext(ctx, query, thresholds.LedgerID, thresholds.CategoryID, strings.Join(values, ","))
+	if err != nil {
+		return nil, err
+	}
+
+	return thresholds, nil
+}
+
+func (pg *PostgresBudgetAlertStore) ListBudgetAlerts(ledgerID int, queryParams BudgetAlertQueryParams) ([]*BudgetAlert, error) {
+	alerts := []*BudgetAlert{}
+
+	limit := 50
+	if queryParams.Limit != nil && *queryParams.Limit > 0 {
+		limit = *queryParams.Limit
+	}
+
+	query := `
+		SELECT
+			a.id,
+			a.category_id,
+			COALESCE(c.name, 'Overall'),
+			a.threshold,
+			TO_CHAR(a.period_start, 'YYYY-MM-DD'),
+			a.budget,
+			a.spent,
+			TO_CHAR(a.created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')
+		FROM budget_alerts a
+		LEFT JOIN categories c ON c.id = a.category_id
+		WHERE a.ledger_id = $1 AND ($2::int IS NULL OR a.category_id = $2)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $3`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, queryParams.CategoryID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		alert := &BudgetAlert{LedgerID: ledgerID}
+		var categoryID sql.NullInt64
+		err := rows.Scan(
+			&alert.ID,
+			&categoryID,
+			&alert.CategoryName,
+			&alert.Threshold,
+			&alert.PeriodStart,
+			&alert.Budget,
+			&alert.Spent,
+			&alert.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if categoryID.Valid {
+			id := int(categoryID.Int64)
+			alert.CategoryID = &id
+		}
+
+		alerts = append(alerts, alert)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// thresholdsFor returns the configured thresholds of a scope, falling back to
+// DefaultAlertThresholds.
+func (pg *PostgresBudgetAlertStore) thresholdsFor(ctx context.Context, ledgerID int, categoryID *int) ([]int, error) {
+	var thresholds string
+
+	query := `
+		SELECT ARRAY_TO_STRING(s.thresholds, ',')
+		FROM budget_alert_settings s
+		WHERE s.ledger_id = $1 AND COALESCE(s.category_id, 0) = COALESCE($2::bigint, 0)`
+
+	err := pg.db.QueryRowContext(ctx, query, ledgerID, categoryID).Scan(&thresholds)
+	if err == sql.ErrNoRows {
+		return DefaultAlertThresholds, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parseThresholds(thresholds)
+}
+
+// spentBetween totals the expenses of a ledger, or of one category, between
+// two local dates, start inclusive and end exclusive.
+func (pg *PostgresBudgetAlertStore) spentBetween(ctx context.Context, ledgerID int, categoryID *int, start time.Time, end time.Time, timezone string) (float64, error) {
+	var spent float64
+
+	query := `
+		SELECT COALESCE(SUM(e.amount), 0)
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			($2::bigint IS NULL OR e.category_id = $2) AND
+			e.expense_date >= ($3::date::timestamp AT TIME ZONE $5::text) AND
+			e.expense_date < ($4::date::timestamp AT TIME ZONE $5::text)`
+
+	err := pg.db.QueryRowContext(ctx, query, ledgerID, categoryID, start.Format(dateLayout), end.Format(dateLayout), timezone).Scan(&spent)
+	return spent, err
+}
+
+// recordCrossedThresholds stores an alert for every threshold that spent has
+// reached. Thresholds already recorded for the period are skipped, so only
+// newly crossed ones are returned.
+func (pg *PostgresBudgetAlertStore) recordCrossedThresholds(ctx context.Context, alert BudgetAlert, thresholds []int) ([]*BudgetAlert, error) {
+	alerts := []*BudgetAlert{}
+
+	if alert.Budget <= 0 {
+		return alerts, nil
+	}
+
+	query := `
+		INSERT INTO budget_alerts (ledger_id, category_id, threshold, period_start, budget, spent)
+		VALUES ($1, $2, $3, $4::date, $5, $6)
+		ON CONFLICT (ledger_id, (COALESCE(category_id, 0)), threshold, period_start) DO NOTHING
+		RETURNING id, TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')`
+
+	for _, threshold := range thresholds {
+		if alert.Spent < alert.Budget*float64(threshold)/100 {
+			continue
+		}
+
+		crossed := alert
+		crossed.Threshold = threshold
+
+		err := pg.db.QueryRowContext(
+			ctx,
+			query,
+			crossed.LedgerID,
+			crossed.CategoryID,
+			crossed.Threshold,
+			crossed.PeriodStart,
+			crossed.Budget,
+			crossed.Spent,
+		).Scan(&crossed.ID, &crossed.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		alerts = append(alerts, &crossed)
+	}
+
+	return alerts, nil
+}
+
+// EvaluateBudgetAlerts checks the budget period that contains the expense,
+// both for its category and for the overall monthly limit, and returns the
+// thresholds it newly crossed.
+func (pg *PostgresBudgetAlertStore) EvaluateBudgetAlerts(expense *Expense, timezone string) ([]*BudgetAlert, error) {
+	alerts := []*BudgetAlert{}
+
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var localDate string
+	err := pg.db.QueryRowContext(
+		ctx,
+		`SELECT TO_CHAR($1::timestamptz AT TIME ZONE $2::text, 'YYYY-MM-DD')`,
+		expense.ExpenseDate,
+		timezone,
+	).Scan(&localDate)
+	if err != nil {
+		return nil, err
+	}
+
+	date, err := time.Parse(dateLayout, localDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if expense.CategoryID != 0 {
+		var name, cadence string
+		err := pg.db.QueryRowContext(
+			ctx,
+			`SELECT c.name, c.budget_cadence FROM categories c WHERE c.id = $1 AND c.ledger_id = $2`,
+			expense.CategoryID,
+			expense.LedgerID,
+		).Scan(&name, &cadence)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		if err == nil {
+			budgets, err := loadLedgerBudgets(ctx, pg.db, expense.LedgerID)
+			if err != nil {
+				return nil, err
+			}
+
+			categoryID := expense.CategoryID
+			periodStart := BudgetPeriodStart(cadence, date)
+
+			spent, err := pg.spentBetween(ctx, expense.LedgerID, &categoryID, periodStart, nextBudgetPeriod(cadence, periodStart), timezone)
+			if err != nil {
+				return nil, err
+			}
+
+			thresholds, err := pg.thresholdsFor(ctx, expense.LedgerID, &categoryID)
+			if err != nil {
+				return nil, err
+			}
+
+			crossed, err := pg.recordCrossedThresholds(ctx, BudgetAlert{
+				CategoryID:   &categoryID,
+				CategoryName: name,
+				PeriodStart:  periodStart.Format(dateLayout),
+				Budget:       budgets[categoryID].amountFor(cadence, periodStart),
+				Spent:        roundAmount(spent),
+				LedgerID:     expense.LedgerID,
+			}, thresholds)
+			if err != nil {
+				return nil, err
+			}
+
+			alerts = append(alerts, crossed...)
+		}
+	}
+
+	var monthlyLimit sql.NullFloat64
+	err = pg.db.QueryRowContext(ctx, `SELECT l.monthly_limit FROM ledgers l WHERE l.id = $1`, expense.LedgerID).Scan(&monthlyLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if monthlyLimit.Valid {
+		periodStart := BudgetPeriodStart(BudgetCadenceMonthly, date)
+
+		spent, err := pg.spentBetween(ctx, expense.LedgerID, nil, periodStart, nextBudgetPeriod(BudgetCadenceMonthly, periodStart), timezone)
+		if err != nil {
+			return nil, err
+		}
+
+		thresholds, err := pg.thresholdsFor(ctx, expense.LedgerID, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		crossed, err := pg.recordCrossedThresholds(ctx, BudgetAlert{
+			CategoryName: "Overall",
+			PeriodStart:  periodStart.Format(dateLayout),
+			Budget:       monthlyLimit.Float64,
+			Spent:        roundAmount(spent),
+			LedgerID:     expense.LedgerID,
+		}, thresholds)
+		if err != nil {
+			return nil, err
+		}
+
+		alerts = append(alerts, crossed...)
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,414 @@
+package store
+
+import (
+	"cha-ching-server/internal/utils"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type BudgetGroup struct {
+	ID          int     `json:"id"`
+	Name        string  `json:"name"`
+	Amount      float64 `json:"amount"`
+	CategoryIDs []int   `json:"category_ids"`
+	LedgerID    int     `json:"-"`
+}
+
+type BudgetStatusQueryParams struct {
+	StartDate *string   `schema:"start_date"`
+	EndDate   *string   `schema:"end_date"`
+	Today     time.Time `schema:"-"`
+}
+
+type BudgetStatus struct {
+	ID          int      `json:"id,omitempty"`
+	Name        string   `json:"name"`
+	CategoryIDs []int    `json:"category_ids,omitempty"`
+	Limit       float64  `json:"limit"`
+	Spent       float64  `json:"spent"`
+	Remaining   float64  `json:"remaining"`
+	PercentUsed *float64 `json:"percent_used"`
+}
+
+type BudgetStatusReport struct {
+	StartDate string          `json:"start_date"`
+	EndDate   string          `json:"end_date"`
+	DaysLeft  int             `json:"days_left"`
+	Overall   *BudgetStatus   `json:"overall"`
+	Groups    []*BudgetStatus `json:"groups"`
+}
+
+// monthlyLimitForRange prorates a fixed monthly limit over start..end using
+// the same period rules as category budgets.
+func monthlyLimitForRange(amount float64, start time.Time, end time.Time) float64 {
+	limit := categoryBudgets{{Cadence: BudgetCadenceMonthly, PeriodStart: "0001-01-01", Amount: amount, CarryForward: true}}
+	return limit.proratedAmount(BudgetCadenceMonthly, start, end)
+}
+
+func newBudgetStatus(name string, limit float64, spent float64) *BudgetStatus {
+	status := &BudgetStatus{
+		Name:      name,
+		Limit:     limit,
+		Spent:     roundAmount(spent),
+		Remaining: roundAmount(limit - spent),
+	}
+
+	if limit > 0 {
+		percent := roundAmount(spent / limit * 100)
+		status.PercentUsed = &percent
+	}
+
+	return status
+}
+
+type PostgresBudgetGroupStore struct {
+	db *sql.DB
+}
+
+func NewPostgresBudgetGroupStore(db *sql.DB) *PostgresBudgetGroupStore {
+	return &PostgresBudgetGroupStore{
+		db: db,
+	}
+}
+
+type BudgetGroupStore interface {
+	GetMonthlyLimit(ledgerID int) (*float64, error)
+	SetMonthlyLimit(ledgerID int, amount *float64) error
+	CreateBudgetGroup(group *BudgetGroup) (*BudgetGroup, error)
+	UpdateBudgetGroup(group *BudgetGroup) (*BudgetGroup, error)
+	ListBudgetGroups(ledgerID int) ([]*BudgetGroup, error)
+	DeleteBudgetGroup(ledgerID int, id int64) (bool, error)
+	BudgetStatus(ledgerID int, queryParams BudgetStatusQueryParams) (*BudgetStatusReport, error)
+}
+
+func (pg *PostgresBudgetGroupStore) GetMonthlyLimit(ledgerID int) (*float64, error) {
+	var limit sql.NullFloat64
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pg.db.QueryRowContext(ctx, `SELECT l.monthly_limit FROM ledgers l WHERE l.id = $1`, ledgerID).Scan(&limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if !limit.Valid {
+		return nil, nil
+	}
+
+	return &limit.Float64, nil
+}
+
+// SetMonthlyLimit sets the overall monthly limit of a ledger. A nil amount
+// removes the limit.
+func (pg *PostgresBudgetGroupStore) SetMonthlyLimit(ledgerID int, amount *float64) error {
+	query := `
+		UPDATE ledgers
+		SET monthly_limit = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := pg.db.ExecContext(ctx, query, amount, ledgerID)
+	return err
+}
+
+// setBudgetGroupCategories replaces the categories of a group. Every category
+// must belong to the group's ledger.
+func setBudgetGroupCategories(ctx context.Context, tx *sql.Tx, group *BudgetGroup) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM budget_group_categories WHERE group_id = $1`, group.ID)
+	if err != nil {
+		return err
+	}
+
+	categoryIDs := []int64{}
+	seen := make(map[int]bool)
+	for _, id := range group.CategoryIDs {
+		if !seen[id] {
+			seen[id] = true
+			categoryIDs = append(categoryIDs, int64(id))
+		}
+	}
+
+	query := `
+		INSERT INTO budget_group_categories (group_id, category_id)
+		SELECT $1, c.id
+		FROM categories c
+		WHERE c.ledger_id = $2 AND c.id = ANY($3::bigint[])`
+
+	result, err := tx.ExecContext(ctx, query, group.ID, group.LedgerID, categoryIDs)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if int(inserted) != len(categoryIDs) {
+		return ErrCategoryNotFound
+	}
+
+	group.CategoryIDs = []int{}
+	for _, id := range categoryIDs {
+		group.CategoryIDs = append(group.CategoryIDs, int(id))
+	}
+
+	return nil
+}
+
+func (pg *PostgresBudgetGroupStore) CreateBudgetGroup(group *BudgetGroup) (*BudgetGroup, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO budget_groups (ledger_id, name, amount)
+		    VALUES ($1, $2, $3)
+		RETURNING id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, group.LedgerID, group.Name, group.Amount).Scan(&group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setBudgetGroupCategories(ctx, tx, group)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+func (pg *PostgresBudgetGroupStore) UpdateBudgetGroup(group *BudgetGroup) (*BudgetGroup, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+	UPDATE budget_groups
+	SET name = $1, amount = $2, updated_at = CURRENT_TIMESTAMP
+	WHERE id = $3 AND ledger_id = $4
+	RETURNING id
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = tx.QueryRowContext(ctx, query, group.Name, group.Amount, group.ID, group.LedgerID).Scan(&group.ID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = setBudgetGroupCategories(ctx, tx, group)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+func (pg *PostgresBudgetGroupStore) ListBudgetGroups(ledgerID int) ([]*BudgetGroup, error) {
+	groups := []*BudgetGroup{}
+
+	query := `
+		SELECT g.id, g.name, g.amount, gc.category_id
+		FROM budget_groups g
+		LEFT JOIN budget_group_categories gc ON gc.group_id = g.id
+		WHERE g.ledger_id = $1
+		ORDER BY g.id, gc.category_id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var group *BudgetGroup
+	for rows.Next() {
+		var id int
+		var name string
+		var amount float64
+		var categoryID sql.NullInt64
+		err := rows.Scan(&id, &name, &amount, &categoryID)
+		if err != nil {
+			return nil, err
+		}
+
+		if group == nil || group.ID != id {
+			group = &BudgetGroup{ID: id, Name: name, Amount: amount, CategoryIDs: []int{}, LedgerID: ledgerID}
+			groups = append(groups, group)
+		}
+
+		if categoryID.Valid {
+			group.CategoryIDs = append(group.CategoryIDs, int(categoryID.Int64))
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
+func (pg *PostgresBudgetGroupStore) DeleteBudgetGroup(ledgerID int, id int64) (bool, error) {
+	query := `
+		DELETE FROM budget_groups
+		WHERE id = $1 AND ledger_id = $2`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// BudgetStatus reports spending against the overall monthly limit and every
+// budget group. Without dates the current month is used. Spending is filtered
+// the same way as CategoryStats and monthly limits are prorated over the
+// range.
+func (pg *PostgresBudgetGroupStore) BudgetStatus(ledgerID int, queryParams BudgetStatusQueryParams) (*BudgetStatusReport, error) {
+	today := budgetDay(queryParams.Today)
+
+	end := nextBudgetPeriod(BudgetCadenceMonthly, BudgetPeriodStart(BudgetCadenceMonthly, today)).AddDate(0, 0, -1)
+	if queryParams.EndDate != nil {
+		parsed, err := time.Parse(dateLayout, *queryParams.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		end = parsed
+	}
+
+	start := BudgetPeriodStart(BudgetCadenceMonthly, end)
+	if queryParams.StartDate != nil {
+		parsed, err := time.Parse(dateLayout, *queryParams.StartDate)
+		if err != nil {
+			return nil, err
+		}
+		start = parsed
+	}
+
+	report := &BudgetStatusReport{
+		StartDate: start.Format(dateLayout),
+		EndDate:   end.Format(dateLayout),
+		Groups:    []*BudgetStatus{},
+	}
+
+	switch {
+	case today.After(end):
+		report.DaysLeft = 0
+	case today.Before(start):
+		report.DaysLeft = int(end.Sub(start).Hours()/24) + 1
+	default:
+		report.DaysLeft = int(end.Sub(today).Hours()/24) + 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+		SELECT e.category_id, SUM(e.amount)
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1
+			AND ($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata'))
+			AND ($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata'))
+		GROUP BY e.category_id`
+
+	startDate, endDate := utils.FormatStartEndDate(&report.StartDate, &report.EndDate)
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	totalSpent := 0.0
+	spentByCategory := make(map[int]float64)
+	for rows.Next() {
+		var categoryID sql.NullInt64
+		var amount float64
+		err := rows.Scan(&categoryID, &amount)
+		if err != nil {
+			return nil, err
+		}
+
+		totalSpent += amount
+		if categoryID.Valid {
+			spentByCategory[int(categoryID.Int64)] = amount
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	monthlyLimit, err := pg.GetMonthlyLimit(ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	if monthlyLimit != nil {
+		report.Overall = newBudgetStatus("Overall", monthlyLimitForRange(*monthlyLimit, start, end), totalSpent)
+	}
+
+	groups, err := pg.ListBudgetGroups(ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		spent := 0.0
+		for _, categoryID := range group.CategoryIDs {
+			spent += spentByCategory[categoryID]
+		}
+
+		status := newBudgetStatus(group.Name, monthlyLimitForRange(group.Amount, start, end), spent)
+		status.ID = group.ID
+		status.CategoryIDs = group.CategoryIDs
+		report.Groups = append(report.Groups, status)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,339 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	BudgetCadenceWeekly  = "weekly"
+	BudgetCadenceMonthly = "monthly"
+	BudgetCadenceYearly  = "yearly"
+)
+
+var ErrCategoryNotFound = errors.New("category does not exist in the ledger")
+
+func IsValidBudgetCadence(cadence string) bool {
+	switch cadence {
+	case BudgetCadenceWeekly, BudgetCadenceMonthly, BudgetCadenceYearly:
+		return true
+	}
+	return false
+}
+
+// BudgetPeriodStart returns the first day of the cadence period containing
+// date. Weeks start on Monday to match Postgres DATE_TRUNC('week').
+func BudgetPeriodStart(cadence string, date time.Time) time.Time {
+	date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	switch cadence {
+	case BudgetCadenceWeekly:
+		offset := (int(date.Weekday()) + 6) % 7
+		return date.AddDate(0, 0, -offset)
+	case BudgetCadenceYearly:
+		return time.Date(date.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+func nextBudgetPeriod(cadence string, periodStart time.Time) time.Time {
+	switch cadence {
+	case BudgetCadenceWeekly:
+		return periodStart.AddDate(0, 0, 7)
+	case BudgetCadenceYearly:
+		return periodStart.AddDate(1, 0, 0)
+	default:
+		return periodStart.AddDate(0, 1, 0)
+	}
+}
+
+// budgetToday is the date used to pick the current budget period when the
+// caller has no user timezone to pass, as in background jobs.
+func budgetToday() time.Time {
+	location, err := time.LoadLocation(DefaultTimezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	now := time.Now().In(location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// budgetDay returns today, falling back to budgetToday when it is unset.
+func budgetDay(today time.Time) time.Time {
+	if today.IsZero() {
+		return budgetToday()
+	}
+	return today
+}
+
+type Budget struct {
+	ID           int     `json:"id"`
+	CategoryID   int     `json:"category_id"`
+	Cadence      string  `json:"cadence"`
+	PeriodStart  string  `json:"period_start"`
+	Amount       float64 `json:"amount"`
+	CarryForward bool    `json:"carry_forward"`
+	LedgerID     int     `json:"-"`
+}
+
+type BudgetQueryParams struct {
+	CategoryID *int `schema:"category_id"`
+}
+
+// categoryBudgets is the budget history of one category, oldest first.
+type categoryBudgets []*Budget
+
+// amountFor resolves the budget for the period starting at periodStart: a
+// budget set for exactly that period wins, otherwise the latest earlier
+// budget that carries forward applies.
+func (cb categoryBudgets) amountFor(cadence string, periodStart time.Time) float64 {
+	key := periodStart.Format(dateLayout)
+	amount := 0.0
+
+	for _, budget := range cb {
+		if budget.Cadence != cadence {
+			continue
+		}
+		if budget.PeriodStart == key {
+			return budget.Amount
+		}
+		if budget.PeriodStart > key {
+			break
+		}
+		if budget.CarryForward {
+			amount = budget.Amount
+		}
+	}
+
+	return amount
+}
+
+// proratedAmount sums the budgets of every period overlapping start..end,
+// counting partially covered periods by their share of days.
+func (cb categoryBudgets) proratedAmount(cadence string, start time.Time, end time.Time) float64 {
+	total := 0.0
+
+	for periodStart := BudgetPeriodStart(cadence, start); !periodStart.After(end); periodStart = nextBudgetPeriod(cadence, periodStart) {
+		periodEnd := nextBudgetPeriod(cadence, periodStart).AddDate(0, 0, -1)
+		periodDays := periodEnd.Sub(periodStart).Hours()/24 + 1
+
+		overlapStart := periodStart
+		if start.After(overlapStart) {
+			overlapStart = start
+		}
+		overlapEnd := periodEnd
+		if end.Before(overlapEnd) {
+			overlapEnd = end
+		}
+		overlapDays := overlapEnd.Sub(overlapStart).Hours()/24 + 1
+
+		total += cb.amountFor(cadence, periodStart) * overlapDays / periodDays
+	}
+
+	return roundAmount(total)
+}
+
+// budgetForRange resolves the budget that applied to a stats range. Without
+// dates it is the budget of the period containing today.
+func (cb categoryBudgets) budgetForRange(cadence string, startDate *string, endDate *string, today time.Time) (float64, error) {
+	if startDate == nil && endDate == nil {
+		return cb.amountFor(cadence, BudgetPeriodStart(cadence, today)), nil
+	}
+
+	end := today
+	if endDate != nil {
+		parsed, err := time.Parse(dateLayout, *endDate)
+		if err != nil {
+			return 0, err
+		}
+		end = parsed
+	}
+
+	start := BudgetPeriodStart(cadence, end)
+	if startDate != nil {
+		parsed, err := time.Parse(dateLayout, *startDate)
+		if err != nil {
+			return 0, err
+		}
+		start = parsed
+	}
+
+	return cb.proratedAmount(cadence, start, end), nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadLedgerBudgets returns the budget history of every category in a ledger.
+func loadLedgerBudgets(ctx context.Context, db queryer, ledgerID int) (map[int]categoryBudgets, error) {
+	budgets := make(map[int]categoryBudgets)
+
+	query := `
+		SELECT b.id, b.category_id, b.cadence, TO_CHAR(b.period_start, 'YYYY-MM-DD'), b.amount, b.carry_forward
+		FROM budgets b
+		WHERE b.ledger_id = $1
+		ORDER BY b.category_id, b.period_start`
+
+	rows, err := db.QueryContext(ctx, query, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		budget := &Budget{LedgerID: ledgerID}
+		err := rows.Scan(&budget.ID, &budget.CategoryID, &budget.Cadence, &budget.PeriodStart, &budget.Amount, &budget.CarryForward)
+		if err != nil {
+			return nil, err
+		}
+		budgets[budget.CategoryID] = append(budgets[budget.CategoryID], budget)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return budgets, nil
+}
+
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// upsertBudget stores budget for its period, replacing any budget already
+// set for the same category, cadence and period.
+func upsertBudget(ctx context.Context, db execQueryer, budget *Budget) (*Budget, error) {
+	query := `
+		INSERT INTO budgets (ledger_id, category_id, cadence, period_start, amount, carry_forward)
+		SELECT c.ledger_id, c.id, $3, $4::date, $5, $6
+		FROM categories c
+		WHERE c.id = $1 AND c.ledger_id = $2
+		ON CONFLICT (category_id, cadence, period_start)
+		DO UPDATE SET amount = EXCLUDED.amount, carry_forward = EXCLUDED.carry_forward, updated_at = CURRENT_TIMESTAMP
+		RETURNING id`
+
+	err := db.QueryRowContext(
+		ctx,
+		query,
+		budget.CategoryID,
+		budget.LedgerID,
+		budget.Cadence,
+		budget.PeriodStart,
+		budget.Amount,
+		budget.CarryForward,
+	).Scan(&budget.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return budget, nil
+}
+
+type PostgresBudgetStore struct {
+	db *sql.DB
+}
+
+func NewPostgresBudgetStore(db *sql.DB) *PostgresBudgetStore {
+	return &PostgresBudgetStore{
+		db: db,
+	}
+}
+
+type BudgetStore interface {
+	SetBudget(budget *Budget) (*Budget, error)
+	ListBudgets(ledgerID int, queryParams BudgetQueryParams) ([]*Budget, error)
+	DeleteBudget(ledgerID int, id int64) (bool, error)
+}
+
+// SetBudget aligns the budget to the start of its period. When no cadence is
+// given the category's current cadence is used.
+func (pg *PostgresBudgetStore) SetBudget(budget *Budget) (*Budget, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if budget.Cadence == "" {
+		query := `SELECT c.budget_cadence FROM categories c WHERE c.id = $1 AND c.ledger_id = $2`
+		err := pg.db.QueryRowContext(ctx, query, budget.CategoryID, budget.LedgerID).Scan(&budget.Cadence)
+		if err == sql.ErrNoRows {
+			return nil, ErrCategoryNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	periodStart, err := time.Parse(dateLayout, budget.PeriodStart)
+	if err != nil {
+		return nil, err
+	}
+	budget.PeriodStart = BudgetPeriodStart(budget.Cadence, periodStart).Format(dateLayout)
+
+	return upsertBudget(ctx, pg.db, budget)
+}
+
+func (pg *PostgresBudgetStore) ListBudgets(ledgerID int, queryParams BudgetQueryParams) ([]*Budget, error) {
+	budgets := []*Budget{}
+
+	query := `
+		SELECT b.id, b.category_id, b.cadence, TO_CHAR(b.period_start, 'YYYY-MM-DD'), b.amount, b.carry_forward
+		FROM budgets b
+		WHERE b.ledger_id = $1 AND ($2::int IS NULL OR b.category_id = $2)
+		ORDER BY b.category_id, b.period_start DESC`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, queryParams.CategoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var budget Budget
+		err := rows.Scan(&budget.ID, &budget.CategoryID, &budget.Cadence, &budget.PeriodStart, &budget.Amount, &budget.CarryForward)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, &budget)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return budgets, nil
+}
+
+func (pg *PostgresBudgetStore) DeleteBudget(ledgerID int, id int64) (bool, error) {
+	query := `
+		DELETE FROM budgets
+		WHERE id = $1 AND ledger_id = $2`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
@@ -4,26 +4,57 @@ import (
 	"cha-ching-server/internal/utils"
 	"context"
 	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidParentCategory = errors.New("parent must be a category in the same ledger that is not the category itself or one of its descendants")
+	ErrInvalidTargetCategory = errors.New("target must be another category in the same ledger")
 )
 
 type Category struct {
-	ID     int     `json:"id"`
-	Name   string  `json:"name"`
-	Budget float64 `json:"budget"`
-	UserID int     `json:"-"`
+	ID            int     `json:"id"`
+	Name          string  `json:"name"`
+	ParentID      *int    `json:"parent_id"`
+	Budget        float64 `json:"budget"`
+	BudgetCadence string  `json:"budget_cadence"`
+	TaxSection    *string `json:"tax_section"`
+	Archived      bool    `json:"archived"`
+	UserID        int     `json:"-"`
+	LedgerID      int     `json:"-"`
+}
+
+type CategoryTreeNode struct {
+	*Category
+	Children []*CategoryTreeNode `json:"children"`
 }
 
+// CategoryStat holds the totals of expenses filed directly under a category
+// and, in the rolled up fields, the totals of the category and all of its
+// descendants.
 type CategoryStat struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Count       int     `json:"count"`
-	Budget      float64 `json:"budget"`
-	TotalAmount float64 `json:"total_amount"`
+	ID                  int     `json:"id"`
+	Name                string  `json:"name"`
+	ParentID            *int    `json:"parent_id"`
+	Count               int     `json:"count"`
+	Budget              float64 `json:"budget"`
+	BudgetCadence       string  `json:"budget_cadence"`
+	TotalAmount         float64 `json:"total_amount"`
+	RolledUpCount       int     `json:"rolled_up_count"`
+	RolledUpBudget      float64 `json:"rolled_up_budget"`
+	RolledUpTotalAmount float64 `json:"rolled_up_total_amount"`
+}
+
+type CategoryQueryParams struct {
+	IncludeArchived *bool     `schema:"include_archived"`
+	Today           time.Time `schema:"-"`
 }
 
 type CategoryStatQueryParams struct {
-	StartDate *string `schema:"start_date"`
-	EndDate   *string `schema:"end_date"`
+	StartDate *string   `schema:"start_date"`
+	EndDate   *string   `schema:"end_date"`
+	Today     time.Time `schema:"-"`
 }
 
 type PostgresCategoryStore struct {
@@ -37,13 +68,21 @@ func NewPostgresCategoryStore(db *sql.DB) *PostgresCategoryStore {
 }
 
 type CategoryStore interface {
-	CreateCategory(category *Category) (*Category, error)
-	UpdateCategory(category *Category) (*Category, error)
-	ListCategories(userID int) ([]*Category, error)
-	CategoryStats(userID int, queryParams CategoryStatQueryParams) ([]*CategoryStat, error)
+	CreateCategory(category *Category, today time.Time) (*Category, error)
+	UpdateCategory(update *CategoryUpdate, today time.Time) (*Category, error)
+	ListCategories(ledgerID int, queryParams CategoryQueryParams) ([]*Category, error)
+	ListCategoryTree(ledgerID int, queryParams CategoryQueryParams) ([]*CategoryTreeNode, error)
+	CategoryStats(ledgerID int, queryParams CategoryStatQueryParams) ([]*CategoryStat, error)
+	SetCategoryArchived(ledgerID int, id int64, archived bool) (bool, error)
+	DeleteCategory(ledgerID int, id int64, reassignTo int) (bool, error)
+	MergeCategories(ledgerID int, sourceID int64, targetID int) (bool, error)
 }
 
-func (pg *PostgresCategoryStore) CreateCategory(category *Category) (*Category, error) {
+func (pg *PostgresCategoryStore) CreateCategory(category *Category, today time.Time) (*Category, error) {
+	if category.BudgetCadence == "" {
+		category.BudgetCadence = BudgetCadenceMonthly
+	}
+
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return nil, err
@@ -51,24 +90,39 @@ func (pg *PostgresCategoryStore) CreateCategory(category *Category) (*Category,
 
 	defer tx.Rollback()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = checkParentCategory(ctx, tx, category)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
-		INSERT INTO categories (user_id, name, budget)
-		    VALUES ($1, $2, $3)
+		INSERT INTO categories (user_id, ledger_id, name, budget_cadence, parent_id, tax_section)
+		    VALUES ($1, $2, $3, $4, $5, $6)
 		RETURNING
 		    id`
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
 	err = tx.QueryRowContext(ctx, query,
 		category.UserID,
+		category.LedgerID,
 		category.Name,
-		category.Budget,
+		category.BudgetCadence,
+		category.ParentID,
+		category.TaxSection,
 	).Scan(&category.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	if category.Budget > 0 {
+		err = setCurrentBudget(ctx, tx, category, today)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -77,7 +131,25 @@ func (pg *PostgresCategoryStore) CreateCategory(category *Category) (*Category,
 	return category, nil
 }
 
-func (pg *PostgresCategoryStore) UpdateCategory(category *Category) (*Category, error) {
+// CategoryUpdate is a partial update of a category. Fields left nil, or not
+// Set, keep their current value, so a client that only renames a category
+// does not move it or reset its cadence and tax section. ParentID and
+// TaxSection are cleared by setting them to null.
+type CategoryUpdate struct {
+	ID            int                     `json:"id"`
+	Name          *string                 `json:"name"`
+	ParentID      utils.Optional[*int]    `json:"parent_id"`
+	BudgetCadence *string                 `json:"budget_cadence"`
+	TaxSection    utils.Optional[*string] `json:"tax_section"`
+	Budget        *float64                `json:"budget"`
+	LedgerID      int                     `json:"-"`
+}
+
+// UpdateCategory applies update to the category and returns it, or nil when
+// the ledger has no such category. A budget is set for the period containing
+// today only, leaving the budgets of earlier periods untouched. Without a
+// budget the category keeps the one already in effect.
+func (pg *PostgresCategoryStore) UpdateCategory(update *CategoryUpdate, today time.Time) (*Category, error) {
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return nil, err
@@ -85,51 +157,202 @@ func (pg *PostgresCategoryStore) UpdateCategory(category *Category) (*Category,
 
 	defer tx.Rollback()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if update.ParentID.Set {
+		err = lockCategoryTree(ctx, tx, update.LedgerID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	category, err := lockCategory(ctx, tx, update.LedgerID, update.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if category == nil {
+		return nil, nil
+	}
+
+	if update.Name != nil {
+		category.Name = *update.Name
+	}
+	if update.BudgetCadence != nil {
+		category.BudgetCadence = *update.BudgetCadence
+	}
+	if update.TaxSection.Set {
+		category.TaxSection = update.TaxSection.Value
+	}
+	if update.ParentID.Set {
+		category.ParentID = update.ParentID.Value
+
+		err = checkParentCategory(ctx, tx, category)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	query := `
 	UPDATE categories
-	SET	name=$1 , budget=$2
-	WHERE id=$3 AND user_id=$4
-	RETURNING id
+	SET	name=$1 , budget_cadence=$2, parent_id=$3, tax_section=$4
+	WHERE id=$5 AND ledger_id=$6
 	`
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
 
-	err = tx.QueryRowContext(
+	_, err = tx.ExecContext(
 		ctx,
 		query,
 		category.Name,
-		category.Budget,
+		category.BudgetCadence,
+		category.ParentID,
+		category.TaxSection,
 		category.ID,
-		category.UserID,
-	).Scan(&category.ID)
-	if err == sql.ErrNoRows {
-		return nil, nil
+		category.LedgerID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.Budget != nil {
+		category.Budget = *update.Budget
+		err = setCurrentBudget(ctx, tx, category, today)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		budgets, err := loadLedgerBudgets(ctx, tx, category.LedgerID)
+		if err != nil {
+			return nil, err
+		}
+		category.Budget = budgets[category.ID].amountFor(category.BudgetCadence, BudgetPeriodStart(category.BudgetCadence, budgetDay(today)))
 	}
 
+	err = tx.Commit()
 	if err != nil {
 		return nil, err
 	}
 
-	err = tx.Commit()
+	return category, nil
+}
+
+// lockCategory loads a category of the ledger for update, or nil when there
+// is none. Budget is left for the caller to fill in.
+func lockCategory(ctx context.Context, tx *sql.Tx, ledgerID int, id int) (*Category, error) {
+	category := &Category{LedgerID: ledgerID}
+
+	query := `
+		SELECT c.id, c.name, c.parent_id, c.budget_cadence, c.tax_section, c.archived_at IS NOT NULL, c.user_id
+		FROM categories c
+		WHERE c.id = $1 AND c.ledger_id = $2
+		FOR UPDATE`
+
+	var parentID sql.NullInt64
+	var taxSection sql.NullString
+
+	err := tx.QueryRowContext(ctx, query, id, ledgerID).Scan(
+		&category.ID,
+		&category.Name,
+		&parentID,
+		&category.BudgetCadence,
+		&taxSection,
+		&category.Archived,
+		&category.UserID,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
 	if err != nil {
 		return nil, err
 	}
 
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		category.ParentID = &id
+	}
+	if taxSection.Valid {
+		category.TaxSection = &taxSection.String
+	}
+
 	return category, nil
 }
 
-func (pg *PostgresCategoryStore) ListCategories(userID int) ([]*Category, error) {
+// lockCategoryTree serializes the changes to parent_id in a ledger until the
+// transaction ends. Without it two moves, such as A under B and B under A,
+// could each pass the cycle check and together create a cycle. It is taken
+// before any row is locked or written, as the sync triggers lock the ledger
+// on every write.
+func lockCategoryTree(ctx context.Context, tx *sql.Tx, ledgerID int) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext('category_tree'), $1::int)`, ledgerID)
+	return err
+}
+
+// checkParentCategory rejects a parent outside the category's ledger, and for
+// an existing category, a parent that would create a cycle. Moving an
+// existing category needs lockCategoryTree.
+func checkParentCategory(ctx context.Context, tx *sql.Tx, category *Category) error {
+	if category.ParentID == nil {
+		return nil
+	}
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1
+			FROM categories c
+			WHERE
+				c.id = $1 AND
+				c.ledger_id = $2 AND
+				c.id NOT IN (SELECT category_descendants($3))
+		)`
+
+	var valid bool
+	err := tx.QueryRowContext(ctx, query, *category.ParentID, category.LedgerID, category.ID).Scan(&valid)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		return ErrInvalidParentCategory
+	}
+
+	return nil
+}
+
+// setCurrentBudget stores category.Budget as a carry-forward budget starting
+// in the period of the category's cadence that contains today.
+func setCurrentBudget(ctx context.Context, tx *sql.Tx, category *Category, today time.Time) error {
+	_, err := upsertBudget(ctx, tx, &Budget{
+		CategoryID:   category.ID,
+		LedgerID:     category.LedgerID,
+		Cadence:      category.BudgetCadence,
+		PeriodStart:  BudgetPeriodStart(category.BudgetCadence, budgetDay(today)).Format(dateLayout),
+		Amount:       category.Budget,
+		CarryForward: true,
+	})
+	return err
+}
+
+// ListCategories hides archived categories unless they are asked for.
+func (pg *PostgresCategoryStore) ListCategories(ledgerID int, queryParams CategoryQueryParams) ([]*Category, error) {
 	categories := []*Category{}
+	today := budgetDay(queryParams.Today)
 
 	query := `
-		SELECT c.id, c.name, c.budget
+		SELECT c.id, c.name, c.parent_id, c.budget_cadence, c.tax_section, c.archived_at IS NOT NULL
 		FROM categories c
-		WHERE c.user_id = $1
+		WHERE c.ledger_id = $1 AND ($2::boolean IS TRUE OR c.archived_at IS NULL)
 		ORDER BY c.id`
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	rows, err := pg.db.QueryContext(ctx, query, userID)
+
+	budgets, err := loadLedgerBudgets(ctx, pg.db, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, queryParams.IncludeArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -138,10 +361,20 @@ func (pg *PostgresCategoryStore) ListCategories(userID int) ([]*Category, error)
 
 	for rows.Next() {
 		var category Category
-		err := rows.Scan(&category.ID, &category.Name, &category.Budget)
+		var parentID sql.NullInt64
+		var taxSection sql.NullString
+		err := rows.Scan(&category.ID, &category.Name, &parentID, &category.BudgetCadence, &taxSection, &category.Archived)
 		if err != nil {
 			return nil, err
 		}
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			category.ParentID = &id
+		}
+		if taxSection.Valid {
+			category.TaxSection = &taxSection.String
+		}
+		category.Budget = budgets[category.ID].amountFor(category.BudgetCadence, BudgetPeriodStart(category.BudgetCadence, today))
 		categories = append(categories, &category)
 	}
 
@@ -152,20 +385,21 @@ func (pg *PostgresCategoryStore) ListCategories(userID int) ([]*Category, error)
 	return categories, nil
 }
 
-func (pg *PostgresCategoryStore) CategoryStats(userID int, queryParams CategoryStatQueryParams) ([]*CategoryStat, error) {
+func (pg *PostgresCategoryStore) CategoryStats(ledgerID int, queryParams CategoryStatQueryParams) ([]*CategoryStat, error) {
 	categoryStats := []*CategoryStat{}
+	today := budgetDay(queryParams.Today)
 
 	query := `
-	SELECT c.id, c.name, c.budget, COALESCE(SUM(e.amount), 0) as total_amount, COUNT(e.id) as count
+	SELECT c.id, c.name, c.parent_id, c.budget_cadence, COALESCE(SUM(e.amount), 0) as total_amount, COUNT(e.id) as count
 	FROM categories c
 	LEFT JOIN expenses e 
 	ON c.id = e.category_id 
-		AND e.user_id = $1
+		AND e.ledger_id = $1
 		AND ($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata'))
 		AND ($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata'))
 	WHERE 
-		c.user_id = $1
-	GROUP BY c.id, c.name, c.budget
+		c.ledger_id = $1
+	GROUP BY c.id, c.name, c.parent_id, c.budget_cadence
 	ORDER BY c.id`
 
 	startDate, endDate := utils.FormatStartEndDate(queryParams.StartDate, queryParams.EndDate)
@@ -173,24 +407,45 @@ func (pg *PostgresCategoryStore) CategoryStats(userID int, queryParams CategoryS
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	rows, err := pg.db.QueryContext(ctx, query, userID, startDate, endDate)
+	budgets, err := loadLedgerBudgets(ctx, pg.db, ledgerID)
 	if err != nil {
 		return nil, err
 	}
 
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
 	for rows.Next() {
 		var categoryStat CategoryStat
+		var parentID sql.NullInt64
 
 		err := rows.Scan(
 			&categoryStat.ID,
 			&categoryStat.Name,
-			&categoryStat.Budget,
+			&parentID,
+			&categoryStat.BudgetCadence,
 			&categoryStat.TotalAmount,
 			&categoryStat.Count,
 		)
 		if err != nil {
 			return nil, err
 		}
+
+		if parentID.Valid {
+			id := int(parentID.Int64)
+			categoryStat.ParentID = &id
+		}
+
+		// Report the budget that applied to the queried range
+		categoryStat.Budget, err = budgets[categoryStat.ID].budgetForRange(categoryStat.BudgetCadence, queryParams.StartDate, queryParams.EndDate, today)
+		if err != nil {
+			return nil, err
+		}
+
 		categoryStats = append(categoryStats, &categoryStat)
 	}
 
@@ -199,5 +454,262 @@ func (pg *PostgresCategoryStore) CategoryStats(userID int, queryParams CategoryS
 		return nil, err
 	}
 
+	rollUpCategoryStats(categoryStats)
+
 	return categoryStats, nil
 }
+
+// rollUpCategoryStats adds the totals and budgets of every descendant to the
+// rolled up fields of each category.
+func rollUpCategoryStats(categoryStats []*CategoryStat) {
+	children := make(map[int][]*CategoryStat)
+	for _, categoryStat := range categoryStats {
+		if categoryStat.ParentID != nil {
+			children[*categoryStat.ParentID] = append(children[*categoryStat.ParentID], categoryStat)
+		}
+	}
+
+	var rollUp func(categoryStat *CategoryStat)
+	rollUp = func(categoryStat *CategoryStat) {
+		categoryStat.RolledUpCount = categoryStat.Count
+		categoryStat.RolledUpBudget = categoryStat.Budget
+		categoryStat.RolledUpTotalAmount = categoryStat.TotalAmount
+
+		for _, child := range children[categoryStat.ID] {
+			rollUp(child)
+			categoryStat.RolledUpCount += child.RolledUpCount
+			categoryStat.RolledUpBudget += child.RolledUpBudget
+			categoryStat.RolledUpTotalAmount += child.RolledUpTotalAmount
+		}
+
+		categoryStat.RolledUpBudget = roundAmount(categoryStat.RolledUpBudget)
+		categoryStat.RolledUpTotalAmount = roundAmount(categoryStat.RolledUpTotalAmount)
+	}
+
+	for _, categoryStat := range categoryStats {
+		if categoryStat.ParentID == nil {
+			rollUp(categoryStat)
+		}
+	}
+}
+
+// ListCategoryTree returns the categories of a ledger nested under their
+// parents, with top-level categories at the root.
+func (pg *PostgresCategoryStore) ListCategoryTree(ledgerID int, queryParams CategoryQueryParams) ([]*CategoryTreeNode, error) {
+	categories, err := pg.ListCategories(ledgerID, queryParams)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[int]*CategoryTreeNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryTreeNode{Category: category, Children: []*CategoryTreeNode{}}
+	}
+
+	tree := []*CategoryTreeNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if category.ParentID != nil {
+			if parent, ok := nodes[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		tree = append(tree, node)
+	}
+
+	return tree, nil
+}
+
+// SetCategoryArchived hides a category from pickers, or shows it again. Its
+// expenses, budgets and stats are kept.
+func (pg *PostgresCategoryStore) SetCategoryArchived(ledgerID int, id int64, archived bool) (bool, error) {
+	query := `
+		UPDATE categories
+		SET
+			archived_at = CASE WHEN $1::boolean THEN COALESCE(archived_at, CURRENT_TIMESTAMP) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND ledger_id = $3`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, archived, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// lockCategoryPair locks the source and target categories for the rest of the
+// transaction and returns the source's parent. It reports false when the
+// source does not exist and ErrInvalidTargetCategory for a bad target.
+func lockCategoryPair(ctx context.Context, tx *sql.Tx, ledgerID int, sourceID int64, targetID int) (sql.NullInt64, bool, error) {
+	var parentID sql.NullInt64
+
+	err := tx.QueryRowContext(
+		ctx,
+		`SELECT c.parent_id FROM categories c WHERE c.id = $1 AND c.ledger_id = $2 FOR UPDATE`,
+		sourceID,
+		ledgerID,
+	).Scan(&parentID)
+	if err == sql.ErrNoRows {
+		return parentID, false, nil
+	}
+
+	if err != nil {
+		return parentID, false, err
+	}
+
+	if int64(targetID) == sourceID {
+		return parentID, true, ErrInvalidTargetCategory
+	}
+
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT c.id FROM categories c WHERE c.id = $1 AND c.ledger_id = $2 FOR UPDATE`,
+		targetID,
+		ledgerID,
+	).Scan(&targetID)
+	if err == sql.ErrNoRows {
+		return parentID, true, ErrInvalidTargetCategory
+	}
+
+	if err != nil {
+		return parentID, true, err
+	}
+
+	return parentID, true, nil
+}
+
+// DeleteCategory moves every expense of a category to reassignTo and then
+// deletes it. Child categories move up to the deleted category's parent.
+func (pg *PostgresCategoryStore) DeleteCategory(ledgerID int, id int64, reassignTo int) (bool, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Children move up to the parent of the deleted category
+	err = lockCategoryTree(ctx, tx, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	parentID, found, err := lockCategoryPair(ctx, tx, ledgerID, id, reassignTo)
+	if !found || err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE expenses SET category_id = $1, updated_at = CURRENT_TIMESTAMP WHERE category_id = $2`, reassignTo, id)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE categories SET parent_id = $1, updated_at = CURRENT_TIMESTAMP WHERE parent_id = $2`, parentID, id)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM categories WHERE id = $1 AND ledger_id = $2`, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// MergeCategories folds the source category into the target: expenses,
+// envelope allocations and budget group memberships move over, budgets for
+// the same period are added together, and the source is deleted. The target
+// must not be a descendant of the source.
+func (pg *PostgresCategoryStore) MergeCategories(ledgerID int, sourceID int64, targetID int) (bool, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Children move under the target
+	err = lockCategoryTree(ctx, tx, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	_, found, err := lockCategoryPair(ctx, tx, ledgerID, sourceID, targetID)
+	if !found || err != nil {
+		return false, err
+	}
+
+	var isDescendant bool
+	err = tx.QueryRowContext(
+		ctx,
+		`SELECT $1::bigint IN (SELECT category_descendants($2))`,
+		targetID,
+		sourceID,
+	).Scan(&isDescendant)
+	if err != nil {
+		return false, err
+	}
+
+	if isDescendant {
+		return false, ErrInvalidTargetCategory
+	}
+
+	statements := []string{
+		`UPDATE expenses SET category_id = $1, updated_at = CURRENT_TIMESTAMP WHERE category_id = $2`,
+		`INSERT INTO budgets (ledger_id, category_id, cadence, period_start, amount, carry_forward)
+		SELECT b.ledger_id, $1, b.cadence, b.period_start, b.amount, b.carry_forward
+		FROM budgets b
+		WHERE b.category_id = $2
+		ON CONFLICT (category_id, cadence, period_start)
+		DO UPDATE SET amount = budgets.amount + EXCLUDED.amount, updated_at = CURRENT_TIMESTAMP`,
+		`UPDATE envelope_allocations SET category_id = $1 WHERE category_id = $2`,
+		`UPDATE envelope_allocations SET counterpart_category_id = $1 WHERE counterpart_category_id = $2`,
+		`INSERT INTO budget_group_categories (group_id, category_id)
+		SELECT gc.group_id, $1
+		FROM budget_group_categories gc
+		WHERE gc.category_id = $2
+		ON CONFLICT DO NOTHING`,
+		`UPDATE categories SET parent_id = $1, updated_at = CURRENT_TIMESTAMP WHERE parent_id = $2`,
+	}
+
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement, targetID, sourceID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM categories WHERE id = $1 AND ledger_id = $2`, sourceID, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,243 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+const topMoversLimit = 5
+
+var ErrInvalidComparisonPreset = errors.New("preset must be one of mom, yoy")
+
+type DateRange struct {
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+type ComparisonQueryParams struct {
+	Preset            *string `schema:"preset"`
+	Date              *string `schema:"date"`
+	StartDate         *string `schema:"start_date"`
+	EndDate           *string `schema:"end_date"`
+	PreviousStartDate *string `schema:"previous_start_date"`
+	PreviousEndDate   *string `schema:"previous_end_date"`
+}
+
+type PeriodTotals struct {
+	DateRange
+	TotalAmount float64 `json:"total_amount"`
+	TotalCount  int     `json:"total_count"`
+}
+
+type Delta struct {
+	Amount  float64  `json:"amount"`
+	Percent *float64 `json:"percent"`
+	Count   int      `json:"count"`
+}
+
+type ComparisonItem struct {
+	ID             int     `json:"id"`
+	Name           string  `json:"name"`
+	CurrentAmount  float64 `json:"current_amount"`
+	PreviousAmount float64 `json:"previous_amount"`
+	CurrentCount   int     `json:"current_count"`
+	PreviousCount  int     `json:"previous_count"`
+	Delta          Delta   `json:"delta"`
+}
+
+type TopMover struct {
+	Type string `json:"type"`
+	*ComparisonItem
+}
+
+type PeriodComparison struct {
+	Current        PeriodTotals      `json:"current"`
+	Previous       PeriodTotals      `json:"previous"`
+	Delta          Delta             `json:"delta"`
+	Categories     []*ComparisonItem `json:"categories"`
+	PaymentMethods []*ComparisonItem `json:"payment_methods"`
+	TopMovers      []*TopMover       `json:"top_movers"`
+}
+
+// ResolveComparisonPreset turns a preset into the pair of ranges it compares.
+// The current range runs from the start of anchor's month up to anchor, and
+// the previous range is the same span one month (mom) or one year (yoy) back.
+func ResolveComparisonPreset(preset string, anchor time.Time) (DateRange, DateRange, error) {
+	var months int
+	switch preset {
+	case "mom":
+		months = 1
+	case "yoy":
+		months = 12
+	default:
+		return DateRange{}, DateRange{}, ErrInvalidComparisonPreset
+	}
+
+	currentStart := time.Date(anchor.Year(), anchor.Month(), 1, 0, 0, 0, 0, time.UTC)
+	previousStart := currentStart.AddDate(0, -months, 0)
+
+	// Clamp the day so that e.g. Mar 31 maps to Feb 28 rather than Mar 3
+	previousEnd := previousStart.AddDate(0, 0, anchor.Day()-1)
+	previousMonthEnd := previousStart.AddDate(0, 1, -1)
+	if previousEnd.After(previousMonthEnd) {
+		previousEnd = previousMonthEnd
+	}
+
+	current := DateRange{StartDate: currentStart.Format(dateLayout), EndDate: anchor.Format(dateLayout)}
+	previous := DateRange{StartDate: previousStart.Format(dateLayout), EndDate: previousEnd.Format(dateLayout)}
+
+	return current, previous, nil
+}
+
+type PostgresComparisonStore struct {
+	expenseStore       *PostgresExpenseStore
+	categoryStore      *PostgresCategoryStore
+	paymentMethodStore *PostgresPaymentMethodStore
+}
+
+func NewPostgresComparisonStore(db *sql.DB) *PostgresComparisonStore {
+	return &PostgresComparisonStore{
+		expenseStore:       NewPostgresExpenseStore(db),
+		categoryStore:      NewPostgresCategoryStore(db),
+		paymentMethodStore: NewPostgresPaymentMethodStore(db),
+	}
+}
+
+type ComparisonStore interface {
+	ComparePeriods(ledgerID int, current DateRange, previous DateRange) (*PeriodComparison, error)
+}
+
+func (pg *PostgresComparisonStore) ComparePeriods(ledgerID int, current DateRange, previous DateRange) (*PeriodComparison, error) {
+	currentTotals, err := pg.periodTotals(ledgerID, current)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTotals, err := pg.periodTotals(ledgerID, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	currentCategories, err := pg.categoryStore.CategoryStats(ledgerID, CategoryStatQueryParams{StartDate: &current.StartDate, EndDate: &current.EndDate})
+	if err != nil {
+		return nil, err
+	}
+
+	previousCategories, err := pg.categoryStore.CategoryStats(ledgerID, CategoryStatQueryParams{StartDate: &previous.StartDate, EndDate: &previous.EndDate})
+	if err != nil {
+		return nil, err
+	}
+
+	currentPaymentMethods, err := pg.paymentMethodStore.PaymentMethodStats(ledgerID, PaymentMethodStatsQueryParams{StartDate: &current.StartDate, EndDate: &current.EndDate})
+	if err != nil {
+		return nil, err
+	}
+
+	previousPaymentMethods, err := pg.paymentMethodStore.PaymentMethodStats(ledgerID, PaymentMethodStatsQueryParams{StartDate: &previous.StartDate, EndDate: &previous.EndDate})
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []*ComparisonItem{}
+	previousCategoryByID := make(map[int]*CategoryStat)
+	for _, stat := range previousCategories {
+		previousCategoryByID[stat.ID] = stat
+	}
+	for _, stat := range currentCategories {
+		item := &ComparisonItem{ID: stat.ID, Name: stat.Name, CurrentAmount: stat.TotalAmount, CurrentCount: stat.Count}
+		if previousStat, ok := previousCategoryByID[stat.ID]; ok {
+			item.PreviousAmount = previousStat.TotalAmount
+			item.PreviousCount = previousStat.Count
+		}
+		item.Delta = computeDelta(item.CurrentAmount, item.PreviousAmount, item.CurrentCount, item.PreviousCount)
+		categories = append(categories, item)
+	}
+
+	paymentMethods := []*ComparisonItem{}
+	previousPaymentMethodByID := make(map[int]*PaymentMethodStats)
+	for _, stat := range previousPaymentMethods {
+		previousPaymentMethodByID[stat.ID] = stat
+	}
+	for _, stat := range currentPaymentMethods {
+		item := &ComparisonItem{ID: stat.ID, Name: stat.Name, CurrentAmount: stat.TotalAmount, CurrentCount: stat.Count}
+		if previousStat, ok := previousPaymentMethodByID[stat.ID]; ok {
+			item.PreviousAmount = previousStat.TotalAmount
+			item.PreviousCount = previousStat.Count
+		}
+		item.Delta = computeDelta(item.CurrentAmount, item.PreviousAmount, item.CurrentCount, item.PreviousCount)
+		paymentMethods = append(paymentMethods, item)
+	}
+
+	return &PeriodComparison{
+		Current:        *currentTotals,
+		Previous:       *previousTotals,
+		Delta:          computeDelta(currentTotals.TotalAmount, previousTotals.TotalAmount, currentTotals.TotalCount, previousTotals.TotalCount),
+		Categories:     categories,
+		PaymentMethods: paymentMethods,
+		TopMovers:      topMovers(categories, paymentMethods),
+	}, nil
+}
+
+func (pg *PostgresComparisonStore) periodTotals(ledgerID int, dateRange DateRange) (*PeriodTotals, error) {
+	metaItems, err := pg.expenseStore.GetExpenseMetaItems(ledgerID, ExpenseQueryParams{
+		StartDate: &dateRange.StartDate,
+		EndDate:   &dateRange.EndDate,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PeriodTotals{
+		DateRange:   dateRange,
+		TotalAmount: metaItems.TotalAmount,
+		TotalCount:  metaItems.TotalCount,
+	}, nil
+}
+
+// computeDelta leaves Percent nil when there is nothing to compare against.
+func computeDelta(currentAmount, previousAmount float64, currentCount, previousCount int) Delta {
+	delta := Delta{
+		Amount: roundAmount(currentAmount - previousAmount),
+		Count:  currentCount - previousCount,
+	}
+
+	if previousAmount != 0 {
+		percent := roundAmount((currentAmount - previousAmount) / previousAmount * 100)
+		delta.Percent = &percent
+	}
+
+	return delta
+}
+
+func topMovers(categories []*ComparisonItem, paymentMethods []*ComparisonItem) []*TopMover {
+	movers := []*TopMover{}
+	for _, item := range categories {
+		if item.Delta.Amount != 0 {
+			movers = append(movers, &TopMover{Type: "category", ComparisonItem: item})
+		}
+	}
+	for _, item := range paymentMethods {
+		if item.Delta.Amount != 0 {
+			movers = append(movers, &TopMover{Type: "payment_method", ComparisonItem: item})
+		}
+	}
+
+	sort.SliceStable(movers, func(i, j int) bool {
+		return math.Abs(movers[i].Delta.Amount) > math.Abs(movers[j].Delta.Amount)
+	})
+
+	if len(movers) > topMoversLimit {
+		movers = movers[:topMoversLimit]
+	}
+
+	return movers
+}
+
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
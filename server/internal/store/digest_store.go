@@ -0,0 +1,423 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+const (
+	DigestFrequencyWeekly  = "weekly"
+	DigestFrequencyMonthly = "monthly"
+)
+
+const digestListLimit = 5
+
+var ErrInvalidDigestLedger = errors.New("ledger_id must be a ledger you are a member of")
+
+func IsValidDigestFrequency(frequency string) bool {
+	return frequency == DigestFrequencyWeekly || frequency == DigestFrequencyMonthly
+}
+
+// DigestPeriods returns the last complete period before today and the one
+// before it. Weekly periods run Monday to Sunday, monthly periods are
+// calendar months.
+func DigestPeriods(frequency string, today time.Time) (DateRange, DateRange) {
+	cadence := BudgetCadenceMonthly
+	if frequency == DigestFrequencyWeekly {
+		cadence = BudgetCadenceWeekly
+	}
+
+	end := BudgetPeriodStart(cadence, today)
+	currentStart := BudgetPeriodStart(cadence, end.AddDate(0, 0, -1))
+	previousStart := BudgetPeriodStart(cadence, currentStart.AddDate(0, 0, -1))
+
+	current := DateRange{StartDate: currentStart.Format(dateLayout), EndDate: end.AddDate(0, 0, -1).Format(dateLayout)}
+	previous := DateRange{StartDate: previousStart.Format(dateLayout), EndDate: currentStart.AddDate(0, 0, -1).Format(dateLayout)}
+
+	return current, previous
+}
+
+type DigestPreference struct {
+	LedgerID        int     `json:"ledger_id"`
+	Frequency       string  `json:"frequency"`
+	Enabled         bool    `json:"enabled"`
+	LastPeriodStart *string `json:"last_period_start"`
+	LastSentAt      *string `json:"last_sent_at"`
+	UserID          int     `json:"-"`
+}
+
+// DigestSubscriber is an enabled digest preference together with what is
+// needed to address and schedule the email.
+type DigestSubscriber struct {
+	DigestPreference
+	Name       string
+	Email      string
+	Timezone   string
+	LedgerName string
+}
+
+type DigestCategory struct {
+	ID          int      `json:"id"`
+	Name        string   `json:"name"`
+	TotalAmount float64  `json:"total_amount"`
+	Budget      float64  `json:"budget"`
+	PercentUsed *float64 `json:"percent_used"`
+}
+
+type DigestExpense struct {
+	ID           int     `json:"id"`
+	Title        string  `json:"title"`
+	Amount       float64 `json:"amount"`
+	ExpenseDate  string  `json:"expense_date"`
+	CategoryName string  `json:"category_name"`
+}
+
+// Digest summarizes one period of a ledger against the period before it.
+type Digest struct {
+	Current         PeriodTotals      `json:"current"`
+	Previous        PeriodTotals      `json:"previous"`
+	Delta           Delta             `json:"delta"`
+	TopCategories   []*DigestCategory `json:"top_categories"`
+	BiggestExpenses []*DigestExpense  `json:"biggest_expenses"`
+}
+
+type PostgresDigestStore struct {
+	db              *sql.DB
+	comparisonStore *PostgresComparisonStore
+	categoryStore   *PostgresCategoryStore
+}
+
+func NewPostgresDigestStore(db *sql.DB) *PostgresDigestStore {
+	return &PostgresDigestStore{
+		db:              db,
+		comparisonStore: NewPostgresComparisonStore(db),
+		categoryStore:   NewPostgresCategoryStore(db),
+	}
+}
+
+type DigestStore interface {
+	GetDigestPreference(userID int) (*DigestPreference, error)
+	SetDigestPreference(preference *DigestPreference) (*DigestPreference, error)
+	ListDigestSubscribers() ([]*DigestSubscriber, error)
+	BuildDigest(ledgerID int, current DateRange, previous DateRange) (*Digest, error)
+	ClaimDigest(userID int, periodStart string, lease time.Duration) (bool, error)
+	ReleaseDigest(userID int) error
+	MarkDigestSent(userID int, periodStart string) error
+}
+
+func scanDigestPreference(row interface{ Scan(...any) error }, preference *DigestPreference, extra ...any) error {
+	var lastPeriodStart, lastSentAt sql.NullString
+
+	dest := append([]any{
+		&preference.UserID,
+		&preference.LedgerID,
+		&preference.Frequency,
+		&preference.Enabled,
+		&lastPeriodStart,
+		&lastSentAt,
+	}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	if lastPeriodStart.Valid {
+		preference.LastPeriodStart = &lastPeriodStart.String
+	}
+	if lastSentAt.Valid {
+		preference.LastSentAt = &lastSentAt.String
+	}
+
+	return nil
+}
+
+const digestPreferenceColumns = `
+	d.user_id, d.ledger_id, d.frequency, d.enabled,
+	TO_CHAR(d.last_period_start, 'YYYY-MM-DD'),
+	TO_CHAR(d.last_sent_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')`
+
+// GetDigestPreference returns nil when the user never opted in.
+func (pg *PostgresDigestStore) GetDigestPreference(userID int) (*DigestPreference, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	SELECT` + digestPreferenceColumns + `
+	FROM digest_preferences d
+	WHERE d.user_id = $1`
+
+	var preference DigestPreference
+	err := scanDigestPreference(pg.db.QueryRowContext(ctx, query, userID), &preference)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &preference, nil
+}
+
+// SetDigestPreference creates or replaces the preference of a user. The
+// ledger must be one the user is a member of.
+func (pg *PostgresDigestStore) SetDigestPreference(preference *DigestPreference) (*DigestPreference, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	WITH upserted AS (
+		INSERT INTO digest_preferences (user_id, ledger_id, frequency, enabled)
+		SELECT lm.user_id, lm.ledger_id, $3, $4
+		FROM ledger_members lm
+		WHERE lm.user_id = $1 AND lm.ledger_id = $2
+		ON CONFLICT (user_id) DO UPDATE SET
+			ledger_id = EXCLUDED.ledger_id,
+			frequency = EXCLUDED.frequency,
+			enabled = EXCLUDED.enabled,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING *
+	)
+	SELECT` + digestPreferenceColumns + `
+	FROM upserted d`
+
+	var saved DigestPreference
+	err := scanDigestPreference(
+		pg.db.QueryRowContext(ctx, query, preference.UserID, preference.LedgerID, preference.Frequency, preference.Enabled),
+		&saved,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidDigestLedger
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+// ListDigestSubscribers returns every enabled preference whose user is still
+// a member of the chosen ledger.
+func (pg *PostgresDigestStore) ListDigestSubscribers() ([]*DigestSubscriber, error) {
+	subscribers := []*DigestSubscriber{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	SELECT` + digestPreferenceColumns + `, u.name, u.email, u.timezone, l.name
+	FROM digest_preferences d
+	JOIN users u ON u.id = d.user_id
+	JOIN ledgers l ON l.id = d.ledger_id
+	JOIN ledger_members lm ON lm.ledger_id = d.ledger_id AND lm.user_id = d.user_id
+	WHERE d.enabled
+	ORDER BY d.user_id`
+
+	rows, err := pg.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var subscriber DigestSubscriber
+
+		err := scanDigestPreference(
+			rows,
+			&subscriber.DigestPreference,
+			&subscriber.Name,
+			&subscriber.Email,
+			&subscriber.Timezone,
+			&subscriber.LedgerName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		subscribers = append(subscribers, &subscriber)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return subscribers, nil
+}
+
+func (pg *PostgresDigestStore) BuildDigest(ledgerID int, current DateRange, previous DateRange) (*Digest, error) {
+	currentTotals, err := pg.comparisonStore.periodTotals(ledgerID, current)
+	if err != nil {
+		return nil, err
+	}
+
+	previousTotals, err := pg.comparisonStore.periodTotals(ledgerID, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := &Digest{
+		Current:  *currentTotals,
+		Previous: *previousTotals,
+		Delta:    computeDelta(currentTotals.TotalAmount, previousTotals.TotalAmount, currentTotals.TotalCount, previousTotals.TotalCount),
+	}
+
+	digest.TopCategories, err = pg.topCategories(ledgerID, current)
+	if err != nil {
+		return nil, err
+	}
+
+	digest.BiggestExpenses, err = pg.biggestExpenses(ledgerID, current)
+	if err != nil {
+		return nil, err
+	}
+
+	return digest, nil
+}
+
+// topCategories ranks the top level categories by their rolled up spend, so
+// a subcategory counts towards its parent's budget.
+func (pg *PostgresDigestStore) topCategories(ledgerID int, dateRange DateRange) ([]*DigestCategory, error) {
+	stats, err := pg.categoryStore.CategoryStats(ledgerID, CategoryStatQueryParams{StartDate: &dateRange.StartDate, EndDate: &dateRange.EndDate})
+	if err != nil {
+		return nil, err
+	}
+
+	categories := []*DigestCategory{}
+	for _, stat := range stats {
+		if stat.ParentID != nil || stat.RolledUpTotalAmount == 0 {
+			continue
+		}
+
+		category := &DigestCategory{
+			ID:          stat.ID,
+			Name:        stat.Name,
+			TotalAmount: roundAmount(stat.RolledUpTotalAmount),
+			Budget:      roundAmount(stat.RolledUpBudget),
+		}
+		if category.Budget > 0 {
+			percent := roundAmount(category.TotalAmount / category.Budget * 100)
+			category.PercentUsed = &percent
+		}
+
+		categories = append(categories, category)
+	}
+
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i].TotalAmount > categories[j].TotalAmount
+	})
+
+	if len(categories) > digestListLimit {
+		categories = categories[:digestListLimit]
+	}
+
+	return categories, nil
+}
+
+func (pg *PostgresDigestStore) biggestExpenses(ledgerID int, dateRange DateRange) ([]*DigestExpense, error) {
+	expenses := []*DigestExpense{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	SELECT e.id, e.title, e.amount, TO_CHAR(e.expense_date AT TIME ZONE 'Asia/Kolkata', 'YYYY-MM-DD'), c.name
+	FROM expenses e
+	JOIN categories c ON c.id = e.category_id
+	WHERE
+		e.ledger_id = $1
+		AND e.expense_date >= ($2::date::timestamp AT TIME ZONE 'Asia/Kolkata')
+		AND e.expense_date < (($3::date + 1)::timestamp AT TIME ZONE 'Asia/Kolkata')
+	ORDER BY e.amount DESC, e.expense_date DESC
+	LIMIT $4`
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, dateRange.StartDate, dateRange.EndDate, digestListLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var expense DigestExpense
+
+		err := rows.Scan(&expense.ID, &expense.Title, &expense.Amount, &expense.ExpenseDate, &expense.CategoryName)
+		if err != nil {
+			return nil, err
+		}
+
+		expenses = append(expenses, &expense)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return expenses, nil
+}
+
+// ClaimDigest reserves the digest of the period starting at periodStart for
+// the caller for lease. It reports false when the digest was already sent,
+// the preference was turned off, or another instance holds the claim.
+func (pg *PostgresDigestStore) ClaimDigest(userID int, periodStart string, lease time.Duration) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	UPDATE digest_preferences
+	SET claimed_until = CURRENT_TIMESTAMP + $3 * INTERVAL '1 millisecond'
+	WHERE
+		user_id = $1
+		AND enabled
+		AND (last_period_start IS NULL OR last_period_start < $2::date)
+		AND (claimed_until IS NULL OR claimed_until < CURRENT_TIMESTAMP)
+	RETURNING user_id`
+
+	var claimedID int
+	err := pg.db.QueryRowContext(ctx, query, userID, periodStart, lease.Milliseconds()).Scan(&claimedID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ReleaseDigest gives up a claim after a failed send, so the next check can
+// retry right away.
+func (pg *PostgresDigestStore) ReleaseDigest(userID int) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	UPDATE digest_preferences
+	SET claimed_until = NULL
+	WHERE user_id = $1`
+
+	_, err := pg.db.ExecContext(ctx, query, userID)
+	return err
+}
+
+// MarkDigestSent records the period as sent and drops the claim.
+func (pg *PostgresDigestStore) MarkDigestSent(userID int, periodStart string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	UPDATE digest_preferences
+	SET last_period_start = $2::date, last_sent_at = CURRENT_TIMESTAMP, claimed_until = NULL
+	WHERE user_id = $1`
+
+	_, err := pg.db.ExecContext(ctx, query, userID, periodStart)
+	return err
+}
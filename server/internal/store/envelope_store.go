@@ -0,0 +1,433 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	RolloverNone     = "none"
+	RolloverPositive = "positive"
+	RolloverBoth     = "both"
+)
+
+var ErrEnvelopeNotEnabled = errors.New("both categories must have envelope mode enabled")
+
+func IsValidRollover(rollover string) bool {
+	switch rollover {
+	case RolloverNone, RolloverPositive, RolloverBoth:
+		return true
+	}
+	return false
+}
+
+// carryOver is the part of a month's remaining balance that rolls into the
+// next month under the given rollover rule.
+func carryOver(rollover string, available float64) float64 {
+	switch rollover {
+	case RolloverBoth:
+		return available
+	case RolloverPositive:
+		if available > 0 {
+			return available
+		}
+	}
+	return 0
+}
+
+type EnvelopeSettings struct {
+	CategoryID int    `json:"category_id"`
+	Enabled    bool   `json:"enabled"`
+	Rollover   string `json:"rollover"`
+	LedgerID   int    `json:"-"`
+}
+
+type EnvelopeQueryParams struct {
+	Month    *string   `schema:"month"`
+	Months   *int      `schema:"months"`
+	Timezone string    `schema:"-"`
+	Today    time.Time `schema:"-"`
+}
+
+type EnvelopeMonth struct {
+	CategoryID int     `json:"category_id"`
+	Name       string  `json:"name"`
+	Month      string  `json:"month"`
+	Rollover   string  `json:"rollover"`
+	CarriedIn  float64 `json:"carried_in"`
+	Budgeted   float64 `json:"budgeted"`
+	Moved      float64 `json:"moved"`
+	Spent      float64 `json:"spent"`
+	Available  float64 `json:"available"`
+}
+
+type EnvelopeAllocation struct {
+	ID                    int     `json:"id"`
+	CategoryID            int     `json:"category_id"`
+	CounterpartCategoryID *int    `json:"counterpart_category_id"`
+	Month                 string  `json:"month"`
+	Amount                float64 `json:"amount"`
+	Note                  string  `json:"note"`
+	CreatedAt             string  `json:"created_at"`
+}
+
+type EnvelopeTransfer struct {
+	FromCategoryID int     `json:"from_category_id"`
+	ToCategoryID   int     `json:"to_category_id"`
+	Month          string  `json:"month"`
+	Amount         float64 `json:"amount"`
+	Note           string  `json:"note"`
+	LedgerID       int     `json:"-"`
+	UserID         int     `json:"-"`
+}
+
+type PostgresEnvelopeStore struct {
+	db *sql.DB
+}
+
+func NewPostgresEnvelopeStore(db *sql.DB) *PostgresEnvelopeStore {
+	return &PostgresEnvelopeStore{
+		db: db,
+	}
+}
+
+type EnvelopeStore interface {
+	UpdateEnvelopeSettings(settings *EnvelopeSettings) (*EnvelopeSettings, error)
+	ListEnvelopes(ledgerID int, queryParams EnvelopeQueryParams) ([]*EnvelopeMonth, error)
+	MoveMoney(transfer *EnvelopeTransfer) ([]*EnvelopeAllocation, error)
+	ListAllocations(ledgerID int, month string) ([]*EnvelopeAllocation, error)
+}
+
+func (pg *PostgresEnvelopeStore) UpdateEnvelopeSettings(settings *EnvelopeSettings) (*EnvelopeSettings, error) {
+	query := `
+	UPDATE categories
+	SET envelope_enabled = $1, rollover = $2
+	WHERE id = $3 AND ledger_id = $4
+	RETURNING id
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pg.db.QueryRowContext(
+		ctx,
+		query,
+		settings.Enabled,
+		settings.Rollover,
+		settings.CategoryID,
+		settings.LedgerID,
+	).Scan(&settings.CategoryID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+type envelopeCategory struct {
+	id            int
+	name          string
+	budgetCadence string
+	rollover      string
+}
+
+// ListEnvelopes walks every envelope category month by month from the first
+// month it had a budget or allocation, carrying balances forward according
+// to its rollover rule, and returns the requested months.
+func (pg *PostgresEnvelopeStore) ListEnvelopes(ledgerID int, queryParams EnvelopeQueryParams) ([]*EnvelopeMonth, error) {
+	envelopes := []*EnvelopeMonth{}
+
+	timezone := queryParams.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	firstMonth := BudgetPeriodStart(BudgetCadenceMonthly, queryParams.Today)
+	if queryParams.Month != nil {
+		parsed, err := time.Parse("2006-01", *queryParams.Month)
+		if err != nil {
+			return nil, err
+		}
+		firstMonth = parsed
+	}
+
+	months := 1
+	if queryParams.Months != nil && *queryParams.Months > 0 {
+		months = *queryParams.Months
+	}
+	lastMonth := firstMonth.AddDate(0, months-1, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	categoriesQuery := `
+		SELECT c.id, c.name, c.budget_cadence, c.rollover
+		FROM categories c
+		WHERE c.ledger_id = $1 AND c.envelope_enabled
+		ORDER BY c.id`
+
+	rows, err := pg.db.QueryContext(ctx, categoriesQuery, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	categories := []*envelopeCategory{}
+	for rows.Next() {
+		var category envelopeCategory
+		err := rows.Scan(&category.id, &category.name, &category.budgetCadence, &category.rollover)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, &category)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(categories) == 0 {
+		return envelopes, nil
+	}
+
+	budgets, err := loadLedgerBudgets(ctx, pg.db, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	moved, err := pg.monthlyTotals(ctx, `
+		SELECT a.category_id, TO_CHAR(a.month, 'YYYY-MM-DD'), SUM(a.amount)
+		FROM envelope_allocations a
+		WHERE a.ledger_id = $1 AND a.month <= $2::date
+		GROUP BY a.category_id, a.month`,
+		ledgerID, lastMonth.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	spent, err := pg.monthlyTotals(ctx, `
+		SELECT
+			e.category_id,
+			TO_CHAR(DATE_TRUNC('month', e.expense_date AT TIME ZONE $2::text), 'YYYY-MM-DD') AS month,
+			SUM(e.amount)
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			e.category_id IS NOT NULL AND
+			e.expense_date < (($3::date + INTERVAL '1 month')::timestamp AT TIME ZONE $2::text)
+		GROUP BY e.category_id, month`,
+		ledgerID, timezone, lastMonth.Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, category := range categories {
+		start := firstMonth
+		if categoryBudgets := budgets[category.id]; len(categoryBudgets) > 0 {
+			periodStart, err := time.Parse(dateLayout, categoryBudgets[0].PeriodStart)
+			if err != nil {
+				return nil, err
+			}
+			if monthStart := BudgetPeriodStart(BudgetCadenceMonthly, periodStart); monthStart.Before(start) {
+				start = monthStart
+			}
+		}
+		for key := range moved[category.id] {
+			month, err := time.Parse(dateLayout, key)
+			if err != nil {
+				return nil, err
+			}
+			if month.Before(start) {
+				start = month
+			}
+		}
+
+		carriedIn := 0.0
+		for month := start; !month.After(lastMonth); month = month.AddDate(0, 1, 0) {
+			key := month.Format(dateLayout)
+			monthEnd := month.AddDate(0, 1, -1)
+
+			envelope := &EnvelopeMonth{
+				CategoryID: category.id,
+				Name:       category.name,
+				Month:      month.Format("2006-01"),
+				Rollover:   category.rollover,
+				CarriedIn:  roundAmount(carriedIn),
+				Budgeted:   budgets[category.id].proratedAmount(category.budgetCadence, month, monthEnd),
+				Moved:      roundAmount(moved[category.id][key]),
+				Spent:      roundAmount(spent[category.id][key]),
+			}
+			envelope.Available = roundAmount(envelope.CarriedIn + envelope.Budgeted + envelope.Moved - envelope.Spent)
+
+			if !month.Before(firstMonth) {
+				envelopes = append(envelopes, envelope)
+			}
+
+			carriedIn = carryOver(category.rollover, envelope.Available)
+		}
+	}
+
+	return envelopes, nil
+}
+
+// monthlyTotals runs a query returning (category_id, month, amount) rows and
+// indexes the amounts by category and month.
+func (pg *PostgresEnvelopeStore) monthlyTotals(ctx context.Context, query string, args ...any) (map[int]map[string]float64, error) {
+	totals := make(map[int]map[string]float64)
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var categoryID int
+		var month string
+		var amount float64
+		err := rows.Scan(&categoryID, &month, &amount)
+		if err != nil {
+			return nil, err
+		}
+
+		if totals[categoryID] == nil {
+			totals[categoryID] = make(map[string]float64)
+		}
+		totals[categoryID][month] += amount
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return totals, nil
+}
+
+// MoveMoney records a transfer as a pair of allocation transactions: a
+// negative one on the source envelope and a positive one on the target.
+func (pg *PostgresEnvelopeStore) MoveMoney(transfer *EnvelopeTransfer) ([]*EnvelopeAllocation, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var enabledCount int
+	enabledQuery := `
+		SELECT COUNT(*)
+		FROM categories c
+		WHERE c.ledger_id = $1 AND c.id IN ($2, $3) AND c.envelope_enabled
+	`
+	err = tx.QueryRowContext(ctx, enabledQuery, transfer.LedgerID, transfer.FromCategoryID, transfer.ToCategoryID).Scan(&enabledCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if enabledCount != 2 {
+		return nil, ErrEnvelopeNotEnabled
+	}
+
+	query := `
+		INSERT INTO envelope_allocations (ledger_id, category_id, counterpart_category_id, month, amount, note, user_id)
+		VALUES ($1, $2, $3, $4::date, $5, $6, $7)
+		RETURNING id, TO_CHAR(month, 'YYYY-MM'), TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')
+	`
+
+	legs := []*EnvelopeAllocation{
+		{CategoryID: transfer.FromCategoryID, CounterpartCategoryID: &transfer.ToCategoryID, Amount: -transfer.Amount, Note: transfer.Note},
+		{CategoryID: transfer.ToCategoryID, CounterpartCategoryID: &transfer.FromCategoryID, Amount: transfer.Amount, Note: transfer.Note},
+	}
+
+	for _, leg := range legs {
+		err = tx.QueryRowContext(
+			ctx,
+			query,
+			transfer.LedgerID,
+			leg.CategoryID,
+			leg.CounterpartCategoryID,
+			transfer.Month,
+			leg.Amount,
+			leg.Note,
+			transfer.UserID,
+		).Scan(&leg.ID, &leg.Month, &leg.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return legs, nil
+}
+
+func (pg *PostgresEnvelopeStore) ListAllocations(ledgerID int, month string) ([]*EnvelopeAllocation, error) {
+	allocations := []*EnvelopeAllocation{}
+
+	query := `
+		SELECT
+			a.id,
+			a.category_id,
+			a.counterpart_category_id,
+			TO_CHAR(a.month, 'YYYY-MM'),
+			a.amount,
+			a.note,
+			TO_CHAR(a.created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')
+		FROM envelope_allocations a
+		WHERE a.ledger_id = $1 AND a.month = $2::date
+		ORDER BY a.created_at, a.id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, month)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var allocation EnvelopeAllocation
+		var counterpartID sql.NullInt64
+		err := rows.Scan(
+			&allocation.ID,
+			&allocation.CategoryID,
+			&counterpartID,
+			&allocation.Month,
+			&allocation.Amount,
+			&allocation.Note,
+			&allocation.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if counterpartID.Valid {
+			id := int(counterpartID.Int64)
+			allocation.CounterpartCategoryID = &id
+		}
+
+		allocations = append(allocations, &allocation)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return allocations, nil
+}
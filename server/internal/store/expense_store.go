@@ -4,17 +4,21 @@ import (
 	"cha-ching-server/internal/utils"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 )
 
 type Expense struct {
 	ID              int     `json:"id"`
 	UserID          int     `json:"-"`
+	LedgerID        int     `json:"-"`
 	CategoryID      int     `json:"category_id"`
 	PaymentMethodID int     `json:"payment_method_id"`
 	Title           string  `json:"title"`
 	Amount          float64 `json:"amount"`
 	ExpenseDate     string  `json:"expense_date"`
+	TaxSection      *string `json:"tax_section"`
 	CreatedAt       string  `json:"-"`
 	UpdatedAt       string  `json:"-"`
 }
@@ -32,6 +36,7 @@ type ExpenseQueryParams struct {
 	EndDate         *string `schema:"end_date"`
 	CategoryID      *int    `schema:"category_id"`
 	PaymentMethodID *int    `schema:"payment_method_id"`
+	Cycle           *string `schema:"cycle"`
 }
 
 type ExpenseTotalPerDayQueryParams struct {
@@ -39,8 +44,104 @@ type ExpenseTotalPerDayQueryParams struct {
 	EndDate         *string `schema:"end_date"`
 	CategoryID      *int    `schema:"category_id"`
 	PaymentMethodID *int    `schema:"payment_method_id"`
+	Cycle           *string `schema:"cycle"`
 }
 
+type ExpenseTimeSeriesQueryParams struct {
+	StartDate       *string `schema:"start_date"`
+	EndDate         *string `schema:"end_date"`
+	CategoryID      *int    `schema:"category_id"`
+	PaymentMethodID *int    `schema:"payment_method_id"`
+	Cycle           *string `schema:"cycle"`
+	Granularity     string  `schema:"granularity"`
+	GroupBy         *string `schema:"group_by"`
+	Timezone        string  `schema:"-"`
+}
+
+type ExpenseHeatmapQueryParams struct {
+	Year            *int   `schema:"year"`
+	CategoryID      *int   `schema:"category_id"`
+	PaymentMethodID *int   `schema:"payment_method_id"`
+	Timezone        string `schema:"-"`
+}
+
+// timeSeriesIntervals maps each supported granularity to the step used when
+// generating its buckets. Postgres has no "1 quarter" interval.
+var timeSeriesIntervals = map[string]string{
+	"day":     "1 day",
+	"week":    "1 week",
+	"month":   "1 month",
+	"quarter": "3 months",
+	"year":    "1 year",
+}
+
+// maxTimeSeriesBuckets caps the columns of one series, a little over four
+// years of days.
+const maxTimeSeriesBuckets = 1500
+
+var ErrTooManyTimeSeriesBuckets = errors.New("the date range has too many buckets, use a shorter range or a coarser granularity")
+
+var timeSeriesGroups = map[string]bool{
+	"category":       true,
+	"payment_method": true,
+}
+
+func IsValidTimeSeriesGranularity(granularity string) bool {
+	_, ok := timeSeriesIntervals[granularity]
+	return ok
+}
+
+func IsValidTimeSeriesGroupBy(groupBy string) bool {
+	return timeSeriesGroups[groupBy]
+}
+
+// ExpenseTimeSeries is a zero-filled matrix of totals: one column per bucket
+// and one row per group. Without a group_by there is a single "Total" row.
+type ExpenseTimeSeries struct {
+	Granularity string                  `json:"granularity"`
+	GroupBy     *string                 `json:"group_by"`
+	Buckets     []string                `json:"buckets"`
+	Series      []*ExpenseTimeSeriesRow `json:"series"`
+}
+
+type ExpenseTimeSeriesRow struct {
+	ID     *int      `json:"id"`
+	Name   string    `json:"name"`
+	Totals []float64 `json:"totals"`
+	Counts []int     `json:"counts"`
+}
+
+type ExpenseHeatmapCell struct {
+	TotalAmount float64 `json:"total_amount"`
+	Count       int     `json:"count"`
+}
+
+// ExpenseHeatmapDay is one day of the calendar grid. Week is the column of
+// the day when the year is laid out in Monday-first weeks.
+type ExpenseHeatmapDay struct {
+	Date        string  `json:"date"`
+	Weekday     int     `json:"weekday"`
+	Week        int     `json:"week"`
+	TotalAmount float64 `json:"total_amount"`
+	Count       int     `json:"count"`
+}
+
+// ExpenseHeatmap holds the spending of a year twice over: as a weekday by
+// hour matrix, Monday first, and as a zero-filled grid of every day.
+type ExpenseHeatmap struct {
+	Year          int                     `json:"year"`
+	Timezone      string                  `json:"timezone"`
+	Weekdays      []string                `json:"weekdays"`
+	Matrix        [][]*ExpenseHeatmapCell `json:"matrix"`
+	Days          []*ExpenseHeatmapDay    `json:"days"`
+	TotalAmount   float64                 `json:"total_amount"`
+	Count         int                     `json:"count"`
+	MaxCellAmount float64                 `json:"max_cell_amount"`
+	MaxDayAmount  float64                 `json:"max_day_amount"`
+}
+
+var heatmapWeekdays = []string{"Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday", "Sunday"}
+
 type ExpenseRelatedItems struct {
 	Categories     map[int]*Category      `json:"categories"`
 	PaymentMethods map[int]*PaymentMethod `json:"payment_methods"`
@@ -72,9 +173,11 @@ func NewPostgresExpenseStore(db *sql.DB) *PostgresExpenseStore {
 type ExpenseStore interface {
 	CreateExpense(expense *Expense) (*Expense, error)
 	UpdateExpense(id int64, expense *Expense) (*Expense, error)
-	ListExpensesByUserID(userID int, queryParams ExpenseQueryParams) ([]*Expense, *ExpensePaginationData, *ExpenseRelatedItems, *ExpenseMetaItems, error)
-	ListExpensesTotalPerDay(userID int, queryParams ExpenseTotalPerDayQueryParams) ([]*ExpenseTotalPerDay, *ExpenseMetaItems, error)
-	SearchExpensesByTitle(userID int, title string) ([]*Expense, *ExpenseRelatedItems, error)
+	ListExpensesByLedgerID(ledgerID int, queryParams ExpenseQueryParams) ([]*Expense, *ExpensePaginationData, *ExpenseRelatedItems, *ExpenseMetaItems, error)
+	ListExpensesTotalPerDay(ledgerID int, queryParams ExpenseTotalPerDayQueryParams) ([]*ExpenseTotalPerDay, *ExpenseMetaItems, error)
+	SearchExpensesByTitle(ledgerID int, title string) ([]*Expense, *ExpenseRelatedItems, error)
+	ExpenseTimeSeries(ledgerID int, queryParams ExpenseTimeSeriesQueryParams) (*ExpenseTimeSeries, *ExpenseMetaItems, error)
+	ExpenseHeatmap(ledgerID int, queryParams ExpenseHeatmapQueryParams) (*ExpenseHeatmap, error)
 }
 
 func (pg *PostgresExpenseStore) CreateExpense(expense *Expense) (*Expense, error) {
@@ -85,58 +188,60 @@ func (pg *PostgresExpenseStore) CreateExpense(expense *Expense) (*Expense, error
 
 	defer tx.Rollback()
 
-	// Verify if the category exists for the user
+	// Verify if the category exists in the ledger
 	var categoryExists bool
 	categoryQuery := `
 		SELECT EXISTS (
 			SELECT 1 
 			FROM categories 
-			WHERE id = $1 AND user_id = $2
+			WHERE id = $1 AND ledger_id = $2
 		)
 	`
-	err = tx.QueryRow(categoryQuery, expense.CategoryID, expense.UserID).Scan(&categoryExists)
+	err = tx.QueryRow(categoryQuery, expense.CategoryID, expense.LedgerID).Scan(&categoryExists)
 	if err != nil {
 		return nil, err
 	}
 
 	if !categoryExists {
-		return nil, fmt.Errorf("category does not exist for the user")
+		return nil, fmt.Errorf("category does not exist in the ledger")
 	}
 
-	// Verify if the payment method exists for the user
+	// Verify if the payment method exists in the ledger
 	var paymentMethodExists bool
 	paymentMethodQuery := `
 		SELECT EXISTS (
 			SELECT 1 
 			FROM payment_methods 
-			WHERE id = $1 AND user_id = $2
+			WHERE id = $1 AND ledger_id = $2
 		)
 	`
-	err = tx.QueryRow(paymentMethodQuery, expense.PaymentMethodID, expense.UserID).Scan(&paymentMethodExists)
+	err = tx.QueryRow(paymentMethodQuery, expense.PaymentMethodID, expense.LedgerID).Scan(&paymentMethodExists)
 	if err != nil {
 		return nil, err
 	}
 
 	if !paymentMethodExists {
-		return nil, fmt.Errorf("payment method does not exist for the user")
+		return nil, fmt.Errorf("payment method does not exist in the ledger")
 	}
 
 	// Insert the expense
 	query := `
 		INSERT INTO expenses (
 			user_id,
+			ledger_id,
 			category_id,
 			payment_method_id,
 			title,
 			amount,
-			expense_date
-		) VALUES ($1, $2, $3, $4, $5, $6)
+			expense_date,
+			tax_section
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING ID
 	`
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	err = tx.QueryRowContext(ctx, query, expense.UserID, expense.CategoryID, expense.PaymentMethodID, expense.Title, expense.Amount, expense.ExpenseDate).Scan(&expense.ID)
+	err = tx.QueryRowContext(ctx, query, expense.UserID, expense.LedgerID, expense.CategoryID, expense.PaymentMethodID, expense.Title, expense.Amount, expense.ExpenseDate, expense.TaxSection).Scan(&expense.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -150,6 +255,48 @@ func (pg *PostgresExpenseStore) CreateExpense(expense *Expense) (*Expense, error
 }
 
 func (pg *PostgresExpenseStore) UpdateExpense(id int64, expense *Expense) (*Expense, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// The new category and payment method must belong to the same ledger
+	var categoryExists bool
+	categoryQuery := `
+		SELECT EXISTS (
+			SELECT 1 
+			FROM categories 
+			WHERE id = $1 AND ledger_id = $2
+		)
+	`
+	err = tx.QueryRow(categoryQuery, expense.CategoryID, expense.LedgerID).Scan(&categoryExists)
+	if err != nil {
+		return nil, err
+	}
+
+	if !categoryExists {
+		return nil, ErrCategoryNotFound
+	}
+
+	var paymentMethodExists bool
+	paymentMethodQuery := `
+		SELECT EXISTS (
+			SELECT 1 
+			FROM payment_methods 
+			WHERE id = $1 AND ledger_id = $2
+		)
+	`
+	err = tx.QueryRow(paymentMethodQuery, expense.PaymentMethodID, expense.LedgerID).Scan(&paymentMethodExists)
+	if err != nil {
+		return nil, err
+	}
+
+	if !paymentMethodExists {
+		return nil, ErrPaymentMethodNotFound
+	}
+
 	query := `
 	UPDATE expenses
 	SET 
@@ -157,15 +304,16 @@ func (pg *PostgresExpenseStore) UpdateExpense(id int64, expense *Expense) (*Expe
 		payment_method_id = $2, 
 		title = $3,
 		amount = $4,
-		expense_date = $5
-	WHERE id = $6 AND user_id = $7
+		expense_date = $5,
+		tax_section = $6
+	WHERE id = $7 AND ledger_id = $8
 	RETURNING id
 	`
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	err := pg.db.QueryRowContext(
+	err = tx.QueryRowContext(
 		ctx,
 		query,
 		expense.CategoryID,
@@ -173,8 +321,9 @@ func (pg *PostgresExpenseStore) UpdateExpense(id int64, expense *Expense) (*Expe
 		expense.Title,
 		expense.Amount,
 		expense.ExpenseDate,
+		expense.TaxSection,
 		id,
-		expense.UserID,
+		expense.LedgerID,
 	).Scan(&expense.ID)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -184,10 +333,15 @@ func (pg *PostgresExpenseStore) UpdateExpense(id int64, expense *Expense) (*Expe
 		return nil, err
 	}
 
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
 	return expense, nil
 }
 
-func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams ExpenseQueryParams) (
+func (pg *PostgresExpenseStore) ListExpensesByLedgerID(ledgerID int, queryParams ExpenseQueryParams) (
 	[]*Expense,
 	*ExpensePaginationData,
 	*ExpenseRelatedItems,
@@ -198,7 +352,7 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 	var categories = make(map[int]*Category)
 	var paymentMethods = make(map[int]*PaymentMethod)
 
-	metaItems, err := pg.GetExpenseMetaItems(userID, queryParams)
+	metaItems, err := pg.GetExpenseMetaItems(ledgerID, queryParams)
 	if err != nil {
 		return nil, nil, nil, nil, err
 	}
@@ -246,18 +400,19 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 			e.title,
 			e.amount, 
 			e.expense_date,
+			e.tax_section,
 			c.id AS category_id,
 			c.name AS category_name,
 			p.id AS payment_method_id,
 			p.name AS payment_method_name
 		FROM expenses e
-		LEFT JOIN categories c ON c.id = e.category_id AND c.user_id = $1
-		LEFT JOIN payment_methods p ON p.id = e.payment_method_id AND p.user_id = $1
+		LEFT JOIN categories c ON c.id = e.category_id AND c.ledger_id = $1
+		LEFT JOIN payment_methods p ON p.id = e.payment_method_id AND p.ledger_id = $1
 		WHERE 
-			e.user_id = $1  AND 
+			e.ledger_id = $1  AND 
 			($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata')) AND
 			($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata')) AND
-			($4::int IS NULL OR e.category_id = $4) AND
+			($4::int IS NULL OR e.category_id IN (SELECT category_descendants($4))) AND
 			($5::int IS NULL OR e.payment_method_id = $5)
 		ORDER BY e.expense_date DESC, e.created_at DESC
 		LIMIT $6 OFFSET $7
@@ -271,7 +426,7 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 	rows, err := pg.db.QueryContext(
 		ctx,
 		query,
-		userID,
+		ledgerID,
 		startDate,
 		endDate,
 		queryParams.CategoryID,
@@ -290,6 +445,7 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 		var expense Expense
 		var category Category
 		var paymentMethod PaymentMethod
+		var taxSection sql.NullString
 		err := rows.Scan(
 			&expense.ID,
 			&expense.CategoryID,
@@ -297,6 +453,7 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 			&expense.Title,
 			&expense.Amount,
 			&expense.ExpenseDate,
+			&taxSection,
 			&category.ID,
 			&category.Name,
 			&paymentMethod.ID,
@@ -306,6 +463,10 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 			return nil, nil, nil, nil, err
 		}
 
+		if taxSection.Valid {
+			expense.TaxSection = &taxSection.String
+		}
+
 		expenses = append(expenses, &expense)
 		categories[category.ID] = &category
 		paymentMethods[paymentMethod.ID] = &paymentMethod
@@ -321,17 +482,17 @@ func (pg *PostgresExpenseStore) ListExpensesByUserID(userID int, queryParams Exp
 	}, metaItems, nil
 }
 
-func (pg *PostgresExpenseStore) GetExpenseMetaItems(userID int, queryParams ExpenseQueryParams) (*ExpenseMetaItems, error) {
+func (pg *PostgresExpenseStore) GetExpenseMetaItems(ledgerID int, queryParams ExpenseQueryParams) (*ExpenseMetaItems, error) {
 	var metaItems = ExpenseMetaItems{}
 
 	// Get total count first
 	query := `
 		SELECT COUNT(*), COALESCE(SUM(e.amount), 0) AS total_amount
 		FROM expenses e
-		WHERE e.user_id = $1 AND
+		WHERE e.ledger_id = $1 AND
 		($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata')) AND
 		($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata')) AND
-		($4::int IS NULL OR e.category_id = $4) AND
+		($4::int IS NULL OR e.category_id IN (SELECT category_descendants($4))) AND
 		($5::int IS NULL OR e.payment_method_id = $5)
 	`
 
@@ -343,7 +504,7 @@ func (pg *PostgresExpenseStore) GetExpenseMetaItems(userID int, queryParams Expe
 	err := pg.db.QueryRowContext(
 		ctx,
 		query,
-		userID,
+		ledgerID,
 		startDate,
 		endDate,
 		queryParams.CategoryID,
@@ -355,9 +516,9 @@ func (pg *PostgresExpenseStore) GetExpenseMetaItems(userID int, queryParams Expe
 	return &metaItems, nil
 }
 
-func (pg *PostgresExpenseStore) ListExpensesTotalPerDay(userID int, queryParams ExpenseTotalPerDayQueryParams) ([]*ExpenseTotalPerDay, *ExpenseMetaItems, error) {
+func (pg *PostgresExpenseStore) ListExpensesTotalPerDay(ledgerID int, queryParams ExpenseTotalPerDayQueryParams) ([]*ExpenseTotalPerDay, *ExpenseMetaItems, error) {
 	var expenseTotalPerDays []*ExpenseTotalPerDay = []*ExpenseTotalPerDay{}
-	metaItems, err := pg.GetExpenseMetaItems(userID, ExpenseQueryParams{
+	metaItems, err := pg.GetExpenseMetaItems(ledgerID, ExpenseQueryParams{
 		StartDate:       queryParams.StartDate,
 		EndDate:         queryParams.EndDate,
 		CategoryID:      queryParams.CategoryID,
@@ -379,10 +540,10 @@ func (pg *PostgresExpenseStore) ListExpensesTotalPerDay(userID int, queryParams
 		COUNT(e.id) AS count
 	FROM expenses e
 	WHERE 
-		e.user_id = $1 AND
+		e.ledger_id = $1 AND
 		($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata')) AND
 		($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata')) AND
-		($4::int IS NULL OR e.category_id = $4) AND
+		($4::int IS NULL OR e.category_id IN (SELECT category_descendants($4))) AND
 		($5::int IS NULL OR e.payment_method_id = $5)
 	GROUP BY formatted_date
 	ORDER BY formatted_date
@@ -391,7 +552,7 @@ func (pg *PostgresExpenseStore) ListExpensesTotalPerDay(userID int, queryParams
 	rows, err := pg.db.QueryContext(
 		ctx,
 		query,
-		userID,
+		ledgerID,
 		startDate,
 		endDate,
 		queryParams.CategoryID,
@@ -422,7 +583,7 @@ func (pg *PostgresExpenseStore) ListExpensesTotalPerDay(userID int, queryParams
 	return expenseTotalPerDays, metaItems, nil
 }
 
-func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string) ([]*Expense, *ExpenseRelatedItems, error) {
+func (pg *PostgresExpenseStore) SearchExpensesByTitle(ledgerID int, title string) ([]*Expense, *ExpenseRelatedItems, error) {
 	var expenses []*Expense = []*Expense{}
 	var categories = make(map[int]*Category)
 	var paymentMethods = make(map[int]*PaymentMethod)
@@ -435,15 +596,16 @@ func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string)
 				e.title,
 				e.amount, 
 				e.expense_date,
+				e.tax_section,
 				c.id AS category_id,
 				c.name AS category_name,
 				p.id AS payment_method_id,
 				p.name AS payment_method_name
 			FROM expenses e
-			LEFT JOIN categories c ON c.id = e.category_id AND c.user_id = $1
-			LEFT JOIN payment_methods p ON p.id = e.payment_method_id AND p.user_id = $1
+			LEFT JOIN categories c ON c.id = e.category_id AND c.ledger_id = $1
+			LEFT JOIN payment_methods p ON p.id = e.payment_method_id AND p.ledger_id = $1
 			WHERE 
-				e.user_id = $1
+				e.ledger_id = $1
 				AND e.title ILIKE '%' || $2 || '%'
 
 			ORDER BY 
@@ -457,7 +619,7 @@ func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string)
 	rows, err := pg.db.QueryContext(
 		ctx,
 		query,
-		userID,
+		ledgerID,
 		title,
 	)
 
@@ -471,6 +633,7 @@ func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string)
 		var expense Expense
 		var category Category
 		var paymentMethod PaymentMethod
+		var taxSection sql.NullString
 		err := rows.Scan(
 			&expense.ID,
 			&expense.CategoryID,
@@ -478,6 +641,7 @@ func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string)
 			&expense.Title,
 			&expense.Amount,
 			&expense.ExpenseDate,
+			&taxSection,
 			&category.ID,
 			&category.Name,
 			&paymentMethod.ID,
@@ -487,6 +651,10 @@ func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string)
 			return nil, nil, err
 		}
 
+		if taxSection.Valid {
+			expense.TaxSection = &taxSection.String
+		}
+
 		expenses = append(expenses, &expense)
 		categories[category.ID] = &category
 		paymentMethods[paymentMethod.ID] = &paymentMethod
@@ -501,3 +669,338 @@ func (pg *PostgresExpenseStore) SearchExpensesByTitle(userID int, title string)
 		PaymentMethods: paymentMethods,
 	}, nil
 }
+
+func (pg *PostgresExpenseStore) ExpenseTimeSeries(ledgerID int, queryParams ExpenseTimeSeriesQueryParams) (*ExpenseTimeSeries, *ExpenseMetaItems, error) {
+	timezone := queryParams.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	// The totals and the first and last bucket come from the same filter as
+	// the series, so they agree with it in the user's timezone
+	metaQuery := `
+	WITH filtered AS (
+		SELECT
+			DATE_TRUNC($6::text, e.expense_date AT TIME ZONE $5::text) AS bucket,
+			e.amount
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			($2::text IS NULL OR e.expense_date >= ($2::date::timestamp AT TIME ZONE $5::text)) AND
+			($3::text IS NULL OR e.expense_date < (($3::date + 1)::timestamp AT TIME ZONE $5::text)) AND
+			($4::int IS NULL OR e.category_id IN (SELECT category_descendants($4))) AND
+			($7::int IS NULL OR e.payment_method_id = $7)
+	)
+	SELECT
+		COUNT(*),
+		COALESCE(SUM(amount), 0),
+		DATE_TRUNC($6::text, COALESCE($2::date::timestamp, MIN(bucket))),
+		DATE_TRUNC($6::text, COALESCE($3::date::timestamp, MAX(bucket)))
+	FROM filtered
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var metaItems ExpenseMetaItems
+	var firstBucket, lastBucket sql.NullTime
+
+	err := pg.db.QueryRowContext(
+		ctx,
+		metaQuery,
+		ledgerID,
+		queryParams.StartDate,
+		queryParams.EndDate,
+		queryParams.CategoryID,
+		timezone,
+		queryParams.Granularity,
+		queryParams.PaymentMethodID,
+	).Scan(&metaItems.TotalCount, &metaItems.TotalAmount, &firstBucket, &lastBucket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if firstBucket.Valid && lastBucket.Valid && timeSeriesBucketCount(queryParams.Granularity, firstBucket.Time, lastBucket.Time) > maxTimeSeriesBuckets {
+		return nil, nil, ErrTooManyTimeSeriesBuckets
+	}
+
+	timeSeries := &ExpenseTimeSeries{
+		Granularity: queryParams.Granularity,
+		GroupBy:     queryParams.GroupBy,
+		Buckets:     []string{},
+		Series:      []*ExpenseTimeSeriesRow{},
+	}
+
+	// Buckets span the requested range, or the range of matching expenses
+	// when no dates are given. Every group gets a row for every bucket.
+	query := `
+	WITH filtered AS (
+		SELECT
+			DATE_TRUNC($6::text, e.expense_date AT TIME ZONE $5::text) AS bucket,
+			CASE $7::text
+				WHEN 'category' THEN e.category_id
+				WHEN 'payment_method' THEN e.payment_method_id
+			END AS group_id,
+			e.id,
+			e.amount
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			($2::text IS NULL OR e.expense_date >= ($2::date::timestamp AT TIME ZONE $5::text)) AND
+			($3::text IS NULL OR e.expense_date < (($3::date + 1)::timestamp AT TIME ZONE $5::text)) AND
+			($4::int IS NULL OR e.category_id IN (SELECT category_descendants($4))) AND
+			($8::int IS NULL OR e.payment_method_id = $8)
+	),
+	buckets AS (
+		SELECT GENERATE_SERIES(
+			DATE_TRUNC($6::text, COALESCE($2::date::timestamp, (SELECT MIN(bucket) FROM filtered))),
+			DATE_TRUNC($6::text, COALESCE($3::date::timestamp, (SELECT MAX(bucket) FROM filtered))),
+			$9::text::interval
+		) AS bucket
+	),
+	groups AS (
+		SELECT NULL::bigint AS id, 'Total'::text AS name
+		WHERE $7::text IS NULL
+		UNION ALL
+		SELECT c.id, c.name::text
+		FROM categories c
+		WHERE $7::text = 'category' AND c.ledger_id = $1
+		UNION ALL
+		SELECT pm.id, pm.name::text
+		FROM payment_methods pm
+		WHERE $7::text = 'payment_method' AND pm.ledger_id = $1
+	)
+	SELECT
+		g.id,
+		g.name,
+		TO_CHAR(b.bucket, 'YYYY-MM-DD') AS formatted_bucket,
+		COALESCE(SUM(f.amount), 0) AS total_amount,
+		COUNT(f.id) AS count
+	FROM buckets b
+	CROSS JOIN groups g
+	LEFT JOIN filtered f
+		ON f.bucket = b.bucket
+		AND ($7::text IS NULL OR f.group_id = g.id)
+	GROUP BY g.id, g.name, b.bucket
+	ORDER BY g.id NULLS FIRST, b.bucket
+	`
+
+	rows, err := pg.db.QueryContext(
+		ctx,
+		query,
+		ledgerID,
+		queryParams.StartDate,
+		queryParams.EndDate,
+		queryParams.CategoryID,
+		timezone,
+		queryParams.Granularity,
+		queryParams.GroupBy,
+		queryParams.PaymentMethodID,
+		timeSeriesIntervals[queryParams.Granularity],
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer rows.Close()
+
+	var current *ExpenseTimeSeriesRow
+
+	for rows.Next() {
+		var id sql.NullInt64
+		var name string
+		var bucket string
+		var totalAmount float64
+		var count int
+
+		err := rows.Scan(&id, &name, &bucket, &totalAmount, &count)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// Rows arrive grouped by series, so a new name or id starts a new row
+		if current == nil || current.Name != name || !sameSeriesID(current.ID, id) {
+			current = &ExpenseTimeSeriesRow{
+				Name:   name,
+				Totals: []float64{},
+				Counts: []int{},
+			}
+			if id.Valid {
+				seriesID := int(id.Int64)
+				current.ID = &seriesID
+			}
+			timeSeries.Series = append(timeSeries.Series, current)
+		}
+
+		if len(timeSeries.Series) == 1 {
+			timeSeries.Buckets = append(timeSeries.Buckets, bucket)
+		}
+
+		current.Totals = append(current.Totals, totalAmount)
+		current.Counts = append(current.Counts, count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return timeSeries, &metaItems, nil
+}
+
+// timeSeriesBucketCount counts the buckets from first to last, both already
+// truncated to the granularity.
+func timeSeriesBucketCount(granularity string, first, last time.Time) int {
+	if last.Before(first) {
+		return 0
+	}
+
+	months := (last.Year()-first.Year())*12 + int(last.Month()) - int(first.Month())
+	days := int(last.Sub(first).Hours() / 24)
+
+	switch granularity {
+	case "week":
+		return days/7 + 1
+	case "month":
+		return months + 1
+	case "quarter":
+		return months/3 + 1
+	case "year":
+		return last.Year() - first.Year() + 1
+	}
+	return days + 1
+}
+
+func sameSeriesID(seriesID *int, id sql.NullInt64) bool {
+	if seriesID == nil || !id.Valid {
+		return seriesID == nil && !id.Valid
+	}
+	return int64(*seriesID) == id.Int64
+}
+
+// ExpenseHeatmap totals a year of expenses by local day and hour in one
+// query, then folds the rows into the weekday by hour matrix and the day
+// grid.
+func (pg *PostgresExpenseStore) ExpenseHeatmap(ledgerID int, queryParams ExpenseHeatmapQueryParams) (*ExpenseHeatmap, error) {
+	timezone := queryParams.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	year := budgetToday().Year()
+	if queryParams.Year != nil {
+		year = *queryParams.Year
+	}
+
+	yearStart := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	yearEnd := yearStart.AddDate(1, 0, 0)
+
+	heatmap := &ExpenseHeatmap{
+		Year:     year,
+		Timezone: timezone,
+		Weekdays: heatmapWeekdays,
+		Matrix:   make([][]*ExpenseHeatmapCell, len(heatmapWeekdays)),
+		Days:     []*ExpenseHeatmapDay{},
+	}
+
+	for weekday := range heatmap.Matrix {
+		heatmap.Matrix[weekday] = make([]*ExpenseHeatmapCell, 24)
+		for hour := range heatmap.Matrix[weekday] {
+			heatmap.Matrix[weekday][hour] = &ExpenseHeatmapCell{}
+		}
+	}
+
+	firstColumnOffset := (int(yearStart.Weekday()) + 6) % 7
+	dayIndex := make(map[string]*ExpenseHeatmapDay)
+
+	for date := yearStart; date.Before(yearEnd); date = date.AddDate(0, 0, 1) {
+		day := &ExpenseHeatmapDay{
+			Date:    date.Format(dateLayout),
+			Weekday: (int(date.Weekday()) + 6) % 7,
+			Week:    (date.YearDay() - 1 + firstColumnOffset) / 7,
+		}
+		heatmap.Days = append(heatmap.Days, day)
+		dayIndex[day.Date] = day
+	}
+
+	query := `
+	SELECT
+		TO_CHAR(e.expense_date AT TIME ZONE $2::text, 'YYYY-MM-DD') AS local_date,
+		EXTRACT(HOUR FROM e.expense_date AT TIME ZONE $2::text)::int AS local_hour,
+		SUM(e.amount) AS total_amount,
+		COUNT(e.id) AS count
+	FROM expenses e
+	WHERE
+		e.ledger_id = $1 AND
+		e.expense_date >= ($3::date::timestamp AT TIME ZONE $2::text) AND
+		e.expense_date < ($4::date::timestamp AT TIME ZONE $2::text) AND
+		($5::int IS NULL OR e.category_id IN (SELECT category_descendants($5))) AND
+		($6::int IS NULL OR e.payment_method_id = $6)
+	GROUP BY local_date, local_hour
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(
+		ctx,
+		query,
+		ledgerID,
+		timezone,
+		yearStart.Format(dateLayout),
+		yearEnd.Format(dateLayout),
+		queryParams.CategoryID,
+		queryParams.PaymentMethodID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var date string
+		var hour int
+		var totalAmount float64
+		var count int
+
+		err := rows.Scan(&date, &hour, &totalAmount, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		day, ok := dayIndex[date]
+		if !ok {
+			continue
+		}
+
+		day.TotalAmount += totalAmount
+		day.Count += count
+
+		cell := heatmap.Matrix[day.Weekday][hour]
+		cell.TotalAmount += totalAmount
+		cell.Count += count
+
+		heatmap.TotalAmount += totalAmount
+		heatmap.Count += count
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	heatmap.TotalAmount = roundAmount(heatmap.TotalAmount)
+
+	for _, row := range heatmap.Matrix {
+		for _, cell := range row {
+			cell.TotalAmount = roundAmount(cell.TotalAmount)
+			heatmap.MaxCellAmount = max(heatmap.MaxCellAmount, cell.TotalAmount)
+		}
+	}
+
+	for _, day := range heatmap.Days {
+		day.TotalAmount = roundAmount(day.TotalAmount)
+		heatmap.MaxDayAmount = max(heatmap.MaxDayAmount, day.TotalAmount)
+	}
+
+	return heatmap, nil
+}
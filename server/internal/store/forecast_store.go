@@ -0,0 +1,425 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+// forecastBandZ is the z-score of the confidence band returned with each
+// projection, roughly an 80% interval.
+const forecastBandZ = 1.28
+
+const defaultForecastLookbackMonths = 3
+
+// A recurring expense has to show up in at least two months, so a shorter
+// lookback could never find one.
+var ErrForecastLookbackTooShort = errors.New("lookback_months must be at least 2")
+
+type ForecastQueryParams struct {
+	LookbackMonths *int      `schema:"lookback_months"`
+	Timezone       string    `schema:"-"`
+	Today          time.Time `schema:"-"`
+}
+
+type ForecastProjection struct {
+	SpentToDate       float64 `json:"spent_to_date"`
+	UpcomingRecurring float64 `json:"upcoming_recurring"`
+	DailyRunRate      float64 `json:"daily_run_rate"`
+	Projected         float64 `json:"projected"`
+	Low               float64 `json:"low"`
+	High              float64 `json:"high"`
+}
+
+type CategoryForecast struct {
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Budget float64 `json:"budget"`
+	ForecastProjection
+	ProjectedOverrun float64 `json:"projected_overrun"`
+	OnTrackToExceed  bool    `json:"on_track_to_exceed"`
+}
+
+type RecurringItem struct {
+	Title        string  `json:"title"`
+	CategoryID   *int    `json:"category_id"`
+	Amount       float64 `json:"amount"`
+	ExpectedDate string  `json:"expected_date"`
+}
+
+type Forecast struct {
+	DateRange
+	AsOf              string              `json:"as_of"`
+	DaysElapsed       int                 `json:"days_elapsed"`
+	DaysRemaining     int                 `json:"days_remaining"`
+	LookbackMonths    int                 `json:"lookback_months"`
+	Overall           ForecastProjection  `json:"overall"`
+	Categories        []*CategoryForecast `json:"categories"`
+	UpcomingRecurring []*RecurringItem    `json:"upcoming_recurring"`
+}
+
+// dailySeries accumulates the daily totals needed for a run-rate and its
+// spread. Days without spending count as zero.
+type dailySeries struct {
+	sum        float64
+	sumSquares float64
+}
+
+func (ds *dailySeries) add(amount float64) {
+	ds.sum += amount
+	ds.sumSquares += amount * amount
+}
+
+func (ds *dailySeries) meanAndVariance(days int) (float64, float64) {
+	if days <= 1 {
+		return ds.sum, 0
+	}
+
+	mean := ds.sum / float64(days)
+	variance := (ds.sumSquares - float64(days)*mean*mean) / float64(days-1)
+	if variance < 0 {
+		variance = 0
+	}
+
+	return mean, variance
+}
+
+func project(spentToDate float64, upcomingRecurring float64, series *dailySeries, lookbackDays int, remainingDays int) ForecastProjection {
+	mean, variance := series.meanAndVariance(lookbackDays)
+	floor := spentToDate + upcomingRecurring
+	projected := floor + mean*float64(remainingDays)
+	band := forecastBandZ * math.Sqrt(variance*float64(remainingDays))
+
+	return ForecastProjection{
+		SpentToDate:       roundAmount(spentToDate),
+		UpcomingRecurring: roundAmount(upcomingRecurring),
+		DailyRunRate:      roundAmount(mean),
+		Projected:         roundAmount(projected),
+		Low:               roundAmount(math.Max(floor, projected-band)),
+		High:              roundAmount(projected + band),
+	}
+}
+
+type PostgresForecastStore struct {
+	db *sql.DB
+}
+
+func NewPostgresForecastStore(db *sql.DB) *PostgresForecastStore {
+	return &PostgresForecastStore{
+		db: db,
+	}
+}
+
+type ForecastStore interface {
+	MonthForecast(ledgerID int, queryParams ForecastQueryParams) (*Forecast, error)
+}
+
+// recurringExpensesCTE finds titles that showed up in every month of the
+// lookback window ($4 to $3). It expects $1 ledger, $2 timezone and $5 the
+// number of lookback months.
+const recurringExpensesCTE = `
+	recurring AS (
+		SELECT
+			LOWER(e.title) AS title_key,
+			MIN(e.title) AS title,
+			MODE() WITHIN GROUP (ORDER BY e.category_id) AS category_id,
+			AVG(e.amount) AS amount,
+			ROUND(AVG(EXTRACT(DAY FROM e.expense_date AT TIME ZONE $2::text)))::int AS typical_day
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			e.expense_date >= ($4::date::timestamp AT TIME ZONE $2::text) AND
+			e.expense_date < ($3::date::timestamp AT TIME ZONE $2::text)
+		GROUP BY LOWER(e.title)
+		HAVING COUNT(DISTINCT DATE_TRUNC('month', e.expense_date AT TIME ZONE $2::text)) >= $5::int
+	)`
+
+func (pg *PostgresForecastStore) MonthForecast(ledgerID int, queryParams ForecastQueryParams) (*Forecast, error) {
+	lookbackMonths := defaultForecastLookbackMonths
+	if queryParams.LookbackMonths != nil {
+		if *queryParams.LookbackMonths < 2 {
+			return nil, ErrForecastLookbackTooShort
+		}
+		lookbackMonths = *queryParams.LookbackMonths
+	}
+
+	timezone := queryParams.Timezone
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	today := queryParams.Today
+	periodStart := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	periodEnd := periodStart.AddDate(0, 1, -1)
+	lookbackStart := periodStart.AddDate(0, -lookbackMonths, 0)
+
+	lookbackDays := int(periodStart.Sub(lookbackStart).Hours() / 24)
+	daysElapsed := today.Day()
+	daysRemaining := periodEnd.Day() - daysElapsed
+
+	forecast := &Forecast{
+		DateRange:         DateRange{StartDate: periodStart.Format(dateLayout), EndDate: periodEnd.Format(dateLayout)},
+		AsOf:              today.Format(dateLayout),
+		DaysElapsed:       daysElapsed,
+		DaysRemaining:     daysRemaining,
+		LookbackMonths:    lookbackMonths,
+		Categories:        []*CategoryForecast{},
+		UpcomingRecurring: []*RecurringItem{},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Daily spend per category over the lookback window, recurring items
+	// excluded since they are projected separately
+	runRateQuery := `
+	WITH ` + recurringExpensesCTE + `
+	SELECT
+		e.category_id,
+		SUM(e.amount) AS total_amount
+	FROM expenses e
+	WHERE
+		e.ledger_id = $1 AND
+		e.expense_date >= ($4::date::timestamp AT TIME ZONE $2::text) AND
+		e.expense_date < ($3::date::timestamp AT TIME ZONE $2::text) AND
+		LOWER(e.title) NOT IN (SELECT title_key FROM recurring)
+	GROUP BY e.category_id, (e.expense_date AT TIME ZONE $2::text)::date
+	`
+
+	rows, err := pg.db.QueryContext(
+		ctx,
+		runRateQuery,
+		ledgerID,
+		timezone,
+		periodStart.Format(dateLayout),
+		lookbackStart.Format(dateLayout),
+		lookbackMonths,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	categorySeries := make(map[int]*dailySeries)
+
+	for rows.Next() {
+		var categoryID sql.NullInt64
+		var amount float64
+		err := rows.Scan(&categoryID, &amount)
+		if err != nil {
+			return nil, err
+		}
+
+		if !categoryID.Valid {
+			continue
+		}
+
+		id := int(categoryID.Int64)
+		if categorySeries[id] == nil {
+			categorySeries[id] = &dailySeries{}
+		}
+		categorySeries[id].add(amount)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Overall daily totals are summed per day across categories before the
+	// spread is computed
+	overallQuery := `
+	WITH ` + recurringExpensesCTE + `
+	SELECT SUM(e.amount) AS total_amount
+	FROM expenses e
+	WHERE
+		e.ledger_id = $1 AND
+		e.expense_date >= ($4::date::timestamp AT TIME ZONE $2::text) AND
+		e.expense_date < ($3::date::timestamp AT TIME ZONE $2::text) AND
+		LOWER(e.title) NOT IN (SELECT title_key FROM recurring)
+	GROUP BY (e.expense_date AT TIME ZONE $2::text)::date
+	`
+
+	overallRows, err := pg.db.QueryContext(
+		ctx,
+		overallQuery,
+		ledgerID,
+		timezone,
+		periodStart.Format(dateLayout),
+		lookbackStart.Format(dateLayout),
+		lookbackMonths,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer overallRows.Close()
+
+	overallSeries := &dailySeries{}
+
+	for overallRows.Next() {
+		var amount float64
+		err := overallRows.Scan(&amount)
+		if err != nil {
+			return nil, err
+		}
+		overallSeries.add(amount)
+	}
+
+	if err = overallRows.Err(); err != nil {
+		return nil, err
+	}
+
+	upcomingQuery := `
+	WITH ` + recurringExpensesCTE + `
+	SELECT r.title, r.category_id, r.amount, r.typical_day
+	FROM recurring r
+	WHERE NOT EXISTS (
+		SELECT 1
+		FROM expenses e
+		WHERE
+			e.ledger_id = $1 AND
+			LOWER(e.title) = r.title_key AND
+			e.expense_date >= ($3::date::timestamp AT TIME ZONE $2::text) AND
+			e.expense_date < (($6::date + 1)::timestamp AT TIME ZONE $2::text)
+	)
+	ORDER BY r.typical_day, r.title
+	`
+
+	upcomingRows, err := pg.db.QueryContext(
+		ctx,
+		upcomingQuery,
+		ledgerID,
+		timezone,
+		periodStart.Format(dateLayout),
+		lookbackStart.Format(dateLayout),
+		lookbackMonths,
+		periodEnd.Format(dateLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer upcomingRows.Close()
+
+	overallUpcoming := 0.0
+	categoryUpcoming := make(map[int]float64)
+
+	for upcomingRows.Next() {
+		var item RecurringItem
+		var categoryID sql.NullInt64
+		var typicalDay int
+		err := upcomingRows.Scan(&item.Title, &categoryID, &item.Amount, &typicalDay)
+		if err != nil {
+			return nil, err
+		}
+
+		expectedDay := min(max(typicalDay, daysElapsed+1), periodEnd.Day())
+		item.ExpectedDate = time.Date(today.Year(), today.Month(), expectedDay, 0, 0, 0, 0, time.UTC).Format(dateLayout)
+		item.Amount = roundAmount(item.Amount)
+
+		if categoryID.Valid {
+			id := int(categoryID.Int64)
+			item.CategoryID = &id
+			categoryUpcoming[id] += item.Amount
+		}
+
+		overallUpcoming += item.Amount
+		forecast.UpcomingRecurring = append(forecast.UpcomingRecurring, &item)
+	}
+
+	if err = upcomingRows.Err(); err != nil {
+		return nil, err
+	}
+
+	budgets, err := loadLedgerBudgets(ctx, pg.db, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	spentQuery := `
+	SELECT c.id, c.name, c.budget_cadence, COALESCE(SUM(e.amount), 0) AS total_amount
+	FROM categories c
+	LEFT JOIN expenses e
+	ON c.id = e.category_id
+		AND e.ledger_id = $1
+		AND e.expense_date >= ($3::date::timestamp AT TIME ZONE $2::text)
+		AND e.expense_date < (($4::date + 1)::timestamp AT TIME ZONE $2::text)
+	WHERE
+		c.ledger_id = $1
+	GROUP BY c.id, c.name, c.budget_cadence
+	ORDER BY c.id`
+
+	spentRows, err := pg.db.QueryContext(
+		ctx,
+		spentQuery,
+		ledgerID,
+		timezone,
+		periodStart.Format(dateLayout),
+		today.Format(dateLayout),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer spentRows.Close()
+
+	for spentRows.Next() {
+		var categoryForecast CategoryForecast
+		var budgetCadence string
+		var spentToDate float64
+		err := spentRows.Scan(&categoryForecast.ID, &categoryForecast.Name, &budgetCadence, &spentToDate)
+		if err != nil {
+			return nil, err
+		}
+
+		categoryForecast.Budget = budgets[categoryForecast.ID].proratedAmount(budgetCadence, periodStart, periodEnd)
+
+		series := categorySeries[categoryForecast.ID]
+		if series == nil {
+			series = &dailySeries{}
+		}
+
+		categoryForecast.ForecastProjection = project(spentToDate, categoryUpcoming[categoryForecast.ID], series, lookbackDays, daysRemaining)
+
+		if categoryForecast.Budget > 0 && categoryForecast.Projected > categoryForecast.Budget {
+			categoryForecast.ProjectedOverrun = roundAmount(categoryForecast.Projected - categoryForecast.Budget)
+			categoryForecast.OnTrackToExceed = true
+		}
+
+		forecast.Categories = append(forecast.Categories, &categoryForecast)
+	}
+
+	if err = spentRows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Overall spend has its own query so uncategorized expenses count, the
+	// same base as the overall daily series
+	overallSpentQuery := `
+	SELECT COALESCE(SUM(e.amount), 0) AS total_amount
+	FROM expenses e
+	WHERE
+		e.ledger_id = $1 AND
+		e.expense_date >= ($3::date::timestamp AT TIME ZONE $2::text) AND
+		e.expense_date < (($4::date + 1)::timestamp AT TIME ZONE $2::text)`
+
+	var overallSpent float64
+	err = pg.db.QueryRowContext(
+		ctx,
+		overallSpentQuery,
+		ledgerID,
+		timezone,
+		periodStart.Format(dateLayout),
+		today.Format(dateLayout),
+	).Scan(&overallSpent)
+	if err != nil {
+		return nil, err
+	}
+
+	forecast.Overall = project(overallSpent, overallUpcoming, overallSeries, lookbackDays, daysRemaining)
+
+	return forecast, nil
+}
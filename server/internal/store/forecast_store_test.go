@@ -0,0 +1,17 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMonthForecastRejectsShortLookback(t *testing.T) {
+	// The check runs before any query, so the store needs no database
+	pg := &PostgresForecastStore{}
+	for _, months := range []int{-1, 0, 1} {
+		_, err := pg.MonthForecast(1, ForecastQueryParams{LookbackMonths: &months})
+		if !errors.Is(err, ErrForecastLookbackTooShort) {
+			t.Errorf("MonthForecast(lookback %d) error = %v, want %v", months, err, ErrForecastLookbackTooShort)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	GoalStatusCompleted = "completed"
+	GoalStatusOnTrack   = "on_track"
+	GoalStatusBehind    = "behind"
+	GoalStatusOverdue   = "overdue"
+)
+
+var ErrGoalNotFound = errors.New("goal does not exist in the ledger")
+
+type Goal struct {
+	ID               int     `json:"id"`
+	Name             string  `json:"name"`
+	TargetAmount     float64 `json:"target_amount"`
+	StartDate        string  `json:"start_date"`
+	TargetDate       string  `json:"target_date"`
+	PaymentMethodID  *int    `json:"payment_method_id"`
+	Saved            float64 `json:"saved"`
+	Remaining        float64 `json:"remaining"`
+	PercentComplete  float64 `json:"percent_complete"`
+	ExpectedSaved    float64 `json:"expected_saved"`
+	MonthsLeft       int     `json:"months_left"`
+	RequiredPerMonth float64 `json:"required_per_month"`
+	Status           string  `json:"status"`
+	UserID           int     `json:"-"`
+	LedgerID         int     `json:"-"`
+}
+
+type GoalContribution struct {
+	ID               int     `json:"id"`
+	GoalID           int     `json:"goal_id"`
+	Amount           float64 `json:"amount"`
+	ContributionDate string  `json:"contribution_date"`
+	Note             string  `json:"note"`
+	UserID           int     `json:"-"`
+	LedgerID         int     `json:"-"`
+}
+
+type GoalsSummary struct {
+	Count            int     `json:"count"`
+	TargetTotal      float64 `json:"target_total"`
+	SavedTotal       float64 `json:"saved_total"`
+	RemainingTotal   float64 `json:"remaining_total"`
+	RequiredPerMonth float64 `json:"required_per_month"`
+	Completed        int     `json:"completed"`
+	OnTrack          int     `json:"on_track"`
+	Behind           int     `json:"behind"`
+	Overdue          int     `json:"overdue"`
+}
+
+// monthsUntil counts the monthly contributions left between today and the
+// target date, including the current month. It is zero once the target date
+// has passed.
+func monthsUntil(today time.Time, target time.Time) int {
+	if target.Before(today) {
+		return 0
+	}
+
+	months := (target.Year()-today.Year())*12 + int(target.Month()-today.Month())
+	if target.Day() >= today.Day() {
+		months++
+	}
+
+	return max(months, 1)
+}
+
+// computeProgress fills in the derived fields of a goal. A goal is on track
+// when the amount saved keeps pace with a straight line from the start date
+// to the target amount on the target date.
+func (g *Goal) computeProgress(today time.Time) error {
+	start, err := time.Parse(dateLayout, g.StartDate)
+	if err != nil {
+		return err
+	}
+
+	target, err := time.Parse(dateLayout, g.TargetDate)
+	if err != nil {
+		return err
+	}
+
+	g.Saved = roundAmount(g.Saved)
+	g.Remaining = roundAmount(math.Max(g.TargetAmount-g.Saved, 0))
+	g.PercentComplete = roundAmount(math.Min(g.Saved/g.TargetAmount*100, 100))
+	g.MonthsLeft = monthsUntil(today, target)
+
+	totalDays := target.Sub(start).Hours() / 24
+	elapsedDays := today.Sub(start).Hours() / 24
+	share := 1.0
+	if totalDays > 0 {
+		share = math.Min(math.Max(elapsedDays/totalDays, 0), 1)
+	}
+	g.ExpectedSaved = roundAmount(g.TargetAmount * share)
+
+	g.RequiredPerMonth = g.Remaining
+	if g.MonthsLeft > 0 {
+		g.RequiredPerMonth = roundAmount(g.Remaining / float64(g.MonthsLeft))
+	}
+
+	switch {
+	case g.Remaining == 0:
+		g.Status = GoalStatusCompleted
+		g.RequiredPerMonth = 0
+	case today.After(target):
+		g.Status = GoalStatusOverdue
+	case g.Saved >= g.ExpectedSaved:
+		g.Status = GoalStatusOnTrack
+	default:
+		g.Status = GoalStatusBehind
+	}
+
+	return nil
+}
+
+type PostgresGoalStore struct {
+	db *sql.DB
+}
+
+func NewPostgresGoalStore(db *sql.DB) *PostgresGoalStore {
+	return &PostgresGoalStore{
+		db: db,
+	}
+}
+
+type GoalStore interface {
+	CreateGoal(goal *Goal, today time.Time) (*Goal, error)
+	UpdateGoal(goal *Goal, today time.Time) (*Goal, error)
+	ListGoals(ledgerID int, today time.Time) ([]*Goal, error)
+	DeleteGoal(ledgerID int, id int64) (bool, error)
+	AddContribution(contribution *GoalContribution) (*GoalContribution, error)
+	ListContributions(ledgerID int, goalID int64) ([]*GoalContribution, error)
+	DeleteContribution(ledgerID int, goalID int64, id int64) (bool, error)
+	GoalsSummary(ledgerID int, today time.Time) (*GoalsSummary, error)
+}
+
+// checkGoalPaymentMethod makes sure a linked payment method belongs to the
+// goal's ledger.
+func checkGoalPaymentMethod(ctx context.Context, db execQueryer, goal *Goal) error {
+	if goal.PaymentMethodID == nil {
+		return nil
+	}
+
+	var exists bool
+	err := db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM payment_methods pm WHERE pm.id = $1 AND pm.ledger_id = $2)`,
+		*goal.PaymentMethodID,
+		goal.LedgerID,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return ErrPaymentMethodNotFound
+	}
+
+	return nil
+}
+
+func (pg *PostgresGoalStore) CreateGoal(goal *Goal, today time.Time) (*Goal, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := checkGoalPaymentMethod(ctx, pg.db, goal)
+	if err != nil {
+		return nil, err
+	}
+
+	if goal.StartDate == "" {
+		goal.StartDate = today.Format(dateLayout)
+	}
+
+	query := `
+		INSERT INTO goals (ledger_id, user_id, payment_method_id, name, target_amount, start_date, target_date)
+		    VALUES ($1, $2, $3, $4, $5, $6::date, $7::date)
+		RETURNING id`
+
+	err = pg.db.QueryRowContext(
+		ctx,
+		query,
+		goal.LedgerID,
+		goal.UserID,
+		goal.PaymentMethodID,
+		goal.Name,
+		goal.TargetAmount,
+		goal.StartDate,
+		goal.TargetDate,
+	).Scan(&goal.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	goal.Saved = 0
+	err = goal.computeProgress(today)
+	if err != nil {
+		return nil, err
+	}
+
+	return goal, nil
+}
+
+func (pg *PostgresGoalStore) UpdateGoal(goal *Goal, today time.Time) (*Goal, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := checkGoalPaymentMethod(ctx, pg.db, goal)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+	UPDATE goals
+	SET
+		payment_method_id = $1,
+		name = $2,
+		target_amount = $3,
+		start_date = COALESCE(NULLIF($4::text, '')::date, start_date),
+		target_date = $5::date,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = $6 AND ledger_id = $7
+	RETURNING
+		TO_CHAR(start_date, 'YYYY-MM-DD'),
+		(SELECT COALESCE(SUM(gc.amount), 0) FROM goal_contributions gc WHERE gc.goal_id = goals.id)
+	`
+
+	err = pg.db.QueryRowContext(
+		ctx,
+		query,
+		goal.PaymentMethodID,
+		goal.Name,
+		goal.TargetAmount,
+		goal.StartDate,
+		goal.TargetDate,
+		goal.ID,
+		goal.LedgerID,
+	).Scan(&goal.StartDate, &goal.Saved)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = goal.computeProgress(today)
+	if err != nil {
+		return nil, err
+	}
+
+	return goal, nil
+}
+
+func (pg *PostgresGoalStore) ListGoals(ledgerID int, today time.Time) ([]*Goal, error) {
+	goals := []*Goal{}
+
+	query := `
+		SELECT
+			g.id,
+			g.name,
+			g.target_amount,
+			TO_CHAR(g.start_date, 'YYYY-MM-DD'),
+			TO_CHAR(g.target_date, 'YYYY-MM-DD'),
+			g.payment_method_id,
+			COALESCE(SUM(gc.amount), 0)
+		FROM goals g
+		LEFT JOIN goal_contributions gc ON gc.goal_id = g.id
+		WHERE g.ledger_id = $1
+		GROUP BY g.id
+		ORDER BY g.target_date, g.id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		goal := &Goal{LedgerID: ledgerID}
+		var paymentMethodID sql.NullInt64
+		err := rows.Scan(
+			&goal.ID,
+			&goal.Name,
+			&goal.TargetAmount,
+			&goal.StartDate,
+			&goal.TargetDate,
+			&paymentMethodID,
+			&goal.Saved,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if paymentMethodID.Valid {
+			id := int(paymentMethodID.Int64)
+			goal.PaymentMethodID = &id
+		}
+
+		err = goal.computeProgress(today)
+		if err != nil {
+			return nil, err
+		}
+
+		goals = append(goals, goal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return goals, nil
+}
+
+func (pg *PostgresGoalStore) DeleteGoal(ledgerID int, id int64) (bool, error) {
+	query := `
+		DELETE FROM goals
+		WHERE id = $1 AND ledger_id = $2`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (pg *PostgresGoalStore) AddContribution(contribution *GoalContribution) (*GoalContribution, error) {
+	query := `
+		INSERT INTO goal_contributions (goal_id, user_id, amount, contribution_date, note)
+		SELECT g.id, $3, $4, $5::date, $6
+		FROM goals g
+		WHERE g.id = $1 AND g.ledger_id = $2
+		RETURNING id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pg.db.QueryRowContext(
+		ctx,
+		query,
+		contribution.GoalID,
+		contribution.LedgerID,
+		contribution.UserID,
+		contribution.Amount,
+		contribution.ContributionDate,
+		contribution.Note,
+	).Scan(&contribution.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrGoalNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return contribution, nil
+}
+
+func (pg *PostgresGoalStore) ListContributions(ledgerID int, goalID int64) ([]*GoalContribution, error) {
+	contributions := []*GoalContribution{}
+
+	query := `
+		SELECT gc.id, gc.goal_id, gc.amount, TO_CHAR(gc.contribution_date, 'YYYY-MM-DD'), gc.note
+		FROM goal_contributions gc
+		INNER JOIN goals g ON g.id = gc.goal_id
+		WHERE gc.goal_id = $1 AND g.ledger_id = $2
+		ORDER BY gc.contribution_date DESC, gc.id DESC`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, goalID, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		contribution := &GoalContribution{LedgerID: ledgerID}
+		err := rows.Scan(
+			&contribution.ID,
+			&contribution.GoalID,
+			&contribution.Amount,
+			&contribution.ContributionDate,
+			&contribution.Note,
+		)
+		if err != nil {
+			return nil, err
+		}
+		contributions = append(contributions, contribution)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contributions, nil
+}
+
+func (pg *PostgresGoalStore) DeleteContribution(ledgerID int, goalID int64, id int64) (bool, error) {
+	query := `
+		DELETE FROM goal_contributions gc
+		USING goals g
+		WHERE gc.id = $1 AND gc.goal_id = $2 AND g.id = gc.goal_id AND g.ledger_id = $3`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, id, goalID, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (pg *PostgresGoalStore) GoalsSummary(ledgerID int, today time.Time) (*GoalsSummary, error) {
+	goals, err := pg.ListGoals(ledgerID, today)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &GoalsSummary{Count: len(goals)}
+	for _, goal := range goals {
+		summary.TargetTotal += goal.TargetAmount
+		summary.SavedTotal += goal.Saved
+		summary.RemainingTotal += goal.Remaining
+		summary.RequiredPerMonth += goal.RequiredPerMonth
+
+		switch goal.Status {
+		case GoalStatusCompleted:
+			summary.Completed++
+		case GoalStatusOnTrack:
+			summary.OnTrack++
+		case GoalStatusBehind:
+			summary.Behind++
+		case GoalStatusOverdue:
+			summary.Overdue++
+		}
+	}
+
+	summary.TargetTotal = roundAmount(summary.TargetTotal)
+	summary.SavedTotal = roundAmount(summary.SavedTotal)
+	summary.RemainingTotal = roundAmount(summary.RemainingTotal)
+	summary.RequiredPerMonth = roundAmount(summary.RequiredPerMonth)
+
+	return summary, nil
+}
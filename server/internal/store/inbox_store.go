@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+)
+
+type InboxItem struct {
+	ID        int     `json:"id"`
+	LedgerID  *int    `json:"ledger_id"`
+	Kind      string  `json:"kind"`
+	Title     string  `json:"title"`
+	Body      string  `json:"body"`
+	ReadAt    *string `json:"read_at"`
+	CreatedAt string  `json:"created_at"`
+}
+
+type InboxQueryParams struct {
+	Unread *bool `schema:"unread"`
+	Limit  *int  `schema:"limit"`
+}
+
+type PostgresInboxStore struct {
+	db *sql.DB
+}
+
+func NewPostgresInboxStore(db *sql.DB) *PostgresInboxStore {
+	return &PostgresInboxStore{
+		db: db,
+	}
+}
+
+type InboxStore interface {
+	CreateInboxItems(item *InboxItem, userIDs []int) error
+	ListInboxItems(userID int, queryParams InboxQueryParams) ([]*InboxItem, error)
+	MarkInboxItemRead(userID int, id int64) (bool, error)
+}
+
+// CreateInboxItems delivers a copy of item to the inbox of every user.
+func (pg *PostgresInboxStore) CreateInboxItems(item *InboxItem, userIDs []int) error {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO inbox_items (user_id, ledger_id, kind, title, body)
+		    VALUES ($1, $2, $3, $4, $5)`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, userID := range userIDs {
+		_, err = tx.ExecContext(ctx, query, userID, item.LedgerID, item.Kind, item.Title, item.Body)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (pg *PostgresInboxStore) ListInboxItems(userID int, queryParams InboxQueryParams) ([]*InboxItem, error) {
+	items := []*InboxItem{}
+
+	limit := 50
+	if queryParams.Limit != nil && *queryParams.Limit > 0 {
+		limit = *queryParams.Limit
+	}
+
+	query := `
+		SELECT
+			i.id,
+			i.ledger_id,
+			i.kind,
+			i.title,
+			i.body,
+			TO_CHAR(i.read_at, 'YYYY-MM-DD"T"HH24:MI:SSOF'),
+			TO_CHAR(i.created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')
+		FROM inbox_items i
+		WHERE i.user_id = $1 AND ($2::boolean IS NULL OR (i.read_at IS NULL) = $2)
+		ORDER BY i.created_at DESC, i.id DESC
+		LIMIT $3`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, userID, queryParams.Unread, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var item InboxItem
+		var ledgerID sql.NullInt64
+		var readAt sql.NullString
+		err := rows.Scan(&item.ID, &ledgerID, &item.Kind, &item.Title, &item.Body, &readAt, &item.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if ledgerID.Valid {
+			id := int(ledgerID.Int64)
+			item.LedgerID = &id
+		}
+
+		if readAt.Valid {
+			item.ReadAt = &readAt.String
+		}
+
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+func (pg *PostgresInboxStore) MarkInboxItemRead(userID int, id int64) (bool, error) {
+	query := `
+		UPDATE inbox_items
+		SET read_at = COALESCE(read_at, CURRENT_TIMESTAMP)
+		WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
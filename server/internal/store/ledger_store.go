@@ -0,0 +1,479 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	LedgerRoleOwner  = "owner"
+	LedgerRoleEditor = "editor"
+	LedgerRoleViewer = "viewer"
+)
+
+var ledgerRoleRanks = map[string]int{
+	LedgerRoleViewer: 1,
+	LedgerRoleEditor: 2,
+	LedgerRoleOwner:  3,
+}
+
+// IsValidLedgerRole reports whether role is one of the known membership roles.
+func IsValidLedgerRole(role string) bool {
+	_, ok := ledgerRoleRanks[role]
+	return ok
+}
+
+// LedgerRoleAllows reports whether a member with role may act as required.
+func LedgerRoleAllows(role string, required string) bool {
+	return ledgerRoleRanks[role] >= ledgerRoleRanks[required] && ledgerRoleRanks[role] > 0
+}
+
+var (
+	ErrLastLedgerOwner = errors.New("ledger must keep at least one owner")
+	ErrInvalidInvite   = errors.New("invite is invalid or expired")
+)
+
+type Ledger struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Role      string `json:"role"`
+	CreatedBy int    `json:"-"`
+}
+
+type LedgerMember struct {
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+type LedgerInvite struct {
+	TokenString string    `json:"token"`
+	Hash        []byte    `json:"-"`
+	LedgerID    int       `json:"ledger_id"`
+	Email       string    `json:"email"`
+	Role        string    `json:"role"`
+	InvitedBy   int       `json:"-"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+func GenerateLedgerInvite(ledgerID int, email string, role string, invitedBy int, ttl time.Duration) (*LedgerInvite, error) {
+	invite := &LedgerInvite{
+		LedgerID:  ledgerID,
+		Email:     strings.ToLower(email),
+		Role:      role,
+		InvitedBy: invitedBy,
+		Expiry:    time.Now().Add(ttl),
+	}
+
+	emptyBytes := make([]byte, 32)
+	_, err := rand.Read(emptyBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	invite.TokenString = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
+	hash := sha256.Sum256([]byte(invite.TokenString))
+	invite.Hash = hash[:]
+	return invite, nil
+}
+
+type PostgresLedgerStore struct {
+	db *sql.DB
+}
+
+func NewPostgresLedgerStore(db *sql.DB) *PostgresLedgerStore {
+	return &PostgresLedgerStore{
+		db: db,
+	}
+}
+
+type LedgerStore interface {
+	CreateLedger(ledger *Ledger) (*Ledger, error)
+	ListLedgersForUser(userID int) ([]*Ledger, error)
+	GetLedgerForUser(ledgerID int, userID int) (*Ledger, error)
+	GetDefaultLedgerForUser(userID int) (*Ledger, error)
+	ListLedgerMembers(ledgerID int) ([]*LedgerMember, error)
+	UpdateLedgerMemberRole(ledgerID int, userID int, role string) (*LedgerMember, error)
+	RemoveLedgerMember(ledgerID int, userID int) (bool, error)
+	CreateLedgerInvite(ledgerID int, email string, role string, invitedBy int, ttl time.Duration) (*LedgerInvite, error)
+	AcceptLedgerInvite(tokenString string, user *User) (*Ledger, error)
+}
+
+// createLedger inserts a ledger owned by ledger.CreatedBy inside an existing
+// transaction, so that signup can create the personal ledger atomically.
+func createLedger(ctx context.Context, tx *sql.Tx, ledger *Ledger) (*Ledger, error) {
+	query := `
+		INSERT INTO ledgers (name, created_by)
+		    VALUES ($1, $2)
+		RETURNING
+		    id`
+
+	err := tx.QueryRowContext(ctx, query, ledger.Name, ledger.CreatedBy).Scan(&ledger.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	memberQuery := `
+		INSERT INTO ledger_members (ledger_id, user_id, role)
+		    VALUES ($1, $2, $3)`
+
+	_, err = tx.ExecContext(ctx, memberQuery, ledger.ID, ledger.CreatedBy, LedgerRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger.Role = LedgerRoleOwner
+	return ledger, nil
+}
+
+func (pg *PostgresLedgerStore) CreateLedger(ledger *Ledger) (*Ledger, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ledger, err = createLedger(ctx, tx, ledger)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+func (pg *PostgresLedgerStore) ListLedgersForUser(userID int) ([]*Ledger, error) {
+	ledgers := []*Ledger{}
+
+	query := `
+		SELECT l.id, l.name, lm.role
+		FROM ledgers l
+		INNER JOIN ledger_members lm ON lm.ledger_id = l.id
+		WHERE lm.user_id = $1
+		ORDER BY lm.created_at, l.id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var ledger Ledger
+		err := rows.Scan(&ledger.ID, &ledger.Name, &ledger.Role)
+		if err != nil {
+			return nil, err
+		}
+		ledgers = append(ledgers, &ledger)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ledgers, nil
+}
+
+func (pg *PostgresLedgerStore) GetLedgerForUser(ledgerID int, userID int) (*Ledger, error) {
+	ledger := &Ledger{}
+
+	query := `
+		SELECT l.id, l.name, lm.role
+		FROM ledgers l
+		INNER JOIN ledger_members lm ON lm.ledger_id = l.id
+		WHERE l.id = $1 AND lm.user_id = $2`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pg.db.QueryRowContext(ctx, query, ledgerID, userID).Scan(&ledger.ID, &ledger.Name, &ledger.Role)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+func (pg *PostgresLedgerStore) GetDefaultLedgerForUser(userID int) (*Ledger, error) {
+	ledger := &Ledger{}
+
+	// The ledger a user joined first is their personal one created at signup
+	query := `
+		SELECT l.id, l.name, lm.role
+		FROM ledgers l
+		INNER JOIN ledger_members lm ON lm.ledger_id = l.id
+		WHERE lm.user_id = $1
+		ORDER BY lm.created_at, l.id
+		LIMIT 1`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pg.db.QueryRowContext(ctx, query, userID).Scan(&ledger.ID, &ledger.Name, &ledger.Role)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
+
+func (pg *PostgresLedgerStore) ListLedgerMembers(ledgerID int) ([]*LedgerMember, error) {
+	members := []*LedgerMember{}
+
+	query := `
+		SELECT u.id, u.name, u.email, lm.role
+		FROM ledger_members lm
+		INNER JOIN users u ON u.id = lm.user_id
+		WHERE lm.ledger_id = $1
+		ORDER BY lm.created_at, u.id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var member LedgerMember
+		err := rows.Scan(&member.UserID, &member.Name, &member.Email, &member.Role)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, &member)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+// ensureAnotherOwner guards against a ledger losing its last owner when the
+// given member is demoted or removed.
+func ensureAnotherOwner(ctx context.Context, tx *sql.Tx, ledgerID int, userID int) error {
+	var otherOwners int
+
+	query := `
+		SELECT COUNT(*)
+		FROM ledger_members
+		WHERE ledger_id = $1 AND user_id <> $2 AND role = $3`
+
+	err := tx.QueryRowContext(ctx, query, ledgerID, userID, LedgerRoleOwner).Scan(&otherOwners)
+	if err != nil {
+		return err
+	}
+
+	if otherOwners == 0 {
+		return ErrLastLedgerOwner
+	}
+
+	return nil
+}
+
+func (pg *PostgresLedgerStore) UpdateLedgerMemberRole(ledgerID int, userID int, role string) (*LedgerMember, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if role != LedgerRoleOwner {
+		err = ensureAnotherOwner(ctx, tx, ledgerID, userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := `
+	WITH updated AS (
+		UPDATE ledger_members
+		SET role = $1
+		WHERE ledger_id = $2 AND user_id = $3
+		RETURNING user_id, role
+	)
+	SELECT u.id, u.name, u.email, updated.role
+	FROM updated
+	INNER JOIN users u ON u.id = updated.user_id
+	`
+
+	member := &LedgerMember{}
+	err = tx.QueryRowContext(ctx, query, role, ledgerID, userID).Scan(
+		&member.UserID,
+		&member.Name,
+		&member.Email,
+		&member.Role,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+func (pg *PostgresLedgerStore) RemoveLedgerMember(ledgerID int, userID int) (bool, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = ensureAnotherOwner(ctx, tx, ledgerID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	query := `
+		DELETE FROM ledger_members
+		WHERE ledger_id = $1 AND user_id = $2`
+
+	result, err := tx.ExecContext(ctx, query, ledgerID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+func (pg *PostgresLedgerStore) CreateLedgerInvite(ledgerID int, email string, role string, invitedBy int, ttl time.Duration) (*LedgerInvite, error) {
+	invite, err := GenerateLedgerInvite(ledgerID, email, role, invitedBy, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO ledger_invites (hash, ledger_id, email, role, invited_by, expiry)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err = pg.db.Exec(query, invite.Hash, invite.LedgerID, invite.Email, invite.Role, invite.InvitedBy, invite.Expiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return invite, nil
+}
+
+func (pg *PostgresLedgerStore) AcceptLedgerInvite(tokenString string, user *User) (*Ledger, error) {
+	tokenHash := sha256.Sum256([]byte(tokenString))
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+		UPDATE ledger_invites
+		SET accepted_at = CURRENT_TIMESTAMP
+		WHERE hash = $1
+			AND email = LOWER($2)
+			AND accepted_at IS NULL
+			AND expiry > CURRENT_TIMESTAMP
+		RETURNING ledger_id, role
+	`
+
+	var ledgerID int
+	var role string
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], user.Email).Scan(&ledgerID, &role)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidInvite
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Accepting never downgrades an existing membership
+	memberQuery := `
+		INSERT INTO ledger_members (ledger_id, user_id, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (ledger_id, user_id) DO NOTHING
+	`
+
+	_, err = tx.ExecContext(ctx, memberQuery, ledgerID, user.ID, role)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := &Ledger{}
+	ledgerQuery := `
+		SELECT l.id, l.name, lm.role
+		FROM ledgers l
+		INNER JOIN ledger_members lm ON lm.ledger_id = l.id
+		WHERE l.id = $1 AND lm.user_id = $2`
+
+	err = tx.QueryRowContext(ctx, ledgerQuery, ledgerID, user.ID).Scan(&ledger.ID, &ledger.Name, &ledger.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger, nil
+}
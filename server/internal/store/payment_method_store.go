@@ -4,22 +4,68 @@ import (
 	"cha-ching-server/internal/utils"
 	"context"
 	"database/sql"
+	"errors"
 )
 
+var (
+	ErrPaymentMethodNotFound      = errors.New("payment method does not exist in the ledger")
+	ErrInvalidTargetPaymentMethod = errors.New("target must be another payment method in the same ledger")
+)
+
+const (
+	PaymentMethodKindUPI        = "upi"
+	PaymentMethodKindCreditCard = "credit_card"
+	PaymentMethodKindDebitCard  = "debit_card"
+	PaymentMethodKindCash       = "cash"
+	PaymentMethodKindNetBanking = "net_banking"
+	PaymentMethodKindWallet     = "wallet"
+)
+
+// paymentMethodKindNames are the display names used when stats are grouped
+// by kind.
+var paymentMethodKindNames = map[string]string{
+	PaymentMethodKindUPI:        "UPI",
+	PaymentMethodKindCreditCard: "Credit card",
+	PaymentMethodKindDebitCard:  "Debit card",
+	PaymentMethodKindCash:       "Cash",
+	PaymentMethodKindNetBanking: "Net banking",
+	PaymentMethodKindWallet:     "Wallet",
+}
+
+func IsValidPaymentMethodKind(kind string) bool {
+	_, ok := paymentMethodKindNames[kind]
+	return ok
+}
+
 type PaymentMethod struct {
-	ID     int    `json:"id"`
-	Name   string `json:"name"`
-	UserID int    `json:"-"`
+	ID              int     `json:"id"`
+	Name            string  `json:"name"`
+	Kind            *string `json:"kind"`
+	LastFour        *string `json:"last_four"`
+	Issuer          *string `json:"issuer"`
+	BillingCycleDay *int    `json:"billing_cycle_day"`
+	PaymentDueDay   *int    `json:"payment_due_day"`
+	Archived        bool    `json:"archived"`
+	UserID          int     `json:"-"`
+	LedgerID        int     `json:"-"`
+}
+
+type PaymentMethodQueryParams struct {
+	IncludeArchived *bool `schema:"include_archived"`
 }
 
 type PaymentMethodStatsQueryParams struct {
 	StartDate *string `schema:"start_date"`
 	EndDate   *string `schema:"end_date"`
+	GroupBy   *string `schema:"group_by"`
 }
 
+// PaymentMethodStats is one payment method, or one kind of payment method
+// when grouped by kind, in which case ID is zero.
 type PaymentMethodStats struct {
-	ID          int     `json:"id"`
+	ID          int     `json:"id,omitempty"`
 	Name        string  `json:"name"`
+	Kind        *string `json:"kind"`
 	Count       int     `json:"count"`
 	TotalAmount float64 `json:"total_amount"`
 }
@@ -36,8 +82,11 @@ func NewPostgresPaymentMethodStore(db *sql.DB) *PostgresPaymentMethodStore {
 
 type PaymentMethodStore interface {
 	CreatePaymentMethod(paymentMethod *PaymentMethod) (*PaymentMethod, error)
-	ListPaymentMethods(userID int) ([]*PaymentMethod, error)
-	PaymentMethodStats(userID int, queryParams PaymentMethodStatsQueryParams) ([]*PaymentMethodStats, error)
+	UpdatePaymentMethod(paymentMethod *PaymentMethod) (*PaymentMethod, error)
+	ListPaymentMethods(ledgerID int, queryParams PaymentMethodQueryParams) ([]*PaymentMethod, error)
+	SetPaymentMethodArchived(ledgerID int, id int64, archived bool) (bool, error)
+	DeletePaymentMethod(ledgerID int, id int64, reassignTo int) (bool, error)
+	PaymentMethodStats(ledgerID int, queryParams PaymentMethodStatsQueryParams) ([]*PaymentMethodStats, error)
 }
 
 func (pg *PostgresPaymentMethodStore) CreatePaymentMethod(paymentMethod *PaymentMethod) (*PaymentMethod, error) {
@@ -49,13 +98,24 @@ func (pg *PostgresPaymentMethodStore) CreatePaymentMethod(paymentMethod *Payment
 	defer tx.Rollback()
 
 	query := `
-		INSERT INTO payment_methods (user_id, name)
-		    VALUES ($1, $2) 
+		INSERT INTO payment_methods (user_id, ledger_id, name, kind, last_four, issuer, billing_cycle_day, payment_due_day)
+		    VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		RETURNING id`
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	err = tx.QueryRowContext(ctx, query, paymentMethod.UserID, paymentMethod.Name).Scan(&paymentMethod.ID)
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		paymentMethod.UserID,
+		paymentMethod.LedgerID,
+		paymentMethod.Name,
+		paymentMethod.Kind,
+		paymentMethod.LastFour,
+		paymentMethod.Issuer,
+		paymentMethod.BillingCycleDay,
+		paymentMethod.PaymentDueDay,
+	).Scan(&paymentMethod.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -68,18 +128,61 @@ func (pg *PostgresPaymentMethodStore) CreatePaymentMethod(paymentMethod *Payment
 	return paymentMethod, nil
 }
 
-func (pg *PostgresPaymentMethodStore) ListPaymentMethods(userID int) ([]*PaymentMethod, error) {
+func (pg *PostgresPaymentMethodStore) UpdatePaymentMethod(paymentMethod *PaymentMethod) (*PaymentMethod, error) {
+	query := `
+	UPDATE payment_methods
+	SET
+		name = $1,
+		kind = $2,
+		last_four = $3,
+		issuer = $4,
+		billing_cycle_day = $5,
+		payment_due_day = $6,
+		updated_at = CURRENT_TIMESTAMP
+	WHERE id = $7 AND ledger_id = $8
+	RETURNING archived_at IS NOT NULL
+	`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := pg.db.QueryRowContext(
+		ctx,
+		query,
+		paymentMethod.Name,
+		paymentMethod.Kind,
+		paymentMethod.LastFour,
+		paymentMethod.Issuer,
+		paymentMethod.BillingCycleDay,
+		paymentMethod.PaymentDueDay,
+		paymentMethod.ID,
+		paymentMethod.LedgerID,
+	).Scan(&paymentMethod.Archived)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return paymentMethod, nil
+}
+
+// ListPaymentMethods hides archived payment methods unless they are asked
+// for.
+func (pg *PostgresPaymentMethodStore) ListPaymentMethods(ledgerID int, queryParams PaymentMethodQueryParams) ([]*PaymentMethod, error) {
 	paymentMethods := []*PaymentMethod{}
 
 	query := `
-		SELECT pm.id, pm.name
+		SELECT pm.id, pm.name, pm.kind, pm.last_four, pm.issuer, pm.billing_cycle_day, pm.payment_due_day, pm.archived_at IS NOT NULL
 		FROM payment_methods pm
-		WHERE pm.user_id = $1
+		WHERE pm.ledger_id = $1 AND ($2::boolean IS TRUE OR pm.archived_at IS NULL)
 		ORDER BY pm.id`
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	rows, err := pg.db.QueryContext(ctx, query, userID)
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, queryParams.IncludeArchived)
 	if err != nil {
 		return nil, err
 	}
@@ -88,10 +191,40 @@ func (pg *PostgresPaymentMethodStore) ListPaymentMethods(userID int) ([]*Payment
 
 	for rows.Next() {
 		var paymentMethod PaymentMethod
-		err := rows.Scan(&paymentMethod.ID, &paymentMethod.Name)
+		var kind, lastFour, issuer sql.NullString
+		var billingCycleDay, paymentDueDay sql.NullInt64
+		err := rows.Scan(
+			&paymentMethod.ID,
+			&paymentMethod.Name,
+			&kind,
+			&lastFour,
+			&issuer,
+			&billingCycleDay,
+			&paymentDueDay,
+			&paymentMethod.Archived,
+		)
 		if err != nil {
 			return nil, err
 		}
+
+		if kind.Valid {
+			paymentMethod.Kind = &kind.String
+		}
+		if lastFour.Valid {
+			paymentMethod.LastFour = &lastFour.String
+		}
+		if issuer.Valid {
+			paymentMethod.Issuer = &issuer.String
+		}
+		if billingCycleDay.Valid {
+			day := int(billingCycleDay.Int64)
+			paymentMethod.BillingCycleDay = &day
+		}
+		if paymentDueDay.Valid {
+			day := int(paymentDueDay.Int64)
+			paymentMethod.PaymentDueDay = &day
+		}
+
 		paymentMethods = append(paymentMethods, &paymentMethod)
 	}
 
@@ -102,28 +235,127 @@ func (pg *PostgresPaymentMethodStore) ListPaymentMethods(userID int) ([]*Payment
 	return paymentMethods, nil
 }
 
-func (pg *PostgresPaymentMethodStore) PaymentMethodStats(userID int, queryParams PaymentMethodStatsQueryParams) ([]*PaymentMethodStats, error) {
+// SetPaymentMethodArchived hides a payment method from pickers, or shows it
+// again. Its expenses and stats are kept.
+func (pg *PostgresPaymentMethodStore) SetPaymentMethodArchived(ledgerID int, id int64, archived bool) (bool, error) {
+	query := `
+		UPDATE payment_methods
+		SET
+			archived_at = CASE WHEN $1::boolean THEN COALESCE(archived_at, CURRENT_TIMESTAMP) END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND ledger_id = $3`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := pg.db.ExecContext(ctx, query, archived, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// DeletePaymentMethod moves every expense of a payment method to reassignTo
+// and then deletes it.
+func (pg *PostgresPaymentMethodStore) DeletePaymentMethod(ledgerID int, id int64, reassignTo int) (bool, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	lockQuery := `SELECT pm.id FROM payment_methods pm WHERE pm.id = $1 AND pm.ledger_id = $2 FOR UPDATE`
+
+	var lockedID int64
+	err = tx.QueryRowContext(ctx, lockQuery, id, ledgerID).Scan(&lockedID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	if int64(reassignTo) == id {
+		return false, ErrInvalidTargetPaymentMethod
+	}
+
+	err = tx.QueryRowContext(ctx, lockQuery, reassignTo, ledgerID).Scan(&lockedID)
+	if err == sql.ErrNoRows {
+		return false, ErrInvalidTargetPaymentMethod
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE expenses SET payment_method_id = $1, updated_at = CURRENT_TIMESTAMP WHERE payment_method_id = $2`, reassignTo, id)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM payment_methods WHERE id = $1 AND ledger_id = $2`, id, ledgerID)
+	if err != nil {
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (pg *PostgresPaymentMethodStore) PaymentMethodStats(ledgerID int, queryParams PaymentMethodStatsQueryParams) ([]*PaymentMethodStats, error) {
 	paymentMethods := []*PaymentMethodStats{}
 
+	groupByKind := queryParams.GroupBy != nil && *queryParams.GroupBy == "kind"
+
 	query := `
-	SELECT pm.id, pm.name, COALESCE(SUM(e.amount), 0) as total_amount, COUNT(e.id) as count
+	SELECT pm.id, pm.name, pm.kind, COALESCE(SUM(e.amount), 0) as total_amount, COUNT(e.id) as count
 	FROM payment_methods pm
 	LEFT JOIN expenses e
 	ON pm.id = e.payment_method_id
-		AND e.user_id = $1 
-		AND ($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata')) 
-		AND ($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata')) 
-	WHERE 
-		pm.user_id = $1 
-	GROUP BY pm.id, pm.name
+		AND e.ledger_id = $1
+		AND ($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata'))
+		AND ($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata'))
+	WHERE
+		pm.ledger_id = $1
+	GROUP BY pm.id, pm.name, pm.kind
 	ORDER BY total_amount DESC`
 
+	if groupByKind {
+		query = `
+	SELECT 0, '', pm.kind, COALESCE(SUM(e.amount), 0) as total_amount, COUNT(e.id) as count
+	FROM payment_methods pm
+	LEFT JOIN expenses e
+	ON pm.id = e.payment_method_id
+		AND e.ledger_id = $1
+		AND ($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata'))
+		AND ($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata'))
+	WHERE
+		pm.ledger_id = $1
+	GROUP BY pm.kind
+	ORDER BY total_amount DESC`
+	}
+
 	startDate, endDate := utils.FormatStartEndDate(queryParams.StartDate, queryParams.EndDate)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	rows, err := pg.db.QueryContext(ctx, query, userID, startDate, endDate)
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, startDate, endDate)
 	if err != nil {
 		return nil, err
 	}
@@ -132,15 +364,29 @@ func (pg *PostgresPaymentMethodStore) PaymentMethodStats(userID int, queryParams
 
 	for rows.Next() {
 		var paymentMethod PaymentMethodStats
+		var kind sql.NullString
 		err := rows.Scan(
 			&paymentMethod.ID,
 			&paymentMethod.Name,
+			&kind,
 			&paymentMethod.TotalAmount,
 			&paymentMethod.Count,
 		)
 		if err != nil {
 			return nil, err
 		}
+
+		if kind.Valid {
+			paymentMethod.Kind = &kind.String
+		}
+
+		if groupByKind {
+			paymentMethod.Name = "Other"
+			if kind.Valid {
+				paymentMethod.Name = paymentMethodKindNames[kind.String]
+			}
+		}
+
 		paymentMethods = append(paymentMethods, &paymentMethod)
 	}
 
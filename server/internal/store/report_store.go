@@ -0,0 +1,504 @@
+package store
+
+import (
+	"cha-ching-server/internal/utils"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ReportFormatJSON = "json"
+	ReportFormatCSV  = "csv"
+)
+
+func IsValidReportFormat(format string) bool {
+	return format == ReportFormatJSON || format == ReportFormatCSV
+}
+
+type PivotQueryParams struct {
+	StartDate *string `schema:"start_date"`
+	EndDate   *string `schema:"end_date"`
+	Format    string  `schema:"format"`
+}
+
+// PivotColumn is one payment method column. ID is nil for expenses without
+// a payment method.
+type PivotColumn struct {
+	ID          *int    `json:"id"`
+	Name        string  `json:"name"`
+	TotalAmount float64 `json:"total_amount"`
+	Count       int     `json:"count"`
+}
+
+// PivotRow is one category row. Totals and Counts line up with the columns
+// of the pivot.
+type PivotRow struct {
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	Totals      []float64 `json:"totals"`
+	Counts      []int     `json:"counts"`
+	TotalAmount float64   `json:"total_amount"`
+	Count       int       `json:"count"`
+}
+
+// CategoryPaymentMethodPivot is spend by category rows and payment method
+// columns, each ordered by total, with row, column and grand totals.
+type CategoryPaymentMethodPivot struct {
+	StartDate   *string        `json:"start_date"`
+	EndDate     *string        `json:"end_date"`
+	Columns     []*PivotColumn `json:"columns"`
+	Rows        []*PivotRow    `json:"rows"`
+	TotalAmount float64        `json:"total_amount"`
+	Count       int            `json:"count"`
+}
+
+// Records lays the pivot out as a CSV table with a total column and a total
+// row.
+func (p *CategoryPaymentMethodPivot) Records() [][]string {
+	header := []string{"Category"}
+	for _, column := range p.Columns {
+		header = append(header, column.Name)
+	}
+	header = append(header, "Total")
+
+	records := [][]string{header}
+	for _, row := range p.Rows {
+		record := []string{row.Name}
+		for _, total := range row.Totals {
+			record = append(record, formatAmount(total))
+		}
+		record = append(record, formatAmount(row.TotalAmount))
+		records = append(records, record)
+	}
+
+	footer := []string{"Total"}
+	for _, column := range p.Columns {
+		footer = append(footer, formatAmount(column.TotalAmount))
+	}
+	footer = append(footer, formatAmount(p.TotalAmount))
+
+	return append(records, footer)
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(amount, 'f', 2, 64)
+}
+
+// MaxReportDimensions caps how many dimensions a built report can group by.
+const MaxReportDimensions = 3
+
+// reportDimension is the SQL of one whitelisted group by dimension. $tz
+// stands for the timezone expense dates are read in, and is only bound when
+// the query uses it.
+type reportDimension struct {
+	selectExpr string
+	groupExprs []string
+	orderExpr  string
+}
+
+// ErrTagDimension is returned for a tag dimension. Grouping by tag was asked
+// for, but expenses have no tags to group by until tagging exists.
+var ErrTagDimension = errors.New("dimension tag is not supported yet, expenses have no tags")
+
+// reportDimensions are the dimensions a built report can group by. There is
+// no tag dimension, see ErrTagDimension.
+var reportDimensions = map[string]reportDimension{
+	"category": {
+		selectExpr: "COALESCE(c.name, 'None')::text",
+		groupExprs: []string{"c.id", "c.name"},
+		orderExpr:  "c.name",
+	},
+	"payment_method": {
+		selectExpr: "COALESCE(pm.name, 'None')::text",
+		groupExprs: []string{"pm.id", "pm.name"},
+		orderExpr:  "pm.name",
+	},
+	"day": {
+		selectExpr: "TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'YYYY-MM-DD')",
+		groupExprs: []string{"TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'YYYY-MM-DD')"},
+		orderExpr:  "TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'YYYY-MM-DD')",
+	},
+	"week": {
+		selectExpr: "TO_CHAR(DATE_TRUNC('week', e.expense_date AT TIME ZONE $tz::text), 'YYYY-MM-DD')",
+		groupExprs: []string{"DATE_TRUNC('week', e.expense_date AT TIME ZONE $tz::text)"},
+		orderExpr:  "DATE_TRUNC('week', e.expense_date AT TIME ZONE $tz::text)",
+	},
+	"month": {
+		selectExpr: "TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'YYYY-MM')",
+		groupExprs: []string{"TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'YYYY-MM')"},
+		orderExpr:  "TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'YYYY-MM')",
+	},
+	"weekday": {
+		selectExpr: "TRIM(TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'Day'))",
+		groupExprs: []string{"EXTRACT(ISODOW FROM e.expense_date AT TIME ZONE $tz::text)", "TRIM(TO_CHAR(e.expense_date AT TIME ZONE $tz::text, 'Day'))"},
+		orderExpr:  "EXTRACT(ISODOW FROM e.expense_date AT TIME ZONE $tz::text)",
+	},
+	"title": {
+		selectExpr: "e.title::text",
+		groupExprs: []string{"e.title"},
+		orderExpr:  "e.title",
+	},
+}
+
+// reportMeasures are the aggregates a built report can compute. Every
+// measure is read back as a float.
+var reportMeasures = map[string]string{
+	"sum":   "COALESCE(SUM(e.amount), 0)::float8",
+	"count": "COUNT(e.id)::float8",
+	"avg":   "COALESCE(ROUND(AVG(e.amount), 2), 0)::float8",
+	"min":   "COALESCE(MIN(e.amount), 0)::float8",
+	"max":   "COALESCE(MAX(e.amount), 0)::float8",
+}
+
+func IsValidReportDimension(dimension string) bool {
+	_, ok := reportDimensions[dimension]
+	return ok
+}
+
+func IsValidReportMeasure(measure string) bool {
+	_, ok := reportMeasures[measure]
+	return ok
+}
+
+type ReportQueryParams struct {
+	Dimensions      []string `schema:"dimension"`
+	Measures        []string `schema:"measure"`
+	StartDate       *string  `schema:"start_date"`
+	EndDate         *string  `schema:"end_date"`
+	CategoryID      *int     `schema:"category_id"`
+	PaymentMethodID *int     `schema:"payment_method_id"`
+	MinAmount       *float64 `schema:"min_amount"`
+	MaxAmount       *float64 `schema:"max_amount"`
+	Title           *string  `schema:"title"`
+	Sort            *string  `schema:"sort"`
+	Limit           *int     `schema:"limit"`
+	Format          string   `schema:"format"`
+	Timezone        string   `schema:"-"`
+}
+
+// ReportTable is a flat table: one column per dimension followed by one per
+// measure. Dimension cells are strings and measure cells are numbers.
+type ReportTable struct {
+	Columns []string `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+func (t *ReportTable) Records() [][]string {
+	records := [][]string{t.Columns}
+	for _, row := range t.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			switch v := value.(type) {
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+type PostgresReportStore struct {
+	db *sql.DB
+}
+
+func NewPostgresReportStore(db *sql.DB) *PostgresReportStore {
+	return &PostgresReportStore{
+		db: db,
+	}
+}
+
+type ReportStore interface {
+	CategoryPaymentMethodPivot(ledgerID int, queryParams PivotQueryParams) (*CategoryPaymentMethodPivot, error)
+	BuildReport(ledgerID int, queryParams ReportQueryParams) (*ReportTable, error)
+}
+
+// CategoryPaymentMethodPivot computes the cells and every total in a single
+// aggregation using grouping sets. GROUPING tells a rolled up row apart from
+// a group whose payment method is NULL.
+func (pg *PostgresReportStore) CategoryPaymentMethodPivot(ledgerID int, queryParams PivotQueryParams) (*CategoryPaymentMethodPivot, error) {
+	pivot := &CategoryPaymentMethodPivot{
+		StartDate: queryParams.StartDate,
+		EndDate:   queryParams.EndDate,
+		Columns:   []*PivotColumn{},
+		Rows:      []*PivotRow{},
+	}
+
+	query := `
+	SELECT
+		c.id,
+		c.name,
+		pm.id,
+		COALESCE(pm.name, 'None'),
+		GROUPING(c.id, c.name) AS category_rolled_up,
+		GROUPING(pm.id, pm.name) AS payment_method_rolled_up,
+		COALESCE(SUM(e.amount), 0) AS total_amount,
+		COUNT(e.id) AS count
+	FROM expenses e
+	INNER JOIN categories c ON c.id = e.category_id
+	LEFT JOIN payment_methods pm ON pm.id = e.payment_method_id
+	WHERE
+		e.ledger_id = $1
+		AND ($2::text IS NULL OR e.expense_date >= ($2::timestamp AT TIME ZONE 'Asia/Kolkata'))
+		AND ($3::text IS NULL OR e.expense_date <= ($3::timestamp AT TIME ZONE 'Asia/Kolkata'))
+	GROUP BY GROUPING SETS ((c.id, c.name, pm.id, pm.name), (c.id, c.name), (pm.id, pm.name), ())`
+
+	startDate, endDate := utils.FormatStartEndDate(queryParams.StartDate, queryParams.EndDate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	type pivotCell struct {
+		categoryID      int
+		paymentMethodID int
+		totalAmount     float64
+		count           int
+	}
+
+	var cells []pivotCell
+	rowByID := make(map[int]*PivotRow)
+	columnByID := make(map[int]*PivotColumn)
+
+	for rows.Next() {
+		var categoryID, paymentMethodID sql.NullInt64
+		var categoryName sql.NullString
+		var paymentMethodName string
+		var categoryRolledUp, paymentMethodRolledUp int
+		var totalAmount float64
+		var count int
+
+		err := rows.Scan(
+			&categoryID,
+			&categoryName,
+			&paymentMethodID,
+			&paymentMethodName,
+			&categoryRolledUp,
+			&paymentMethodRolledUp,
+			&totalAmount,
+			&count,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		totalAmount = roundAmount(totalAmount)
+
+		// Expenses without a payment method share the column keyed 0
+		columnKey := int(paymentMethodID.Int64)
+
+		switch {
+		case categoryRolledUp != 0 && paymentMethodRolledUp != 0:
+			pivot.TotalAmount = totalAmount
+			pivot.Count = count
+		case categoryRolledUp != 0:
+			column := &PivotColumn{Name: paymentMethodName, TotalAmount: totalAmount, Count: count}
+			if paymentMethodID.Valid {
+				id := columnKey
+				column.ID = &id
+			}
+			columnByID[columnKey] = column
+			pivot.Columns = append(pivot.Columns, column)
+		case paymentMethodRolledUp != 0:
+			row := &PivotRow{ID: int(categoryID.Int64), Name: categoryName.String, TotalAmount: totalAmount, Count: count}
+			rowByID[row.ID] = row
+			pivot.Rows = append(pivot.Rows, row)
+		default:
+			cells = append(cells, pivotCell{int(categoryID.Int64), columnKey, totalAmount, count})
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(pivot.Columns, func(i, j int) bool {
+		return pivot.Columns[i].TotalAmount > pivot.Columns[j].TotalAmount
+	})
+	sort.SliceStable(pivot.Rows, func(i, j int) bool {
+		return pivot.Rows[i].TotalAmount > pivot.Rows[j].TotalAmount
+	})
+
+	columnIndex := make(map[*PivotColumn]int, len(pivot.Columns))
+	for i, column := range pivot.Columns {
+		columnIndex[column] = i
+	}
+
+	for _, row := range pivot.Rows {
+		row.Totals = make([]float64, len(pivot.Columns))
+		row.Counts = make([]int, len(pivot.Columns))
+	}
+
+	for _, cell := range cells {
+		row := rowByID[cell.categoryID]
+		column := columnByID[cell.paymentMethodID]
+		if row == nil || column == nil {
+			continue
+		}
+
+		row.Totals[columnIndex[column]] = cell.totalAmount
+		row.Counts[columnIndex[column]] = cell.count
+	}
+
+	return pivot, nil
+}
+
+// BuildReport compiles a report into a single grouped query. Dimensions and
+// measures are only ever taken from the whitelists, every value a caller
+// supplies is passed as a parameter. The caller validates the names.
+func (pg *PostgresReportStore) BuildReport(ledgerID int, queryParams ReportQueryParams) (*ReportTable, error) {
+	table := &ReportTable{
+		Columns: append(append([]string{}, queryParams.Dimensions...), queryParams.Measures...),
+		Rows:    [][]any{},
+	}
+
+	query, args := buildReportQuery(ledgerID, queryParams)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		dimensionValues := make([]sql.NullString, len(queryParams.Dimensions))
+		measureValues := make([]float64, len(queryParams.Measures))
+
+		dest := make([]any, 0, len(table.Columns))
+		for i := range dimensionValues {
+			dest = append(dest, &dimensionValues[i])
+		}
+		for i := range measureValues {
+			dest = append(dest, &measureValues[i])
+		}
+
+		err := rows.Scan(dest...)
+		if err != nil {
+			return nil, err
+		}
+
+		row := make([]any, 0, len(table.Columns))
+		for _, value := range dimensionValues {
+			row = append(row, value.String)
+		}
+		for _, value := range measureValues {
+			row = append(row, value)
+		}
+
+		table.Rows = append(table.Rows, row)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return table, nil
+}
+
+// buildReportQuery returns the SQL of a report and its arguments. The
+// timezone is only passed when a dimension or date filter reads it, since
+// Postgres cannot type a parameter the query never mentions.
+func buildReportQuery(ledgerID int, queryParams ReportQueryParams) (string, []any) {
+	selectExprs := []string{}
+	groupExprs := []string{}
+	orderExprs := []string{}
+
+	for _, name := range queryParams.Dimensions {
+		dimension := reportDimensions[name]
+		selectExprs = append(selectExprs, dimension.selectExpr)
+		groupExprs = append(groupExprs, dimension.groupExprs...)
+		orderExprs = append(orderExprs, dimension.orderExpr)
+	}
+
+	for _, name := range queryParams.Measures {
+		selectExprs = append(selectExprs, reportMeasures[name])
+	}
+
+	args := []any{ledgerID}
+	where := []string{"e.ledger_id = $1"}
+
+	addFilter := func(condition string, value any) {
+		args = append(args, value)
+		where = append(where, strings.ReplaceAll(condition, "$?", fmt.Sprintf("$%d", len(args))))
+	}
+
+	if queryParams.StartDate != nil {
+		addFilter("e.expense_date >= ($?::date::timestamp AT TIME ZONE $tz::text)", *queryParams.StartDate)
+	}
+	if queryParams.EndDate != nil {
+		addFilter("e.expense_date < (($?::date + 1)::timestamp AT TIME ZONE $tz::text)", *queryParams.EndDate)
+	}
+	if queryParams.CategoryID != nil {
+		addFilter("e.category_id IN (SELECT category_descendants($?::int))", *queryParams.CategoryID)
+	}
+	if queryParams.PaymentMethodID != nil {
+		addFilter("e.payment_method_id = $?::int", *queryParams.PaymentMethodID)
+	}
+	if queryParams.MinAmount != nil {
+		addFilter("e.amount >= $?::numeric", *queryParams.MinAmount)
+	}
+	if queryParams.MaxAmount != nil {
+		addFilter("e.amount <= $?::numeric", *queryParams.MaxAmount)
+	}
+	if queryParams.Title != nil {
+		addFilter("e.title ILIKE '%' || $?::text || '%'", *queryParams.Title)
+	}
+
+	query := "SELECT " + strings.Join(selectExprs, ", ") + `
+	FROM expenses e
+	LEFT JOIN categories c ON c.id = e.category_id
+	LEFT JOIN payment_methods pm ON pm.id = e.payment_method_id
+	WHERE ` + strings.Join(where, " AND ")
+
+	if len(groupExprs) > 0 {
+		query += "\n\tGROUP BY " + strings.Join(groupExprs, ", ")
+	}
+
+	// A sort names one of the selected measures, largest first
+	if queryParams.Sort != nil {
+		for i, name := range queryParams.Measures {
+			if name == *queryParams.Sort {
+				orderExprs = append([]string{fmt.Sprintf("%d DESC", len(queryParams.Dimensions)+i+1)}, orderExprs...)
+				break
+			}
+		}
+	}
+
+	if len(orderExprs) > 0 {
+		query += "\n\tORDER BY " + strings.Join(orderExprs, ", ")
+	}
+
+	if queryParams.Limit != nil {
+		args = append(args, *queryParams.Limit)
+		query += fmt.Sprintf("\n\tLIMIT $%d", len(args))
+	}
+
+	if strings.Contains(query, "$tz") {
+		timezone := queryParams.Timezone
+		if timezone == "" {
+			timezone = DefaultTimezone
+		}
+
+		args = append(args, timezone)
+		query = strings.ReplaceAll(query, "$tz", fmt.Sprintf("$%d", len(args)))
+	}
+
+	return query, args
+}
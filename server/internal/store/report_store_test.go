@@ -0,0 +1,95 @@
+package store
+
+import (
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var placeholderPattern = regexp.MustCompile(`\$(\d+)`)
+
+// checkPlaceholders fails unless the query uses every argument, and only
+// those, as Postgres requires.
+func checkPlaceholders(t *testing.T, query string, args []any) {
+	t.Helper()
+
+	used := map[int]bool{}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(query, -1) {
+		n, _ := strconv.Atoi(match[1])
+		used[n] = true
+	}
+
+	for n := range used {
+		if n < 1 || n > len(args) {
+			t.Errorf("query uses $%d but has %d arguments", n, len(args))
+		}
+	}
+	for n := 1; n <= len(args); n++ {
+		if !used[n] {
+			t.Errorf("argument $%d (%v) is never used", n, args[n-1])
+		}
+	}
+
+	if strings.Contains(query, "$tz") || strings.Contains(query, "$?") {
+		t.Errorf("query has an unbound placeholder:\n%s", query)
+	}
+}
+
+func TestBuildReportQueryCategoryOnly(t *testing.T) {
+	limit := 1000
+	query, args := buildReportQuery(7, ReportQueryParams{
+		Dimensions: []string{"category"},
+		Measures:   []string{"sum"},
+		Limit:      &limit,
+		Timezone:   "America/New_York",
+	})
+
+	checkPlaceholders(t, query, args)
+
+	if want := []any{7, 1000}; !reflect.DeepEqual(args, want) {
+		t.Errorf("args = %v, want %v", args, want)
+	}
+}
+
+func TestBuildReportQueryTimezone(t *testing.T) {
+	startDate := "2024-04-01"
+	endDate := "2024-04-30"
+	categoryID := 3
+	limit := 50
+
+	tests := []struct {
+		name        string
+		queryParams ReportQueryParams
+		want        []any
+	}{
+		{
+			name:        "month dimension",
+			queryParams: ReportQueryParams{Dimensions: []string{"category", "month"}, Measures: []string{"count"}, Limit: &limit, Timezone: "Europe/London"},
+			want:        []any{7, 50, "Europe/London"},
+		},
+		{
+			name:        "date filters",
+			queryParams: ReportQueryParams{Dimensions: []string{"payment_method"}, Measures: []string{"sum"}, StartDate: &startDate, EndDate: &endDate, CategoryID: &categoryID},
+			want:        []any{7, startDate, endDate, categoryID, DefaultTimezone},
+		},
+		{
+			name:        "no dimensions",
+			queryParams: ReportQueryParams{Measures: []string{"avg", "max"}},
+			want:        []any{7},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, args := buildReportQuery(7, test.queryParams)
+
+			checkPlaceholders(t, query, args)
+
+			if !reflect.DeepEqual(args, test.want) {
+				t.Errorf("args = %v, want %v", args, test.want)
+			}
+		})
+	}
+}
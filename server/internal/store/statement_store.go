@@ -0,0 +1,324 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+const (
+	StatementCycleCurrent  = "current"
+	StatementCyclePrevious = "previous"
+)
+
+var (
+	ErrNotStatementCard     = errors.New("payment method must be a credit card with a billing_cycle_day")
+	ErrInvalidCardPayment   = errors.New("payment amount must be positive")
+	ErrStatementAlreadyPaid = errors.New("statement is already paid")
+)
+
+func IsValidStatementCycle(cycle string) bool {
+	return cycle == StatementCycleCurrent || cycle == StatementCyclePrevious
+}
+
+// Statement is one billing cycle of a credit card. EndDate is the closing
+// day of the cycle.
+type Statement struct {
+	StartDate    string  `json:"start_date"`
+	EndDate      string  `json:"end_date"`
+	DueDate      *string `json:"due_date"`
+	Count        int     `json:"count"`
+	TotalAmount  float64 `json:"total_amount"`
+	PaidAmount   float64 `json:"paid_amount"`
+	UnpaidAmount float64 `json:"unpaid_amount"`
+	Paid         bool    `json:"paid"`
+}
+
+type StatementSummary struct {
+	PaymentMethodID int        `json:"payment_method_id"`
+	ClosingDay      int        `json:"closing_day"`
+	DueDay          *int       `json:"due_day"`
+	Current         *Statement `json:"current"`
+	Previous        *Statement `json:"previous"`
+}
+
+type CardPayment struct {
+	ID               int     `json:"id"`
+	PaymentMethodID  int     `json:"payment_method_id"`
+	StatementEndDate string  `json:"statement_end_date"`
+	Amount           float64 `json:"amount"`
+	PaidOn           string  `json:"paid_on"`
+	UserID           int     `json:"-"`
+	LedgerID         int     `json:"-"`
+}
+
+// statementCard is the part of a payment method needed to work out its
+// billing cycles.
+type statementCard struct {
+	closingDay int
+	dueDay     *int
+}
+
+// dayInMonth clamps day to the length of the month, so a closing day of 31
+// falls on the last day of shorter months.
+func dayInMonth(year int, month time.Month, day int) time.Time {
+	lastDay := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return time.Date(year, month, min(day, lastDay), 0, 0, 0, 0, time.UTC)
+}
+
+// statementWindow returns the first and last day of a billing cycle. The
+// current cycle is the one that closes on or after today, the previous
+// cycle is the last one that has closed.
+func (c statementCard) statementWindow(cycle string, today time.Time) (time.Time, time.Time) {
+	end := dayInMonth(today.Year(), today.Month(), c.closingDay)
+	if today.After(end) {
+		end = dayInMonth(today.Year(), today.Month()+1, c.closingDay)
+	}
+
+	if cycle == StatementCyclePrevious {
+		end = dayInMonth(end.Year(), end.Month()-1, c.closingDay)
+	}
+
+	previousEnd := dayInMonth(end.Year(), end.Month()-1, c.closingDay)
+	return previousEnd.AddDate(0, 0, 1), end
+}
+
+// dueDate is the first payment due day after the statement closes.
+func (c statementCard) dueDate(end time.Time) *time.Time {
+	if c.dueDay == nil {
+		return nil
+	}
+
+	due := dayInMonth(end.Year(), end.Month(), *c.dueDay)
+	if !due.After(end) {
+		due = dayInMonth(end.Year(), end.Month()+1, *c.dueDay)
+	}
+
+	return &due
+}
+
+type PostgresStatementStore struct {
+	db *sql.DB
+}
+
+func NewPostgresStatementStore(db *sql.DB) *PostgresStatementStore {
+	return &PostgresStatementStore{
+		db: db,
+	}
+}
+
+type StatementStore interface {
+	GetStatements(ledgerID int, paymentMethodID int, today time.Time, timezone string) (*StatementSummary, error)
+	GetStatement(ledgerID int, paymentMethodID int, cycle string, today time.Time, timezone string) (*Statement, error)
+	RecordCardPayment(payment *CardPayment, cycle string, today time.Time, timezone string) (*Statement, error)
+}
+
+func (pg *PostgresStatementStore) loadStatementCard(ctx context.Context, q execQueryer, ledgerID int, paymentMethodID int) (*statementCard, error) {
+	query := `
+	SELECT pm.kind, pm.billing_cycle_day, pm.payment_due_day
+	FROM payment_methods pm
+	WHERE pm.id = $1 AND pm.ledger_id = $2`
+
+	var kind sql.NullString
+	var closingDay, dueDay sql.NullInt64
+	err := q.QueryRowContext(ctx, query, paymentMethodID, ledgerID).Scan(&kind, &closingDay, &dueDay)
+	if err == sql.ErrNoRows {
+		return nil, ErrPaymentMethodNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if kind.String != PaymentMethodKindCreditCard || !closingDay.Valid {
+		return nil, ErrNotStatementCard
+	}
+
+	card := &statementCard{closingDay: int(closingDay.Int64)}
+	if dueDay.Valid {
+		day := int(dueDay.Int64)
+		card.dueDay = &day
+	}
+
+	return card, nil
+}
+
+// loadStatement totals the expenses dated within the statement window in
+// the user's timezone, and the payments made against it.
+func (pg *PostgresStatementStore) loadStatement(ctx context.Context, q execQueryer, ledgerID int, paymentMethodID int, card *statementCard, cycle string, today time.Time, timezone string) (*Statement, error) {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	start, end := card.statementWindow(cycle, today)
+
+	statement := &Statement{
+		StartDate: start.Format(dateLayout),
+		EndDate:   end.Format(dateLayout),
+	}
+
+	if due := card.dueDate(end); due != nil {
+		dueDate := due.Format(dateLayout)
+		statement.DueDate = &dueDate
+	}
+
+	query := `
+	SELECT COUNT(e.id), COALESCE(SUM(e.amount), 0)
+	FROM expenses e
+	WHERE
+		e.ledger_id = $1
+		AND e.payment_method_id = $2
+		AND e.expense_date >= ($3::date::timestamp AT TIME ZONE $5::text)
+		AND e.expense_date < (($4::date + 1)::timestamp AT TIME ZONE $5::text)`
+
+	err := q.QueryRowContext(ctx, query, ledgerID, paymentMethodID, statement.StartDate, statement.EndDate, timezone).Scan(&statement.Count, &statement.TotalAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	paidQuery := `
+	SELECT COALESCE(SUM(cp.amount), 0)
+	FROM card_payments cp
+	WHERE cp.payment_method_id = $1 AND cp.statement_end_date = $2::date`
+
+	err = q.QueryRowContext(ctx, paidQuery, paymentMethodID, statement.EndDate).Scan(&statement.PaidAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	statement.TotalAmount = roundAmount(statement.TotalAmount)
+	statement.PaidAmount = roundAmount(statement.PaidAmount)
+	statement.UnpaidAmount = roundAmount(max(statement.TotalAmount-statement.PaidAmount, 0))
+	statement.Paid = statement.UnpaidAmount == 0
+
+	return statement, nil
+}
+
+// GetStatements returns the open statement of a credit card and the last
+// closed one.
+func (pg *PostgresStatementStore) GetStatements(ledgerID int, paymentMethodID int, today time.Time, timezone string) (*StatementSummary, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	card, err := pg.loadStatementCard(ctx, pg.db, ledgerID, paymentMethodID)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &StatementSummary{
+		PaymentMethodID: paymentMethodID,
+		ClosingDay:      card.closingDay,
+		DueDay:          card.dueDay,
+	}
+
+	summary.Current, err = pg.loadStatement(ctx, pg.db, ledgerID, paymentMethodID, card, StatementCycleCurrent, today, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Previous, err = pg.loadStatement(ctx, pg.db, ledgerID, paymentMethodID, card, StatementCyclePrevious, today, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+func (pg *PostgresStatementStore) GetStatement(ledgerID int, paymentMethodID int, cycle string, today time.Time, timezone string) (*Statement, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	card, err := pg.loadStatementCard(ctx, pg.db, ledgerID, paymentMethodID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pg.loadStatement(ctx, pg.db, ledgerID, paymentMethodID, card, cycle, today, timezone)
+}
+
+// RecordCardPayment records a payment against a statement. A payment with
+// no amount settles whatever is still unpaid, so the statement is marked
+// paid.
+func (pg *PostgresStatementStore) RecordCardPayment(payment *CardPayment, cycle string, today time.Time, timezone string) (*Statement, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Payments to the same card wait for each other, so two settle requests
+	// cannot both read the same unpaid amount
+	lockQuery := `SELECT pm.id FROM payment_methods pm WHERE pm.id = $1 AND pm.ledger_id = $2 FOR UPDATE`
+
+	var lockedID int64
+	err = tx.QueryRowContext(ctx, lockQuery, payment.PaymentMethodID, payment.LedgerID).Scan(&lockedID)
+	if err == sql.ErrNoRows {
+		return nil, ErrPaymentMethodNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	card, err := pg.loadStatementCard(ctx, tx, payment.LedgerID, payment.PaymentMethodID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := pg.loadStatement(ctx, tx, payment.LedgerID, payment.PaymentMethodID, card, cycle, today, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	if payment.Amount == 0 {
+		if statement.Paid {
+			return nil, ErrStatementAlreadyPaid
+		}
+		payment.Amount = statement.UnpaidAmount
+	}
+
+	if payment.Amount < 0 {
+		return nil, ErrInvalidCardPayment
+	}
+
+	if payment.PaidOn == "" {
+		payment.PaidOn = today.Format(dateLayout)
+	}
+
+	payment.StatementEndDate = statement.EndDate
+
+	query := `
+	INSERT INTO card_payments (ledger_id, payment_method_id, user_id, statement_end_date, amount, paid_on)
+	VALUES ($1, $2, $3, $4::date, $5, $6::date)
+	RETURNING id`
+
+	err = tx.QueryRowContext(
+		ctx,
+		query,
+		payment.LedgerID,
+		payment.PaymentMethodID,
+		payment.UserID,
+		payment.StatementEndDate,
+		payment.Amount,
+		payment.PaidOn,
+	).Scan(&payment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement.PaidAmount = roundAmount(statement.PaidAmount + payment.Amount)
+	statement.UnpaidAmount = roundAmount(max(statement.TotalAmount-statement.PaidAmount, 0))
+	statement.Paid = statement.UnpaidAmount == 0
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, nil
+}
@@ -0,0 +1,978 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	SyncEntityExpense       = "expense"
+	SyncEntityCategory      = "category"
+	SyncEntityPaymentMethod = "payment_method"
+)
+
+const (
+	SyncOpUpsert = "upsert"
+	SyncOpDelete = "delete"
+)
+
+// Conflicts happen when a record changed on the server after the version
+// the client based its change on. Last writer wins keeps whichever side
+// changed last as a whole, merge keeps every field only the client changed.
+const (
+	SyncStrategyLastWriterWins = "lww"
+	SyncStrategyMerge          = "merge"
+)
+
+const (
+	SyncStatusApplied  = "applied"
+	SyncStatusMerged   = "merged"
+	SyncStatusConflict = "conflict"
+	SyncStatusRejected = "rejected"
+)
+
+var ErrInvalidSyncStrategy = errors.New("strategy must be one of lww, merge")
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func IsValidSyncStrategy(strategy string) bool {
+	return strategy == SyncStrategyLastWriterWins || strategy == SyncStrategyMerge
+}
+
+type syncFieldKind int
+
+const (
+	syncText syncFieldKind = iota
+	syncNumber
+	syncInt
+	syncTimestamp
+	syncBool
+)
+
+// syncField maps one JSON field of a synced record to its column. read is
+// the select expression, write the value expression around the parameter.
+// Fields with ref hold the id of a record of that entity in the same ledger,
+// given either as its id or as its client_id.
+type syncField struct {
+	name     string
+	kind     syncFieldKind
+	read     string
+	column   string
+	write    string
+	nullable bool
+	required bool
+	ref      string
+	validate func(value any) string
+}
+
+type syncEntity struct {
+	name      string
+	table     string
+	fields    []syncField
+	deletable bool
+}
+
+func validTaxSection(value any) string {
+	if value != nil && !IsValidTaxSection(value.(string)) {
+		return "tax_section must be one of 80C, 80D, 80E, 80G, business"
+	}
+	return ""
+}
+
+func validDayOfMonth(name string) func(value any) string {
+	return func(value any) string {
+		if value != nil && (value.(int64) < 1 || value.(int64) > 31) {
+			return name + " must be between 1 and 31"
+		}
+		return ""
+	}
+}
+
+// validMaxLength keeps a text field within the length of its VARCHAR column.
+func validMaxLength(name string, length int) func(value any) string {
+	return func(value any) string {
+		if value != nil && utf8.RuneCountInString(value.(string)) > length {
+			return fmt.Sprintf("%s must be at most %d characters", name, length)
+		}
+		return ""
+	}
+}
+
+// maxSyncAmount is the first amount a DECIMAL(10, 2) column cannot hold.
+const maxSyncAmount = 1e8
+
+func validAmount(value any) string {
+	amount := value.(float64)
+	if amount <= 0 || amount >= maxSyncAmount {
+		return "amount must be greater than zero and less than 100000000"
+	}
+	return ""
+}
+
+var lastFourPattern = regexp.MustCompile(`^[0-9]{4}$`)
+
+func validLastFour(value any) string {
+	if value != nil && !lastFourPattern.MatchString(value.(string)) {
+		return "last_four must be 4 digits"
+	}
+	return ""
+}
+
+func archivedField() syncField {
+	return syncField{
+		name:   "archived",
+		kind:   syncBool,
+		read:   "t.archived_at IS NOT NULL",
+		column: "archived_at",
+		write:  "CASE WHEN %s::boolean THEN CURRENT_TIMESTAMP END",
+	}
+}
+
+var syncEntities = map[string]*syncEntity{
+	SyncEntityExpense: {
+		name:      SyncEntityExpense,
+		table:     "expenses",
+		deletable: true,
+		fields: []syncField{
+			{name: "title", kind: syncText, read: "t.title", column: "title", write: "%s::text", required: true, validate: validMaxLength("title", 150)},
+			{name: "amount", kind: syncNumber, read: "t.amount::float8", column: "amount", write: "%s::numeric", required: true, validate: validAmount},
+			{name: "expense_date", kind: syncTimestamp, read: `TO_CHAR(t.expense_date, 'YYYY-MM-DD"T"HH24:MI:SSOF')`, column: "expense_date", write: "%s::timestamptz", required: true},
+			{name: "category_id", kind: syncInt, read: "t.category_id", column: "category_id", write: "%s::bigint", required: true, ref: SyncEntityCategory},
+			{name: "payment_method_id", kind: syncInt, read: "t.payment_method_id", column: "payment_method_id", write: "%s::bigint", required: true, ref: SyncEntityPaymentMethod},
+			{name: "tax_section", kind: syncText, read: "t.tax_section", column: "tax_section", write: "%s::text", nullable: true, validate: validTaxSection},
+		},
+	},
+	SyncEntityCategory: {
+		name:  SyncEntityCategory,
+		table: "categories",
+		fields: []syncField{
+			{name: "name", kind: syncText, read: "t.name", column: "name", write: "%s::text", required: true, validate: validMaxLength("name", 255)},
+			{name: "parent_id", kind: syncInt, read: "t.parent_id", column: "parent_id", write: "%s::bigint", nullable: true, ref: SyncEntityCategory},
+			{name: "budget_cadence", kind: syncText, read: "t.budget_cadence", column: "budget_cadence", write: "%s::text", validate: func(value any) string {
+				if !IsValidBudgetCadence(value.(string)) {
+					return "budget_cadence must be one of weekly, monthly, yearly"
+				}
+				return ""
+			}},
+			{name: "tax_section", kind: syncText, read: "t.tax_section", column: "tax_section", write: "%s::text", nullable: true, validate: validTaxSection},
+			archivedField(),
+		},
+	},
+	SyncEntityPaymentMethod: {
+		name:  SyncEntityPaymentMethod,
+		table: "payment_methods",
+		fields: []syncField{
+			{name: "name", kind: syncText, read: "t.name", column: "name", write: "%s::text", required: true, validate: validMaxLength("name", 100)},
+			{name: "kind", kind: syncText, read: "t.kind", column: "kind", write: "%s::text", nullable: true, validate: func(value any) string {
+				if value != nil && !IsValidPaymentMethodKind(value.(string)) {
+					return "kind must be one of upi, credit_card, debit_card, cash, net_banking, wallet"
+				}
+				return ""
+			}},
+			{name: "last_four", kind: syncText, read: "t.last_four", column: "last_four", write: "%s::text", nullable: true, validate: validLastFour},
+			{name: "issuer", kind: syncText, read: "t.issuer", column: "issuer", write: "%s::text", nullable: true, validate: validMaxLength("issuer", 100)},
+			{name: "billing_cycle_day", kind: syncInt, read: "t.billing_cycle_day::bigint", column: "billing_cycle_day", write: "%s::smallint", nullable: true, validate: validDayOfMonth("billing_cycle_day")},
+			{name: "payment_due_day", kind: syncInt, read: "t.payment_due_day::bigint", column: "payment_due_day", write: "%s::smallint", nullable: true, validate: validDayOfMonth("payment_due_day")},
+			archivedField(),
+		},
+	},
+}
+
+func (e *syncEntity) field(name string) *syncField {
+	for i := range e.fields {
+		if e.fields[i].name == name {
+			return &e.fields[i]
+		}
+	}
+	return nil
+}
+
+func (e *syncEntity) selectColumns() string {
+	columns := []string{"t.id", "t.client_id::text", "t.sync_version", "COALESCE(t.updated_at, t.created_at, CURRENT_TIMESTAMP)"}
+	for _, field := range e.fields {
+		columns = append(columns, field.read)
+	}
+	return strings.Join(columns, ", ")
+}
+
+// SyncRecord is a synced row with its fields keyed by their JSON names.
+type SyncRecord struct {
+	ID        int            `json:"id"`
+	ClientID  *string        `json:"client_id"`
+	Version   int64          `json:"version"`
+	UpdatedAt string         `json:"updated_at"`
+	Fields    map[string]any `json:"fields"`
+	updatedAt time.Time
+}
+
+type SyncTombstone struct {
+	Entity   string  `json:"entity"`
+	ID       int     `json:"id"`
+	ClientID *string `json:"client_id"`
+	Version  int64   `json:"version"`
+}
+
+// SyncChanges is a page of what changed in a ledger after a token. Token is
+// passed as since on the next pull, right away while HasMore is set.
+type SyncChanges struct {
+	Token          string           `json:"token"`
+	HasMore        bool             `json:"has_more"`
+	Expenses       []*SyncRecord    `json:"expenses"`
+	Categories     []*SyncRecord    `json:"categories"`
+	PaymentMethods []*SyncRecord    `json:"payment_methods"`
+	Deleted        []*SyncTombstone `json:"deleted"`
+}
+
+// SyncMutation is one offline change. A record is identified by ClientID,
+// or by ID when it was created on the server. BaseVersion is the version
+// the change was made against and is left out for new records. Base holds
+// the values the changed fields had before, which merge compares against.
+type SyncMutation struct {
+	Entity      string         `json:"entity"`
+	Op          string         `json:"op"`
+	ClientID    *string        `json:"client_id"`
+	ID          *int           `json:"id"`
+	BaseVersion *int64         `json:"base_version"`
+	UpdatedAt   *string        `json:"updated_at"`
+	Fields      map[string]any `json:"fields"`
+	Base        map[string]any `json:"base"`
+}
+
+// SyncResult reports what became of a mutation. Record is the server copy
+// after the mutation whenever it differs from what the client sent.
+type SyncResult struct {
+	Index             int         `json:"index"`
+	Entity            string      `json:"entity"`
+	ClientID          *string     `json:"client_id"`
+	ID                *int        `json:"id"`
+	Status            string      `json:"status"`
+	Version           *int64      `json:"version,omitempty"`
+	Error             string      `json:"error,omitempty"`
+	ConflictingFields []string    `json:"conflicting_fields,omitempty"`
+	Record            *SyncRecord `json:"record,omitempty"`
+	Op                string      `json:"-"`
+	Created           bool        `json:"-"`
+	Deleted           bool        `json:"-"`
+}
+
+type PostgresSyncStore struct {
+	db *sql.DB
+}
+
+func NewPostgresSyncStore(db *sql.DB) *PostgresSyncStore {
+	return &PostgresSyncStore{
+		db: db,
+	}
+}
+
+type SyncStore interface {
+	PullChanges(ledgerID int, since int64, limit int) (*SyncChanges, error)
+	PushMutations(ledgerID int, userID int, strategy string, mutations []*SyncMutation) ([]*SyncResult, error)
+}
+
+func scanSyncRecord(row interface{ Scan(...any) error }, entity *syncEntity) (*SyncRecord, error) {
+	var record SyncRecord
+	var clientID sql.NullString
+
+	values := make([]any, len(entity.fields))
+	dest := []any{&record.ID, &clientID, &record.Version, &record.updatedAt}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return nil, err
+	}
+
+	if clientID.Valid {
+		record.ClientID = &clientID.String
+	}
+
+	record.UpdatedAt = record.updatedAt.Format(time.RFC3339)
+	record.Fields = make(map[string]any, len(entity.fields))
+	for i, field := range entity.fields {
+		record.Fields[field.name] = values[i]
+	}
+
+	return &record, nil
+}
+
+// PullChanges returns a page of the records and tombstones of a ledger with
+// a version above since, lowest versions first. A since of 0 starts from the
+// whole ledger. HasMore is set when versions beyond the page remain, and the
+// token then continues the pull. A record can refer to one that only comes on
+// a later page, when that one changed since.
+func (pg *PostgresSyncStore) PullChanges(ledgerID int, since int64, limit int) (*SyncChanges, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Every table is read from the same snapshot, so a page cannot hold one
+	// side of a commit without the other
+	tx, err := pg.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	// Each query stops after limit+1 rows, which is enough to find the
+	// limit-th lowest version across all of them and whether more remain
+	records := make(map[string][]*SyncRecord, len(syncEntities))
+	versions := []int64{}
+
+	for _, name := range []string{SyncEntityCategory, SyncEntityPaymentMethod, SyncEntityExpense} {
+		records[name], err = changedRecords(ctx, tx, syncEntities[name], ledgerID, since, limit+1)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, record := range records[name] {
+			versions = append(versions, record.Version)
+		}
+	}
+
+	tombstones, err := changedTombstones(ctx, tx, ledgerID, since, limit+1)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tombstone := range tombstones {
+		versions = append(versions, tombstone.Version)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	changes := &SyncChanges{Deleted: []*SyncTombstone{}}
+	token := since
+
+	if len(versions) > limit {
+		changes.HasMore = true
+		versions = versions[:limit]
+	}
+	if len(versions) > 0 {
+		token = versions[len(versions)-1]
+	}
+
+	changes.Categories = recordsUpTo(records[SyncEntityCategory], token)
+	changes.PaymentMethods = recordsUpTo(records[SyncEntityPaymentMethod], token)
+	changes.Expenses = recordsUpTo(records[SyncEntityExpense], token)
+
+	for _, tombstone := range tombstones {
+		if tombstone.Version <= token {
+			changes.Deleted = append(changes.Deleted, tombstone)
+		}
+	}
+
+	changes.Token = fmt.Sprint(token)
+
+	return changes, nil
+}
+
+// recordsUpTo cuts records, sorted by version, after the given version.
+func recordsUpTo(records []*SyncRecord, version int64) []*SyncRecord {
+	end := sort.Search(len(records), func(i int) bool { return records[i].Version > version })
+	return records[:end]
+}
+
+func changedRecords(ctx context.Context, tx *sql.Tx, entity *syncEntity, ledgerID int, since int64, limit int) ([]*SyncRecord, error) {
+	records := []*SyncRecord{}
+
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM %s t
+	WHERE t.ledger_id = $1 AND t.sync_version > $2
+	ORDER BY t.sync_version
+	LIMIT $3`, entity.selectColumns(), entity.table)
+
+	rows, err := tx.QueryContext(ctx, query, ledgerID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanSyncRecord(rows, entity)
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, record)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func changedTombstones(ctx context.Context, tx *sql.Tx, ledgerID int, since int64, limit int) ([]*SyncTombstone, error) {
+	tombstones := []*SyncTombstone{}
+
+	query := `
+	SELECT ts.entity, ts.id, ts.client_id::text, ts.sync_version
+	FROM sync_tombstones ts
+	WHERE ts.ledger_id = $1 AND ts.sync_version > $2
+	ORDER BY ts.sync_version
+	LIMIT $3`
+
+	rows, err := tx.QueryContext(ctx, query, ledgerID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var tombstone SyncTombstone
+		var clientID sql.NullString
+
+		err := rows.Scan(&tombstone.Entity, &tombstone.ID, &clientID, &tombstone.Version)
+		if err != nil {
+			return nil, err
+		}
+
+		if clientID.Valid {
+			tombstone.ClientID = &clientID.String
+		}
+
+		tombstones = append(tombstones, &tombstone)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return tombstones, nil
+}
+
+// syncRejection is a mutation that cannot be applied as sent. It is reported
+// back to the client instead of failing the batch.
+type syncRejection string
+
+func (r syncRejection) Error() string {
+	return string(r)
+}
+
+// asSyncRejection reports the errors a mutation can cause with the values it
+// sends as a rejection. These are the data exceptions and integrity
+// violations, SQLSTATE classes 22 and 23, which the field checks missed.
+func asSyncRejection(err error, rejection *syncRejection) bool {
+	if errors.As(err, rejection) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (strings.HasPrefix(pgErr.Code, "22") || strings.HasPrefix(pgErr.Code, "23")) {
+		*rejection = syncRejection(pgErr.Message)
+		return true
+	}
+
+	return false
+}
+
+// PushMutations applies a batch of mutations in order in one transaction.
+// Each mutation runs in its own savepoint, so a rejected one leaves the rest
+// of the batch alone, and later mutations can refer to records created by
+// earlier ones through their client_id.
+func (pg *PostgresSyncStore) PushMutations(ledgerID int, userID int, strategy string, mutations []*SyncMutation) ([]*SyncResult, error) {
+	if !IsValidSyncStrategy(strategy) {
+		return nil, ErrInvalidSyncStrategy
+	}
+
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A category may be moved, which has to hold the tree lock before the
+	// first write of the batch
+	for _, mutation := range mutations {
+		if mutation.Entity == SyncEntityCategory {
+			err = lockCategoryTree(ctx, tx, ledgerID)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+
+	results := make([]*SyncResult, 0, len(mutations))
+
+	for i, mutation := range mutations {
+		result := &SyncResult{Index: i, Entity: mutation.Entity, ClientID: mutation.ClientID, ID: mutation.ID, Op: mutation.Op}
+
+		_, err := tx.ExecContext(ctx, "SAVEPOINT sync_mutation")
+		if err != nil {
+			return nil, err
+		}
+
+		err = pg.applyMutation(ctx, tx, ledgerID, userID, strategy, mutation, result)
+
+		var rejection syncRejection
+		if asSyncRejection(err, &rejection) {
+			result.Status = SyncStatusRejected
+			result.Error = rejection.Error()
+			_, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT sync_mutation")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (pg *PostgresSyncStore) applyMutation(ctx context.Context, tx *sql.Tx, ledgerID int, userID int, strategy string, mutation *SyncMutation, result *SyncResult) error {
+	entity, ok := syncEntities[mutation.Entity]
+	if !ok {
+		return syncRejection("entity must be one of expense, category, payment_method")
+	}
+
+	if mutation.Op != SyncOpUpsert && mutation.Op != SyncOpDelete {
+		return syncRejection("op must be one of upsert, delete")
+	}
+
+	if mutation.ClientID == nil && mutation.ID == nil {
+		return syncRejection("client_id or id is required")
+	}
+
+	if mutation.ClientID != nil && !uuidPattern.MatchString(*mutation.ClientID) {
+		return syncRejection("client_id must be a UUID")
+	}
+
+	current, err := pg.lockRecord(ctx, tx, entity, ledgerID, mutation)
+	if err != nil {
+		return err
+	}
+
+	if current != nil {
+		result.ID = &current.ID
+		result.ClientID = current.ClientID
+	}
+
+	if mutation.Op == SyncOpDelete {
+		return pg.applyDelete(ctx, tx, entity, mutation, current, result)
+	}
+
+	values, err := pg.resolveFields(ctx, tx, entity, ledgerID, mutation.Fields)
+	if err != nil {
+		return err
+	}
+
+	if current == nil {
+		if mutation.ClientID == nil {
+			return syncRejection(entity.name + " not found")
+		}
+
+		return pg.insertRecord(ctx, tx, entity, ledgerID, userID, *mutation.ClientID, values, result)
+	}
+
+	apply := values
+	if isSyncConflict(mutation, current) {
+		switch strategy {
+		case SyncStrategyLastWriterWins:
+			if !clientChangedLast(mutation, current) {
+				result.Status = SyncStatusConflict
+				result.Version = &current.Version
+				result.Record = current
+				return nil
+			}
+		case SyncStrategyMerge:
+			apply, result.ConflictingFields = mergeSyncFields(values, mutation.Base, current.Fields)
+			sort.Strings(result.ConflictingFields)
+		}
+	}
+
+	if entity.name == SyncEntityCategory && apply["parent_id"] != nil {
+		err := checkCategoryParent(ctx, tx, current.ID, apply["parent_id"].(int64))
+		if err != nil {
+			return err
+		}
+	}
+
+	return pg.updateRecord(ctx, tx, entity, current, apply, result)
+}
+
+// lockRecord loads the record a mutation refers to and locks it for the rest
+// of the batch. A client_id already used in another ledger is rejected, as
+// client ids are unique across ledgers.
+func (pg *PostgresSyncStore) lockRecord(ctx context.Context, tx *sql.Tx, entity *syncEntity, ledgerID int, mutation *SyncMutation) (*SyncRecord, error) {
+	query := fmt.Sprintf(`
+	SELECT %s
+	FROM %s t
+	WHERE t.ledger_id = $1 AND (t.id = $2 OR t.client_id = $3::uuid)
+	FOR UPDATE`, entity.selectColumns(), entity.table)
+
+	record, err := scanSyncRecord(tx.QueryRowContext(ctx, query, ledgerID, mutation.ID, mutation.ClientID), entity)
+	if err == sql.ErrNoRows {
+		if mutation.ClientID == nil {
+			return nil, nil
+		}
+
+		var used bool
+		query = fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM %s WHERE client_id = $1::uuid)`, entity.table)
+		err = tx.QueryRowContext(ctx, query, mutation.ClientID).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+
+		if used {
+			return nil, syncRejection("client_id is already used")
+		}
+
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+func (pg *PostgresSyncStore) applyDelete(ctx context.Context, tx *sql.Tx, entity *syncEntity, mutation *SyncMutation, current *SyncRecord, result *SyncResult) error {
+	if !entity.deletable {
+		return syncRejection("categories and payment methods are archived rather than deleted")
+	}
+
+	// Already gone, deleting twice is not an error
+	if current == nil {
+		result.Status = SyncStatusApplied
+		return nil
+	}
+
+	// A delete is all or nothing, so both strategies let the later side win
+	if isSyncConflict(mutation, current) && !clientChangedLast(mutation, current) {
+		result.Status = SyncStatusConflict
+		result.Version = &current.Version
+		result.Record = current
+		return nil
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id = $1`, entity.table)
+	_, err := tx.ExecContext(ctx, query, current.ID)
+	if err != nil {
+		return err
+	}
+
+	result.Status = SyncStatusApplied
+	result.Deleted = true
+	return nil
+}
+
+func (pg *PostgresSyncStore) insertRecord(ctx context.Context, tx *sql.Tx, entity *syncEntity, ledgerID int, userID int, clientID string, values map[string]any, result *SyncResult) error {
+	columns := []string{"ledger_id", "user_id", "client_id"}
+	placeholders := []string{"$1", "$2", "$3::uuid"}
+	args := []any{ledgerID, userID, clientID}
+
+	for _, field := range entity.fields {
+		value, ok := values[field.name]
+		if !ok {
+			if field.required {
+				return syncRejection(field.name + " is required")
+			}
+			continue
+		}
+
+		args = append(args, value)
+		columns = append(columns, field.column)
+		placeholders = append(placeholders, fmt.Sprintf(field.write, fmt.Sprintf("$%d", len(args))))
+	}
+
+	query := fmt.Sprintf(`
+	INSERT INTO %s (%s)
+	VALUES (%s)
+	RETURNING id, sync_version`, entity.table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+
+	var id int
+	var version int64
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&id, &version)
+	if err != nil {
+		return err
+	}
+
+	result.ID = &id
+	result.Version = &version
+	result.Status = SyncStatusApplied
+	result.Created = true
+
+	return nil
+}
+
+func (pg *PostgresSyncStore) updateRecord(ctx context.Context, tx *sql.Tx, entity *syncEntity, current *SyncRecord, values map[string]any, result *SyncResult) error {
+	assignments := []string{}
+	args := []any{current.ID}
+
+	for _, field := range entity.fields {
+		value, ok := values[field.name]
+		if !ok {
+			continue
+		}
+
+		args = append(args, value)
+		assignments = append(assignments, field.column+" = "+fmt.Sprintf(field.write, fmt.Sprintf("$%d", len(args))))
+	}
+
+	result.Status = SyncStatusApplied
+	if len(result.ConflictingFields) > 0 {
+		result.Status = SyncStatusMerged
+	}
+
+	if len(assignments) > 0 {
+		query := fmt.Sprintf(`UPDATE %s SET %s WHERE id = $1`, entity.table, strings.Join(assignments, ", "))
+		_, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM %s t WHERE t.id = $1`, entity.selectColumns(), entity.table)
+	record, err := scanSyncRecord(tx.QueryRowContext(ctx, query, current.ID), entity)
+	if err != nil {
+		return err
+	}
+
+	result.Version = &record.Version
+	if result.Status == SyncStatusMerged {
+		result.Record = record
+	}
+
+	return nil
+}
+
+// resolveFields checks the fields of a mutation against the entity and
+// converts them to the values written to the database. References given as
+// a client_id are resolved to the id of the record in the ledger.
+func (pg *PostgresSyncStore) resolveFields(ctx context.Context, tx *sql.Tx, entity *syncEntity, ledgerID int, fields map[string]any) (map[string]any, error) {
+	values := make(map[string]any, len(fields))
+
+	for name, raw := range fields {
+		field := entity.field(name)
+		if field == nil {
+			return nil, syncRejection(fmt.Sprintf("%s is not a field of %s", name, entity.name))
+		}
+
+		if raw == nil {
+			if !field.nullable {
+				return nil, syncRejection(name + " must not be null")
+			}
+			values[name] = nil
+			continue
+		}
+
+		var value any
+		switch field.kind {
+		case syncText:
+			text, ok := raw.(string)
+			if !ok || (field.required && strings.TrimSpace(text) == "") {
+				return nil, syncRejection(name + " must be a non-empty string")
+			}
+			value = text
+		case syncNumber:
+			number, ok := raw.(float64)
+			if !ok {
+				return nil, syncRejection(name + " must be a number")
+			}
+			value = number
+		case syncTimestamp:
+			text, ok := raw.(string)
+			if !ok || !isSyncTimestamp(text) {
+				return nil, syncRejection(name + " must be a date or RFC 3339 timestamp")
+			}
+			value = text
+		case syncBool:
+			flag, ok := raw.(bool)
+			if !ok {
+				return nil, syncRejection(name + " must be a boolean")
+			}
+			value = flag
+		case syncInt:
+			id, err := pg.resolveInt(ctx, tx, field, ledgerID, raw)
+			if err != nil {
+				return nil, err
+			}
+			value = id
+		}
+
+		if field.validate != nil {
+			if message := field.validate(value); message != "" {
+				return nil, syncRejection(message)
+			}
+		}
+
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+func (pg *PostgresSyncStore) resolveInt(ctx context.Context, tx *sql.Tx, field *syncField, ledgerID int, raw any) (int64, error) {
+	clientID, isClientID := raw.(string)
+	if isClientID && (field.ref == "" || !uuidPattern.MatchString(clientID)) {
+		return 0, syncRejection(field.name + " must be an integer")
+	}
+
+	var id int64
+	if !isClientID {
+		number, ok := raw.(float64)
+		if !ok || number != math.Trunc(number) {
+			return 0, syncRejection(field.name + " must be an integer")
+		}
+		id = int64(number)
+	}
+
+	if field.ref == "" {
+		return id, nil
+	}
+
+	query := fmt.Sprintf(`
+	SELECT t.id
+	FROM %s t
+	WHERE t.ledger_id = $1 AND (t.id = $2 OR t.client_id = $3::uuid)`, syncEntities[field.ref].table)
+
+	var clientIDArg *string
+	if isClientID {
+		clientIDArg = &clientID
+	}
+
+	err := tx.QueryRowContext(ctx, query, ledgerID, id, clientIDArg).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, syncRejection(fmt.Sprintf("%s must refer to a %s in the ledger", field.name, field.ref))
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func isSyncTimestamp(value string) bool {
+	_, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return true
+	}
+
+	_, err = time.Parse(dateLayout, value)
+	return err == nil
+}
+
+// checkCategoryParent rejects a parent that is the category itself or one
+// of its descendants.
+func checkCategoryParent(ctx context.Context, tx *sql.Tx, categoryID int, parentID int64) error {
+	query := `SELECT $2 IN (SELECT category_descendants($1))`
+
+	var cyclic bool
+	err := tx.QueryRowContext(ctx, query, categoryID, parentID).Scan(&cyclic)
+	if err != nil {
+		return err
+	}
+
+	if cyclic {
+		return syncRejection(ErrInvalidParentCategory.Error())
+	}
+
+	return nil
+}
+
+// isSyncConflict reports whether the record changed on the server since the
+// version the client based its change on.
+func isSyncConflict(mutation *SyncMutation, current *SyncRecord) bool {
+	return mutation.BaseVersion == nil || current.Version > *mutation.BaseVersion
+}
+
+// clientChangedLast compares the time of the client change with the last
+// server change. A change without a time never wins a conflict.
+func clientChangedLast(mutation *SyncMutation, current *SyncRecord) bool {
+	if mutation.UpdatedAt == nil {
+		return false
+	}
+
+	changedAt, err := time.Parse(time.RFC3339, *mutation.UpdatedAt)
+	if err != nil {
+		return false
+	}
+
+	return changedAt.After(current.updatedAt)
+}
+
+// mergeSyncFields keeps the client value of every field the server left
+// alone since the client's base. Fields both sides changed to different
+// values keep the server value and are reported as conflicting.
+func mergeSyncFields(values map[string]any, base map[string]any, server map[string]any) (map[string]any, []string) {
+	merged := make(map[string]any, len(values))
+	conflicting := []string{}
+
+	for name, value := range values {
+		baseValue, hasBase := base[name]
+
+		switch {
+		case syncValuesEqual(value, server[name]):
+			// Both sides agree, nothing to write
+		case hasBase && syncValuesEqual(baseValue, server[name]):
+			merged[name] = value
+		default:
+			conflicting = append(conflicting, name)
+		}
+	}
+
+	return merged, conflicting
+}
+
+func syncValuesEqual(a any, b any) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	toFloat := func(value any) (float64, bool) {
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case int64:
+			return float64(v), true
+		case int:
+			return float64(v), true
+		}
+		return 0, false
+	}
+
+	fa, aIsNumber := toFloat(a)
+	fb, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		return math.Abs(fa-fb) < 0.005
+	}
+
+	return a == b
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestSyncFieldValidate(t *testing.T) {
+	tests := []struct {
+		entity string
+		field  string
+		value  any
+		valid  bool
+	}{
+		{SyncEntityExpense, "title", strings.Repeat("é", 150), true},
+		{SyncEntityExpense, "title", strings.Repeat("a", 151), false},
+		{SyncEntityExpense, "amount", 0.01, true},
+		{SyncEntityExpense, "amount", 99999999.99, true},
+		{SyncEntityExpense, "amount", 0.0, false},
+		{SyncEntityExpense, "amount", -5.0, false},
+		{SyncEntityExpense, "amount", 1e8, false},
+		{SyncEntityCategory, "name", strings.Repeat("a", 255), true},
+		{SyncEntityCategory, "name", strings.Repeat("a", 256), false},
+		{SyncEntityPaymentMethod, "name", strings.Repeat("a", 101), false},
+		{SyncEntityPaymentMethod, "issuer", nil, true},
+		{SyncEntityPaymentMethod, "issuer", strings.Repeat("a", 101), false},
+	}
+
+	for _, test := range tests {
+		field := syncEntities[test.entity].field(test.field)
+		message := field.validate(test.value)
+		if (message == "") != test.valid {
+			t.Errorf("%s.%s validate(%.20v) = %q, want valid %v", test.entity, test.field, test.value, message, test.valid)
+		}
+	}
+}
+
+func TestAsSyncRejection(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+		ok   bool
+	}{
+		{syncRejection("title is required"), "title is required", true},
+		{fmt.Errorf("applying: %w", &pgconn.PgError{Code: "22003", Message: "numeric field overflow"}), "numeric field overflow", true},
+		{&pgconn.PgError{Code: "23503", Message: "violates foreign key constraint"}, "violates foreign key constraint", true},
+		{&pgconn.PgError{Code: "40P01", Message: "deadlock detected"}, "", false},
+		{errors.New("connection reset"), "", false},
+	}
+
+	for _, test := range tests {
+		var rejection syncRejection
+		ok := asSyncRejection(test.err, &rejection)
+		if ok != test.ok || string(rejection) != test.want {
+			t.Errorf("asSyncRejection(%v) = %q, %v, want %q, %v", test.err, rejection, ok, test.want, test.ok)
+		}
+	}
+}
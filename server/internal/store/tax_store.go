@@ -0,0 +1,204 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DefaultFinancialYearStartMonth is April, the start of the Indian financial
+// year.
+const DefaultFinancialYearStartMonth = time.April
+
+// taxSectionNames are the supported tax sections and their display names.
+var taxSectionNames = map[string]string{
+	"80C":      "Section 80C",
+	"80D":      "Section 80D",
+	"80E":      "Section 80E",
+	"80G":      "Section 80G",
+	"business": "Business expense",
+}
+
+// taxSectionOrder is the order sections are reported in.
+var taxSectionOrder = []string{"80C", "80D", "80E", "80G", "business"}
+
+func IsValidTaxSection(section string) bool {
+	_, ok := taxSectionNames[section]
+	return ok
+}
+
+type TaxReportQueryParams struct {
+	Year       *int   `schema:"year"`
+	StartMonth *int   `schema:"start_month"`
+	Format     string `schema:"format"`
+}
+
+// TaxLineItem is one expense counted towards a section, either tagged
+// directly or through its category.
+type TaxLineItem struct {
+	ExpenseID     int     `json:"expense_id"`
+	Title         string  `json:"title"`
+	Amount        float64 `json:"amount"`
+	ExpenseDate   string  `json:"expense_date"`
+	CategoryID    int     `json:"category_id"`
+	CategoryName  string  `json:"category_name"`
+	PaymentMethod string  `json:"payment_method"`
+	FromCategory  bool    `json:"from_category"`
+}
+
+type TaxSectionTotal struct {
+	Section     string         `json:"section"`
+	Name        string         `json:"name"`
+	TotalAmount float64        `json:"total_amount"`
+	Count       int            `json:"count"`
+	Items       []*TaxLineItem `json:"items"`
+}
+
+// TaxReport covers one financial year. Year is the calendar year the
+// financial year starts in, so FY 2025-26 is year 2025.
+type TaxReport struct {
+	Year        int                `json:"year"`
+	Label       string             `json:"label"`
+	StartDate   string             `json:"start_date"`
+	EndDate     string             `json:"end_date"`
+	Sections    []*TaxSectionTotal `json:"sections"`
+	TotalAmount float64            `json:"total_amount"`
+}
+
+// Records lays the report out as CSV, one line item per row followed by a
+// total row per section.
+func (t *TaxReport) Records() [][]string {
+	records := [][]string{{"Section", "Date", "Title", "Category", "Payment method", "Amount"}}
+	for _, section := range t.Sections {
+		for _, item := range section.Items {
+			records = append(records, []string{section.Name, item.ExpenseDate, item.Title, item.CategoryName, item.PaymentMethod, formatAmount(item.Amount)})
+		}
+		records = append(records, []string{section.Name + " total", "", "", "", "", formatAmount(section.TotalAmount)})
+	}
+	return append(records, []string{"Total", "", "", "", "", formatAmount(t.TotalAmount)})
+}
+
+// FinancialYear returns the year a financial year starting in startMonth
+// begins in for the given date.
+func FinancialYear(date time.Time, startMonth time.Month) int {
+	if date.Month() < startMonth {
+		return date.Year() - 1
+	}
+	return date.Year()
+}
+
+type PostgresTaxStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTaxStore(db *sql.DB) *PostgresTaxStore {
+	return &PostgresTaxStore{
+		db: db,
+	}
+}
+
+type TaxStore interface {
+	TaxReport(ledgerID int, year int, startMonth time.Month, timezone string) (*TaxReport, error)
+}
+
+// TaxReport totals deductible expenses by section for a financial year. An
+// expense's own section wins over its category's. Expenses are dated in the
+// user's timezone.
+func (pg *PostgresTaxStore) TaxReport(ledgerID int, year int, startMonth time.Month, timezone string) (*TaxReport, error) {
+	if timezone == "" {
+		timezone = DefaultTimezone
+	}
+
+	start := time.Date(year, startMonth, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, -1)
+
+	report := &TaxReport{
+		Year:      year,
+		Label:     "FY " + start.Format("2006") + "-" + end.Format("06"),
+		StartDate: start.Format(dateLayout),
+		EndDate:   end.Format(dateLayout),
+		Sections:  []*TaxSectionTotal{},
+	}
+
+	if start.Year() == end.Year() {
+		report.Label = "FY " + start.Format("2006")
+	}
+
+	query := `
+	SELECT
+		COALESCE(e.tax_section, c.tax_section) AS section,
+		e.tax_section IS NULL AS from_category,
+		e.id,
+		e.title,
+		e.amount,
+		TO_CHAR(e.expense_date AT TIME ZONE $4::text, 'YYYY-MM-DD'),
+		c.id,
+		c.name,
+		COALESCE(pm.name, '')
+	FROM expenses e
+	INNER JOIN categories c ON c.id = e.category_id
+	LEFT JOIN payment_methods pm ON pm.id = e.payment_method_id
+	WHERE
+		e.ledger_id = $1
+		AND COALESCE(e.tax_section, c.tax_section) IS NOT NULL
+		AND e.expense_date >= ($2::date::timestamp AT TIME ZONE $4::text)
+		AND e.expense_date < (($3::date + 1)::timestamp AT TIME ZONE $4::text)
+	ORDER BY e.expense_date, e.id`
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, report.StartDate, report.EndDate, timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sections := make(map[string]*TaxSectionTotal)
+
+	for rows.Next() {
+		var section string
+		var item TaxLineItem
+		err := rows.Scan(
+			&section,
+			&item.FromCategory,
+			&item.ExpenseID,
+			&item.Title,
+			&item.Amount,
+			&item.ExpenseDate,
+			&item.CategoryID,
+			&item.CategoryName,
+			&item.PaymentMethod,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		total, ok := sections[section]
+		if !ok {
+			total = &TaxSectionTotal{Section: section, Name: taxSectionNames[section], Items: []*TaxLineItem{}}
+			sections[section] = total
+		}
+
+		total.Items = append(total.Items, &item)
+		total.TotalAmount += item.Amount
+		total.Count++
+		report.TotalAmount += item.Amount
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, section := range taxSectionOrder {
+		if total, ok := sections[section]; ok {
+			total.TotalAmount = roundAmount(total.TotalAmount)
+			report.Sections = append(report.Sections, total)
+		}
+	}
+
+	report.TotalAmount = roundAmount(report.TotalAmount)
+
+	return report, nil
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"cha-ching-server/internal/templates"
+	"context"
+	"database/sql"
+)
+
+// TemplateResult counts what applying a template added. Categories and
+// payment methods the ledger already has, matched by name, are left alone.
+type TemplateResult struct {
+	Template              string `json:"template"`
+	CategoriesCreated     int    `json:"categories_created"`
+	PaymentMethodsCreated int    `json:"payment_methods_created"`
+}
+
+type PostgresTemplateStore struct {
+	db *sql.DB
+}
+
+func NewPostgresTemplateStore(db *sql.DB) *PostgresTemplateStore {
+	return &PostgresTemplateStore{
+		db: db,
+	}
+}
+
+type TemplateStore interface {
+	ApplyTemplate(ledgerID int, userID int, template *templates.Template) (*TemplateResult, error)
+}
+
+func (pg *PostgresTemplateStore) ApplyTemplate(ledgerID int, userID int, template *templates.Template) (*TemplateResult, error) {
+	tx, err := pg.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := applyTemplate(ctx, tx, template, ledgerID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// applyTemplate seeds a ledger with the categories and payment methods of a
+// template inside the caller's transaction.
+func applyTemplate(ctx context.Context, tx *sql.Tx, template *templates.Template, ledgerID int, userID int) (*TemplateResult, error) {
+	result := &TemplateResult{Template: template.Key}
+
+	for _, category := range template.Categories {
+		err := applyTemplateCategory(ctx, tx, category, nil, ledgerID, userID, result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, paymentMethod := range template.PaymentMethods {
+		query := `
+		INSERT INTO payment_methods (user_id, ledger_id, name, kind)
+		SELECT $1, $2, $3::text, $4
+		WHERE NOT EXISTS (
+			SELECT 1 FROM payment_methods pm WHERE pm.ledger_id = $2 AND LOWER(pm.name) = LOWER($3::text)
+		)`
+
+		res, err := tx.ExecContext(ctx, query, userID, ledgerID, paymentMethod.Name, paymentMethod.Kind)
+		if err != nil {
+			return nil, err
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+
+		result.PaymentMethodsCreated += int(affected)
+	}
+
+	return result, nil
+}
+
+func applyTemplateCategory(ctx context.Context, tx *sql.Tx, category templates.Category, parentID *int, ledgerID int, userID int, result *TemplateResult) error {
+	var id int
+
+	existingQuery := `
+	SELECT c.id
+	FROM categories c
+	WHERE c.ledger_id = $1 AND LOWER(c.name) = LOWER($2::text) AND c.parent_id IS NOT DISTINCT FROM $3::bigint
+	LIMIT 1`
+
+	err := tx.QueryRowContext(ctx, existingQuery, ledgerID, category.Name, parentID).Scan(&id)
+	if err == sql.ErrNoRows {
+		insertQuery := `
+		INSERT INTO categories (user_id, ledger_id, name, budget_cadence, parent_id)
+		    VALUES ($1, $2, $3, $4, $5)
+		RETURNING
+		    id`
+
+		err = tx.QueryRowContext(ctx, insertQuery, userID, ledgerID, category.Name, BudgetCadenceMonthly, parentID).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		result.CategoriesCreated++
+	} else if err != nil {
+		return err
+	}
+
+	for _, child := range category.Children {
+		err = applyTemplateCategory(ctx, tx, child, &id, ledgerID, userID, result)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"cha-ching-server/internal/templates"
 	"context"
 	"crypto/sha256"
 	"database/sql"
@@ -41,9 +42,13 @@ type User struct {
 	ID           int      `json:"id"`
 	Name         string   `json:"name"`
 	Email        string   `json:"email"`
+	Timezone     string   `json:"timezone"`
 	PasswordHash password `json:"-"`
 }
 
+// DefaultTimezone is used for users that have not picked a timezone.
+const DefaultTimezone = "Asia/Kolkata"
+
 var AnonymousUser = &User{}
 
 func (u *User) IsAnonymous() bool {
@@ -61,12 +66,18 @@ func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 }
 
 type UserStore interface {
-	CreateUser(user *User) (*User, error)
+	CreateUser(user *User, starterTemplate *templates.Template) (*User, error)
 	GetUserByEmail(email string) (*User, error)
 	GetUserByToken(tokenString string) (*User, error)
 }
 
-func (pg *PostgresUserStore) CreateUser(user *User) (*User, error) {
+// CreateUser creates the user with a personal ledger, seeded from
+// starterTemplate unless it is nil.
+func (pg *PostgresUserStore) CreateUser(user *User, starterTemplate *templates.Template) (*User, error) {
+	if user.Timezone == "" {
+		user.Timezone = DefaultTimezone
+	}
+
 	tx, err := pg.db.Begin()
 	if err != nil {
 		return nil, err
@@ -75,8 +86,8 @@ func (pg *PostgresUserStore) CreateUser(user *User) (*User, error) {
 	defer tx.Rollback()
 
 	query := `
-		INSERT INTO users (name, email, password_hash)
-		    VALUES ($1, $2, $3)
+		INSERT INTO users (name, email, password_hash, timezone)
+		    VALUES ($1, $2, $3, $4)
 		RETURNING
 		    id`
 
@@ -89,11 +100,24 @@ func (pg *PostgresUserStore) CreateUser(user *User) (*User, error) {
 		user.Name,
 		user.Email,
 		user.PasswordHash.hash,
+		user.Timezone,
 	).Scan(&user.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	ledger, err := createLedger(ctx, tx, &Ledger{Name: "Personal", CreatedBy: user.ID})
+	if err != nil {
+		return nil, err
+	}
+
+	if starterTemplate != nil {
+		_, err = applyTemplate(ctx, tx, starterTemplate, ledger.ID, user.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, err
@@ -106,7 +130,7 @@ func (pg *PostgresUserStore) GetUserByEmail(email string) (*User, error) {
 	user := &User{}
 
 	query := `
-		SELECT u.id, u.name, u.email, u.password_hash
+		SELECT u.id, u.name, u.email, u.timezone, u.password_hash
 		FROM users u
 		WHERE u.email = $1`
 
@@ -116,6 +140,7 @@ func (pg *PostgresUserStore) GetUserByEmail(email string) (*User, error) {
 		&user.ID,
 		&user.Name,
 		&user.Email,
+		&user.Timezone,
 		&user.PasswordHash.hash,
 	)
 
@@ -133,7 +158,7 @@ func (pg *PostgresUserStore) GetUserByToken(tokenString string) (*User, error) {
 	tokenHash := sha256.Sum256([]byte(tokenString))
 
 	query := `
-	SELECT u.id, u.name, u.email, u.password_hash
+	SELECT u.id, u.name, u.email, u.timezone, u.password_hash
 	FROM users u
 	INNER JOIN tokens t
 	ON t.user_id = u.id
@@ -151,6 +176,7 @@ func (pg *PostgresUserStore) GetUserByToken(tokenString string) (*User, error) {
 		&user.ID,
 		&user.Name,
 		&user.Email,
+		&user.Timezone,
 		&user.PasswordHash.hash,
 	)
 
@@ -0,0 +1,561 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Event types published for ledger changes, both to webhooks and to the live
+// event stream.
+const (
+	WebhookEventExpenseCreated   = "expense.created"
+	WebhookEventExpenseUpdated   = "expense.updated"
+	WebhookEventExpenseDeleted   = "expense.deleted"
+	WebhookEventCategoryCreated  = "category.created"
+	WebhookEventCategoryUpdated  = "category.updated"
+	WebhookEventCategoryArchived = "category.archived"
+	WebhookEventCategoryDeleted  = "category.deleted"
+	WebhookEventCategoryMerged   = "category.merged"
+
+	WebhookEventPaymentMethodCreated  = "payment_method.created"
+	WebhookEventPaymentMethodUpdated  = "payment_method.updated"
+	WebhookEventPaymentMethodArchived = "payment_method.archived"
+	WebhookEventPaymentMethodDeleted  = "payment_method.deleted"
+)
+
+var WebhookEventTypes = []string{
+	WebhookEventExpenseCreated,
+	WebhookEventExpenseUpdated,
+	WebhookEventExpenseDeleted,
+	WebhookEventCategoryCreated,
+	WebhookEventCategoryUpdated,
+	WebhookEventCategoryArchived,
+	WebhookEventCategoryDeleted,
+	WebhookEventCategoryMerged,
+	WebhookEventPaymentMethodCreated,
+	WebhookEventPaymentMethodUpdated,
+	WebhookEventPaymentMethodArchived,
+	WebhookEventPaymentMethodDeleted,
+}
+
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+var (
+	ErrInvalidWebhookLedger        = errors.New("ledger_id must be a ledger you are a member of")
+	ErrWebhookSubscriptionNotFound = errors.New("webhook subscription not found")
+)
+
+func IsValidWebhookEventType(eventType string) bool {
+	for _, valid := range WebhookEventTypes {
+		if eventType == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookSubscription is only returned with its secret when it is created.
+type WebhookSubscription struct {
+	ID         int      `json:"id"`
+	LedgerID   int      `json:"ledger_id"`
+	URL        string   `json:"url"`
+	Secret     string   `json:"secret,omitempty"`
+	EventTypes []string `json:"event_types"`
+	Active     bool     `json:"active"`
+	CreatedAt  string   `json:"created_at"`
+	UserID     int      `json:"-"`
+}
+
+// WebhookDelivery is one attempt sequence at delivering an event to a
+// subscription. URL and Secret are filled in for deliveries that are about
+// to be sent.
+type WebhookDelivery struct {
+	ID             int     `json:"id"`
+	SubscriptionID int     `json:"subscription_id"`
+	EventID        string  `json:"event_id"`
+	EventType      string  `json:"event_type"`
+	Payload        string  `json:"payload"`
+	Status         string  `json:"status"`
+	Attempts       int     `json:"attempts"`
+	ResponseStatus *int    `json:"response_status"`
+	LastError      *string `json:"last_error"`
+	NextAttemptAt  *string `json:"next_attempt_at"`
+	DeliveredAt    *string `json:"delivered_at"`
+	CreatedAt      string  `json:"created_at"`
+	URL            string  `json:"-"`
+	Secret         string  `json:"-"`
+}
+
+// WebhookAttempt is the outcome of sending a delivery once. NextAttemptAt is
+// nil when no retry should follow.
+type WebhookAttempt struct {
+	DeliveryID     int
+	Succeeded      bool
+	ResponseStatus *int
+	Error          *string
+	NextAttemptAt  *time.Time
+}
+
+type WebhookDeliveryQueryParams struct {
+	Status *string `schema:"status"`
+	Limit  *int    `schema:"limit"`
+}
+
+type PostgresWebhookStore struct {
+	db *sql.DB
+}
+
+func NewPostgresWebhookStore(db *sql.DB) *PostgresWebhookStore {
+	return &PostgresWebhookStore{
+		db: db,
+	}
+}
+
+type WebhookStore interface {
+	CreateWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error)
+	ListWebhookSubscriptions(userID int) ([]*WebhookSubscription, error)
+	UpdateWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error)
+	DeleteWebhookSubscription(userID int, id int64) (bool, error)
+	ListWebhookDeliveries(userID int, subscriptionID int64, queryParams WebhookDeliveryQueryParams) ([]*WebhookDelivery, error)
+	RedeliverWebhook(userID int, subscriptionID int64, deliveryID int64) (*WebhookDelivery, error)
+	CreateWebhookDeliveries(ledgerID int, eventType string, eventID string, payload string, lease time.Duration) ([]*WebhookDelivery, error)
+	ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	RecordWebhookAttempt(attempt *WebhookAttempt) error
+}
+
+const webhookSubscriptionColumns = `
+	s.id, s.ledger_id, s.url, ARRAY_TO_STRING(s.event_types, ','), s.active,
+	TO_CHAR(s.created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')`
+
+func scanWebhookSubscription(row interface{ Scan(...any) error }, subscription *WebhookSubscription) error {
+	var eventTypes string
+
+	err := row.Scan(
+		&subscription.ID,
+		&subscription.LedgerID,
+		&subscription.URL,
+		&eventTypes,
+		&subscription.Active,
+		&subscription.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	subscription.EventTypes = []string{}
+	if eventTypes != "" {
+		subscription.EventTypes = strings.Split(eventTypes, ",")
+	}
+
+	return nil
+}
+
+const webhookDeliveryColumns = `
+	d.id, d.subscription_id, d.event_id, d.event_type, d.payload, d.status, d.attempts,
+	d.response_status, d.last_error,
+	TO_CHAR(d.next_attempt_at, 'YYYY-MM-DD"T"HH24:MI:SSOF'),
+	TO_CHAR(d.delivered_at, 'YYYY-MM-DD"T"HH24:MI:SSOF'),
+	TO_CHAR(d.created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')`
+
+func scanWebhookDelivery(row interface{ Scan(...any) error }, delivery *WebhookDelivery, extra ...any) error {
+	var responseStatus sql.NullInt64
+	var lastError, nextAttemptAt, deliveredAt sql.NullString
+
+	dest := append([]any{
+		&delivery.ID,
+		&delivery.SubscriptionID,
+		&delivery.EventID,
+		&delivery.EventType,
+		&delivery.Payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&responseStatus,
+		&lastError,
+		&nextAttemptAt,
+		&deliveredAt,
+		&delivery.CreatedAt,
+	}, extra...)
+
+	err := row.Scan(dest...)
+	if err != nil {
+		return err
+	}
+
+	if responseStatus.Valid {
+		status := int(responseStatus.Int64)
+		delivery.ResponseStatus = &status
+	}
+	if lastError.Valid {
+		delivery.LastError = &lastError.String
+	}
+	if nextAttemptAt.Valid {
+		delivery.NextAttemptAt = &nextAttemptAt.String
+	}
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.String
+	}
+
+	return nil
+}
+
+// CreateWebhookSubscription requires the user to be a member of the ledger
+// whose events are subscribed to.
+func (pg *PostgresWebhookStore) CreateWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	INSERT INTO webhook_subscriptions (user_id, ledger_id, url, secret, event_types, active)
+	SELECT lm.user_id, lm.ledger_id, $3, $4, STRING_TO_ARRAY($5::text, ','), $6
+	FROM ledger_members lm
+	WHERE lm.user_id = $1 AND lm.ledger_id = $2
+	RETURNING id, TO_CHAR(created_at, 'YYYY-MM-DD"T"HH24:MI:SSOF')`
+
+	err := pg.db.QueryRowContext(
+		ctx,
+		query,
+		subscription.UserID,
+		subscription.LedgerID,
+		subscription.URL,
+		subscription.Secret,
+		strings.Join(subscription.EventTypes, ","),
+		subscription.Active,
+	).Scan(&subscription.ID, &subscription.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidWebhookLedger
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+func (pg *PostgresWebhookStore) ListWebhookSubscriptions(userID int) ([]*WebhookSubscription, error) {
+	subscriptions := []*WebhookSubscription{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	SELECT` + webhookSubscriptionColumns + `
+	FROM webhook_subscriptions s
+	WHERE s.user_id = $1
+	ORDER BY s.id`
+
+	rows, err := pg.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var subscription WebhookSubscription
+
+		err := scanWebhookSubscription(rows, &subscription)
+		if err != nil {
+			return nil, err
+		}
+
+		subscriptions = append(subscriptions, &subscription)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return subscriptions, nil
+}
+
+// UpdateWebhookSubscription changes the URL, event types and active flag.
+// The ledger and secret stay as they were created.
+func (pg *PostgresWebhookStore) UpdateWebhookSubscription(subscription *WebhookSubscription) (*WebhookSubscription, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	UPDATE webhook_subscriptions s
+	SET url = $3, event_types = STRING_TO_ARRAY($4::text, ','), active = $5, updated_at = CURRENT_TIMESTAMP
+	WHERE s.id = $1 AND s.user_id = $2
+	RETURNING` + webhookSubscriptionColumns
+
+	var updated WebhookSubscription
+	err := scanWebhookSubscription(
+		pg.db.QueryRowContext(
+			ctx,
+			query,
+			subscription.ID,
+			subscription.UserID,
+			subscription.URL,
+			strings.Join(subscription.EventTypes, ","),
+			subscription.Active,
+		),
+		&updated,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (pg *PostgresWebhookStore) DeleteWebhookSubscription(userID int, id int64) (bool, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	DELETE FROM webhook_subscriptions
+	WHERE id = $1 AND user_id = $2`
+
+	result, err := pg.db.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+func (pg *PostgresWebhookStore) ownsWebhookSubscription(ctx context.Context, userID int, subscriptionID int64) (bool, error) {
+	query := `
+	SELECT EXISTS (SELECT 1 FROM webhook_subscriptions WHERE id = $1 AND user_id = $2)`
+
+	var exists bool
+	err := pg.db.QueryRowContext(ctx, query, subscriptionID, userID).Scan(&exists)
+	return exists, err
+}
+
+// ListWebhookDeliveries returns the delivery log of a subscription, newest
+// first.
+func (pg *PostgresWebhookStore) ListWebhookDeliveries(userID int, subscriptionID int64, queryParams WebhookDeliveryQueryParams) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	owned, err := pg.ownsWebhookSubscription(ctx, userID, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !owned {
+		return nil, ErrWebhookSubscriptionNotFound
+	}
+
+	query := `
+	SELECT` + webhookDeliveryColumns + `
+	FROM webhook_deliveries d
+	WHERE
+		d.subscription_id = $1
+		AND ($2::text IS NULL OR d.status = $2)
+	ORDER BY d.created_at DESC, d.id DESC
+	LIMIT $3`
+
+	rows, err := pg.db.QueryContext(ctx, query, subscriptionID, queryParams.Status, queryParams.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := scanWebhookDelivery(rows, &delivery)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RedeliverWebhook queues the event of an earlier delivery again as a new
+// delivery, due right away. It returns nil when the delivery is not found.
+func (pg *PostgresWebhookStore) RedeliverWebhook(userID int, subscriptionID int64, deliveryID int64) (*WebhookDelivery, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	WITH redelivered AS (
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, next_attempt_at)
+		SELECT d.subscription_id, d.event_id, d.event_type, d.payload, CURRENT_TIMESTAMP
+		FROM webhook_deliveries d
+		JOIN webhook_subscriptions s ON s.id = d.subscription_id
+		WHERE d.id = $3 AND d.subscription_id = $2 AND s.user_id = $1
+		RETURNING *
+	)
+	SELECT` + webhookDeliveryColumns + `
+	FROM redelivered d`
+
+	var delivery WebhookDelivery
+	err := scanWebhookDelivery(pg.db.QueryRowContext(ctx, query, userID, subscriptionID, deliveryID), &delivery)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &delivery, nil
+}
+
+// CreateWebhookDeliveries queues an event for every active subscription of
+// the ledger that asked for its type and whose owner is still a member. The
+// deliveries are leased to the caller, so the retry worker only picks them
+// up if the caller never records an attempt.
+func (pg *PostgresWebhookStore) CreateWebhookDeliveries(ledgerID int, eventType string, eventID string, payload string, lease time.Duration) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	WITH created AS (
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload, next_attempt_at)
+		SELECT s.id, $3, $2, $4, CURRENT_TIMESTAMP + ($5::int * INTERVAL '1 second')
+		FROM webhook_subscriptions s
+		JOIN ledger_members lm ON lm.ledger_id = s.ledger_id AND lm.user_id = s.user_id
+		WHERE s.ledger_id = $1 AND s.active AND $2 = ANY (s.event_types)
+		RETURNING *
+	)
+	SELECT` + webhookDeliveryColumns + `, s.url, s.secret
+	FROM created d
+	JOIN webhook_subscriptions s ON s.id = d.subscription_id`
+
+	rows, err := pg.db.QueryContext(ctx, query, ledgerID, eventType, eventID, payload, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := scanWebhookDelivery(rows, &delivery, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// ClaimDueWebhookDeliveries leases up to limit pending deliveries that are
+// due. Rows locked by another server are skipped, and so are deliveries of
+// subscriptions that were turned off or whose owner left the ledger since
+// they were queued.
+func (pg *PostgresWebhookStore) ClaimDueWebhookDeliveries(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	deliveries := []*WebhookDelivery{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	query := `
+	WITH claimed AS (
+		UPDATE webhook_deliveries
+		SET next_attempt_at = CURRENT_TIMESTAMP + ($2::int * INTERVAL '1 second')
+		WHERE id IN (
+			SELECT pending.id
+			FROM webhook_deliveries pending
+			JOIN webhook_subscriptions s ON s.id = pending.subscription_id
+			JOIN ledger_members lm ON lm.ledger_id = s.ledger_id AND lm.user_id = s.user_id
+			WHERE pending.status = 'pending' AND pending.next_attempt_at <= CURRENT_TIMESTAMP AND s.active
+			ORDER BY pending.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF pending SKIP LOCKED
+		)
+		RETURNING *
+	)
+	SELECT` + webhookDeliveryColumns + `, s.url, s.secret
+	FROM claimed d
+	JOIN webhook_subscriptions s ON s.id = d.subscription_id`
+
+	rows, err := pg.db.QueryContext(ctx, query, limit, int(lease.Seconds()))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery WebhookDelivery
+
+		err := scanWebhookDelivery(rows, &delivery, &delivery.URL, &delivery.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, &delivery)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt counts an attempt and moves the delivery on: it
+// succeeds, is scheduled again, or fails for good when no retry is left.
+func (pg *PostgresWebhookStore) RecordWebhookAttempt(attempt *WebhookAttempt) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	status := WebhookDeliveryPending
+	if attempt.Succeeded {
+		status = WebhookDeliverySucceeded
+	} else if attempt.NextAttemptAt == nil {
+		status = WebhookDeliveryFailed
+	}
+
+	query := `
+	UPDATE webhook_deliveries
+	SET
+		attempts = attempts + 1,
+		status = $2,
+		response_status = $3,
+		last_error = $4,
+		next_attempt_at = $5,
+		delivered_at = CASE WHEN $2 = 'succeeded' THEN CURRENT_TIMESTAMP ELSE delivered_at END
+	WHERE id = $1`
+
+	_, err := pg.db.ExecContext(ctx, query, attempt.DeliveryID, status, attempt.ResponseStatus, attempt.Error, attempt.NextAttemptAt)
+	return err
+}
@@ -0,0 +1,60 @@
+// Package templates holds the starter sets of categories and payment methods
+// a new ledger can be seeded with.
+package templates
+
+import (
+	_ "embed"
+	"encoding/json"
+)
+
+// DefaultKey is the template applied at signup when none is chosen.
+const DefaultKey = "india_default"
+
+// NoneKey skips seeding at signup.
+const NoneKey = "none"
+
+type Category struct {
+	Name     string     `json:"name"`
+	Children []Category `json:"children,omitempty"`
+}
+
+type PaymentMethod struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type Template struct {
+	Key            string          `json:"key"`
+	Name           string          `json:"name"`
+	Description    string          `json:"description"`
+	Categories     []Category      `json:"categories"`
+	PaymentMethods []PaymentMethod `json:"payment_methods"`
+}
+
+//go:embed templates.json
+var data []byte
+
+var all []*Template
+
+func init() {
+	err := json.Unmarshal(data, &all)
+	if err != nil {
+		panic("templates: invalid templates.json: " + err.Error())
+	}
+}
+
+// All returns every template in the order they are defined.
+func All() []*Template {
+	return all
+}
+
+// Get returns the template with the given key, or nil if there is none.
+func Get(key string) *Template {
+	for _, template := range all {
+		if template.Key == key {
+			return template
+		}
+	}
+
+	return nil
+}
@@ -1,10 +1,12 @@
 package utils
 
 import (
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -13,6 +15,18 @@ import (
 
 type Envelope map[string]interface{}
 
+// Optional is a request field that tells one left out of the body apart
+// from one sent as null. Set is true whenever the field was sent.
+type Optional[T any] struct {
+	Value T
+	Set   bool
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	return json.Unmarshal(data, &o.Value)
+}
+
 func WriteJSONResponse(w http.ResponseWriter, status int, data Envelope) error {
 	js, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -28,6 +42,45 @@ func WriteJSONResponse(w http.ResponseWriter, status int, data Envelope) error {
 	return nil
 }
 
+// WriteCSVResponse writes records as a CSV attachment named filename. Cells
+// a spreadsheet would run as a formula are escaped.
+func WriteCSVResponse(w http.ResponseWriter, filename string, records [][]string) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	for _, record := range records {
+		escaped := make([]string, len(record))
+		for i, cell := range record {
+			escaped[i] = EscapeCSVCell(cell)
+		}
+
+		err := writer.Write(escaped)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// EscapeCSVCell prefixes a cell starting with =, +, -, @, tab or carriage
+// return with a quote, so spreadsheets show it as text instead of running
+// it as a formula. Plain numbers such as negative amounts are left alone.
+func EscapeCSVCell(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+
+	if _, err := strconv.ParseFloat(cell, 64); err == nil {
+		return cell
+	}
+
+	return "'" + cell
+}
+
 func ReadRequestBody(r *http.Request, dst interface{}) error {
 	dec := json.NewDecoder(r.Body)
 	dec.DisallowUnknownFields()
@@ -36,14 +89,18 @@ func ReadRequestBody(r *http.Request, dst interface{}) error {
 }
 
 func ReadIDParam(r *http.Request) (int64, error) {
-	idParam := chi.URLParam(r, "id")
+	return ReadInt64URLParam(r, "id")
+}
+
+func ReadInt64URLParam(r *http.Request, key string) (int64, error) {
+	idParam := chi.URLParam(r, key)
 	if idParam == "" {
-		return 0, errors.New("invalid id parameter")
+		return 0, errors.New("invalid " + key + " parameter")
 	}
 
 	id, err := strconv.ParseInt(idParam, 10, 64)
 	if err != nil {
-		return 0, errors.New("invalid id parameter")
+		return 0, errors.New("invalid " + key + " parameter")
 	}
 
 	return id, nil
@@ -119,3 +176,15 @@ func ExtractTokenFromHeader(authHeader string) (string, error) {
 
 	return authHeader[len(prefix):], nil
 }
+
+// TodayIn returns today's calendar date in the given IANA timezone as a UTC
+// midnight, so it can be formatted and compared as a plain date.
+func TodayIn(timezone string) (time.Time, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now().In(location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC), nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestEscapeCSVCell(t *testing.T) {
+	tests := []struct {
+		cell string
+		want string
+	}{
+		{"", ""},
+		{"Groceries", "Groceries"},
+		{"=HYPERLINK(\"http://evil.test\",\"click\")", "'=HYPERLINK(\"http://evil.test\",\"click\")"},
+		{"+cmd|' /C calc'!A0", "'+cmd|' /C calc'!A0"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1:A2)", "'@SUM(A1:A2)"},
+		{"\t=1+1", "'\t=1+1"},
+		{"\r=1+1", "'\r=1+1"},
+		{"-1250.50", "-1250.50"},
+		{"+12", "+12"},
+		{"a=b", "a=b"},
+	}
+
+	for _, test := range tests {
+		if got := EscapeCSVCell(test.cell); got != test.want {
+			t.Errorf("EscapeCSVCell(%q) = %q, want %q", test.cell, got, test.want)
+		}
+	}
+}
+
+func TestWriteCSVResponseEscapesFormulas(t *testing.T) {
+	w := httptest.NewRecorder()
+
+	err := WriteCSVResponse(w, "report.csv", [][]string{
+		{"category", "amount"},
+		{"=1+1", "-40.00"},
+	})
+	if err != nil {
+		t.Fatalf("WriteCSVResponse: %v", err)
+	}
+
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="report.csv"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+
+	want := [][]string{{"category", "amount"}, {"'=1+1", "-40.00"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %q, want %q", records, want)
+	}
+}
+
+func TestOptional(t *testing.T) {
+	type body struct {
+		ParentID Optional[*int] `json:"parent_id"`
+	}
+
+	tests := []struct {
+		json  string
+		set   bool
+		value *int
+	}{
+		{`{}`, false, nil},
+		{`{"parent_id": null}`, true, nil},
+		{`{"parent_id": 4}`, true, new(int)},
+	}
+
+	for _, test := range tests {
+		var got body
+		if err := json.Unmarshal([]byte(test.json), &got); err != nil {
+			t.Fatalf("Unmarshal(%s): %v", test.json, err)
+		}
+
+		if got.ParentID.Set != test.set || (got.ParentID.Value == nil) != (test.value == nil) {
+			t.Errorf("Unmarshal(%s) = %+v, want set %v and value %v", test.json, got.ParentID, test.set, test.value)
+		}
+	}
+
+	var got body
+	json.Unmarshal([]byte(`{"parent_id": 4}`), &got)
+	if *got.ParentID.Value != 4 {
+		t.Errorf("parent_id = %d, want 4", *got.ParentID.Value)
+	}
+}
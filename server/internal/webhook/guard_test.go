@@ -0,0 +1,231 @@
+package webhook
+
+import (
+	"cha-ching-server/internal/store"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{"93.184.215.14", true},
+		{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.10", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"255.255.255.255", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"::", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"64:ff9b::a00:1", false},
+	}
+
+	for _, test := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(test.addr)); got != test.public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", test.addr, got, test.public)
+		}
+	}
+}
+
+func TestValidateURL(t *testing.T) {
+	for _, rawURL := range []string{
+		"http://127.0.0.1:5432/",
+		"http://localhost/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"https://[::1]/hook",
+		"http://10.0.0.8/hook",
+		"ftp://example.com/hook",
+		"/relative",
+		"http://",
+	} {
+		if err := ValidateURL(context.Background(), rawURL); err == nil {
+			t.Errorf("ValidateURL(%q) accepted a URL it should reject", rawURL)
+		}
+	}
+
+	err := ValidateURL(context.Background(), "http://127.0.0.1/hook")
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("ValidateURL(loopback) = %v, want ErrPrivateAddress", err)
+	}
+}
+
+func TestClientRefusesPrivateAddress(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	_, err := newClient(requestTimeout).Post(server.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, ErrPrivateAddress) {
+		t.Fatalf("Post to %s = %v, want ErrPrivateAddress", server.URL, err)
+	}
+
+	if hits != 0 {
+		t.Errorf("server received %d requests, want none", hits)
+	}
+}
+
+func TestClientDoesNotFollowRedirects(t *testing.T) {
+	followed := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/internal" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/internal", http.StatusFound)
+	}))
+	defer server.Close()
+
+	// The test server is on loopback, so only the redirect policy is kept
+	client := newClient(requestTimeout)
+	client.Transport = http.DefaultTransport
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("Post: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusFound || followed {
+		t.Errorf("status = %d, followed = %v; want the 302 returned as is", res.StatusCode, followed)
+	}
+}
+
+type recordingWebhookStore struct {
+	store.WebhookStore
+
+	mu       sync.Mutex
+	attempts []*store.WebhookAttempt
+}
+
+func (s *recordingWebhookStore) RecordWebhookAttempt(attempt *store.WebhookAttempt) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.attempts = append(s.attempts, attempt)
+	return nil
+}
+
+func TestDeliverRecordsGenericError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	webhookStore := &recordingWebhookStore{}
+	publisher := NewPublisher(log.New(io.Discard, "", 0), webhookStore)
+
+	publisher.deliver(&store.WebhookDelivery{
+		ID:        1,
+		URL:       server.URL,
+		Secret:    "0123456789abcdef",
+		EventType: store.WebhookEventExpenseCreated,
+		Payload:   "{}",
+	})
+
+	if len(webhookStore.attempts) != 1 {
+		t.Fatalf("recorded %d attempts, want 1", len(webhookStore.attempts))
+	}
+
+	attempt := webhookStore.attempts[0]
+	if attempt.Succeeded || attempt.Error == nil {
+		t.Fatalf("attempt to a loopback URL succeeded")
+	}
+
+	if *attempt.Error != ErrPrivateAddress.Error() {
+		t.Errorf("recorded error = %q, want %q", *attempt.Error, ErrPrivateAddress.Error())
+	}
+	if strings.Contains(*attempt.Error, "127.0.0.1") {
+		t.Errorf("recorded error %q leaks the dialed address", *attempt.Error)
+	}
+}
+
+func TestAttemptError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{statusError(503), "webhook responded with status 503"},
+		{context.DeadlineExceeded, "webhook request timed out"},
+		{errors.New("dial tcp 10.0.0.5:5432: connect: connection refused"), "could not connect to the webhook URL"},
+	}
+
+	for _, test := range tests {
+		if got := attemptError(test.err); got != test.want {
+			t.Errorf("attemptError(%v) = %q, want %q", test.err, got, test.want)
+		}
+	}
+}
+
+func TestDeliverAllIsBounded(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			seen := peak.Load()
+			if current <= seen || peak.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}))
+	defer server.Close()
+
+	webhookStore := &recordingWebhookStore{}
+	publisher := NewPublisher(log.New(io.Discard, "", 0), webhookStore)
+
+	// The test server is on loopback, so skip the address guard
+	publisher.client = server.Client()
+
+	deliveries := make([]*store.WebhookDelivery, 3*deliveryWorkers)
+	for i := range deliveries {
+		deliveries[i] = &store.WebhookDelivery{
+			ID:        i + 1,
+			URL:       server.URL,
+			Secret:    "0123456789abcdef",
+			EventType: store.WebhookEventExpenseCreated,
+			Payload:   "{}",
+		}
+	}
+
+	started := time.Now()
+	publisher.deliverAll(deliveries)
+
+	if len(webhookStore.attempts) != len(deliveries) {
+		t.Fatalf("recorded %d attempts, want %d", len(webhookStore.attempts), len(deliveries))
+	}
+	for _, attempt := range webhookStore.attempts {
+		if !attempt.Succeeded {
+			t.Errorf("delivery %d failed", attempt.DeliveryID)
+		}
+	}
+
+	if got := peak.Load(); got > deliveryWorkers {
+		t.Errorf("%d requests in flight at once, want at most %d", got, deliveryWorkers)
+	}
+	if elapsed := time.Since(started); elapsed > time.Duration(len(deliveries))*20*time.Millisecond {
+		t.Errorf("deliverAll took %v, deliveries were not sent concurrently", elapsed)
+	}
+}
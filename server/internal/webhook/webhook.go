@@ -0,0 +1,278 @@
+// Package webhook delivers ledger events to the URLs users subscribe with.
+// Every request is signed with the subscription secret, and failed
+// deliveries are retried with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"cha-ching-server/internal/store"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Cha-Ching-Signature"
+	TimestampHeader = "X-Cha-Ching-Timestamp"
+	EventHeader     = "X-Cha-Ching-Event"
+	DeliveryHeader  = "X-Cha-Ching-Delivery"
+)
+
+const (
+	// MaxAttempts is the number of tries before a delivery is marked failed.
+	MaxAttempts = 8
+
+	// retryBase is the wait after the first failure, doubled for each one
+	// after it, so the last retry comes about an hour after the first.
+	retryBase = 30 * time.Second
+
+	pollInterval = 15 * time.Second
+	claimLimit   = 50
+
+	// deliveryWorkers is how many requests one server sends at a time.
+	deliveryWorkers = 10
+
+	// requestTimeout bounds one delivery request, including reading the
+	// response headers.
+	requestTimeout = 10 * time.Second
+
+	// lease is how long a claimed delivery is left alone by other servers.
+	// It covers a whole claimed batch even if every request ran to its
+	// timeout one after another, so a delivery still in flight is never
+	// claimed and sent again elsewhere.
+	lease = claimLimit * requestTimeout
+)
+
+// Event is the JSON body posted to subscribers.
+type Event struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	LedgerID  int    `json:"ledger_id"`
+	CreatedAt string `json:"created_at"`
+	Data      any    `json:"data"`
+}
+
+// Sign returns the signature header value for a request body. Receivers
+// compute HMAC-SHA256 over "<timestamp>.<body>" with their secret and compare
+// it against the hex digest after "sha256=".
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret generates a signing secret for a subscription created without one.
+func NewSecret() (string, error) {
+	return randomHex(32)
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// retryDelay is the wait before the next try after the given number of
+// failed attempts.
+func retryDelay(attempts int) time.Duration {
+	return retryBase << (attempts - 1)
+}
+
+type Publisher struct {
+	logger       *log.Logger
+	webhookStore store.WebhookStore
+	client       *http.Client
+}
+
+func NewPublisher(logger *log.Logger, webhookStore store.WebhookStore) *Publisher {
+	return &Publisher{
+		logger:       logger,
+		webhookStore: webhookStore,
+		client:       newClient(requestTimeout),
+	}
+}
+
+// Publish queues an event for every matching subscription of the ledger and
+// makes the first delivery attempt in the background, so a slow receiver
+// never holds up a request.
+func (p *Publisher) Publish(ledgerID int, eventType string, data any) {
+	go func() {
+		eventID, err := randomHex(16)
+		if err != nil {
+			p.logger.Printf("ERROR: generating webhook event id: %v", err)
+			return
+		}
+
+		payload, err := json.Marshal(&Event{
+			ID:        eventID,
+			Type:      eventType,
+			LedgerID:  ledgerID,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339),
+			Data:      data,
+		})
+		if err != nil {
+			p.logger.Printf("ERROR: encoding webhook event %s: %v", eventType, err)
+			return
+		}
+
+		deliveries, err := p.webhookStore.CreateWebhookDeliveries(ledgerID, eventType, eventID, string(payload), lease)
+		if err != nil {
+			p.logger.Printf("ERROR: CreateWebhookDeliveries: %v", err)
+			return
+		}
+
+		p.deliverAll(deliveries)
+	}()
+}
+
+// Start retries due deliveries every poll interval until ctx is done. This
+// also sends redeliveries and any delivery whose first attempt was lost to a
+// restart.
+func (p *Publisher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			deliveries, err := p.webhookStore.ClaimDueWebhookDeliveries(claimLimit, lease)
+			if err != nil {
+				p.logger.Printf("ERROR: ClaimDueWebhookDeliveries: %v", err)
+				continue
+			}
+
+			p.deliverAll(deliveries)
+		}
+	}()
+}
+
+// deliverAll sends deliveries deliveryWorkers at a time and returns once
+// every attempt is recorded.
+func (p *Publisher) deliverAll(deliveries []*store.WebhookDelivery) {
+	var wg sync.WaitGroup
+	workers := make(chan struct{}, deliveryWorkers)
+
+	for _, delivery := range deliveries {
+		workers <- struct{}{}
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-workers }()
+
+			p.deliver(delivery)
+		}()
+	}
+
+	wg.Wait()
+}
+
+func (p *Publisher) deliver(delivery *store.WebhookDelivery) {
+	attempt := &store.WebhookAttempt{DeliveryID: delivery.ID}
+
+	status, err := p.send(delivery)
+	if status != 0 {
+		attempt.ResponseStatus = &status
+	}
+
+	if err == nil {
+		attempt.Succeeded = true
+	} else {
+		// The log is shown to the subscriber, so it only gets a summary of
+		// network errors, which could otherwise map out the network
+		p.logger.Printf("ERROR: webhook delivery %d: %v", delivery.ID, err)
+
+		message := attemptError(err)
+		attempt.Error = &message
+
+		if delivery.Attempts+1 < MaxAttempts {
+			next := time.Now().Add(retryDelay(delivery.Attempts + 1))
+			attempt.NextAttemptAt = &next
+		}
+	}
+
+	err = p.webhookStore.RecordWebhookAttempt(attempt)
+	if err != nil {
+		p.logger.Printf("ERROR: RecordWebhookAttempt: %v", err)
+	}
+}
+
+func (p *Publisher) send(delivery *store.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cha-ching-webhooks")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, body))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer res.Body.Close()
+
+	// Redirects are not followed, so they fail here as well
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return res.StatusCode, statusError(res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("webhook responded with status %d", int(e))
+}
+
+// attemptError is the message recorded for a failed attempt.
+func attemptError(err error) string {
+	var status statusError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &status):
+		return status.Error()
+	case errors.Is(err, ErrPrivateAddress):
+		return ErrPrivateAddress.Error()
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "webhook request timed out"
+	default:
+		return "could not connect to the webhook URL"
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"cha-ching-server/internal/app"
 	"cha-ching-server/internal/config"
 	"cha-ching-server/internal/routes"
+	"context"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
+	_ "time/tzdata"
 )
 
 func main() {
@@ -23,6 +25,13 @@ func main() {
 
 	defer app.Database.Close()
 
+	if app.DigestScheduler != nil {
+		app.DigestScheduler.Start(context.Background())
+	}
+
+	app.WebhookPublisher.Start(context.Background())
+	app.EventBroker.Start(context.Background())
+
 	port, _ := strconv.Atoi(cfg.Server.Port)
 	app.Logger.Printf("Starting server on %s:%d", cfg.Server.Host, port)
 